@@ -8,11 +8,20 @@ import (
 
 	"github.com/vesicash/mor-api/external/request"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
 	"github.com/vesicash/mor-api/utility"
 )
 
 var (
-	cronJobs    = map[string]CronJobObject{}
+	cronJobs = map[string]CronJobObject{
+		"dispute-reminders":   {CronJob: mor.SendDisputeReminders, Interval: time.Hour},
+		"merchant-statements": {CronJob: mor.GenerateMerchantStatements, Interval: 6 * time.Hour},
+		"reconcile-wallets":   {CronJob: mor.ReconcileWallets, Interval: 24 * time.Hour},
+		"release-reserves":    {CronJob: mor.ReleasePayoutReserves, Interval: time.Hour},
+		"resume-payouts":      {CronJob: mor.ResumePayouts, Interval: 10 * time.Minute},
+		"scheduled-payouts":   {CronJob: mor.RunScheduledPayouts, Interval: time.Hour},
+		"settle-wallets":      {CronJob: mor.SettleWalletCalls, Interval: 10 * time.Minute},
+	}
 	stopSignals = map[string]chan bool{}
 )
 
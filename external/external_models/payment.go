@@ -1,5 +1,7 @@
 package external_models
 
+import "time"
+
 type Payment struct {
 	ID               int64   `json:"id"`
 	PaymentID        string  `json:"payment_id"`
@@ -107,3 +109,27 @@ type PaymentInvoiceNotificationRequest struct {
 	EscrowCharge              float64 `json:"escrow_charge"`
 	BrokerCharge              float64 `json:"broker_charge"`
 }
+
+type TransactionDocumentNotificationRequest struct {
+	Email                string  `json:"email"`
+	DocumentType         string  `json:"document_type"`
+	Number               string  `json:"number"`
+	TransactionReference string  `json:"transaction_reference"`
+	MerchantName         string  `json:"merchant_name"`
+	Currency             string  `json:"currency"`
+	Amount               float64 `json:"amount"`
+	FileName             string  `json:"file_name"`
+	FileContent          string  `json:"file_content"`
+}
+
+type DisputeNotificationRequest struct {
+	AccountID            int       `json:"account_id"`
+	Event                string    `json:"event"`
+	DisputeID            uint      `json:"dispute_id"`
+	TransactionReference string    `json:"transaction_reference"`
+	Status               string    `json:"status"`
+	Reason               string    `json:"reason"`
+	Currency             string    `json:"currency"`
+	Amount               float64   `json:"amount"`
+	EvidenceDueAt        time.Time `json:"evidence_due_at"`
+}
@@ -112,6 +112,23 @@ type CreateExchangeTransactionRequest struct {
 	FinalAmount   float64 `json:"final_amount"`
 	RateID        int     `json:"rate_id"`
 	Status        string  `json:"status"`
+	Reference     string  `json:"reference,omitempty"`
+}
+
+type ExchangeTransaction struct {
+	ID            int64   `json:"id"`
+	AccountID     int     `json:"account_id"`
+	InitialAmount float64 `json:"initial_amount"`
+	FinalAmount   float64 `json:"final_amount"`
+	RateID        int     `json:"rate_id"`
+	Status        string  `json:"status"`
+	Reference     string  `json:"reference"`
+}
+type ExchangeTransactionResponse struct {
+	Status  string              `json:"status"`
+	Code    int                 `json:"code"`
+	Message string              `json:"message"`
+	Data    ExchangeTransaction `json:"data"`
 }
 
 type Rate struct {
@@ -108,6 +108,74 @@ func (r *RequestObj) PaymentInvoiceNotification() (interface{}, error) {
 	return nil, nil
 }
 
+func (r *RequestObj) TransactionDocumentNotification() (interface{}, error) {
+	var (
+		outBoundResponse map[string]interface{}
+		logger           = r.Logger
+		idata            = r.RequestData
+	)
+	data, ok := idata.(external_models.TransactionDocumentNotificationRequest)
+	if !ok {
+		logger.Error("transaction document notification", idata, "request data format error")
+		return nil, fmt.Errorf("request data format error")
+	}
+	accessToken, err := r.getAccessTokenObject().GetAccessToken()
+	if err != nil {
+		logger.Error("transaction document notification", outBoundResponse, err.Error())
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"v-private-key": accessToken.PrivateKey,
+		"v-public-key":  accessToken.PublicKey,
+	}
+
+	logger.Info("transaction document notification", data)
+	err = r.getNewSendRequestObject(data, headers, "").SendRequest(&outBoundResponse)
+	if err != nil {
+		logger.Error("transaction document notification", outBoundResponse, err.Error())
+		return nil, err
+	}
+	logger.Info("transaction document notification", outBoundResponse)
+
+	return nil, nil
+}
+
+func (r *RequestObj) DisputeNotification() (interface{}, error) {
+	var (
+		outBoundResponse map[string]interface{}
+		logger           = r.Logger
+		idata            = r.RequestData
+	)
+	data, ok := idata.(external_models.DisputeNotificationRequest)
+	if !ok {
+		logger.Error("dispute notification", idata, "request data format error")
+		return nil, fmt.Errorf("request data format error")
+	}
+	accessToken, err := r.getAccessTokenObject().GetAccessToken()
+	if err != nil {
+		logger.Error("dispute notification", outBoundResponse, err.Error())
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"v-private-key": accessToken.PrivateKey,
+		"v-public-key":  accessToken.PublicKey,
+	}
+
+	logger.Info("dispute notification", data)
+	err = r.getNewSendRequestObject(data, headers, "").SendRequest(&outBoundResponse)
+	if err != nil {
+		logger.Error("dispute notification", outBoundResponse, err.Error())
+		return nil, err
+	}
+	logger.Info("dispute notification", outBoundResponse)
+
+	return nil, nil
+}
+
 func (r *RequestObj) TransactionPaidNotification() (interface{}, error) {
 	var (
 		outBoundResponse map[string]interface{}
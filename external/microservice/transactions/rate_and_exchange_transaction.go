@@ -7,9 +7,9 @@ import (
 	"github.com/vesicash/mor-api/internal/config"
 )
 
-func (r *RequestObj) CreateExchangeTransaction() (interface{}, error) {
+func (r *RequestObj) CreateExchangeTransaction() (external_models.ExchangeTransaction, error) {
 	var (
-		outBoundResponse map[string]interface{}
+		outBoundResponse external_models.ExchangeTransactionResponse
 		logger           = r.Logger
 		idata            = r.RequestData
 		appKey           = config.GetConfig().App.Key
@@ -17,7 +17,7 @@ func (r *RequestObj) CreateExchangeTransaction() (interface{}, error) {
 	data, ok := idata.(external_models.CreateExchangeTransactionRequest)
 	if !ok {
 		logger.Error("create exchange transaction", idata, "request data format error")
-		return nil, fmt.Errorf("request data format error")
+		return outBoundResponse.Data, fmt.Errorf("request data format error")
 	}
 
 	headers := map[string]string{
@@ -29,11 +29,11 @@ func (r *RequestObj) CreateExchangeTransaction() (interface{}, error) {
 	err := r.getNewSendRequestObject(data, headers, "").SendRequest(&outBoundResponse)
 	if err != nil {
 		logger.Error("create exchange transaction", outBoundResponse, err.Error())
-		return nil, err
+		return outBoundResponse.Data, err
 	}
 	logger.Info("create exchange transaction", outBoundResponse)
 
-	return nil, nil
+	return outBoundResponse.Data, nil
 }
 
 func (r *RequestObj) GetRateByID() (external_models.Rate, error) {
@@ -52,6 +52,36 @@ func PaymentInvoiceNotification(logger *utility.Logger, idata interface{}) (inte
 	return nil, nil
 }
 
+func TransactionDocumentNotification(logger *utility.Logger, idata interface{}) (interface{}, error) {
+	var (
+		outBoundResponse map[string]interface{}
+	)
+	data, ok := idata.(external_models.TransactionDocumentNotificationRequest)
+	if !ok {
+		logger.Error("transaction document notification", idata, "request data format error")
+		return nil, fmt.Errorf("request data format error")
+	}
+
+	logger.Info("transaction document notification", outBoundResponse, data)
+
+	return nil, nil
+}
+
+func DisputeNotification(logger *utility.Logger, idata interface{}) (interface{}, error) {
+	var (
+		outBoundResponse map[string]interface{}
+	)
+	data, ok := idata.(external_models.DisputeNotificationRequest)
+	if !ok {
+		logger.Error("dispute notification", idata, "request data format error")
+		return nil, fmt.Errorf("request data format error")
+	}
+
+	logger.Info("dispute notification", outBoundResponse, data)
+
+	return nil, nil
+}
+
 func TransactionPaidNotification(logger *utility.Logger, idata interface{}) (interface{}, error) {
 	var (
 		outBoundResponse map[string]interface{}
@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/vesicash/mor-api/external/mocks/appruve_mocks"
 	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
@@ -37,9 +38,23 @@ type RequestObj struct {
 var (
 	JsonDecodeMethod    string = "json"
 	PhpSerializerMethod string = "phpserializer"
+
+	sentRequests      = map[string]int{}
+	sentRequestsMutex sync.Mutex
 )
 
+// SentRequests returns how many times the named request was sent, tests use it to check a service made no external calls
+func SentRequests(name string) int {
+	sentRequestsMutex.Lock()
+	defer sentRequestsMutex.Unlock()
+	return sentRequests[name]
+}
+
 func (er ExternalRequest) SendExternalRequest(name string, data interface{}) (interface{}, error) {
+	sentRequestsMutex.Lock()
+	sentRequests[name]++
+	sentRequestsMutex.Unlock()
+
 	switch name {
 	case "get_user":
 		return auth_mocks.GetUser(er.Logger, data)
@@ -143,6 +158,10 @@ func (er ExternalRequest) SendExternalRequest(name string, data interface{}) (in
 		return transactions_mocks.CreateActivityLog(er.Logger, data)
 	case "payment_invoice_notification":
 		notification_mocks.PaymentInvoiceNotification(er.Logger, data)
+	case "transaction_document_notification":
+		return notification_mocks.TransactionDocumentNotification(er.Logger, data)
+	case "dispute_notification":
+		return notification_mocks.DisputeNotification(er.Logger, data)
 	case "transaction_update_status":
 		return transactions_mocks.TransactionUpdateStatus(er.Logger, data)
 	case "buyer_satisfied":
@@ -163,6 +182,8 @@ func (er ExternalRequest) SendExternalRequest(name string, data interface{}) (in
 		return transactions_mocks.CreateExchangeTransaction(er.Logger, data)
 	case "get_rate_by_id":
 		return transactions_mocks.GetRateByID(er.Logger, data)
+	case "get_rate_by_from_and_to_currencies":
+		return transactions_mocks.GetRateByFromAndToCurrencies(er.Logger, data)
 	case "get_bank":
 		return auth_mocks.GetBank(er.Logger, data)
 	case "rave_init_transfer":
@@ -7,19 +7,32 @@ import (
 	"github.com/vesicash/mor-api/utility"
 )
 
-func CreateExchangeTransaction(logger *utility.Logger, idata interface{}) (interface{}, error) {
+func CreateExchangeTransaction(logger *utility.Logger, idata interface{}) (external_models.ExchangeTransaction, error) {
 	var (
-		outBoundResponse map[string]interface{}
+		outBoundResponse external_models.ExchangeTransactionResponse
 	)
 	data, ok := idata.(external_models.CreateExchangeTransactionRequest)
 	if !ok {
 		logger.Error("create exchange transaction", idata, "request data format error")
-		return nil, fmt.Errorf("request data format error")
+		return outBoundResponse.Data, fmt.Errorf("request data format error")
 	}
 
 	logger.Info("create exchange transaction", outBoundResponse, data)
 
-	return nil, nil
+	reference := data.Reference
+	if reference == "" {
+		reference = utility.RandomString(25)
+	}
+
+	return external_models.ExchangeTransaction{
+		ID:            int64(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID:     data.AccountID,
+		InitialAmount: data.InitialAmount,
+		FinalAmount:   data.FinalAmount,
+		RateID:        data.RateID,
+		Status:        data.Status,
+		Reference:     reference,
+	}, nil
 }
 
 func GetRateByID(logger *utility.Logger, idata interface{}) (external_models.Rate, error) {
@@ -41,3 +54,24 @@ func GetRateByID(logger *utility.Logger, idata interface{}) (external_models.Rat
 		Amount:       0.7,
 	}, nil
 }
+
+func GetRateByFromAndToCurrencies(logger *utility.Logger, idata interface{}) (external_models.Rate, error) {
+	var (
+		outBoundResponse external_models.RateResponse
+	)
+	data, ok := idata.(external_models.RateRequest)
+	if !ok {
+		logger.Error("get rate by from and to currencies", idata, "request data format error")
+		return outBoundResponse.Data, fmt.Errorf("request data format error")
+	}
+
+	logger.Info("get rate by from and to currencies", outBoundResponse)
+
+	return external_models.Rate{
+		ID:            1,
+		FromCurrency:  data.FromCurrency,
+		ToCurrency:    data.ToCurrency,
+		InitialAmount: 1,
+		Amount:        0.5,
+	}, nil
+}
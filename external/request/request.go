@@ -88,12 +88,14 @@ var (
 	UpdateWalletBalance                    string = "update_wallet_balance"
 	UpdateTransactionAmountPaid            string = "update_transaction_amount_paid"
 
-	WalletFundedNotification   string = "wallet_funded_notification"
-	WalletDebitNotification    string = "wallet_debit_notification"
-	CreateActivityLog          string = "create_activity_log"
-	PaymentInvoiceNotification string = "payment_invoice_notification"
-	TransactionUpdateStatus    string = "transaction_update_status"
-	BuyerSatisfied             string = "buyer_satisfied"
+	WalletFundedNotification        string = "wallet_funded_notification"
+	WalletDebitNotification         string = "wallet_debit_notification"
+	CreateActivityLog               string = "create_activity_log"
+	PaymentInvoiceNotification      string = "payment_invoice_notification"
+	TransactionDocumentNotification string = "transaction_document_notification"
+	DisputeNotification             string = "dispute_notification"
+	TransactionUpdateStatus         string = "transaction_update_status"
+	BuyerSatisfied                  string = "buyer_satisfied"
 
 	RaveChargeCard                       string = "rave_charge_card"
 	MonnifyReserveAccount                string = "monnify_reserve_account"
@@ -695,6 +697,28 @@ func (er ExternalRequest) SendExternalRequest(name string, data interface{}) (in
 				Logger:       er.Logger,
 			}
 			return obj.PaymentInvoiceNotification()
+		case "transaction_document_notification":
+			obj := notification.RequestObj{
+				Name:         name,
+				Path:         fmt.Sprintf("%v/v2/send/send_transaction_document", config.Microservices.Notification),
+				Method:       "POST",
+				SuccessCode:  200,
+				DecodeMethod: JsonDecodeMethod,
+				RequestData:  data,
+				Logger:       er.Logger,
+			}
+			return obj.TransactionDocumentNotification()
+		case "dispute_notification":
+			obj := notification.RequestObj{
+				Name:         name,
+				Path:         fmt.Sprintf("%v/v2/send/send_dispute_notification", config.Microservices.Notification),
+				Method:       "POST",
+				SuccessCode:  200,
+				DecodeMethod: JsonDecodeMethod,
+				RequestData:  data,
+				Logger:       er.Logger,
+			}
+			return obj.DisputeNotification()
 		case "transaction_update_status":
 			obj := transactions.RequestObj{
 				Name:         name,
@@ -14,9 +14,12 @@ type Configuration struct {
 	Monnify        Monnify
 	Appruve        Appruve
 	Rave           Rave
+	Paystack       Paystack
+	ETranzact      ETranzact
 	IPStack        IPStack
 	ONLINE_PAYMENT OnlinePayment
 	Slack          Slack
+	LegalEntity    LegalEntity
 }
 
 type BaseConfig struct {
@@ -114,6 +117,10 @@ type BaseConfig struct {
 	FLUTTERWAVE_MERCHANT_ID  string `mapstructure:"FLUTTERWAVE_MERCHANT_ID"`
 	FLUTTERWAVE_ACCOUNT_NAME string `mapstructure:"FLUTTERWAVE_ACCOUNT_NAME"`
 
+	PAYSTACK_SECRET_KEY string `mapstructure:"PAYSTACK_SECRET_KEY"`
+
+	ETRANZACT_SECRET_KEY string `mapstructure:"ETRANZACT_SECRET_KEY"`
+
 	IPSTACK_KEY      string `mapstructure:"IPSTACK_KEY"`
 	IPSTACK_BASE_URL string `mapstructure:"IPSTACK_BASE_URL"`
 
@@ -121,10 +128,17 @@ type BaseConfig struct {
 	DISBURSEMENT_CHARGE float64 `mapstructure:"DISBURSEMENT_CHARGE"`
 	NAIRA_THRESHOLD     float64 `mapstructure:"NAIRA_THRESHOLD"`
 
-	SLACK_OAUTH_TOKEN             string `mapstructure:"SLACK_OAUTH_TOKEN"`
-	SLACK_PAYMENT_CHANNELID       string `mapstructure:"SLACK_PAYMENT_CHANNELID"`
-	SLACK_DISBURSEMENTS_CHANNELID string `mapstructure:"SLACK_DISBURSEMENTS_CHANNELID"`
-	SLACK_WITHDRAWAL_CHANNELID    string `mapstructure:"SLACK_WITHDRAWAL_CHANNELID"`
+	SLACK_OAUTH_TOKEN              string `mapstructure:"SLACK_OAUTH_TOKEN"`
+	SLACK_PAYMENT_CHANNELID        string `mapstructure:"SLACK_PAYMENT_CHANNELID"`
+	SLACK_DISBURSEMENTS_CHANNELID  string `mapstructure:"SLACK_DISBURSEMENTS_CHANNELID"`
+	SLACK_WITHDRAWAL_CHANNELID     string `mapstructure:"SLACK_WITHDRAWAL_CHANNELID"`
+	SLACK_RECONCILIATION_CHANNELID string `mapstructure:"SLACK_RECONCILIATION_CHANNELID"`
+
+	LEGAL_ENTITY_CODE    string `mapstructure:"LEGAL_ENTITY_CODE"`
+	LEGAL_ENTITY_NAME    string `mapstructure:"LEGAL_ENTITY_NAME"`
+	LEGAL_ENTITY_ADDRESS string `mapstructure:"LEGAL_ENTITY_ADDRESS"`
+	LEGAL_ENTITY_TAX_ID  string `mapstructure:"LEGAL_ENTITY_TAX_ID"`
+	LEGAL_ENTITY_EMAIL   string `mapstructure:"LEGAL_ENTITY_EMAIL"`
 }
 
 func (config *BaseConfig) SetupConfigurationn() *Configuration {
@@ -238,6 +252,12 @@ func (config *BaseConfig) SetupConfigurationn() *Configuration {
 			AccountName:   config.FLUTTERWAVE_ACCOUNT_NAME,
 			WebhookSecret: config.RAVE_WEBHOOK_SECRET,
 		},
+		Paystack: Paystack{
+			SecretKey: config.PAYSTACK_SECRET_KEY,
+		},
+		ETranzact: ETranzact{
+			SecretKey: config.ETRANZACT_SECRET_KEY,
+		},
 
 		IPStack: IPStack{
 			Key:     config.IPSTACK_KEY,
@@ -250,10 +270,18 @@ func (config *BaseConfig) SetupConfigurationn() *Configuration {
 			NairaThreshold:     config.NAIRA_THRESHOLD,
 		},
 		Slack: Slack{
-			OauthToken:            config.SLACK_OAUTH_TOKEN,
-			PaymentChannelID:      config.SLACK_PAYMENT_CHANNELID,
-			DisbursementChannelID: config.SLACK_DISBURSEMENTS_CHANNELID,
-			WithdrawalChannelID:   config.SLACK_WITHDRAWAL_CHANNELID,
+			OauthToken:              config.SLACK_OAUTH_TOKEN,
+			PaymentChannelID:        config.SLACK_PAYMENT_CHANNELID,
+			DisbursementChannelID:   config.SLACK_DISBURSEMENTS_CHANNELID,
+			WithdrawalChannelID:     config.SLACK_WITHDRAWAL_CHANNELID,
+			ReconciliationChannelID: config.SLACK_RECONCILIATION_CHANNELID,
+		},
+		LegalEntity: LegalEntity{
+			Code:    config.LEGAL_ENTITY_CODE,
+			Name:    config.LEGAL_ENTITY_NAME,
+			Address: config.LEGAL_ENTITY_ADDRESS,
+			TaxID:   config.LEGAL_ENTITY_TAX_ID,
+			Email:   config.LEGAL_ENTITY_EMAIL,
 		},
 	}
 }
@@ -0,0 +1,5 @@
+package config
+
+type ETranzact struct {
+	SecretKey string
+}
@@ -0,0 +1,9 @@
+package config
+
+type LegalEntity struct {
+	Code    string
+	Name    string
+	Address string
+	TaxID   string
+	Email   string
+}
@@ -0,0 +1,5 @@
+package config
+
+type Paystack struct {
+	SecretKey string
+}
@@ -1,8 +1,9 @@
 package config
 
 type Slack struct {
-	OauthToken            string
-	PaymentChannelID      string
-	DisbursementChannelID string
-	WithdrawalChannelID   string
+	OauthToken              string
+	PaymentChannelID        string
+	DisbursementChannelID   string
+	WithdrawalChannelID     string
+	ReconciliationChannelID string
 }
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type ApprovalAction string
+
+var (
+	// ApprovalWithdrawalApprove debits a pending withdrawal and transfers it to the merchant's bank
+	ApprovalWithdrawalApprove ApprovalAction = "withdrawal_approve"
+	// ApprovalWithdrawalComplete confirms a withdrawal's transfer by hand
+	ApprovalWithdrawalComplete ApprovalAction = "withdrawal_complete"
+	// ApprovalPayoutToWallet pays merchants' unpaid transactions into their MOR_ wallets
+	ApprovalPayoutToWallet ApprovalAction = "payout_to_wallet"
+	// ApprovalPayoutBatchRetry pays out again the merchants whose lines failed in a payout batch, it follows the payout_to_wallet policy
+	ApprovalPayoutBatchRetry ApprovalAction = "payout_batch_retry"
+	// ApprovalPayoutResume carries on with a payout that hasn't settled, it follows the payout_to_wallet policy
+	ApprovalPayoutResume ApprovalAction = "payout_resume"
+)
+
+type ApprovalRequestStatus string
+
+var (
+	ApprovalRequestPending  ApprovalRequestStatus = "pending"
+	ApprovalRequestApproved ApprovalRequestStatus = "approved"
+	ApprovalRequestDeclined ApprovalRequestStatus = "declined"
+	ApprovalRequestExecuted ApprovalRequestStatus = "executed"
+	ApprovalRequestFailed   ApprovalRequestStatus = "failed"
+)
+
+type ApprovalDecisionType string
+
+var (
+	ApprovalDecisionApprove ApprovalDecisionType = "approve"
+	ApprovalDecisionDecline ApprovalDecisionType = "decline"
+)
+
+// ApprovalPolicy sets how many distinct admins must approve an action moving more than the threshold,
+// smaller amounts need one admin. A policy without currency applies to every currency without a policy of its own.
+type ApprovalPolicy struct {
+	ID                uint           `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Action            ApprovalAction `gorm:"column:action; type:varchar(255); not null; uniqueIndex:idx_approval_policies_action_currency" json:"action"`
+	Currency          string         `gorm:"column:currency; type:varchar(255); uniqueIndex:idx_approval_policies_action_currency" json:"currency"`
+	Threshold         float64        `gorm:"column:threshold; type:decimal(20,2); default: 0; comment: amounts above it need the required approvals" json:"threshold"`
+	RequiredApprovals int            `gorm:"column:required_approvals; type:int; default: 1; comment: distinct admins including the one requesting the action" json:"required_approvals"`
+	UpdatedBy         int64          `gorm:"column:updated_by; type:int" json:"updated_by"`
+	CreatedAt         time.Time      `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time      `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// ApprovalRequest is an action waiting for the approvals its policy requires, it runs once the last one is given
+type ApprovalRequest struct {
+	ID                uint                  `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Action            ApprovalAction        `gorm:"column:action; type:varchar(255); not null; index" json:"action"`
+	ResourceID        int64                 `gorm:"column:resource_id; type:int; index; comment: withdrawal, payout batch or payout id the action runs on" json:"resource_id"`
+	Payload           string                `gorm:"column:payload; type:text; comment: request the action runs with" json:"payload"`
+	Amount            float64               `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	Currency          string                `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	RequiredApprovals int                   `gorm:"column:required_approvals; type:int" json:"required_approvals"`
+	Approvals         int                   `gorm:"column:approvals; type:int; default: 0" json:"approvals"`
+	Status            ApprovalRequestStatus `gorm:"column:status; type:varchar(255); index; comment: pending, approved, declined, executed or failed" json:"status"`
+	RequestedBy       int64                 `gorm:"column:requested_by; type:int; index" json:"requested_by"`
+	Reason            string                `gorm:"column:reason; type:text" json:"reason"`
+	ExecutedAt        time.Time             `gorm:"column:executed_at" json:"executed_at"`
+	Decisions         []ApprovalDecision    `gorm:"-" json:"decisions"`
+	CreatedAt         time.Time             `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time             `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// ApprovalDecision records an admin approving or declining an approval request, decisions are never changed or deleted
+type ApprovalDecision struct {
+	ID                uint                 `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	ApprovalRequestID int64                `gorm:"column:approval_request_id; type:int; not null; uniqueIndex:idx_approval_decisions_request_admin" json:"approval_request_id"`
+	AdminID           int64                `gorm:"column:admin_id; type:int; not null; uniqueIndex:idx_approval_decisions_request_admin" json:"admin_id"`
+	Decision          ApprovalDecisionType `gorm:"column:decision; type:varchar(255); comment: approve or decline" json:"decision"`
+	Note              string               `gorm:"column:note; type:text" json:"note"`
+	CreatedAt         time.Time            `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+}
+
+type UpdateApprovalPolicyRequest struct {
+	Action            ApprovalAction `json:"action" validate:"required,oneof=withdrawal_approve withdrawal_complete payout_to_wallet"`
+	Currency          string         `json:"currency"`
+	Threshold         float64        `json:"threshold" validate:"gte=0"`
+	RequiredApprovals int            `json:"required_approvals" validate:"required,gte=1"`
+}
+
+type ApprovalDecisionRequest struct {
+	Note string `json:"note"`
+}
+
+type GetApprovalRequestsRequest struct {
+	Action string `json:"action"`
+	Status string `json:"status"`
+}
+
+func (a *ApprovalPolicy) CreateApprovalPolicy(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &a)
+	if err != nil {
+		return fmt.Errorf("approval policy creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (a *ApprovalPolicy) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &a)
+	return err
+}
+
+func (a *ApprovalPolicy) GetApprovalPolicyByActionAndCurrency(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &a, "action = ? and upper(currency) = upper(?)", a.Action, a.Currency)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (a *ApprovalPolicy) GetApprovalPolicies(db *gorm.DB) ([]ApprovalPolicy, error) {
+	details := []ApprovalPolicy{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "")
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (a *ApprovalRequest) CreateApprovalRequest(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &a)
+	if err != nil {
+		return fmt.Errorf("approval request creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (a *ApprovalRequest) GetApprovalRequestByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &a, "id = ?", a.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// LockApprovalRequest reads the approval request and locks it until the transaction ends, so decisions on it are taken one at a time
+func (a *ApprovalRequest) LockApprovalRequest(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDbForUpdate(db, &a, "id = ?", a.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// GetPendingApprovalRequestByResource returns the pending approval request of the action on the resource
+func (a *ApprovalRequest) GetPendingApprovalRequestByResource(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &a, "action = ? and resource_id = ? and status = ?", a.Action, a.ResourceID, ApprovalRequestPending)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (a *ApprovalRequest) GetApprovalRequests(db *gorm.DB, paginator postgresql.Pagination) ([]ApprovalRequest, postgresql.PaginationResponse, error) {
+	var (
+		details = []ApprovalRequest{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if a.Action != "" {
+		query = addQuery(query, "action = ?", "and")
+		args = append(args, a.Action)
+	}
+
+	if a.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, a.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// UpdateApprovalRequestStatus applies the updates when the approval request is still in from, it returns false when it moved on meanwhile
+func (a *ApprovalRequest) UpdateApprovalRequestStatus(db *gorm.DB, from ApprovalRequestStatus, updates map[string]interface{}) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &ApprovalRequest{}, updates, "id = ? and status = ?", a.ID, from)
+	if err != nil {
+		return false, fmt.Errorf("approval request update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+func (a *ApprovalDecision) CreateApprovalDecision(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &a)
+	if err != nil {
+		return fmt.Errorf("approval decision creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (a *ApprovalDecision) GetApprovalDecisionsByRequestID(db *gorm.DB) ([]ApprovalDecision, error) {
+	details := []ApprovalDecision{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "approval_request_id = ?", a.ApprovalRequestID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// CountApprovals counts the distinct admins who approved the approval request
+func (a *ApprovalDecision) CountApprovals(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&ApprovalDecision{}).Where("approval_request_id = ? and decision = ?", a.ApprovalRequestID, ApprovalDecisionApprove).Distinct("admin_id").Count(&count).Error
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
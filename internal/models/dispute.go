@@ -0,0 +1,315 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type DisputeStatus string
+
+var (
+	DisputeOpened           DisputeStatus = "opened"
+	DisputeEvidenceRequired DisputeStatus = "evidence_required"
+	DisputeSubmitted        DisputeStatus = "submitted"
+	DisputeWon              DisputeStatus = "won"
+	DisputeLost             DisputeStatus = "lost"
+)
+
+// DisputeOpenStatuses are the statuses of disputes the provider hasn't decided yet, their funds stay held
+var DisputeOpenStatuses = []DisputeStatus{DisputeOpened, DisputeEvidenceRequired, DisputeSubmitted}
+
+// DisputeTransitions are the statuses a dispute can move to from each status, won and lost are final
+var DisputeTransitions = map[DisputeStatus][]DisputeStatus{
+	DisputeOpened:           {DisputeEvidenceRequired, DisputeSubmitted, DisputeWon, DisputeLost},
+	DisputeEvidenceRequired: {DisputeSubmitted, DisputeWon, DisputeLost},
+	DisputeSubmitted:        {DisputeEvidenceRequired, DisputeWon, DisputeLost},
+}
+
+type DisputeHoldMethod string
+
+var (
+	// DisputeHoldWallet debits the disputed amount from the MOR_ wallet of a transaction already paid out
+	DisputeHoldWallet DisputeHoldMethod = "wallet"
+	// DisputeHoldPayout keeps a transaction not paid out yet out of payouts
+	DisputeHoldPayout DisputeHoldMethod = "payout"
+	// DisputeHoldNone is a paid out transaction whose MOR_ wallet couldn't cover the disputed amount
+	DisputeHoldNone DisputeHoldMethod = "none"
+)
+
+type DisputeHoldStatus string
+
+var (
+	// DisputeHoldPending is a wallet hold recorded with its dispute, the MOR_ wallet is debited once the dispute is saved
+	DisputeHoldPending DisputeHoldStatus = "pending"
+	DisputeHoldHeld    DisputeHoldStatus = "held"
+	// DisputeHoldReleasing is the hold of a won dispute, the held amount is credited back to the MOR_ wallet once the decision is saved
+	DisputeHoldReleasing DisputeHoldStatus = "releasing"
+	DisputeHoldReleased  DisputeHoldStatus = "released"
+)
+
+func (s DisputeStatus) In(statuses []DisputeStatus) bool {
+	for _, v := range statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispute is a chargeback raised by the customer's bank against a transaction
+type Dispute struct {
+	ID                uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	TransactionID     int64             `gorm:"column:transaction_id; type:int; not null; index" json:"transaction_id"`
+	MerchantID        int64             `gorm:"column:merchant_id; type:int; index" json:"merchant_id"`
+	CountryID         int64             `gorm:"column:country_id; type:int" json:"country_id"`
+	Provider          string            `gorm:"column:provider; type:varchar(255); uniqueIndex:idx_disputes_provider_reference" json:"provider"`
+	ProviderReference string            `gorm:"column:provider_reference; type:varchar(255); uniqueIndex:idx_disputes_provider_reference; comment: chargeback id at the provider" json:"provider_reference"`
+	Reason            string            `gorm:"column:reason; type:varchar(255)" json:"reason"`
+	Amount            float64           `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	Status            DisputeStatus     `gorm:"column:status; type:varchar(255); index; comment: opened, evidence_required, submitted, won or lost" json:"status"`
+	HoldMethod        DisputeHoldMethod `gorm:"column:hold_method; type:varchar(255); comment: wallet, payout or none" json:"hold_method"`
+	HeldAmount        float64           `gorm:"column:held_amount; type:decimal(20,2); default: 0; comment: debited from the MOR_ wallet while the dispute is open" json:"held_amount"`
+	HoldStatus        DisputeHoldStatus `gorm:"column:hold_status; type:varchar(255); index; comment: pending, held, releasing or released, for wallet holds" json:"hold_status"`
+	EvidenceDueAt     time.Time         `gorm:"column:evidence_due_at; comment: last day to submit evidence to the provider" json:"evidence_due_at"`
+	LastReminderAt    time.Time         `gorm:"column:last_reminder_at" json:"last_reminder_at"`
+	SubmittedAt       time.Time         `gorm:"column:submitted_at" json:"submitted_at"`
+	ResolvedAt        time.Time         `gorm:"column:resolved_at" json:"resolved_at"`
+	Note              string            `gorm:"column:note; type:text" json:"note"`
+	Evidence          []DisputeEvidence `gorm:"-" json:"evidence"`
+	CreatedAt         time.Time         `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time         `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type DisputeEvidence struct {
+	ID          uint      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	DisputeID   int64     `gorm:"column:dispute_id; type:int; not null; index" json:"dispute_id"`
+	FileName    string    `gorm:"column:file_name; type:varchar(255)" json:"file_name"`
+	FileUrl     string    `gorm:"column:file_url; type:varchar(255)" json:"file_url"`
+	Description string    `gorm:"column:description; type:text" json:"description"`
+	CreatedAt   time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+}
+
+type OpenDisputeRequest struct {
+	TransactionID     int64   `json:"transaction_id" validate:"required"`
+	Amount            float64 `json:"amount" validate:"gte=0"`
+	Reason            string  `json:"reason" validate:"required"`
+	Provider          string  `json:"provider"`
+	ProviderReference string  `json:"provider_reference"`
+	EvidenceDueAt     int     `json:"evidence_due_at"`
+}
+
+type UpdateDisputeStatusRequest struct {
+	Status DisputeStatus `json:"status" validate:"required,oneof=evidence_required submitted won lost"`
+	Note   string        `json:"note"`
+}
+
+type GetDisputesRequest struct {
+	AccountID     int    `json:"account_id"`
+	TransactionID int    `json:"transaction_id"`
+	Status        string `json:"status"`
+}
+
+func (d *Dispute) CreateDispute(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &d)
+	if err != nil {
+		return fmt.Errorf("dispute creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (d *Dispute) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &d)
+	return err
+}
+
+func (d *Dispute) GetDisputeByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &d, "id = ?", d.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (d *Dispute) GetDisputeByProviderReference(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &d, "provider = ? and provider_reference = ?", d.Provider, d.ProviderReference)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// CountOpenDisputesByTransactionID counts the transaction's disputes not decided yet
+func (d *Dispute) CountOpenDisputesByTransactionID(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&Dispute{}).Where("transaction_id = ? and status in (?)", d.TransactionID, DisputeOpenStatuses).Count(&count).Error
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func (d *Dispute) GetDisputes(db *gorm.DB, paginator postgresql.Pagination) ([]Dispute, postgresql.PaginationResponse, error) {
+	var (
+		details = []Dispute{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if d.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, d.MerchantID)
+	}
+
+	if d.TransactionID != 0 {
+		query = addQuery(query, "transaction_id = ?", "and")
+		args = append(args, d.TransactionID)
+	}
+
+	if d.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, d.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// UpdateDisputeStatus moves the dispute to status when it is still in from, it returns false when the dispute moved on meanwhile
+func (d *Dispute) UpdateDisputeStatus(db *gorm.DB, from DisputeStatus, updates map[string]interface{}) (bool, error) {
+	updates["updated_at"] = time.Now()
+	updated, err := postgresql.UpdateFieldsWhere(db, &Dispute{}, updates, "id = ? and status = ?", d.ID, from)
+	if err != nil {
+		return false, fmt.Errorf("dispute status update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetDisputesToRemind returns open disputes waiting on evidence due before dueBefore that weren't reminded since remindedBefore
+func (d *Dispute) GetDisputesToRemind(db *gorm.DB, now time.Time, dueBefore time.Time, remindedBefore time.Time) ([]Dispute, error) {
+	details := []Dispute{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "evidence_due_at", "asc", &details, "status in (?) and evidence_due_at > ? and evidence_due_at <= ? and (last_reminder_at is null or last_reminder_at < ?)",
+		[]DisputeStatus{DisputeOpened, DisputeEvidenceRequired}, now, dueBefore, remindedBefore)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ClaimDisputeReminder marks the reminder as sent so only one run sends it, it returns false when another run sent it first
+func (d *Dispute) ClaimDisputeReminder(db *gorm.DB, remindedBefore time.Time) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &Dispute{}, map[string]interface{}{
+		"last_reminder_at": time.Now(),
+	}, "id = ? and (last_reminder_at is null or last_reminder_at < ?)", d.ID, remindedBefore)
+	if err != nil {
+		return false, fmt.Errorf("dispute reminder claim failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetDisputesToSettle returns the disputes whose wallet hold is still to be debited or released that weren't touched since updatedBefore, oldest first.
+// A dispute with a merchant only returns the merchant's disputes.
+func (d *Dispute) GetDisputesToSettle(db *gorm.DB, updatedBefore time.Time) ([]Dispute, error) {
+	var (
+		details = []Dispute{}
+		query   = "hold_status in (?) and updated_at <= ?"
+		args    = []interface{}{[]DisputeHoldStatus{DisputeHoldPending, DisputeHoldReleasing}, updatedBefore}
+	)
+
+	if d.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, d.MerchantID)
+	}
+
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, query, args...)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// UpdateDisputeHold moves the wallet hold of the dispute on when it is still in from and the dispute in one of statuses,
+// it returns false when the hold or the dispute moved on meanwhile
+func (d *Dispute) UpdateDisputeHold(db *gorm.DB, from DisputeHoldStatus, statuses []DisputeStatus, updates map[string]interface{}) (bool, error) {
+	updates["updated_at"] = time.Now()
+	updated, err := postgresql.UpdateFieldsWhere(db, &Dispute{}, updates, "id = ? and hold_status = ? and status in (?)", d.ID, from, statuses)
+	if err != nil {
+		return false, fmt.Errorf("dispute hold update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetWalletHoldTotals sums the disputed amounts debited from the MOR_ wallet and not released per merchant and country
+func (d *Dispute) GetWalletHoldTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "hold_method = ? and (status <> ? or hold_status = ?) and coalesce(hold_status, '') <> ?"
+		args    = []interface{}{DisputeHoldWallet, DisputeWon, DisputeHoldReleasing, DisputeHoldReleased}
+	)
+
+	if d.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, d.MerchantID)
+	}
+
+	err := db.Model(&Dispute{}).Select("merchant_id, country_id, SUM(held_amount) as amount").Where(query, args...).Group("merchant_id, country_id").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+func (d *DisputeEvidence) CreateDisputeEvidence(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &d)
+	if err != nil {
+		return fmt.Errorf("dispute evidence creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (d *DisputeEvidence) GetDisputeEvidence(db *gorm.DB) ([]DisputeEvidence, error) {
+	details := []DisputeEvidence{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "dispute_id = ?", d.DisputeID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetStatementDisputes returns the merchant's disputes in the country opened or decided between start and end, end excluded
+func (d *Dispute) GetStatementDisputes(db *gorm.DB, start time.Time, end time.Time) ([]Dispute, error) {
+	details := []Dispute{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "merchant_id = ? and country_id = ? and ((created_at >= ? and created_at < ?) or (status in (?) and resolved_at >= ? and resolved_at < ?))",
+		d.MerchantID, d.CountryID, start, end, []DisputeStatus{DisputeWon, DisputeLost}, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetWalletHeldTotal sums what the merchant's disputes in the country held from the MOR_ wallet before the given time, less what won disputes released before it
+func (d *Dispute) GetWalletHeldTotal(db *gorm.DB, before time.Time) (float64, error) {
+	var total float64
+	err := db.Model(&Dispute{}).Select("COALESCE(SUM(held_amount), 0)").Where("merchant_id = ? and country_id = ? and held_amount > 0 and created_at < ? and not (status = ? and resolved_at < ?)",
+		d.MerchantID, d.CountryID, before, DisputeWon, before).Scan(&total).Error
+	if err != nil {
+		return total, err
+	}
+	return total, nil
+}
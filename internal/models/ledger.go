@@ -0,0 +1,235 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type LedgerAccount string
+
+var (
+	// assets and expenses, their balance grows with debits
+	LedgerProviderClearing LedgerAccount = "provider_clearing"
+	LedgerSettlementBank   LedgerAccount = "settlement_bank"
+	LedgerFeeExpense       LedgerAccount = "processing_fee_expense"
+	LedgerTaxExpense       LedgerAccount = "tax_expense"
+	// LedgerFxClearing takes payouts converted into another currency in their own currency and gives them out in the settlement currency
+	LedgerFxClearing LedgerAccount = "fx_clearing"
+	// liabilities, their balance grows with credits
+	LedgerMerchantPending LedgerAccount = "merchant_pending"
+	LedgerMerchantReserve LedgerAccount = "merchant_reserve"
+	LedgerMerchantWallet  LedgerAccount = "merchant_wallet"
+	LedgerDisputeHold     LedgerAccount = "merchant_dispute_hold"
+	LedgerTaxPayable      LedgerAccount = "tax_payable"
+	// income, its balance grows with credits
+	LedgerFxFeeIncome LedgerAccount = "fx_fee_income"
+)
+
+// MerchantLedgerAccounts are the accounts that hold money owed to the merchant
+var MerchantLedgerAccounts = []LedgerAccount{LedgerMerchantPending, LedgerMerchantReserve, LedgerMerchantWallet, LedgerDisputeHold}
+
+type JournalEntryType string
+
+var (
+	JournalTransactionCapture  JournalEntryType = "transaction_capture"
+	JournalTransactionReversal JournalEntryType = "transaction_reversal"
+	JournalProcessingFee       JournalEntryType = "processing_fee"
+	JournalTax                 JournalEntryType = "tax"
+	JournalPayout              JournalEntryType = "payout"
+	JournalPayoutDeduction     JournalEntryType = "payout_deduction"
+	JournalReserveHold         JournalEntryType = "reserve_hold"
+	JournalReserveRelease      JournalEntryType = "reserve_release"
+	JournalWithdrawal          JournalEntryType = "withdrawal"
+	JournalWithdrawalReversal  JournalEntryType = "withdrawal_reversal"
+	JournalRefund              JournalEntryType = "refund"
+	JournalDisputeHold         JournalEntryType = "dispute_hold"
+	JournalDisputeRelease      JournalEntryType = "dispute_release"
+	JournalChargeback          JournalEntryType = "chargeback"
+	JournalFxConversion        JournalEntryType = "fx_conversion"
+)
+
+// JournalEntry groups postings that move money together, entries are never updated or deleted
+type JournalEntry struct {
+	ID          uint             `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Reference   string           `gorm:"column:reference; type:varchar(255); not null; uniqueIndex; comment: source record and entry type, e.g. transaction:12:capture" json:"reference"`
+	EntryType   JournalEntryType `gorm:"column:entry_type; type:varchar(255)" json:"entry_type"`
+	MerchantID  int64            `gorm:"column:merchant_id; type:int; index" json:"merchant_id"`
+	Currency    string           `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	Description string           `gorm:"column:description; type:varchar(255)" json:"description"`
+	CreatedAt   time.Time        `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+}
+
+type LedgerPosting struct {
+	ID             uint             `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	JournalEntryID int64            `gorm:"column:journal_entry_id; type:int; not null; index" json:"journal_entry_id"`
+	EntryType      JournalEntryType `gorm:"column:entry_type; type:varchar(255)" json:"entry_type"`
+	Account        LedgerAccount    `gorm:"column:account; type:varchar(255); not null; index:idx_ledger_postings_account_merchant_currency" json:"account"`
+	MerchantID     int64            `gorm:"column:merchant_id; type:int; index:idx_ledger_postings_account_merchant_currency" json:"merchant_id"`
+	Currency       string           `gorm:"column:currency; type:varchar(255); not null; index:idx_ledger_postings_account_merchant_currency" json:"currency"`
+	Debit          float64          `gorm:"column:debit; type:decimal(20,2); default: 0" json:"debit"`
+	Credit         float64          `gorm:"column:credit; type:decimal(20,2); default: 0" json:"credit"`
+	Description    string           `gorm:"column:description; type:varchar(255)" json:"description"`
+	CreatedAt      time.Time        `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+}
+
+type LedgerBalance struct {
+	Account  LedgerAccount `gorm:"column:account" json:"account"`
+	Currency string        `gorm:"column:currency" json:"currency"`
+	Debit    float64       `gorm:"column:debit" json:"debit"`
+	Credit   float64       `gorm:"column:credit" json:"credit"`
+	Balance  float64       `gorm:"-" json:"balance"`
+}
+
+type LedgerCheck struct {
+	Balanced          bool            `json:"balanced"`
+	Currencies        []LedgerBalance `json:"currencies"`
+	UnbalancedEntries []int64         `json:"unbalanced_entries"`
+}
+
+type GetLedgerRequest struct {
+	Account   string `json:"account"`
+	AccountID int    `json:"account_id"`
+	Currency  string `json:"currency"`
+	FromTime  int    `json:"from_time"`
+	ToTime    int    `json:"to_time"`
+}
+
+// IsCreditNormal reports whether the account's balance grows with credits
+func (a LedgerAccount) IsCreditNormal() bool {
+	return a == LedgerMerchantPending || a == LedgerMerchantReserve || a == LedgerMerchantWallet || a == LedgerTaxPayable
+}
+
+func (a LedgerAccount) In(accounts []LedgerAccount) bool {
+	for _, v := range accounts {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateJournalEntryIfNotExists returns false when an entry with the same reference was already written
+func (j *JournalEntry) CreateJournalEntryIfNotExists(db *gorm.DB) (bool, error) {
+	created, err := postgresql.CreateOneRecordIfNotExists(db, &j)
+	if err != nil {
+		return false, fmt.Errorf("journal entry creation failed: %v", err.Error())
+	}
+	return created, nil
+}
+
+func (j *JournalEntry) GetJournalEntryByReference(db *gorm.DB) (bool, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &j, "reference = ?", j.Reference)
+	if nilErr != nil {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *LedgerPosting) CreateLedgerPosting(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &l)
+	if err != nil {
+		return fmt.Errorf("ledger posting creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+// GetLedgerBalances sums postings per account and currency, filtered by whichever of account, merchant and currency are set
+func (l *LedgerPosting) GetLedgerBalances(db *gorm.DB) ([]LedgerBalance, error) {
+	var (
+		details = []LedgerBalance{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if l.Account != "" {
+		query = addQuery(query, "account = ?", "and")
+		args = append(args, l.Account)
+	}
+
+	if l.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, l.MerchantID)
+	}
+
+	if l.Currency != "" {
+		query = addQuery(query, "currency = ?", "and")
+		args = append(args, l.Currency)
+	}
+
+	err := db.Model(&LedgerPosting{}).Select("account, currency, SUM(debit) as debit, SUM(credit) as credit").Where(query, args...).Group("account, currency").Order("account, currency").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	for i, d := range details {
+		if d.Account.IsCreditNormal() {
+			details[i].Balance = d.Credit - d.Debit
+		} else {
+			details[i].Balance = d.Debit - d.Credit
+		}
+	}
+
+	return details, nil
+}
+
+func (l *LedgerPosting) GetLedgerStatement(db *gorm.DB, paginator postgresql.Pagination, from int, to int) ([]LedgerPosting, postgresql.PaginationResponse, error) {
+	var (
+		details = []LedgerPosting{}
+		query   = "account = ? and currency = ?"
+		args    = []interface{}{l.Account, l.Currency}
+	)
+
+	if l.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, l.MerchantID)
+	}
+
+	if from != 0 {
+		query = addQuery(query, "created_at >= ?", "and")
+		args = append(args, time.Unix(int64(from), 0))
+	}
+
+	if to != 0 {
+		query = addQuery(query, "created_at <= ?", "and")
+		args = append(args, time.Unix(int64(to), 0))
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// GetLedgerTotals sums every posting per currency, debits and credits must match
+func (l *LedgerPosting) GetLedgerTotals(db *gorm.DB) ([]LedgerBalance, error) {
+	details := []LedgerBalance{}
+	err := db.Model(&LedgerPosting{}).Select("currency, SUM(debit) as debit, SUM(credit) as credit").Group("currency").Order("currency").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	for i, d := range details {
+		details[i].Balance = d.Debit - d.Credit
+	}
+
+	return details, nil
+}
+
+// GetUnbalancedJournalEntryIDs returns the journal entries whose postings don't net to zero
+func (l *LedgerPosting) GetUnbalancedJournalEntryIDs(db *gorm.DB) ([]int64, error) {
+	ids := []int64{}
+	err := db.Model(&LedgerPosting{}).Select("journal_entry_id").Group("journal_entry_id").Having("SUM(debit) <> SUM(credit)").Order("journal_entry_id").Pluck("journal_entry_id", &ids).Error
+	if err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type MerchantStatementLineKind string
+
+var (
+	MerchantStatementLineTransaction MerchantStatementLineKind = "transaction"
+	MerchantStatementLinePayout      MerchantStatementLineKind = "payout"
+	MerchantStatementLineWithdrawal  MerchantStatementLineKind = "withdrawal"
+	MerchantStatementLineRefund      MerchantStatementLineKind = "refund"
+	// dispute holds and releases move the MOR_ wallet, a chargeback only records the lost dispute
+	MerchantStatementLineDisputeHold    MerchantStatementLineKind = "dispute_hold"
+	MerchantStatementLineDisputeRelease MerchantStatementLineKind = "dispute_release"
+	MerchantStatementLineChargeback     MerchantStatementLineKind = "chargeback"
+)
+
+// MerchantStatement explains the movements of a merchant's MOR_ wallet in one currency over a period.
+// Statements of past months are generated and stored by the month-end cronjob, any other period is worked out when it is read.
+type MerchantStatement struct {
+	ID                 uint                    `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID         int64                   `gorm:"column:merchant_id; type:int; not null; uniqueIndex:idx_merchant_statements_merchant_currency_period" json:"merchant_id"`
+	CountryID          int64                   `gorm:"column:country_id; type:int" json:"country_id"`
+	Currency           string                  `gorm:"column:currency; type:varchar(255); not null; uniqueIndex:idx_merchant_statements_merchant_currency_period" json:"currency"`
+	Period             string                  `gorm:"column:period; type:varchar(255); not null; uniqueIndex:idx_merchant_statements_merchant_currency_period; comment: 2024, 2024-Q1 or 2024-01" json:"period"`
+	PeriodStart        time.Time               `gorm:"column:period_start" json:"period_start"`
+	PeriodEnd          time.Time               `gorm:"column:period_end; comment: exclusive" json:"period_end"`
+	OpeningBalance     float64                 `gorm:"column:opening_balance; type:decimal(20,2)" json:"opening_balance"`
+	TransactionsCount  int                     `gorm:"column:transactions_count; type:int" json:"transactions_count"`
+	TransactionsAmount float64                 `gorm:"column:transactions_amount; type:decimal(20,2); comment: transactions captured in the period, refunded or reversed ones included" json:"transactions_amount"`
+	ProcessingFee      float64                 `gorm:"column:processing_fee; type:decimal(20,2)" json:"processing_fee"`
+	TaxFee             float64                 `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	PayoutsCount       int                     `gorm:"column:payouts_count; type:int" json:"payouts_count"`
+	PayoutsAmount      float64                 `gorm:"column:payouts_amount; type:decimal(20,2); comment: credited to the MOR_ wallet" json:"payouts_amount"`
+	WithdrawalsCount   int                     `gorm:"column:withdrawals_count; type:int" json:"withdrawals_count"`
+	WithdrawalsAmount  float64                 `gorm:"column:withdrawals_amount; type:decimal(20,2)" json:"withdrawals_amount"`
+	RefundsCount       int                     `gorm:"column:refunds_count; type:int" json:"refunds_count"`
+	RefundsAmount      float64                 `gorm:"column:refunds_amount; type:decimal(20,2); comment: refunds recovered from the MOR_ wallet" json:"refunds_amount"`
+	ChargebacksCount   int                     `gorm:"column:chargebacks_count; type:int" json:"chargebacks_count"`
+	ChargebacksAmount  float64                 `gorm:"column:chargebacks_amount; type:decimal(20,2); comment: disputes lost in the period" json:"chargebacks_amount"`
+	DisputeHoldsAmount float64                 `gorm:"column:dispute_holds_amount; type:decimal(20,2); comment: held from the MOR_ wallet for disputes less what won disputes released" json:"dispute_holds_amount"`
+	ClosingBalance     float64                 `gorm:"column:closing_balance; type:decimal(20,2); comment: opening balance plus payouts less withdrawals, refunds and dispute holds" json:"closing_balance"`
+	GeneratedAt        time.Time               `gorm:"column:generated_at" json:"generated_at"`
+	Lines              []MerchantStatementLine `gorm:"-" json:"lines"`
+	CreatedAt          time.Time               `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time               `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type MerchantStatementLine struct {
+	ID            uint                      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	StatementID   int64                     `gorm:"column:statement_id; type:int; not null; index" json:"statement_id"`
+	Kind          MerchantStatementLineKind `gorm:"column:kind; type:varchar(255); comment: transaction, payout, withdrawal, refund, dispute_hold, dispute_release or chargeback" json:"kind"`
+	SourceID      int64                     `gorm:"column:source_id; type:int; comment: id of the transaction, payout, withdrawal, refund or dispute" json:"source_id"`
+	Reference     string                    `gorm:"column:reference; type:varchar(255)" json:"reference"`
+	Date          time.Time                 `gorm:"column:date" json:"date"`
+	Amount        float64                   `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	ProcessingFee float64                   `gorm:"column:processing_fee; type:decimal(20,2)" json:"processing_fee"`
+	TaxFee        float64                   `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	WalletAmount  float64                   `gorm:"column:wallet_amount; type:decimal(20,2); comment: credit or debit to the MOR_ wallet, 0 for transactions and chargebacks" json:"wallet_amount"`
+	Balance       float64                   `gorm:"column:balance; type:decimal(20,2); comment: MOR_ wallet balance after the line" json:"balance"`
+}
+
+// MerchantStatementAccount is a merchant and country a statement is generated for
+type MerchantStatementAccount struct {
+	MerchantID int64 `gorm:"column:merchant_id"`
+	CountryID  int64 `gorm:"column:country_id"`
+}
+
+type GetMerchantStatementRequest struct {
+	AccountID int    `json:"account_id"`
+	Currency  string `json:"currency" validate:"required"`
+	Period    string `json:"period" validate:"required"`
+	Format    string `json:"format" validate:"omitempty,oneof=json csv pdf"`
+}
+
+type GetMerchantStatementsRequest struct {
+	AccountID int    `json:"account_id"`
+	Currency  string `json:"currency"`
+	Period    string `json:"period"`
+}
+
+func (m *MerchantStatement) CreateMerchantStatement(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &m)
+	if err != nil {
+		return fmt.Errorf("merchant statement creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (m *MerchantStatement) GetMerchantStatementByMerchantCurrencyAndPeriod(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &m, "merchant_id = ? and currency = ? and period = ?", m.MerchantID, m.Currency, m.Period)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (m *MerchantStatement) GetMerchantStatements(db *gorm.DB, paginator postgresql.Pagination) ([]MerchantStatement, postgresql.PaginationResponse, error) {
+	var (
+		details = []MerchantStatement{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if m.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, m.MerchantID)
+	}
+
+	if m.Currency != "" {
+		query = addQuery(query, "currency = ?", "and")
+		args = append(args, m.Currency)
+	}
+
+	if m.Period != "" {
+		query = addQuery(query, "period = ?", "and")
+		args = append(args, m.Period)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+func (m *MerchantStatementLine) GetMerchantStatementLines(db *gorm.DB) ([]MerchantStatementLine, error) {
+	details := []MerchantStatementLine{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "statement_id = ?", m.StatementID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func CreateMerchantStatementLines(db *gorm.DB, lines []MerchantStatementLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	err := postgresql.CreateMultipleRecords(db, &lines, 500, len(lines))
+	if err != nil {
+		return fmt.Errorf("merchant statement lines creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+// GetMerchantStatementAccounts returns the merchants and countries with a MOR_ wallet balance or transactions by the end of the period
+func GetMerchantStatementAccounts(db *gorm.DB, start time.Time, end time.Time) ([]MerchantStatementAccount, error) {
+	details := []MerchantStatementAccount{}
+	err := db.Raw(`SELECT merchant_id, country_id FROM payouts WHERE status in (?) and created_at < ?
+		UNION SELECT merchant_id, country_id FROM transactions WHERE status in (?) and transaction_date >= ? and transaction_date < ?
+		ORDER BY merchant_id, country_id`,
+		PayoutCreditedStatuses, end, TaxSaleStatuses, start, end).Scan(&details).Error
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
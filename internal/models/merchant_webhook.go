@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type MerchantWebhookEvent string
+
+var (
+	MerchantWebhookTransactionRecorded MerchantWebhookEvent = "transaction.recorded"
+	MerchantWebhookPayoutCompleted     MerchantWebhookEvent = "payout.completed"
+	MerchantWebhookWithdrawalRequested MerchantWebhookEvent = "withdrawal.requested"
+	MerchantWebhookWithdrawalCompleted MerchantWebhookEvent = "withdrawal.completed"
+	MerchantWebhookWithdrawalRejected  MerchantWebhookEvent = "withdrawal.rejected"
+	MerchantWebhookWithdrawalFailed    MerchantWebhookEvent = "withdrawal.failed"
+	MerchantWebhookPing                MerchantWebhookEvent = "ping"
+)
+
+// MerchantWebhookEvents are the events merchants can subscribe an endpoint to
+var MerchantWebhookEvents = []MerchantWebhookEvent{
+	MerchantWebhookTransactionRecorded,
+	MerchantWebhookPayoutCompleted,
+	MerchantWebhookWithdrawalRequested,
+	MerchantWebhookWithdrawalCompleted,
+	MerchantWebhookWithdrawalRejected,
+	MerchantWebhookWithdrawalFailed,
+}
+
+type MerchantWebhookDeliveryStatus string
+
+var (
+	MerchantWebhookDeliveryPending    MerchantWebhookDeliveryStatus = "pending"
+	MerchantWebhookDeliveryProcessing MerchantWebhookDeliveryStatus = "processing"
+	MerchantWebhookDeliveryRetrying   MerchantWebhookDeliveryStatus = "retrying"
+	MerchantWebhookDeliveryDelivered  MerchantWebhookDeliveryStatus = "delivered"
+	MerchantWebhookDeliveryFailed     MerchantWebhookDeliveryStatus = "failed"
+)
+
+type MerchantWebhookEndpoint struct {
+	ID         uint                   `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID int64                  `gorm:"column:merchant_id; type:int; not null; index" json:"merchant_id"`
+	URL        string                 `gorm:"column:url; type:varchar(255); not null" json:"url"`
+	Secret     string                 `gorm:"column:secret; type:varchar(255); not null; comment: HMAC-SHA256 key for the X-Mor-Signature header" json:"secret"`
+	Events     []MerchantWebhookEvent `gorm:"column:events;serializer:json" json:"events"`
+	IsActive   bool                   `gorm:"column:is_active; default:true" json:"is_active"`
+	CreatedAt  time.Time              `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time              `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type MerchantWebhookDelivery struct {
+	ID            uint                          `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	EndpointID    int64                         `gorm:"column:endpoint_id; type:int; not null; index" json:"endpoint_id"`
+	MerchantID    int64                         `gorm:"column:merchant_id; type:int; not null; index" json:"merchant_id"`
+	Event         MerchantWebhookEvent          `gorm:"column:event; type:varchar(255)" json:"event"`
+	Payload       string                        `gorm:"column:payload; type:text" json:"payload"`
+	Status        MerchantWebhookDeliveryStatus `gorm:"column:status; type:varchar(255); index; comment: pending, processing, retrying, delivered or failed" json:"status"`
+	Attempts      int                           `gorm:"column:attempts; type:int; default: 0" json:"attempts"`
+	ResponseCode  int                           `gorm:"column:response_code; type:int" json:"response_code"`
+	ResponseBody  string                        `gorm:"column:response_body; type:text" json:"response_body"`
+	LastError     string                        `gorm:"column:last_error; type:text" json:"last_error"`
+	NextAttemptAt time.Time                     `gorm:"column:next_attempt_at" json:"next_attempt_at"`
+	DeliveredAt   time.Time                     `gorm:"column:delivered_at" json:"delivered_at"`
+	CreatedAt     time.Time                     `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time                     `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type MerchantWebhookPayload struct {
+	ID        uint                 `json:"id"`
+	Event     MerchantWebhookEvent `json:"event"`
+	CreatedAt time.Time            `json:"created_at"`
+	Data      interface{}          `json:"data"`
+}
+
+type CreateMerchantWebhookEndpointRequest struct {
+	URL    string                 `json:"url" validate:"required,url,startswith=https://"`
+	Events []MerchantWebhookEvent `json:"events" validate:"required,min=1"`
+}
+
+type GetMerchantWebhookDeliveriesRequest struct {
+	EndpointID int    `json:"endpoint_id"`
+	Event      string `json:"event"`
+	Status     string `json:"status"`
+}
+
+func (e MerchantWebhookEvent) In(events []MerchantWebhookEvent) bool {
+	for _, v := range events {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MerchantWebhookEndpoint) CreateMerchantWebhookEndpoint(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &m)
+	if err != nil {
+		return fmt.Errorf("merchant webhook endpoint creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (m *MerchantWebhookEndpoint) GetMerchantWebhookEndpointByIDAndMerchantID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &m, "id = ? and merchant_id = ?", m.ID, m.MerchantID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (m *MerchantWebhookEndpoint) GetMerchantWebhookEndpointByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &m, "id = ?", m.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (m *MerchantWebhookEndpoint) GetMerchantWebhookEndpointsByMerchantID(db *gorm.DB) ([]MerchantWebhookEndpoint, error) {
+	details := []MerchantWebhookEndpoint{}
+	err := postgresql.SelectAllFromDb(db, "desc", &details, "merchant_id = ?", m.MerchantID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (m *MerchantWebhookEndpoint) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &m)
+	return err
+}
+
+func (m *MerchantWebhookEndpoint) Delete(db *gorm.DB) error {
+	err := postgresql.DeleteRecordFromDb(db, &m)
+	if err != nil {
+		return fmt.Errorf("merchant webhook endpoint deletion failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (m *MerchantWebhookDelivery) CreateMerchantWebhookDelivery(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &m)
+	if err != nil {
+		return fmt.Errorf("merchant webhook delivery creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (m *MerchantWebhookDelivery) GetMerchantWebhookDeliveryByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &m, "id = ?", m.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (m *MerchantWebhookDelivery) GetMerchantWebhookDeliveries(db *gorm.DB, paginator postgresql.Pagination) ([]MerchantWebhookDelivery, postgresql.PaginationResponse, error) {
+	var (
+		details = []MerchantWebhookDelivery{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if m.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, m.MerchantID)
+	}
+
+	if m.EndpointID != 0 {
+		query = addQuery(query, "endpoint_id = ?", "and")
+		args = append(args, m.EndpointID)
+	}
+
+	if m.Event != "" {
+		query = addQuery(query, "event = ?", "and")
+		args = append(args, m.Event)
+	}
+
+	if m.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, m.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// GetDueMerchantWebhookDeliveries returns deliveries waiting for an attempt, including ones left in processing since staleBefore
+func (m *MerchantWebhookDelivery) GetDueMerchantWebhookDeliveries(db *gorm.DB, staleBefore time.Time) ([]MerchantWebhookDelivery, error) {
+	details := []MerchantWebhookDelivery{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "(status in (?) and next_attempt_at <= ?) or (status = ? and updated_at < ?)",
+		[]MerchantWebhookDeliveryStatus{MerchantWebhookDeliveryPending, MerchantWebhookDeliveryRetrying}, time.Now(), MerchantWebhookDeliveryProcessing, staleBefore)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ClaimMerchantWebhookDelivery moves a due delivery to processing and counts the attempt, it returns false when the delivery is not due or another worker holds it
+func (m *MerchantWebhookDelivery) ClaimMerchantWebhookDelivery(db *gorm.DB, staleBefore time.Time) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &MerchantWebhookDelivery{}, map[string]interface{}{
+		"status":     MerchantWebhookDeliveryProcessing,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"updated_at": time.Now(),
+	}, "id = ? and ((status in (?) and next_attempt_at <= ?) or (status = ? and updated_at < ?))",
+		m.ID, []MerchantWebhookDeliveryStatus{MerchantWebhookDeliveryPending, MerchantWebhookDeliveryRetrying}, time.Now(), MerchantWebhookDeliveryProcessing, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("merchant webhook delivery claim failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+func (m *MerchantWebhookDelivery) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &m)
+	return err
+}
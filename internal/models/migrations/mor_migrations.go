@@ -5,13 +5,38 @@ import "github.com/vesicash/mor-api/internal/models"
 // _ = db.AutoMigrate(MigrationModels()...)
 func AuthMigrationModels() []interface{} {
 	return []interface{}{
+		models.ApprovalDecision{},
+		models.ApprovalPolicy{},
+		models.ApprovalRequest{},
 		models.Customer{},
+		models.Dispute{},
+		models.DisputeEvidence{},
+		models.DocumentSequence{},
+		models.JournalEntry{},
+		models.LedgerPosting{},
+		models.MerchantStatement{},
+		models.MerchantStatementLine{},
+		models.MerchantWebhookDelivery{},
+		models.MerchantWebhookEndpoint{},
 		models.PaymentModule{},
 		models.PaymentOrder{},
 		models.Payout{},
+		models.PayoutBatch{},
+		models.PayoutBatchLine{},
+		models.PayoutReserve{},
+		models.PayoutRun{},
+		models.ProcessedWebhook{},
+		models.ReconciliationReport{},
+		models.ReconciliationRun{},
+		models.Refund{},
 		models.Setting{},
+		models.TaxFiling{},
+		models.TaxFilingLine{},
+		models.TaxRate{},
 		models.Transaction{},
+		models.TransactionDocument{},
 		models.WebhookLog{},
 		models.Withdrawal{},
+		models.WithdrawalAccount{},
 	}
 }
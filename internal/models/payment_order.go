@@ -1,6 +1,12 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
 
 type PaymentOrder struct {
 	ID            uint      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
@@ -12,3 +18,20 @@ type PaymentOrder struct {
 	CreatedAt     time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
 	UpdatedAt     time.Time `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
 }
+
+func (p *PaymentOrder) CreatePaymentOrder(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &p)
+	if err != nil {
+		return fmt.Errorf("payment order creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (p *PaymentOrder) GetPaymentOrdersByTransactionID(db *gorm.DB) ([]PaymentOrder, error) {
+	details := []PaymentOrder{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "transaction_id = ?", p.TransactionID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
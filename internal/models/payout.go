@@ -9,18 +9,46 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	PayoutPending        TransactionStatus = "pending"
+	PayoutWalletCredited TransactionStatus = "wallet_credited"
+	PayoutSettled        TransactionStatus = "settled"
+	// PayoutFailed is a payout whose wallet credit kept failing, it waits for an admin to resume it
+	PayoutFailed TransactionStatus = "failed"
+)
+
+// PayoutCreditedStatuses are the payout statuses whose amount has reached the merchant's MOR_ wallet,
+// successful is kept for payouts made before settlement was tracked
+var PayoutCreditedStatuses = []TransactionStatus{TransactionSuccessful, PayoutWalletCredited, PayoutSettled}
+
 type Payout struct {
-	ID            uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
-	MerchantID    int64             `gorm:"column:merchant_id; type:int" json:"merchant_id"`
-	Reference     string            `gorm:"column:reference; type:varchar(255)" json:"reference"`
-	MerchantName  string            `gorm:"-" json:"merchant_name"`
-	MerchantEmail string            `gorm:"-" json:"merchant_email"`
-	Currency      string            `gorm:"-" json:"Currency"`
-	Amount        float64           `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
-	CountryID     int64             `gorm:"column:country_id; type:int" json:"country_id"`
-	Status        TransactionStatus `gorm:"column:status; type:varchar(255)" json:"status"`
-	CreatedAt     time.Time         `gorm:"column:created_at; autoCreateTime" json:"created_at"`
-	UpdatedAt     time.Time         `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+	ID                 uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID         int64             `gorm:"column:merchant_id; type:int" json:"merchant_id"`
+	Reference          string            `gorm:"column:reference; type:varchar(255)" json:"reference"`
+	MerchantName       string            `gorm:"-" json:"merchant_name"`
+	MerchantEmail      string            `gorm:"-" json:"merchant_email"`
+	Currency           string            `gorm:"-" json:"Currency"`
+	GrossAmount        float64           `gorm:"column:gross_amount; type:decimal(20,2); default: 0; comment: sum of the transactions paid out" json:"gross_amount"`
+	ProcessingFee      float64           `gorm:"column:processing_fee; type:decimal(20,2); default: 0" json:"processing_fee"`
+	TaxFee             float64           `gorm:"column:tax_fee; type:decimal(20,2); default: 0" json:"tax_fee"`
+	NetAmount          float64           `gorm:"column:net_amount; type:decimal(20,2); default: 0; comment: gross less processing fee and tax" json:"net_amount"`
+	Amount             float64           `gorm:"column:amount; type:decimal(20,2); comment: net less the reserve and refund deduction, credited to the MOR_ wallet" json:"amount"`
+	ReserveAmount      float64           `gorm:"column:reserve_amount; type:decimal(20,2); default: 0; comment: rolling reserve held back from the transactions paid out" json:"reserve_amount"`
+	RefundDeduction    float64           `gorm:"column:refund_deduction; type:decimal(20,2); default: 0; comment: refunds recovered from the payout" json:"refund_deduction"`
+	ReserveID          int64             `gorm:"column:reserve_id; type:int; default: 0; comment: set when the payout releases a rolling reserve" json:"reserve_id"`
+	SettlementCurrency string            `gorm:"column:settlement_currency; type:varchar(255); comment: MOR_ wallet currency the payout is converted into, empty when it is paid out in its own currency" json:"settlement_currency"`
+	SettlementAmount   float64           `gorm:"column:settlement_amount; type:decimal(20,2); default: 0; comment: converted amount less the fx fee, credited to the settlement currency wallet" json:"settlement_amount"`
+	FxRateID           int64             `gorm:"column:fx_rate_id; type:int; default: 0" json:"fx_rate_id"`
+	FxRate             float64           `gorm:"column:fx_rate; type:decimal(20,8); default: 0; comment: settlement currency per unit of the payout currency, locked before the wallet is credited" json:"fx_rate"`
+	FxFeeRate          float64           `gorm:"column:fx_fee_rate; type:decimal(5,2); default: 0; comment: percentage of the converted amount charged for the conversion" json:"fx_fee_rate"`
+	FxFee              float64           `gorm:"column:fx_fee; type:decimal(20,2); default: 0; comment: in the settlement currency" json:"fx_fee"`
+	ExchangeReference  string            `gorm:"column:exchange_reference; type:varchar(255); comment: reference of the exchange transaction recorded for the conversion" json:"exchange_reference"`
+	CountryID          int64             `gorm:"column:country_id; type:int" json:"country_id"`
+	Status             TransactionStatus `gorm:"column:status; type:varchar(255); comment: pending, wallet_credited, settled or failed" json:"status"`
+	Attempts           int               `gorm:"column:attempts; type:int; default: 0" json:"attempts"`
+	LastError          string            `gorm:"column:last_error; type:text" json:"last_error"`
+	CreatedAt          time.Time         `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time         `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
 }
 
 type GetPayoutRequest struct {
@@ -36,6 +64,14 @@ type PayoutToWalletRequest struct {
 	All       bool  `json:"all"`
 }
 
+// PayoutApprovalPayload is the manual payout an approval request was given for,
+// only the transactions that were approved are paid out, the ones that became payable meanwhile wait for the next payout
+type PayoutApprovalPayload struct {
+	Merchants      []int                 `json:"merchants"`
+	TransactionIDs []uint                `json:"transaction_ids"`
+	Totals         []ReconciliationTotal `json:"totals"`
+}
+
 func (p *Payout) GetPayoutByID(db *gorm.DB) (int, error) {
 	err, nilErr := postgresql.SelectOneFromDb(db, &p, "id = ?", p.ID)
 	if nilErr != nil {
@@ -98,3 +134,91 @@ func (p *Payout) UpdateAllFields(db *gorm.DB) error {
 	_, err := postgresql.SaveAllFields(db, &p)
 	return err
 }
+
+// GetPayoutTotals sums payouts credited to the MOR_ wallet per merchant and country, payouts converted into another currency
+// only count towards gross. Gross adds back the fees, tax, reserves and refunds deducted from the transactions paid out and leaves out reserve releases
+func (p *Payout) GetPayoutTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "status in (?)"
+		args    = []interface{}{PayoutCreditedStatuses}
+	)
+
+	if p.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, p.MerchantID)
+	}
+
+	err := db.Model(&Payout{}).Select("merchant_id, country_id, SUM(CASE WHEN COALESCE(settlement_currency, '') = '' THEN amount ELSE 0 END) as amount, SUM(CASE WHEN COALESCE(reserve_id, 0) = 0 THEN amount + COALESCE(reserve_amount, 0) + COALESCE(refund_deduction, 0) + COALESCE(processing_fee, 0) + COALESCE(tax_fee, 0) ELSE 0 END) as gross").Where(query, args...).Group("merchant_id, country_id").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// GetConvertedPayoutTotals sums payouts converted into a settlement currency and credited to its MOR_ wallet per merchant and currency
+func (p *Payout) GetConvertedPayoutTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "status in (?) and COALESCE(settlement_currency, '') <> ''"
+		args    = []interface{}{PayoutCreditedStatuses}
+	)
+
+	if p.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, p.MerchantID)
+	}
+
+	err := db.Model(&Payout{}).Select("merchant_id, settlement_currency as currency, SUM(settlement_amount) as amount").Where(query, args...).Group("merchant_id, settlement_currency").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// GetPayoutsToResume returns payouts stuck before settlement that haven't been touched since staleBefore
+func (p *Payout) GetPayoutsToResume(db *gorm.DB, staleBefore time.Time) ([]Payout, error) {
+	details := []Payout{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "status in (?) and updated_at < ?", []TransactionStatus{PayoutPending, PayoutWalletCredited}, staleBefore)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ClaimPayout marks a stuck payout as touched so only one resume picks it up, it returns false when the payout moved on or another resume holds it
+func (p *Payout) ClaimPayout(db *gorm.DB, staleBefore time.Time) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &Payout{}, map[string]interface{}{
+		"updated_at": time.Now(),
+	}, "id = ? and status in (?) and updated_at < ?", p.ID, []TransactionStatus{PayoutPending, PayoutWalletCredited}, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("payout claim failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetStatementPayouts returns the merchant's payouts credited to the MOR_ wallet of the currency between start and end, end excluded,
+// those of the country paid out in their own currency and those converted into the currency
+func (p *Payout) GetStatementPayouts(db *gorm.DB, currency string, start time.Time, end time.Time) ([]Payout, error) {
+	details := []Payout{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "created_at", "asc", &details, "merchant_id = ? and ((country_id = ? and COALESCE(settlement_currency, '') = '') or settlement_currency = ?) and status in (?) and created_at >= ? and created_at < ?",
+		p.MerchantID, p.CountryID, currency, PayoutCreditedStatuses, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetCreditedPayoutsTotal sums the merchant's payouts credited to the MOR_ wallet of the currency before the given time,
+// converted payouts count with their settlement amount
+func (p *Payout) GetCreditedPayoutsTotal(db *gorm.DB, currency string, before time.Time) (float64, error) {
+	var total float64
+	err := db.Model(&Payout{}).Select("COALESCE(SUM(CASE WHEN COALESCE(settlement_currency, '') = '' THEN amount ELSE settlement_amount END), 0)").Where("merchant_id = ? and ((country_id = ? and COALESCE(settlement_currency, '') = '') or settlement_currency = ?) and status in (?) and created_at < ?",
+		p.MerchantID, p.CountryID, currency, PayoutCreditedStatuses, before).Scan(&total).Error
+	if err != nil {
+		return total, err
+	}
+	return total, nil
+}
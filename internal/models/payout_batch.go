@@ -0,0 +1,215 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type PayoutBatchTrigger string
+
+var (
+	PayoutBatchManual    PayoutBatchTrigger = "manual"
+	PayoutBatchScheduled PayoutBatchTrigger = "scheduled"
+	PayoutBatchRetry     PayoutBatchTrigger = "retry"
+)
+
+type PayoutBatchStatus string
+
+var (
+	PayoutBatchPending   PayoutBatchStatus = "pending"
+	PayoutBatchRunning   PayoutBatchStatus = "running"
+	PayoutBatchCompleted PayoutBatchStatus = "completed"
+	PayoutBatchCancelled PayoutBatchStatus = "cancelled"
+)
+
+type PayoutBatchLineStatus string
+
+var (
+	PayoutBatchLinePending   PayoutBatchLineStatus = "pending"
+	PayoutBatchLineSuccess   PayoutBatchLineStatus = "success"
+	PayoutBatchLineFailed    PayoutBatchLineStatus = "failed"
+	PayoutBatchLineSkipped   PayoutBatchLineStatus = "skipped"
+	PayoutBatchLineCancelled PayoutBatchLineStatus = "cancelled"
+)
+
+// PayoutBatch tracks one payout of a list of merchants, whether started by an admin, by the payout schedule or as a retry
+type PayoutBatch struct {
+	ID          uint               `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	TriggeredBy PayoutBatchTrigger `gorm:"column:triggered_by; type:varchar(255); comment: manual, scheduled or retry" json:"triggered_by"`
+	RetryOfID   int64              `gorm:"column:retry_of_id; type:int; comment: batch whose failed lines this batch retries" json:"retry_of_id"`
+	Status      PayoutBatchStatus  `gorm:"column:status; type:varchar(255); index; comment: pending, running, completed or cancelled" json:"status"`
+	Total       int                `gorm:"column:total; type:int; default: 0" json:"total"`
+	Succeeded   int                `gorm:"column:succeeded; type:int; default: 0" json:"succeeded"`
+	Failed      int                `gorm:"column:failed; type:int; default: 0" json:"failed"`
+	Skipped     int                `gorm:"column:skipped; type:int; default: 0" json:"skipped"`
+	Cancelled   int                `gorm:"column:cancelled; type:int; default: 0" json:"cancelled"`
+	CompletedAt time.Time          `gorm:"column:completed_at" json:"completed_at"`
+	CreatedAt   time.Time          `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time          `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type PayoutBatchLine struct {
+	ID         uint                  `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	BatchID    int64                 `gorm:"column:batch_id; type:int; not null; index" json:"batch_id"`
+	MerchantID int64                 `gorm:"column:merchant_id; type:int; not null; index" json:"merchant_id"`
+	Status     PayoutBatchLineStatus `gorm:"column:status; type:varchar(255); comment: pending, success, failed, skipped or cancelled" json:"status"`
+	Code       int                   `gorm:"column:code; type:int" json:"code"`
+	Reason     string                `gorm:"column:reason; type:text" json:"reason"`
+	PayoutIDs  []int64               `gorm:"column:payout_ids;serializer:json" json:"payout_ids"`
+	// TransactionIDs are the transactions an approved payout pinned for the merchant, without them whatever is payable is paid out
+	TransactionIDs []uint    `gorm:"column:transaction_ids;serializer:json" json:"transaction_ids"`
+	ProcessedAt    time.Time `gorm:"column:processed_at" json:"processed_at"`
+	CreatedAt      time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type GetPayoutBatchesRequest struct {
+	TriggeredBy string `json:"triggered_by"`
+	Status      string `json:"status"`
+}
+
+func (p *PayoutBatch) CreatePayoutBatch(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &p)
+	if err != nil {
+		return fmt.Errorf("payout batch creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (p *PayoutBatch) GetPayoutBatchByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &p, "id = ?", p.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (p *PayoutBatch) GetPayoutBatches(db *gorm.DB, paginator postgresql.Pagination) ([]PayoutBatch, postgresql.PaginationResponse, error) {
+	var (
+		details = []PayoutBatch{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if p.TriggeredBy != "" {
+		query = addQuery(query, "triggered_by = ?", "and")
+		args = append(args, p.TriggeredBy)
+	}
+
+	if p.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, p.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// UpdatePayoutBatchStatus moves the batch to status only if it is still in one of from, it returns false otherwise
+func (p *PayoutBatch) UpdatePayoutBatchStatus(db *gorm.DB, status PayoutBatchStatus, from []PayoutBatchStatus) (bool, error) {
+	updates := map[string]interface{}{
+		"status": status,
+	}
+	if status == PayoutBatchCompleted || status == PayoutBatchCancelled {
+		updates["completed_at"] = time.Now()
+	}
+
+	updated, err := postgresql.UpdateFieldsWhere(db, &PayoutBatch{}, updates, "id = ? and status in (?)", p.ID, from)
+	if err != nil {
+		return false, fmt.Errorf("payout batch status update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// UpdatePayoutBatchCounts recounts the batch's lines by status
+func (p *PayoutBatch) UpdatePayoutBatchCounts(db *gorm.DB) error {
+	var (
+		counts = []struct {
+			Status PayoutBatchLineStatus
+			Count  int
+		}{}
+		updates = map[string]interface{}{"succeeded": 0, "failed": 0, "skipped": 0, "cancelled": 0}
+	)
+
+	err := db.Model(&PayoutBatchLine{}).Select("status, COUNT(*) as count").Where("batch_id = ?", p.ID).Group("status").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, c := range counts {
+		switch c.Status {
+		case PayoutBatchLineSuccess:
+			updates["succeeded"] = c.Count
+		case PayoutBatchLineFailed:
+			updates["failed"] = c.Count
+		case PayoutBatchLineSkipped:
+			updates["skipped"] = c.Count
+		case PayoutBatchLineCancelled:
+			updates["cancelled"] = c.Count
+		}
+	}
+
+	_, err = postgresql.UpdateFieldsWhere(db, &PayoutBatch{}, updates, "id = ?", p.ID)
+	return err
+}
+
+func CreatePayoutBatchLines(db *gorm.DB, lines []PayoutBatchLine) error {
+	err := postgresql.CreateMultipleRecords(db, &lines, 500, len(lines))
+	if err != nil {
+		return fmt.Errorf("payout batch lines creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (p *PayoutBatchLine) GetPayoutBatchLines(db *gorm.DB, paginator *postgresql.Pagination) ([]PayoutBatchLine, postgresql.PaginationResponse, error) {
+	var (
+		details    = []PayoutBatchLine{}
+		query      = "batch_id = ?"
+		args       = []interface{}{p.BatchID}
+		pagination postgresql.PaginationResponse
+		err        error
+	)
+
+	if p.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, p.Status)
+	}
+
+	if paginator == nil {
+		err = postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, query, args...)
+	} else {
+		pagination, err = postgresql.SelectAllFromDbOrderByPaginated(db, "id", "asc", *paginator, &details, query, args...)
+	}
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// CancelPendingPayoutBatchLines cancels the lines of the batch that haven't been processed yet
+func (p *PayoutBatchLine) CancelPendingPayoutBatchLines(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &PayoutBatchLine{}, map[string]interface{}{
+		"status":       PayoutBatchLineCancelled,
+		"reason":       "batch cancelled",
+		"processed_at": time.Now(),
+	}, "batch_id = ? and status = ?", p.BatchID, PayoutBatchLinePending)
+	return err
+}
+
+func (p *PayoutBatchLine) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &p)
+	return err
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type PayoutReserveStatus string
+
+var (
+	PayoutReserveHeld     PayoutReserveStatus = "held"
+	PayoutReserveReleased PayoutReserveStatus = "released"
+)
+
+// PayoutReserve is the part of a payout held back against chargebacks until ReleaseAt
+type PayoutReserve struct {
+	ID              uint                `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID      int64               `gorm:"column:merchant_id; type:int; not null; index" json:"merchant_id"`
+	PayoutID        int64               `gorm:"column:payout_id; type:int; not null; index; comment: payout the reserve was held from" json:"payout_id"`
+	CountryID       int64               `gorm:"column:country_id; type:int" json:"country_id"`
+	Currency        string              `gorm:"-" json:"currency"`
+	Amount          float64             `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	Status          PayoutReserveStatus `gorm:"column:status; type:varchar(255); index; comment: held or released" json:"status"`
+	ReleaseAt       time.Time           `gorm:"column:release_at" json:"release_at"`
+	ReleasePayoutID int64               `gorm:"column:release_payout_id; type:int; comment: payout that credited the reserve to the MOR_ wallet" json:"release_payout_id"`
+	ReleasedAt      time.Time           `gorm:"column:released_at" json:"released_at"`
+	CreatedAt       time.Time           `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time           `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type GetPayoutReservesRequest struct {
+	AccountID int    `json:"account_id"`
+	Status    string `json:"status"`
+}
+
+func (p *PayoutReserve) CreatePayoutReserve(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &p)
+	if err != nil {
+		return fmt.Errorf("payout reserve creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (p *PayoutReserve) GetPayoutReserveByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &p, "id = ?", p.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (p *PayoutReserve) GetPayoutReserves(db *gorm.DB, paginator postgresql.Pagination) ([]PayoutReserve, postgresql.PaginationResponse, error) {
+	var (
+		details = []PayoutReserve{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if p.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, p.MerchantID)
+	}
+
+	if p.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, p.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "release_at", "asc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// GetHeldPayoutReserves returns the merchant's reserves that haven't been released, soonest release first
+func (p *PayoutReserve) GetHeldPayoutReserves(db *gorm.DB) ([]PayoutReserve, error) {
+	details := []PayoutReserve{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "release_at", "asc", &details, "merchant_id = ? and status = ?", p.MerchantID, PayoutReserveHeld)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (p *PayoutReserve) GetDuePayoutReserves(db *gorm.DB, now time.Time) ([]PayoutReserve, error) {
+	details := []PayoutReserve{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "status = ? and release_at <= ?", PayoutReserveHeld, now)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ReleasePayoutReserve ties a held reserve to the payout releasing it, it returns false when the reserve was already released
+func (p *PayoutReserve) ReleasePayoutReserve(db *gorm.DB, releasePayoutID uint) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &PayoutReserve{}, map[string]interface{}{
+		"status":            PayoutReserveReleased,
+		"release_payout_id": releasePayoutID,
+		"released_at":       time.Now(),
+	}, "id = ? and status = ?", p.ID, PayoutReserveHeld)
+	if err != nil {
+		return false, fmt.Errorf("payout reserve release failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type PayoutRunStatus string
+
+var (
+	PayoutRunRunning   PayoutRunStatus = "running"
+	PayoutRunCompleted PayoutRunStatus = "completed"
+	PayoutRunFailed    PayoutRunStatus = "failed"
+)
+
+// PayoutRun logs a run of the payout schedule. Runs without a due merchant create no payout batch,
+// the run still shows how many schedules were checked and which checks failed.
+type PayoutRun struct {
+	ID               uint               `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Status           PayoutRunStatus    `gorm:"column:status; type:varchar(255); index; comment: running, completed or failed" json:"status"`
+	MerchantsChecked int                `gorm:"column:merchants_checked; type:int; default: 0" json:"merchants_checked"`
+	MerchantsDue     int                `gorm:"column:merchants_due; type:int; default: 0" json:"merchants_due"`
+	BatchID          int64              `gorm:"column:batch_id; type:int; default: 0; comment: payout batch of the due merchants, 0 when none was due" json:"batch_id"`
+	Failures         []PayoutRunFailure `gorm:"column:failures;serializer:json" json:"failures"`
+	Error            string             `gorm:"column:error; type:text" json:"error"`
+	CompletedAt      time.Time          `gorm:"column:completed_at" json:"completed_at"`
+	CreatedAt        time.Time          `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time          `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// PayoutRunFailure is a merchant whose payout schedule couldn't be checked
+type PayoutRunFailure struct {
+	MerchantID int64  `json:"merchant_id"`
+	Error      string `json:"error"`
+}
+
+func (p *PayoutRun) CreatePayoutRun(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &p)
+	if err != nil {
+		return fmt.Errorf("payout run creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (p *PayoutRun) GetPayoutRuns(db *gorm.DB, paginator postgresql.Pagination) ([]PayoutRun, postgresql.PaginationResponse, error) {
+	var (
+		details = []PayoutRun{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if p.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, p.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+func (p *PayoutRun) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &p)
+	return err
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type ProcessedWebhook struct {
+	ID           uint      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Provider     string    `gorm:"column:provider; type:varchar(255); not null; uniqueIndex:idx_processed_webhooks_provider_event_merchant" json:"provider"`
+	EventKey     string    `gorm:"column:event_key; type:varchar(255); not null; uniqueIndex:idx_processed_webhooks_provider_event_merchant; comment: provider event name and event id or reference" json:"event_key"`
+	MerchantID   int64     `gorm:"column:merchant_id; type:int; not null; uniqueIndex:idx_processed_webhooks_provider_event_merchant" json:"merchant_id"`
+	WebhookLogID int64     `gorm:"column:webhook_log_id; type:int" json:"webhook_log_id"`
+	CreatedAt    time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// CreateProcessedWebhookIfNotExists returns false when the (provider, event_key, merchant_id) delivery was already processed
+func (p *ProcessedWebhook) CreateProcessedWebhookIfNotExists(db *gorm.DB) (bool, error) {
+	created, err := postgresql.CreateOneRecordIfNotExists(db, &p)
+	if err != nil {
+		return false, fmt.Errorf("processed webhook creation failed: %v", err.Error())
+	}
+	return created, nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type ReconciliationRunStatus string
+
+var (
+	ReconciliationRunning   ReconciliationRunStatus = "running"
+	ReconciliationCompleted ReconciliationRunStatus = "completed"
+	ReconciliationFailed    ReconciliationRunStatus = "failed"
+)
+
+type ReconciliationReportStatus string
+
+var (
+	ReconciliationReportOpen     ReconciliationReportStatus = "open"
+	ReconciliationReportResolved ReconciliationReportStatus = "resolved"
+)
+
+type ReconciliationRun struct {
+	ID               uint                    `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID       int64                   `gorm:"column:merchant_id; type:int; comment: set when the run was limited to one merchant" json:"merchant_id"`
+	Status           ReconciliationRunStatus `gorm:"column:status; type:varchar(255); comment: running, completed or failed" json:"status"`
+	WalletsChecked   int                     `gorm:"column:wallets_checked; type:int; default: 0" json:"wallets_checked"`
+	DiscrepancyCount int                     `gorm:"column:discrepancy_count; type:int; default: 0" json:"discrepancy_count"`
+	Error            string                  `gorm:"column:error; type:text" json:"error"`
+	CompletedAt      time.Time               `gorm:"column:completed_at" json:"completed_at"`
+	CreatedAt        time.Time               `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time               `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// ReconciliationReport records a merchant wallet whose local totals don't agree with the remote wallet balance
+type ReconciliationReport struct {
+	ID                  uint                       `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	RunID               int64                      `gorm:"column:run_id; type:int; not null; index" json:"run_id"`
+	MerchantID          int64                      `gorm:"column:merchant_id; type:int; not null; index" json:"merchant_id"`
+	Currency            string                     `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	PaidOutTransactions float64                    `gorm:"column:paid_out_transactions; type:decimal(20,2); comment: successful transactions marked as paid out" json:"paid_out_transactions"`
+	Payouts             float64                    `gorm:"column:payouts; type:decimal(20,2); comment: successful payouts to the MOR_ wallet" json:"payouts"`
+	PayoutsGross        float64                    `gorm:"column:payouts_gross; type:decimal(20,2); comment: payouts of transactions before rolling reserves" json:"payouts_gross"`
+	Withdrawals         float64                    `gorm:"column:withdrawals; type:decimal(20,2); comment: withdrawals debited from the MOR_ wallet" json:"withdrawals"`
+	RefundRecoveries    float64                    `gorm:"column:refund_recoveries; type:decimal(20,2); comment: refunds recovered from the MOR_ wallet" json:"refund_recoveries"`
+	DisputeHolds        float64                    `gorm:"column:dispute_holds; type:decimal(20,2); comment: disputed amounts debited from the MOR_ wallet and not released" json:"dispute_holds"`
+	ExpectedBalance     float64                    `gorm:"column:expected_balance; type:decimal(20,2)" json:"expected_balance"`
+	WalletBalance       float64                    `gorm:"column:wallet_balance; type:decimal(20,2)" json:"wallet_balance"`
+	Difference          float64                    `gorm:"column:difference; type:decimal(20,2); comment: wallet balance minus expected balance" json:"difference"`
+	Issues              []string                   `gorm:"column:issues;serializer:json" json:"issues"`
+	Status              ReconciliationReportStatus `gorm:"column:status; type:varchar(255); index; comment: open or resolved" json:"status"`
+	ResolutionNote      string                     `gorm:"column:resolution_note; type:text" json:"resolution_note"`
+	ResolvedAt          time.Time                  `gorm:"column:resolved_at" json:"resolved_at"`
+	CreatedAt           time.Time                  `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt           time.Time                  `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// ReconciliationTotal is a sum of amounts for one merchant, grouped by country or currency
+type ReconciliationTotal struct {
+	MerchantID int64   `gorm:"column:merchant_id" json:"merchant_id"`
+	CountryID  int64   `gorm:"column:country_id" json:"country_id"`
+	Currency   string  `gorm:"column:currency" json:"currency"`
+	Amount     float64 `gorm:"column:amount" json:"amount"`
+	Gross      float64 `gorm:"column:gross" json:"gross"`
+}
+
+type RunReconciliationRequest struct {
+	AccountID int64 `json:"account_id"`
+}
+
+type GetReconciliationReportsRequest struct {
+	RunID     int    `json:"run_id"`
+	AccountID int    `json:"account_id"`
+	Currency  string `json:"currency"`
+	Status    string `json:"status"`
+}
+
+type ResolveReconciliationReportRequest struct {
+	Note string `json:"note" validate:"required"`
+}
+
+func (r *ReconciliationRun) CreateReconciliationRun(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &r)
+	if err != nil {
+		return fmt.Errorf("reconciliation run creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (r *ReconciliationRun) GetReconciliationRuns(db *gorm.DB, paginator postgresql.Pagination) ([]ReconciliationRun, postgresql.PaginationResponse, error) {
+	details := []ReconciliationRun{}
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, "")
+	if err != nil {
+		return details, pagination, err
+	}
+	return details, pagination, nil
+}
+
+func (r *ReconciliationRun) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &r)
+	return err
+}
+
+func (r *ReconciliationReport) CreateReconciliationReport(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &r)
+	if err != nil {
+		return fmt.Errorf("reconciliation report creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (r *ReconciliationReport) GetReconciliationReportByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &r, "id = ?", r.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (r *ReconciliationReport) GetReconciliationReports(db *gorm.DB, paginator postgresql.Pagination) ([]ReconciliationReport, postgresql.PaginationResponse, error) {
+	var (
+		details = []ReconciliationReport{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if r.RunID != 0 {
+		query = addQuery(query, "run_id = ?", "and")
+		args = append(args, r.RunID)
+	}
+
+	if r.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, r.MerchantID)
+	}
+
+	if r.Currency != "" {
+		query = addQuery(query, "currency = ?", "and")
+		args = append(args, r.Currency)
+	}
+
+	if r.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, r.Status)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+func (r *ReconciliationReport) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &r)
+	return err
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type RefundInitiator string
+
+var (
+	RefundInitiatedByAdmin      RefundInitiator = "admin"
+	RefundInitiatedByMerchant   RefundInitiator = "merchant"
+	RefundInitiatedByProvider   RefundInitiator = "provider"
+	RefundInitiatedByChargeback RefundInitiator = "chargeback"
+)
+
+type RefundRecoveryMethod string
+
+var (
+	RefundRecoveryWallet     RefundRecoveryMethod = "wallet"
+	RefundRecoveryNextPayout RefundRecoveryMethod = "next_payout"
+)
+
+type RefundRecoveryStatus string
+
+var (
+	RefundRecoveryPending   RefundRecoveryStatus = "pending"
+	RefundRecoveryRecovered RefundRecoveryStatus = "recovered"
+)
+
+// Refund is a full or partial refund of a transaction. The processing fee and tax of the refunded share are reversed,
+// the rest is recovered from the merchant, from their MOR_ wallet or by deducting it from their next payout.
+type Refund struct {
+	ID               uint                 `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	TransactionID    int64                `gorm:"column:transaction_id; type:int; not null; index" json:"transaction_id"`
+	MerchantID       int64                `gorm:"column:merchant_id; type:int; index" json:"merchant_id"`
+	CountryID        int64                `gorm:"column:country_id; type:int" json:"country_id"`
+	Reference        string               `gorm:"column:reference; type:varchar(255); not null; uniqueIndex" json:"reference"`
+	Amount           float64              `gorm:"column:amount; type:decimal(20,2); comment: paid back to the customer" json:"amount"`
+	ProcessingFee    float64              `gorm:"column:processing_fee; type:decimal(20,2); default: 0; comment: share of the transaction's processing fee reversed" json:"processing_fee"`
+	TaxFee           float64              `gorm:"column:tax_fee; type:decimal(20,2); default: 0; comment: share of the transaction's tax reversed" json:"tax_fee"`
+	RecoveryAmount   float64              `gorm:"column:recovery_amount; type:decimal(20,2); default: 0; comment: amount less processing fee and tax, owed back by the merchant" json:"recovery_amount"`
+	RecoveredAmount  float64              `gorm:"column:recovered_amount; type:decimal(20,2); default: 0" json:"recovered_amount"`
+	RecoveryMethod   RefundRecoveryMethod `gorm:"column:recovery_method; type:varchar(255); comment: wallet or next_payout" json:"recovery_method"`
+	RecoveryStatus   RefundRecoveryStatus `gorm:"column:recovery_status; type:varchar(255); index; comment: pending or recovered" json:"recovery_status"`
+	RecoveryPayoutID int64                `gorm:"column:recovery_payout_id; type:int; default: 0; comment: last payout the recovery was deducted from" json:"recovery_payout_id"`
+	RecoveredAt      time.Time            `gorm:"column:recovered_at" json:"recovered_at"`
+	Reason           string               `gorm:"column:reason; type:varchar(255)" json:"reason"`
+	InitiatedBy      RefundInitiator      `gorm:"column:initiated_by; type:varchar(255); comment: admin, merchant, provider or chargeback" json:"initiated_by"`
+	CreatedAt        time.Time            `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time            `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type CreateRefundRequest struct {
+	Amount float64 `json:"amount" validate:"gte=0"`
+	Reason string  `json:"reason"`
+}
+
+type GetRefundsRequest struct {
+	AccountID      int    `json:"account_id"`
+	TransactionID  int    `json:"transaction_id"`
+	RecoveryStatus string `json:"recovery_status"`
+}
+
+func (r *Refund) CreateRefund(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &r)
+	if err != nil {
+		return fmt.Errorf("refund creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (r *Refund) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &r)
+	return err
+}
+
+func (r *Refund) GetRefundByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &r, "id = ?", r.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (r *Refund) GetRefundsByTransactionID(db *gorm.DB) ([]Refund, error) {
+	details := []Refund{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "transaction_id = ?", r.TransactionID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (r *Refund) GetRefunds(db *gorm.DB, paginator postgresql.Pagination) ([]Refund, postgresql.PaginationResponse, error) {
+	var (
+		details = []Refund{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if r.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, r.MerchantID)
+	}
+
+	if r.TransactionID != 0 {
+		query = addQuery(query, "transaction_id = ?", "and")
+		args = append(args, r.TransactionID)
+	}
+
+	if r.RecoveryStatus != "" {
+		query = addQuery(query, "recovery_status = ?", "and")
+		args = append(args, r.RecoveryStatus)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// GetPendingPayoutRecoveries returns the merchant's refunds in the country still to be deducted from a payout, oldest first
+func (r *Refund) GetPendingPayoutRecoveries(db *gorm.DB) ([]Refund, error) {
+	details := []Refund{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "merchant_id = ? and country_id = ? and recovery_method = ? and recovery_status = ?",
+		r.MerchantID, r.CountryID, RefundRecoveryNextPayout, RefundRecoveryPending)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// RecoverFromPayout adds amount to what was recovered of the refund, it returns false when another payout recovered from it first
+func (r *Refund) RecoverFromPayout(db *gorm.DB, payoutID uint, amount float64) (bool, error) {
+	var (
+		recovered = r.RecoveredAmount + amount
+		updates   = map[string]interface{}{
+			"recovered_amount":   recovered,
+			"recovery_payout_id": payoutID,
+			"updated_at":         time.Now(),
+		}
+	)
+
+	if recovered >= r.RecoveryAmount {
+		updates["recovery_status"] = RefundRecoveryRecovered
+		updates["recovered_at"] = time.Now()
+	}
+
+	updated, err := postgresql.UpdateFieldsWhere(db, &Refund{}, updates, "id = ? and recovery_status = ? and recovered_amount = ?", r.ID, RefundRecoveryPending, r.RecoveredAmount)
+	if err != nil {
+		return false, fmt.Errorf("refund recovery update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetWalletRecoveriesToSettle returns the refunds still to be debited from the MOR_ wallet that weren't touched since updatedBefore, oldest first.
+// A refund with a merchant only returns the merchant's refunds.
+func (r *Refund) GetWalletRecoveriesToSettle(db *gorm.DB, updatedBefore time.Time) ([]Refund, error) {
+	var (
+		details = []Refund{}
+		query   = "recovery_method = ? and recovery_status = ? and updated_at <= ?"
+		args    = []interface{}{RefundRecoveryWallet, RefundRecoveryPending, updatedBefore}
+	)
+
+	if r.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, r.MerchantID)
+	}
+
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, query, args...)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// SettleWalletRecovery records the outcome of the MOR_ wallet debit of the refund, a refund the wallet couldn't cover is recovered
+// from the next payout instead. It returns false when the recovery was settled first by another run.
+func (r *Refund) SettleWalletRecovery(db *gorm.DB, debited bool) (bool, error) {
+	updates := map[string]interface{}{
+		"recovery_method": RefundRecoveryNextPayout,
+		"updated_at":      time.Now(),
+	}
+	if debited {
+		updates = map[string]interface{}{
+			"recovered_amount": r.RecoveryAmount,
+			"recovery_status":  RefundRecoveryRecovered,
+			"recovered_at":     time.Now(),
+			"updated_at":       time.Now(),
+		}
+	}
+
+	updated, err := postgresql.UpdateFieldsWhere(db, &Refund{}, updates, "id = ? and recovery_method = ? and recovery_status = ?", r.ID, RefundRecoveryWallet, RefundRecoveryPending)
+	if err != nil {
+		return false, fmt.Errorf("refund recovery update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetTaxRefunds returns the refunds of transactions in the country made between start and end, end excluded
+func (r *Refund) GetTaxRefunds(db *gorm.DB, start time.Time, end time.Time) ([]Refund, error) {
+	details := []Refund{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "country_id = ? and created_at >= ? and created_at < ?", r.CountryID, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetWalletRecoveryTotals sums the refunds recovered from the MOR_ wallet per merchant and country
+func (r *Refund) GetWalletRecoveryTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "recovery_method = ?"
+		args    = []interface{}{RefundRecoveryWallet}
+	)
+
+	if r.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, r.MerchantID)
+	}
+
+	err := db.Model(&Refund{}).Select("merchant_id, country_id, SUM(recovered_amount) as amount").Where(query, args...).Group("merchant_id, country_id").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// GetStatementRefunds returns the merchant's refunds in the country made between start and end, end excluded,
+// lost chargebacks are left to the statement's dispute lines
+func (r *Refund) GetStatementRefunds(db *gorm.DB, start time.Time, end time.Time) ([]Refund, error) {
+	details := []Refund{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "created_at", "asc", &details, "merchant_id = ? and country_id = ? and initiated_by <> ? and created_at >= ? and created_at < ?",
+		r.MerchantID, r.CountryID, RefundInitiatedByChargeback, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetWalletRecoveriesTotal sums the merchant's refunds in the country made before the given time and recovered from the MOR_ wallet
+func (r *Refund) GetWalletRecoveriesTotal(db *gorm.DB, before time.Time) (float64, error) {
+	var total float64
+	err := db.Model(&Refund{}).Select("COALESCE(SUM(recovered_amount), 0)").Where("merchant_id = ? and country_id = ? and recovery_method = ? and recovery_status = ? and created_at < ?",
+		r.MerchantID, r.CountryID, RefundRecoveryWallet, RefundRecoveryRecovered, before).Scan(&total).Error
+	if err != nil {
+		return total, err
+	}
+	return total, nil
+}
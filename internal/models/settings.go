@@ -12,6 +12,7 @@ import (
 
 type VerificationStatus string
 type PaymentMethod string
+type PayoutSchedule string
 
 var (
 	NotVerified         VerificationStatus = "not_verified"
@@ -35,21 +36,43 @@ var (
 	CreditMethod            PaymentMethod = "credit"
 )
 
+var (
+	PayoutScheduleManual    PayoutSchedule = "manual"
+	PayoutScheduleDaily     PayoutSchedule = "daily"
+	PayoutScheduleWeekly    PayoutSchedule = "weekly"
+	PayoutScheduleMonthly   PayoutSchedule = "monthly"
+	PayoutScheduleThreshold PayoutSchedule = "threshold"
+)
+
+// AutomaticPayoutSchedules are the schedules the payout cronjob acts on, an empty schedule is manual
+var AutomaticPayoutSchedules = []PayoutSchedule{PayoutScheduleDaily, PayoutScheduleWeekly, PayoutScheduleMonthly, PayoutScheduleThreshold}
+
 type Setting struct {
-	ID             uint                   `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
-	AccountID      int64                  `gorm:"column:account_id; type:int; not null" json:"account_id"`
-	BusinessTypeID int64                  `gorm:"column:business_type_id; type:int" json:"business_type_id"`
-	UsageType      string                 `gorm:"column:usage_type; type:varchar(255)" json:"usage_type"`
-	Countries      []SettingsCountries    `gorm:"column:countries;serializer:json" json:"countries"`
-	Verifications  []SettingsVerification `gorm:"column:verifications;serializer:json" json:"verifications"`
-	CurrencyCodes  []string               `gorm:"column:currency_codes;serializer:json" json:"currency_codes"`
-	PaymentMethods []PaymentMethod        `gorm:"column:payment_methods;serializer:json" json:"payment_methods"`
-	IsVerified     bool                   `gorm:"column:is_verified; default:false" json:"is_verified"`
-	AccountType    string                 `gorm:"-" json:"account_type"`
-	Email          string                 `gorm:"-" json:"email"`
-	FullName       string                 `gorm:"-" json:"full_name"`
-	CreatedAt      time.Time              `gorm:"column:created_at; autoCreateTime" json:"created_at"`
-	UpdatedAt      time.Time              `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+	ID                 uint                   `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	AccountID          int64                  `gorm:"column:account_id; type:int; not null" json:"account_id"`
+	BusinessTypeID     int64                  `gorm:"column:business_type_id; type:int" json:"business_type_id"`
+	UsageType          string                 `gorm:"column:usage_type; type:varchar(255)" json:"usage_type"`
+	Countries          []SettingsCountries    `gorm:"column:countries;serializer:json" json:"countries"`
+	Verifications      []SettingsVerification `gorm:"column:verifications;serializer:json" json:"verifications"`
+	CurrencyCodes      []string               `gorm:"column:currency_codes;serializer:json" json:"currency_codes"`
+	PaymentMethods     []PaymentMethod        `gorm:"column:payment_methods;serializer:json" json:"payment_methods"`
+	IsVerified         bool                   `gorm:"column:is_verified; default:false" json:"is_verified"`
+	PayoutSchedule     PayoutSchedule         `gorm:"column:payout_schedule; type:varchar(255); comment: manual, daily, weekly, monthly or threshold" json:"payout_schedule"`
+	PayoutThreshold    float64                `gorm:"column:payout_threshold; type:decimal(20,2); comment: unpaid amount in any one currency that triggers a threshold payout" json:"payout_threshold"`
+	LastPayoutAt       time.Time              `gorm:"column:last_payout_at; comment: last scheduled payout" json:"last_payout_at"`
+	ReserveRate        float64                `gorm:"column:reserve_rate; type:decimal(5,2); default: 0; comment: percentage of each payout held back as a rolling reserve" json:"reserve_rate"`
+	ReserveDays        int                    `gorm:"column:reserve_days; type:int; default: 0; comment: days a rolling reserve is held before release" json:"reserve_days"`
+	PayoutDelayDays    int                    `gorm:"column:payout_delay_days; type:int; default: 0; comment: minimum age in days of a transaction before it is paid out" json:"payout_delay_days"`
+	PayoutHold         bool                   `gorm:"column:payout_hold; default:false" json:"payout_hold"`
+	PayoutHoldReason   string                 `gorm:"column:payout_hold_reason; type:varchar(255)" json:"payout_hold_reason"`
+	PayoutHeldAt       time.Time              `gorm:"column:payout_held_at" json:"payout_held_at"`
+	SettlementCurrency string                 `gorm:"column:settlement_currency; type:varchar(255); comment: MOR_ wallet currency payouts are converted into, empty pays each currency into its own wallet" json:"settlement_currency"`
+	FxFeeRate          float64                `gorm:"column:fx_fee_rate; type:decimal(5,2); default: 0; comment: percentage of a converted payout charged for the conversion" json:"fx_fee_rate"`
+	AccountType        string                 `gorm:"-" json:"account_type"`
+	Email              string                 `gorm:"-" json:"email"`
+	FullName           string                 `gorm:"-" json:"full_name"`
+	CreatedAt          time.Time              `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time              `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
 }
 
 type SettingsCountries struct {
@@ -91,6 +114,26 @@ type GetSettingsRequest struct {
 	ToTime   int    `json:"to_time"`
 }
 
+type UpdatePayoutScheduleRequest struct {
+	Schedule  PayoutSchedule `json:"schedule" validate:"required,oneof=manual daily weekly monthly threshold"`
+	Threshold float64        `json:"threshold" validate:"required_if=Schedule threshold,gte=0"`
+}
+
+type UpdatePayoutPolicyRequest struct {
+	ReserveRate     float64 `json:"reserve_rate" validate:"gte=0,lte=100"`
+	ReserveDays     int     `json:"reserve_days" validate:"gte=0"`
+	PayoutDelayDays int     `json:"payout_delay_days" validate:"gte=0"`
+	FxFeeRate       float64 `json:"fx_fee_rate" validate:"gte=0,lte=100"`
+}
+
+type UpdateSettlementCurrencyRequest struct {
+	Currency string `json:"currency"`
+}
+
+type HoldPayoutsRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
 type UpdateDocumentStatusRequest struct {
 	CountryId int    `json:"country_id"`
 	Status    string `json:"status" validate:"oneof=not_verified pending verified"`
@@ -133,6 +176,67 @@ func (s *Setting) UpdateAllFields(db *gorm.DB) error {
 	return err
 }
 
+// GetSettingsWithAutomaticPayouts returns the settings of merchants with a daily, weekly, monthly or threshold payout schedule
+func (s *Setting) GetSettingsWithAutomaticPayouts(db *gorm.DB) ([]Setting, error) {
+	details := []Setting{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "payout_schedule in (?)", AutomaticPayoutSchedules)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// UpdateLastPayoutAt only touches last_payout_at so a scheduled run can't overwrite settings the merchant is saving
+func (s *Setting) UpdateLastPayoutAt(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Setting{}, map[string]interface{}{
+		"last_payout_at": s.LastPayoutAt,
+	}, "id = ?", s.ID)
+	return err
+}
+
+// UpdatePayoutPolicy only touches the reserve and payout delay so an admin can't overwrite settings the merchant is saving
+func (s *Setting) UpdatePayoutPolicy(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Setting{}, map[string]interface{}{
+		"reserve_rate":      s.ReserveRate,
+		"reserve_days":      s.ReserveDays,
+		"payout_delay_days": s.PayoutDelayDays,
+		"fx_fee_rate":       s.FxFeeRate,
+	}, "id = ?", s.ID)
+	return err
+}
+
+func (s *Setting) UpdatePayoutSchedule(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Setting{}, map[string]interface{}{
+		"payout_schedule":  s.PayoutSchedule,
+		"payout_threshold": s.PayoutThreshold,
+	}, "id = ?", s.ID)
+	return err
+}
+
+func (s *Setting) UpdateSettlementCurrency(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Setting{}, map[string]interface{}{
+		"settlement_currency": s.SettlementCurrency,
+	}, "id = ?", s.ID)
+	return err
+}
+
+func (s *Setting) UpdatePayoutHold(db *gorm.DB) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Setting{}, map[string]interface{}{
+		"payout_hold":        s.PayoutHold,
+		"payout_hold_reason": s.PayoutHoldReason,
+		"payout_held_at":     s.PayoutHeldAt,
+	}, "id = ?", s.ID)
+	return err
+}
+
+// GetPayoutMaturity returns the latest transaction date that can be paid out at now, a zero time when there's no minimum age
+func (s *Setting) GetPayoutMaturity(now time.Time) time.Time {
+	if s.PayoutDelayDays <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -s.PayoutDelayDays)
+}
+
 func (p PaymentMethod) In(methods []PaymentMethod) bool {
 	for _, v := range methods {
 		if p == v {
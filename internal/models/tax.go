@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type TaxCategory string
+
+var (
+	TaxCategoryStandard TaxCategory = "standard"
+	TaxCategoryReduced  TaxCategory = "reduced"
+	TaxCategoryExempt   TaxCategory = "exempt"
+)
+
+// TaxRate is the VAT/GST rate of a product category in a country from EffectiveFrom until a later rate of the same category takes over
+type TaxRate struct {
+	ID            uint        `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	CountryID     int64       `gorm:"column:country_id; type:int; not null; index:idx_tax_rates_country_category" json:"country_id"`
+	Category      TaxCategory `gorm:"column:category; type:varchar(255); not null; index:idx_tax_rates_country_category; comment: standard or reduced, exempt products are never taxed" json:"category"`
+	Name          string      `gorm:"column:name; type:varchar(255); comment: e.g. VAT or GST" json:"name"`
+	Rate          float64     `gorm:"column:rate; type:decimal(5,2); not null; comment: percentage of the price before tax" json:"rate"`
+	EffectiveFrom time.Time   `gorm:"column:effective_from; not null" json:"effective_from"`
+	CreatedAt     time.Time   `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time   `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// TaxCalculation is the tax due on a tax inclusive amount
+type TaxCalculation struct {
+	CountryID     int64       `json:"country_id"`
+	Category      TaxCategory `json:"category"`
+	TaxRateID     int64       `json:"tax_rate_id"`
+	Name          string      `json:"name"`
+	Rate          float64     `json:"rate"`
+	Amount        float64     `json:"amount"`
+	Tax           float64     `json:"tax"`
+	ReverseCharge bool        `json:"reverse_charge"`
+	CustomerTaxID string      `json:"customer_tax_id"`
+}
+
+type CreateTaxRateRequest struct {
+	Country       int         `json:"country" validate:"required"`
+	Category      TaxCategory `json:"category" validate:"required,oneof=standard reduced"`
+	Name          string      `json:"name" validate:"required"`
+	Rate          float64     `json:"rate" validate:"gte=0,lte=100"`
+	EffectiveFrom int         `json:"effective_from" validate:"required"`
+}
+
+type CalculateTaxRequest struct {
+	Country       int         `json:"country" validate:"required"`
+	Category      TaxCategory `json:"category" validate:"omitempty,oneof=standard reduced exempt"`
+	Amount        float64     `json:"amount" validate:"required,gt=0"`
+	CustomerTaxID string      `json:"customer_tax_id"`
+	Time          int         `json:"time"`
+}
+
+type GetTaxRatesRequest struct {
+	Country  int    `json:"country"`
+	Category string `json:"category"`
+}
+
+func (t *TaxRate) CreateTaxRate(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &t)
+	if err != nil {
+		return fmt.Errorf("tax rate creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+// GetEffectiveTaxRate loads the country's rate for the category that applies at the given time, 400 means no rate applies
+func (t *TaxRate) GetEffectiveTaxRate(db *gorm.DB, at time.Time) (int, error) {
+	details := []TaxRate{}
+	err := db.Where("country_id = ? and category = ? and effective_from <= ?", t.CountryID, t.Category, at).Order("effective_from desc, id desc").Limit(1).Find(&details).Error
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if len(details) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("no %v tax rate for country %v", t.Category, t.CountryID)
+	}
+
+	*t = details[0]
+	return http.StatusOK, nil
+}
+
+func (t *TaxRate) GetTaxRates(db *gorm.DB, paginator postgresql.Pagination) ([]TaxRate, postgresql.PaginationResponse, error) {
+	var (
+		details = []TaxRate{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if t.CountryID != 0 {
+		query = addQuery(query, "country_id = ?", "and")
+		args = append(args, t.CountryID)
+	}
+
+	if t.Category != "" {
+		query = addQuery(query, "category = ?", "and")
+		args = append(args, t.Category)
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "effective_from", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
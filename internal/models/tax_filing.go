@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type TaxFilingStatus string
+
+var (
+	TaxFilingOpen   TaxFilingStatus = "open"
+	TaxFilingClosed TaxFilingStatus = "closed"
+)
+
+type TaxFilingLineKind string
+
+var (
+	TaxFilingLineSale   TaxFilingLineKind = "sale"
+	TaxFilingLineRefund TaxFilingLineKind = "refund"
+)
+
+// TaxFiling is the tax owed in a country for a filing period, an open filing is worked out from the transactions
+// each time it is read and a closed one is stored with its lines so the return can be reproduced
+type TaxFiling struct {
+	ID                  uint            `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	CountryID           int64           `gorm:"column:country_id; type:int; not null; uniqueIndex:idx_tax_filings_country_period" json:"country_id"`
+	Currency            string          `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	Period              string          `gorm:"column:period; type:varchar(255); not null; uniqueIndex:idx_tax_filings_country_period; comment: 2024, 2024-Q1 or 2024-01" json:"period"`
+	PeriodStart         time.Time       `gorm:"column:period_start" json:"period_start"`
+	PeriodEnd           time.Time       `gorm:"column:period_end; comment: exclusive" json:"period_end"`
+	Status              TaxFilingStatus `gorm:"column:status; type:varchar(255)" json:"status"`
+	SalesCount          int             `gorm:"column:sales_count; type:int" json:"sales_count"`
+	GrossSales          float64         `gorm:"column:gross_sales; type:decimal(20,2)" json:"gross_sales"`
+	TaxableAmount       float64         `gorm:"column:taxable_amount; type:decimal(20,2); comment: standard and reduced rated sales before tax" json:"taxable_amount"`
+	ExemptAmount        float64         `gorm:"column:exempt_amount; type:decimal(20,2)" json:"exempt_amount"`
+	ReverseChargeAmount float64         `gorm:"column:reverse_charge_amount; type:decimal(20,2)" json:"reverse_charge_amount"`
+	TaxCollected        float64         `gorm:"column:tax_collected; type:decimal(20,2)" json:"tax_collected"`
+	RefundCount         int             `gorm:"column:refund_count; type:int" json:"refund_count"`
+	RefundedAmount      float64         `gorm:"column:refunded_amount; type:decimal(20,2)" json:"refunded_amount"`
+	RefundedTax         float64         `gorm:"column:refunded_tax; type:decimal(20,2)" json:"refunded_tax"`
+	NetTax              float64         `gorm:"column:net_tax; type:decimal(20,2); comment: tax collected less tax refunded" json:"net_tax"`
+	ClosedAt            time.Time       `gorm:"column:closed_at" json:"closed_at"`
+	CreatedAt           time.Time       `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt           time.Time       `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type TaxFilingLine struct {
+	ID            uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	FilingID      int64             `gorm:"column:filing_id; type:int; not null; index" json:"filing_id"`
+	CountryID     int64             `gorm:"column:country_id; type:int" json:"country_id"`
+	TransactionID int64             `gorm:"column:transaction_id; type:int" json:"transaction_id"`
+	Reference     string            `gorm:"column:reference; type:varchar(255)" json:"reference"`
+	MerchantID    int64             `gorm:"column:merchant_id; type:int" json:"merchant_id"`
+	Kind          TaxFilingLineKind `gorm:"column:kind; type:varchar(255); comment: sale or refund" json:"kind"`
+	Date          time.Time         `gorm:"column:date" json:"date"`
+	Amount        float64           `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	TaxableAmount float64           `gorm:"column:taxable_amount; type:decimal(20,2)" json:"taxable_amount"`
+	TaxFee        float64           `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	TaxCategory   TaxCategory       `gorm:"column:tax_category; type:varchar(255)" json:"tax_category"`
+	TaxRateID     int64             `gorm:"column:tax_rate_id; type:int" json:"tax_rate_id"`
+	TaxRate       float64           `gorm:"column:tax_rate; type:decimal(5,2)" json:"tax_rate"`
+	ReverseCharge bool              `gorm:"column:reverse_charge" json:"reverse_charge"`
+	CustomerTaxID string            `gorm:"column:customer_tax_id; type:varchar(255)" json:"customer_tax_id"`
+}
+
+type GetTaxReportRequest struct {
+	Country int    `json:"country"`
+	Period  string `json:"period" validate:"required"`
+	Format  string `json:"format" validate:"omitempty,oneof=csv xlsx"`
+	Lines   bool   `json:"lines"`
+}
+
+type CloseTaxFilingRequest struct {
+	Country int    `json:"country" validate:"required"`
+	Period  string `json:"period" validate:"required"`
+}
+
+func (t *TaxFiling) CreateTaxFiling(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &t)
+	if err != nil {
+		return fmt.Errorf("tax filing creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (t *TaxFiling) GetTaxFilingByCountryAndPeriod(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &t, "country_id = ? and period = ?", t.CountryID, t.Period)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (t *TaxFilingLine) GetTaxFilingLines(db *gorm.DB) ([]TaxFilingLine, error) {
+	details := []TaxFilingLine{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "filing_id = ?", t.FilingID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func CreateTaxFilingLines(db *gorm.DB, lines []TaxFilingLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	err := postgresql.CreateMultipleRecords(db, &lines, 500, len(lines))
+	if err != nil {
+		return fmt.Errorf("tax filing lines creation failed: %v", err.Error())
+	}
+	return nil
+}
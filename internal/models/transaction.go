@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"time"
 
@@ -15,48 +16,76 @@ var (
 	TransactionSuccessful TransactionStatus = "successful"
 	TransactionPending    TransactionStatus = "pending"
 	TransactionFailed     TransactionStatus = "failed"
+	TransactionReversed   TransactionStatus = "reversed"
+	TransactionRefunded   TransactionStatus = "refunded"
 )
 
+// RefundedStatuses are the statuses of a transaction emptied by refunds or lost chargebacks,
+// one with a refunded_amount is still paid out and its refunds are recovered from the payout
+var RefundedStatuses = []TransactionStatus{TransactionRefunded, TransactionReversed}
+
 type Transaction struct {
-	ID              uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
-	MerchantID      int64             `gorm:"column:merchant_id; type:int" json:"merchant_id"`
-	CustomerID      int64             `gorm:"column:customer_id; type:int" json:"customer_id"`
-	CustomerName    string            `gorm:"-" json:"customer_name"`
-	PaymentModuleID int64             `gorm:"column:payment_module_id; type:int" json:"payment_module_id"`
-	Reference       string            `gorm:"column:reference; type:varchar(255)" json:"reference"`
-	MerchantName    string            `gorm:"-" json:"merchant_name"`
-	MerchantEmail   string            `gorm:"-" json:"merchant_email"`
-	Country         string            `gorm:"-" json:"country"`
-	Currency        string            `gorm:"-" json:"currency"`
-	Description     string            `gorm:"column:description; type:varchar(255)" json:"description"`
-	Amount          float64           `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
-	TaxFee          float64           `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
-	ProcessingFee   float64           `gorm:"column:processing_fee; type:decimal(20,2)" json:"processing_fee"`
-	CountryID       int64             `gorm:"column:country_id; type:int" json:"country_id"`
-	PaymentMethod   PaymentMethod     `gorm:"column:payment_method; type:varchar(255); comment: (card, bank transfer, mobile money etc)" json:"payment_method"`
-	Status          TransactionStatus `gorm:"column:status; type:varchar(255)" json:"status"`
-	IsPaidOut       bool              `gorm:"column:is_paid_out; default: false" json:"is_paid_out"`
-	PayoutID        int64             `gorm:"column:payout_id; type:int" json:"payout_id"`
-	TransactionDate time.Time         `gorm:"column:transaction_date" json:"transaction_date"`
-	CreatedAt       time.Time         `gorm:"column:created_at; autoCreateTime" json:"created_at"`
-	UpdatedAt       time.Time         `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+	ID               uint              `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID       int64             `gorm:"column:merchant_id; type:int" json:"merchant_id"`
+	CustomerID       int64             `gorm:"column:customer_id; type:int" json:"customer_id"`
+	CustomerName     string            `gorm:"-" json:"customer_name"`
+	PaymentModuleID  int64             `gorm:"column:payment_module_id; type:int" json:"payment_module_id"`
+	Reference        string            `gorm:"column:reference; type:varchar(255)" json:"reference"`
+	MerchantName     string            `gorm:"-" json:"merchant_name"`
+	MerchantEmail    string            `gorm:"-" json:"merchant_email"`
+	Country          string            `gorm:"-" json:"country"`
+	Currency         string            `gorm:"-" json:"currency"`
+	Description      string            `gorm:"column:description; type:varchar(255)" json:"description"`
+	Amount           float64           `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	TaxFee           float64           `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	TaxCategory      TaxCategory       `gorm:"column:tax_category; type:varchar(255); comment: standard, reduced or exempt" json:"tax_category"`
+	TaxRateID        int64             `gorm:"column:tax_rate_id; type:int; comment: rate the tax fee was calculated with, 0 when it was sent by the recorder" json:"tax_rate_id"`
+	TaxRate          float64           `gorm:"column:tax_rate; type:decimal(5,2)" json:"tax_rate"`
+	TaxReverseCharge bool              `gorm:"column:tax_reverse_charge; default: false; comment: b2b sale where the customer accounts for the tax" json:"tax_reverse_charge"`
+	CustomerTaxID    string            `gorm:"column:customer_tax_id; type:varchar(255)" json:"customer_tax_id"`
+	ProcessingFee    float64           `gorm:"column:processing_fee; type:decimal(20,2)" json:"processing_fee"`
+	CountryID        int64             `gorm:"column:country_id; type:int" json:"country_id"`
+	PaymentMethod    PaymentMethod     `gorm:"column:payment_method; type:varchar(255); comment: (card, bank transfer, mobile money etc)" json:"payment_method"`
+	Status           TransactionStatus `gorm:"column:status; type:varchar(255)" json:"status"`
+	IsPaidOut        bool              `gorm:"column:is_paid_out; default: false" json:"is_paid_out"`
+	PayoutID         int64             `gorm:"column:payout_id; type:int; index; comment: set when the transaction is reserved for a payout, is_paid_out follows once the payout settles" json:"payout_id"`
+	TransactionDate  time.Time         `gorm:"column:transaction_date" json:"transaction_date"`
+	RefundedAt       time.Time         `gorm:"column:refunded_at; comment: when the transaction was refunded or reversed" json:"refunded_at"`
+	RefundedAmount   float64           `gorm:"column:refunded_amount; type:decimal(20,2); default: 0; comment: refunded through the refund api, a partly refunded transaction stays successful" json:"refunded_amount"`
+	CreatedAt        time.Time         `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time         `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
 }
 
 type TransactionSummary struct {
-	Currency  string  `gorm:"-" json:"currency"`
-	CountryID int64   `gorm:"column:country_id; type:int" json:"-"`
-	Amount    float64 `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	Currency         string    `gorm:"-" json:"currency"`
+	CountryID        int64     `gorm:"column:country_id; type:int" json:"-"`
+	Amount           float64   `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	ProcessingFee    float64   `gorm:"column:processing_fee; type:decimal(20,2)" json:"processing_fee"`
+	TaxFee           float64   `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	RefundedAmount   float64   `gorm:"column:refunded_amount; type:decimal(20,2)" json:"refunded_amount"`
+	RefundedFees     float64   `gorm:"column:refunded_fees; type:decimal(20,2)" json:"-"`
+	Net              float64   `gorm:"-" json:"net"`
+	Payable          float64   `gorm:"-" json:"payable"`
+	Reserved         float64   `gorm:"-" json:"reserved"`
+	NextReleaseAt    time.Time `gorm:"-" json:"next_release_at"`
+	PayoutHold       bool      `gorm:"-" json:"payout_hold"`
+	PayoutHoldReason string    `gorm:"-" json:"payout_hold_reason"`
+	ReserveRate      float64   `gorm:"-" json:"reserve_rate"`
+	ReserveDays      int       `gorm:"-" json:"reserve_days"`
+	PayoutDelayDays  int       `gorm:"-" json:"payout_delay_days"`
 }
 
 type RecordTransactionRequest struct {
-	AccountID            int64   `json:"account_id" validate:"required" pgvalidate:"exists=auth$users$account_id"`
-	Reference            string  `json:"reference" validate:"required"`
-	Description          string  `json:"description"`
-	Country              int     `json:"country"  validate:"required"`
-	Amount               float64 `json:"amount" validate:"required"`
-	TaxFee               float64 `json:"tax_fee"`
-	ProcessingFee        float64 `json:"processing_fee"`
-	TransactionCreatedAt int     `json:"transaction_created_at"`
+	AccountID            int64       `json:"account_id" validate:"required" pgvalidate:"exists=auth$users$account_id"`
+	Reference            string      `json:"reference" validate:"required"`
+	Description          string      `json:"description"`
+	Country              int         `json:"country"  validate:"required"`
+	Amount               float64     `json:"amount" validate:"required"`
+	TaxFee               float64     `json:"tax_fee"`
+	TaxCategory          TaxCategory `json:"tax_category" validate:"omitempty,oneof=standard reduced exempt"`
+	CustomerTaxID        string      `json:"customer_tax_id"`
+	ProcessingFee        float64     `json:"processing_fee"`
+	TransactionCreatedAt int         `json:"transaction_created_at"`
 }
 
 type GetTransactionsRequest struct {
@@ -67,6 +96,15 @@ type GetTransactionsRequest struct {
 	ToTime         int    `json:"to_time"`
 }
 
+func (s TransactionStatus) In(statuses []TransactionStatus) bool {
+	for _, v := range statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Transaction) GetTransactionByID(db *gorm.DB) (int, error) {
 	err, nilErr := postgresql.SelectOneFromDb(db, &t, "id = ?", t.ID)
 	if nilErr != nil {
@@ -79,6 +117,18 @@ func (t *Transaction) GetTransactionByID(db *gorm.DB) (int, error) {
 	return http.StatusOK, nil
 }
 
+func (t *Transaction) GetTransactionByMerchantIDAndReference(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &t, "merchant_id = ? and reference = ?", t.MerchantID, t.Reference)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
 func (t *Transaction) GetTransactionsSummary(db *gorm.DB, paidOut *bool) ([]TransactionSummary, error) {
 	summary := []TransactionSummary{}
 	extraQuery := ""
@@ -94,13 +144,22 @@ func (t *Transaction) GetTransactionsSummary(db *gorm.DB, paidOut *bool) ([]Tran
 	}
 
 	// subQuery := db.Model(&t).Select("SUM(amount)").Where("is_paid_out = ? and currency=transactions.currency", false)
-	selectQuery := fmt.Sprintf("distinct country_id, (SELECT SUM(trx.amount) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as amount")
+	selectQuery := fmt.Sprintf("distinct country_id, (SELECT SUM(trx.amount) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as amount" +
+		", (SELECT COALESCE(SUM(trx.processing_fee), 0) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as processing_fee" +
+		", (SELECT COALESCE(SUM(trx.tax_fee), 0) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as tax_fee" +
+		", (SELECT COALESCE(SUM(trx.refunded_amount), 0) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as refunded_amount" +
+		", (SELECT COALESCE(SUM(trx.refunded_amount * (COALESCE(trx.processing_fee, 0) + COALESCE(trx.tax_fee, 0)) / NULLIF(trx.amount, 0)), 0) from transactions trx where trx.country_id=transactions.country_id " + extraQuery + ") as refunded_fees")
 
 	_, err := postgresql.RawSelectAllFromByGroup(db, "country_id", "desc", nil, &t, &summary, "country_id", selectQuery, whereQuery)
 	if err != nil {
 		return summary, err
 	}
 
+	// refunds reverse their share of the fees and tax, what is left of the refund is owed back by the merchant
+	for i, s := range summary {
+		summary[i].Net = math.Round((s.Amount-s.RefundedAmount-(s.ProcessingFee+s.TaxFee-s.RefundedFees))*100) / 100
+	}
+
 	return summary, nil
 }
 
@@ -190,3 +249,205 @@ func (t *Transaction) UpdateAllFields(db *gorm.DB) error {
 	_, err := postgresql.SaveAllFields(db, &t)
 	return err
 }
+
+// GetPaidOutTransactionTotals sums successful paid out transactions per merchant and country,
+// including ones refunded through the refund api or reversed by a lost dispute after they were paid out
+func (t *Transaction) GetPaidOutTransactionTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "(status = ? or (status = ? and refunded_amount > 0) or (status = ? and id in (SELECT transaction_id FROM disputes WHERE status = ?))) and is_paid_out = ?"
+		args    = []interface{}{TransactionSuccessful, TransactionRefunded, TransactionReversed, DisputeLost, true}
+	)
+
+	if t.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, t.MerchantID)
+	}
+
+	err := db.Model(&Transaction{}).Select("merchant_id, country_id, SUM(amount) as amount").Where(query, args...).Group("merchant_id, country_id").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// GetPayableTransactions returns the merchant's successful transactions that are neither paid out nor reserved for a payout,
+// only ones dated up to maturedBefore when it is set. Transactions fully refunded or charged back are paid out too,
+// their refunds are recovered from the same payout. Transactions with an open dispute are held back until it is decided.
+func (t *Transaction) GetPayableTransactions(db *gorm.DB, maturedBefore time.Time) ([]Transaction, error) {
+	var (
+		details = []Transaction{}
+		query   = "merchant_id = ? and (status = ? or (status in (?) and refunded_amount > 0)) and is_paid_out = ? and (payout_id = 0 or payout_id is null) and id not in (SELECT transaction_id FROM disputes WHERE status in (?))"
+		args    = []interface{}{t.MerchantID, TransactionSuccessful, RefundedStatuses, false, DisputeOpenStatuses}
+	)
+
+	if !maturedBefore.IsZero() {
+		query = addQuery(query, "transaction_date <= ?", "and")
+		args = append(args, maturedBefore)
+	}
+
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, query, args...)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ReserveTransactionsForPayout ties unreserved transactions to a payout and returns how many were reserved
+func (t *Transaction) ReserveTransactionsForPayout(db *gorm.DB, ids []uint, payoutID uint) (int64, error) {
+	reserved, err := postgresql.UpdateFieldsWhere(db, &Transaction{}, map[string]interface{}{
+		"payout_id": payoutID,
+	}, "id in (?) and is_paid_out = ? and (payout_id = 0 or payout_id is null)", ids, false)
+	if err != nil {
+		return 0, fmt.Errorf("reserving transactions for payout %v failed: %v", payoutID, err.Error())
+	}
+	return reserved, nil
+}
+
+// SettlePayoutTransactions marks every transaction reserved for the payout as paid out
+func (t *Transaction) SettlePayoutTransactions(db *gorm.DB, payoutID uint) error {
+	_, err := postgresql.UpdateFieldsWhere(db, &Transaction{}, map[string]interface{}{
+		"is_paid_out": true,
+	}, "payout_id = ?", payoutID)
+	if err != nil {
+		return fmt.Errorf("settling transactions for payout %v failed: %v", payoutID, err.Error())
+	}
+	return nil
+}
+
+// AddRefundedAmount adds a refund to the successful transaction, marking it refunded once nothing is left to refund.
+// It returns false when the transaction is no longer successful or the amount is more than what is left.
+func (t *Transaction) AddRefundedAmount(db *gorm.DB, amount float64) (bool, error) {
+	return t.addRefundedAmount(db, amount, TransactionRefunded)
+}
+
+// AddChargebackAmount adds a lost chargeback to the successful transaction the way AddRefundedAmount adds a refund,
+// marking it reversed once nothing is left
+func (t *Transaction) AddChargebackAmount(db *gorm.DB, amount float64) (bool, error) {
+	return t.addRefundedAmount(db, amount, TransactionReversed)
+}
+
+func (t *Transaction) addRefundedAmount(db *gorm.DB, amount float64, emptiedStatus TransactionStatus) (bool, error) {
+	updates := map[string]interface{}{
+		"refunded_amount": gorm.Expr("COALESCE(refunded_amount, 0) + ?", amount),
+		"updated_at":      time.Now(),
+	}
+
+	if math.Round((t.Amount-t.RefundedAmount-amount)*100) == 0 {
+		updates["status"] = emptiedStatus
+		updates["refunded_at"] = time.Now()
+	}
+
+	updated, err := postgresql.UpdateFieldsWhere(db, &Transaction{}, updates, "id = ? and status = ? and COALESCE(refunded_amount, 0) = ?", t.ID, TransactionSuccessful, t.RefundedAmount)
+	if err != nil {
+		return false, fmt.Errorf("transaction refund update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+func (t *Transaction) GetTransactionsByIDs(db *gorm.DB, ids []int64) ([]Transaction, error) {
+	details := []Transaction{}
+	if len(ids) == 0 {
+		return details, nil
+	}
+
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "id in (?)", ids)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (t *Transaction) CountTransactionsByPayoutID(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&Transaction{}).Where("payout_id = ?", t.PayoutID).Count(&count).Error
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// GetMerchantIDsWithPayableTransactions returns every merchant with successful transactions waiting for a payout
+func (t *Transaction) GetMerchantIDsWithPayableTransactions(db *gorm.DB) ([]int, error) {
+	ids := []int{}
+	err := db.Model(&Transaction{}).Where("(status = ? or (status in (?) and refunded_amount > 0)) and is_paid_out = ? and (payout_id = 0 or payout_id is null)", TransactionSuccessful, RefundedStatuses, false).Distinct().Order("merchant_id").Pluck("merchant_id", &ids).Error
+	if err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// GetPayableTransactionTotals sums the net amount of the merchants' payable transactions per country,
+// before payout delays, reserves and refund recoveries are taken into account
+func (t *Transaction) GetPayableTransactionTotals(db *gorm.DB, merchantIDs []int) ([]ReconciliationTotal, error) {
+	details := []ReconciliationTotal{}
+	err := db.Model(&Transaction{}).Select("country_id, SUM(amount - COALESCE(processing_fee, 0) - COALESCE(tax_fee, 0)) as amount, SUM(amount) as gross").
+		Where("merchant_id in (?) and (status = ? or (status in (?) and refunded_amount > 0)) and is_paid_out = ? and (payout_id = 0 or payout_id is null) and id not in (SELECT transaction_id FROM disputes WHERE status in (?))",
+			merchantIDs, TransactionSuccessful, RefundedStatuses, false, DisputeOpenStatuses).
+		Group("country_id").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetPayableTransactionIDs returns the ids of the merchants' payable transactions, in the order they were created
+func (t *Transaction) GetPayableTransactionIDs(db *gorm.DB, merchantIDs []int) ([]uint, error) {
+	ids := []uint{}
+	err := db.Model(&Transaction{}).
+		Where("merchant_id in (?) and (status = ? or (status in (?) and refunded_amount > 0)) and is_paid_out = ? and (payout_id = 0 or payout_id is null) and id not in (SELECT transaction_id FROM disputes WHERE status in (?))",
+			merchantIDs, TransactionSuccessful, RefundedStatuses, false, DisputeOpenStatuses).
+		Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// TaxSaleStatuses are the statuses of transactions that were sold, a later refund is reported in the period it happened in
+var TaxSaleStatuses = []TransactionStatus{TransactionSuccessful, TransactionRefunded, TransactionReversed}
+
+// GetTaxSales returns the country's transactions sold between start and end, end excluded
+func (t *Transaction) GetTaxSales(db *gorm.DB, start time.Time, end time.Time) ([]Transaction, error) {
+	details := []Transaction{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "country_id = ? and status in (?) and transaction_date >= ? and transaction_date < ?", t.CountryID, TaxSaleStatuses, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetTaxRefunds returns the country's transactions refunded or reversed between start and end, end excluded,
+// transactions refunded through the refund api are reported from their refunds instead
+func (t *Transaction) GetTaxRefunds(db *gorm.DB, start time.Time, end time.Time) ([]Transaction, error) {
+	details := []Transaction{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "country_id = ? and status in (?) and refunded_at >= ? and refunded_at < ? and COALESCE(refunded_amount, 0) = 0",
+		t.CountryID, []TransactionStatus{TransactionRefunded, TransactionReversed}, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (t *Transaction) GetCountryIDsWithTaxActivity(db *gorm.DB, start time.Time, end time.Time) ([]int64, error) {
+	ids := []int64{}
+	err := db.Model(&Transaction{}).Where("(status in (?) and transaction_date >= ? and transaction_date < ?) or (status in (?) and refunded_at >= ? and refunded_at < ?) or id in (SELECT transaction_id FROM refunds WHERE created_at >= ? and created_at < ?)",
+		TaxSaleStatuses, start, end, []TransactionStatus{TransactionRefunded, TransactionReversed}, start, end, start, end).Distinct().Order("country_id").Pluck("country_id", &ids).Error
+	if err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// GetStatementTransactions returns the merchant's transactions in the country captured between start and end, end excluded,
+// including the ones refunded or reversed since
+func (t *Transaction) GetStatementTransactions(db *gorm.DB, start time.Time, end time.Time) ([]Transaction, error) {
+	details := []Transaction{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "transaction_date", "asc", &details, "merchant_id = ? and country_id = ? and status in (?) and transaction_date >= ? and transaction_date < ?",
+		t.MerchantID, t.CountryID, TaxSaleStatuses, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type TransactionDocumentType string
+
+var (
+	TransactionDocumentInvoice TransactionDocumentType = "invoice"
+	TransactionDocumentReceipt TransactionDocumentType = "receipt"
+)
+
+// DocumentSequence is the gapless counter documents of one type are numbered from, one row per legal entity and type
+type DocumentSequence struct {
+	ID           uint                    `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Entity       string                  `gorm:"column:entity; type:varchar(255); not null; uniqueIndex:idx_document_sequences_entity_type" json:"entity"`
+	DocumentType TransactionDocumentType `gorm:"column:document_type; type:varchar(255); not null; uniqueIndex:idx_document_sequences_entity_type" json:"document_type"`
+	LastNumber   int64                   `gorm:"column:last_number; type:int; default: 0" json:"last_number"`
+	CreatedAt    time.Time               `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time               `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// TransactionDocument is an invoice or receipt issued to the customer of a transaction, its number never changes once issued
+type TransactionDocument struct {
+	ID            uint                    `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	TransactionID int64                   `gorm:"column:transaction_id; type:int; not null; uniqueIndex:idx_transaction_documents_transaction_type" json:"transaction_id"`
+	DocumentType  TransactionDocumentType `gorm:"column:document_type; type:varchar(255); not null; uniqueIndex:idx_transaction_documents_transaction_type; comment: invoice or receipt" json:"document_type"`
+	MerchantID    int64                   `gorm:"column:merchant_id; type:int; index" json:"merchant_id"`
+	Entity        string                  `gorm:"column:entity; type:varchar(255); not null; comment: code of the legal entity that issued the document" json:"entity"`
+	Sequence      int64                   `gorm:"column:sequence; type:int; not null" json:"sequence"`
+	Number        string                  `gorm:"column:number; type:varchar(255); not null; uniqueIndex" json:"number"`
+	Currency      string                  `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	Amount        float64                 `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	TaxFee        float64                 `gorm:"column:tax_fee; type:decimal(20,2)" json:"tax_fee"`
+	EmailedTo     string                  `gorm:"column:emailed_to; type:varchar(255)" json:"emailed_to"`
+	EmailedAt     time.Time               `gorm:"column:emailed_at" json:"emailed_at"`
+	CreatedAt     time.Time               `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time               `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type GetTransactionDocumentRequest struct {
+	Type TransactionDocumentType `json:"type" validate:"required,oneof=invoice receipt"`
+}
+
+type EmailTransactionDocumentRequest struct {
+	Type  TransactionDocumentType `json:"type" validate:"required,oneof=invoice receipt"`
+	Email string                  `json:"email" validate:"omitempty,email"`
+}
+
+// NextDocumentNumber takes the next number of the sequence. The increment holds the row lock until tx ends,
+// so when it is called in the same transaction as the document creation a rolled back document leaves no gap.
+func (d *DocumentSequence) NextDocumentNumber(tx *gorm.DB) (int64, error) {
+	_, err := postgresql.CreateOneRecordIfNotExists(tx, &DocumentSequence{Entity: d.Entity, DocumentType: d.DocumentType})
+	if err != nil {
+		return 0, fmt.Errorf("document sequence creation failed: %v", err.Error())
+	}
+
+	_, err = postgresql.UpdateFieldsWhere(tx, &DocumentSequence{}, map[string]interface{}{
+		"last_number": gorm.Expr("last_number + 1"),
+		"updated_at":  time.Now(),
+	}, "entity = ? and document_type = ?", d.Entity, d.DocumentType)
+	if err != nil {
+		return 0, fmt.Errorf("document sequence update failed: %v", err.Error())
+	}
+
+	err, nilErr := postgresql.SelectOneFromDb(tx, &d, "entity = ? and document_type = ?", d.Entity, d.DocumentType)
+	if nilErr != nil {
+		return 0, nilErr
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return d.LastNumber, nil
+}
+
+func (t *TransactionDocument) CreateTransactionDocument(db *gorm.DB) error {
+	err := postgresql.CreateOneRecord(db, &t)
+	if err != nil {
+		return fmt.Errorf("transaction document creation failed: %v", err.Error())
+	}
+	return nil
+}
+
+func (t *TransactionDocument) GetTransactionDocumentByTransactionIDAndType(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &t, "transaction_id = ? and document_type = ?", t.TransactionID, t.DocumentType)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (t *TransactionDocument) GetTransactionDocumentsByTransactionID(db *gorm.DB) ([]TransactionDocument, error) {
+	details := []TransactionDocument{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "transaction_id = ?", t.TransactionID)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+func (t *TransactionDocument) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &t)
+	return err
+}
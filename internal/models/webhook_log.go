@@ -2,18 +2,59 @@ package models
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"gorm.io/gorm"
 )
 
+type WebhookLogStatus string
+
+var (
+	WebhookLogPending    WebhookLogStatus = "pending"
+	WebhookLogProcessing WebhookLogStatus = "processing"
+	WebhookLogRetrying   WebhookLogStatus = "retrying"
+	WebhookLogProcessed  WebhookLogStatus = "processed"
+	WebhookLogDeadLetter WebhookLogStatus = "dead_letter"
+	WebhookLogIgnored    WebhookLogStatus = "ignored"
+)
+
 type WebhookLog struct {
-	ID        uint      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
-	Log       string    `gorm:"column:log; type:text; not null" json:"log"`
-	Provider  string    `gorm:"column:provider; type:varchar(255)" json:"provider"`
-	CreatedAt time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+	ID            uint             `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	Log           string           `gorm:"column:log; type:text; not null" json:"log"`
+	Provider      string           `gorm:"column:provider; type:varchar(255)" json:"provider"`
+	MerchantID    int64            `gorm:"column:merchant_id; type:int" json:"merchant_id"`
+	Status        WebhookLogStatus `gorm:"column:status; type:varchar(255); index; comment: pending, processing, retrying, processed, dead_letter or ignored" json:"status"`
+	Attempts      int              `gorm:"column:attempts; type:int; default: 0" json:"attempts"`
+	LastError     string           `gorm:"column:last_error; type:text" json:"last_error"`
+	NextAttemptAt time.Time        `gorm:"column:next_attempt_at" json:"next_attempt_at"`
+	ProcessedAt   time.Time        `gorm:"column:processed_at" json:"processed_at"`
+	CreatedAt     time.Time        `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+type GetWebhookLogsRequest struct {
+	Search    string `json:"search"`
+	Status    string `json:"status"`
+	Provider  string `json:"provider"`
+	AccountID int    `json:"account_id"`
+	FromTime  int    `json:"from_time"`
+	ToTime    int    `json:"to_time"`
+}
+
+type WebhookReplayResult struct {
+	DryRun     bool                  `json:"dry_run"`
+	Duplicate  bool                  `json:"duplicate"`
+	WebhookLog WebhookLog            `json:"webhook_log"`
+	Changes    []WebhookReplayChange `json:"changes"`
+}
+
+type WebhookReplayChange struct {
+	Model  string      `json:"model"`
+	Action string      `json:"action"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
 }
 
 func (w *WebhookLog) CreateWebhookLog(db *gorm.DB) error {
@@ -23,3 +64,90 @@ func (w *WebhookLog) CreateWebhookLog(db *gorm.DB) error {
 	}
 	return nil
 }
+
+func (w *WebhookLog) GetWebhookLogByID(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &w, "id = ?", w.ID)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (w *WebhookLog) GetWebhookLogs(db *gorm.DB, paginator postgresql.Pagination, search string, from int, to int) ([]WebhookLog, postgresql.PaginationResponse, error) {
+	var (
+		details = []WebhookLog{}
+		query   = ""
+		args    = []interface{}{}
+	)
+
+	if w.Status != "" {
+		query = addQuery(query, "status = ?", "and")
+		args = append(args, w.Status)
+	}
+
+	if w.Provider != "" {
+		query = addQuery(query, "provider = ?", "and")
+		args = append(args, w.Provider)
+	}
+
+	if w.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, w.MerchantID)
+	}
+
+	if search != "" {
+		query = addQuery(query, "(log ilike ? or last_error ilike ?)", "and")
+		args = append(args, "%"+search+"%", "%"+search+"%")
+	}
+
+	if from != 0 {
+		query = addQuery(query, "created_at >= ?", "and")
+		args = append(args, time.Unix(int64(from), 0))
+	}
+
+	if to != 0 {
+		query = addQuery(query, "created_at <= ?", "and")
+		args = append(args, time.Unix(int64(to), 0))
+	}
+
+	pagination, err := postgresql.SelectAllFromDbOrderByPaginated(db, "id", "desc", paginator, &details, query, args...)
+	if err != nil {
+		return details, pagination, err
+	}
+
+	return details, pagination, nil
+}
+
+// GetDueWebhookLogs returns deliveries waiting for an attempt, including ones left in processing since staleBefore
+func (w *WebhookLog) GetDueWebhookLogs(db *gorm.DB, staleBefore time.Time) ([]WebhookLog, error) {
+	details := []WebhookLog{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, "(status in (?) and next_attempt_at <= ?) or (status = ? and updated_at < ?)",
+		[]WebhookLogStatus{WebhookLogPending, WebhookLogRetrying}, time.Now(), WebhookLogProcessing, staleBefore)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// ClaimWebhookLog moves a due delivery to processing and counts the attempt, it returns false when the delivery is not due or another worker holds it
+func (w *WebhookLog) ClaimWebhookLog(db *gorm.DB, staleBefore time.Time) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &WebhookLog{}, map[string]interface{}{
+		"status":     WebhookLogProcessing,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"updated_at": time.Now(),
+	}, "id = ? and ((status in (?) and next_attempt_at <= ?) or (status = ? and updated_at < ?))",
+		w.ID, []WebhookLogStatus{WebhookLogPending, WebhookLogRetrying}, time.Now(), WebhookLogProcessing, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("webhook log claim failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+func (w *WebhookLog) UpdateAllFields(db *gorm.DB) error {
+	_, err := postgresql.SaveAllFields(db, &w)
+	return err
+}
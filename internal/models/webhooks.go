@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type FlutterwaveWebhookRequest struct {
 	Event    string                             `json:"event"`
 	Data     *FlutterwaveWebhookRequestData     `json:"data"`
@@ -45,6 +47,7 @@ type FlutterwaveWebhookRequestTransfer struct {
 	BankCode          *string                                `json:"bank_code"`
 	Fullname          *string                                `json:"fullname"`
 	DateCreated       *string                                `json:"date_created"`
+	CreatedAt         *string                                `json:"created_at"`
 	Currency          *string                                `json:"currency"`
 	DebitCurrency     *string                                `json:"debit_currency"`
 	Amount            *float64                               `json:"amount"`
@@ -60,6 +63,39 @@ type FlutterwaveWebhookRequestTransfer struct {
 	BankName          *string                                `json:"bank_name"`
 }
 
+// FlutterwaveWebhookRequestEvent is the envelope of every flutterwave webhook, data is decoded once the event is known
+type FlutterwaveWebhookRequestEvent struct {
+	Event    string          `json:"event"`
+	Data     json.RawMessage `json:"data"`
+	Transfer json.RawMessage `json:"transfer"`
+}
+
+type FlutterwaveWebhookRequestRefund struct {
+	ID             *int     `json:"id"`
+	TxID           *int     `json:"tx_id"`
+	TxRef          *string  `json:"tx_ref"`
+	FlwRef         *string  `json:"flw_ref"`
+	AmountRefunded *float64 `json:"amount_refunded"`
+	Currency       *string  `json:"currency"`
+	Status         *string  `json:"status"`
+	Comments       *string  `json:"comments"`
+	CreatedAt      *string  `json:"created_at"`
+}
+
+type FlutterwaveWebhookRequestChargeback struct {
+	ID        *int     `json:"id"`
+	TxID      *int     `json:"tx_id"`
+	TxRef     *string  `json:"tx_ref"`
+	FlwRef    *string  `json:"flw_ref"`
+	Amount    *float64 `json:"amount"`
+	Currency  *string  `json:"currency"`
+	Status    *string  `json:"status"`
+	Stage     *string  `json:"stage"`
+	Comment   *string  `json:"comment"`
+	DueDate   *string  `json:"due_date"`
+	CreatedAt *string  `json:"created_at"`
+}
+
 type FlutterwaveWebhookRequestTransferMeta struct {
 	AccountId  *int    `json:"AccountId"`
 	MerchantId *string `json:"merchant_id"`
@@ -81,3 +117,142 @@ type FlutterwaveWebhookRequestDataCard struct {
 	Type         *string `json:"type"`
 	Expiry       *string `json:"expiry"`
 }
+
+type PaystackWebhookRequest struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type PaystackWebhookRequestCharge struct {
+	ID              *int                                 `json:"id"`
+	Domain          *string                              `json:"domain"`
+	Status          *string                              `json:"status"`
+	Reference       *string                              `json:"reference"`
+	Amount          *float64                             `json:"amount"`
+	Message         *string                              `json:"message"`
+	GatewayResponse *string                              `json:"gateway_response"`
+	PaidAt          *string                              `json:"paid_at"`
+	CreatedAt       *string                              `json:"created_at"`
+	Channel         *string                              `json:"channel"`
+	Currency        *string                              `json:"currency"`
+	IPAddress       *string                              `json:"ip_address"`
+	Fees            *float64                             `json:"fees"`
+	Customer        *PaystackWebhookRequestCustomer      `json:"customer"`
+	Authorization   *PaystackWebhookRequestAuthorization `json:"authorization"`
+}
+
+type PaystackWebhookRequestRefund struct {
+	Status               *string                         `json:"status"`
+	TransactionReference *string                         `json:"transaction_reference"`
+	RefundReference      *string                         `json:"refund_reference"`
+	Amount               *json.Number                    `json:"amount"`
+	Currency             *string                         `json:"currency"`
+	Processor            *string                         `json:"processor"`
+	Domain               *string                         `json:"domain"`
+	Customer             *PaystackWebhookRequestCustomer `json:"customer"`
+}
+
+type PaystackWebhookRequestTransfer struct {
+	ID            *int                             `json:"id"`
+	Domain        *string                          `json:"domain"`
+	Amount        *float64                         `json:"amount"`
+	Currency      *string                          `json:"currency"`
+	Reason        *string                          `json:"reason"`
+	Reference     *string                          `json:"reference"`
+	Source        *string                          `json:"source"`
+	Status        *string                          `json:"status"`
+	TransferCode  *string                          `json:"transfer_code"`
+	TransferredAt *string                          `json:"transferred_at"`
+	CreatedAt     *string                          `json:"created_at"`
+	UpdatedAt     *string                          `json:"updated_at"`
+	Recipient     *PaystackWebhookRequestRecipient `json:"recipient"`
+}
+
+type PaystackWebhookRequestCustomer struct {
+	ID           *int    `json:"id"`
+	FirstName    *string `json:"first_name"`
+	LastName     *string `json:"last_name"`
+	Email        *string `json:"email"`
+	CustomerCode *string `json:"customer_code"`
+	Phone        *string `json:"phone"`
+}
+
+type PaystackWebhookRequestAuthorization struct {
+	AuthorizationCode *string `json:"authorization_code"`
+	Bin               *string `json:"bin"`
+	Last4             *string `json:"last4"`
+	ExpMonth          *string `json:"exp_month"`
+	ExpYear           *string `json:"exp_year"`
+	Channel           *string `json:"channel"`
+	CardType          *string `json:"card_type"`
+	Bank              *string `json:"bank"`
+	CountryCode       *string `json:"country_code"`
+	Brand             *string `json:"brand"`
+	AccountName       *string `json:"account_name"`
+}
+
+type PaystackWebhookRequestRecipient struct {
+	Domain        *string                                 `json:"domain"`
+	Type          *string                                 `json:"type"`
+	Currency      *string                                 `json:"currency"`
+	Name          *string                                 `json:"name"`
+	Email         *string                                 `json:"email"`
+	RecipientCode *string                                 `json:"recipient_code"`
+	Details       *PaystackWebhookRequestRecipientDetails `json:"details"`
+}
+
+type PaystackWebhookRequestRecipientDetails struct {
+	AccountNumber *string `json:"account_number"`
+	AccountName   *string `json:"account_name"`
+	BankCode      *string `json:"bank_code"`
+	BankName      *string `json:"bank_name"`
+}
+
+type ETransactWebhookRequest struct {
+	Event string                       `json:"event"`
+	Data  *ETransactWebhookRequestData `json:"data"`
+}
+
+type ETransactWebhookRequestData struct {
+	TransactionID *string                          `json:"transaction_id"`
+	Reference     *string                          `json:"reference"`
+	TerminalID    *string                          `json:"terminal_id"`
+	Amount        *float64                         `json:"amount"`
+	Fee           *float64                         `json:"fee"`
+	Currency      *string                          `json:"currency"`
+	Status        *string                          `json:"status"`
+	ResponseCode  *string                          `json:"response_code"`
+	Channel       *string                          `json:"channel"`
+	Description   *string                          `json:"description"`
+	PaidAt        *string                          `json:"paid_at"`
+	Customer      *ETransactWebhookRequestCustomer `json:"customer"`
+}
+
+type ETransactWebhookRequestCustomer struct {
+	Email       *string `json:"email"`
+	FirstName   *string `json:"first_name"`
+	LastName    *string `json:"last_name"`
+	PhoneNumber *string `json:"phone_number"`
+}
+
+type MonnifyWebhookRequest struct {
+	EventType string          `json:"eventType"`
+	EventData json.RawMessage `json:"eventData"`
+}
+
+type MonnifyWebhookRequestDisbursement struct {
+	Amount                   *float64 `json:"amount"`
+	Fee                      *float64 `json:"fee"`
+	TransactionReference     *string  `json:"transactionReference"`
+	TransactionDescription   *string  `json:"transactionDescription"`
+	Reference                *string  `json:"reference"`
+	Narration                *string  `json:"narration"`
+	Currency                 *string  `json:"currency"`
+	Status                   *string  `json:"status"`
+	SessionID                *string  `json:"sessionId"`
+	DestinationAccountNumber *string  `json:"destinationAccountNumber"`
+	DestinationAccountName   *string  `json:"destinationAccountName"`
+	DestinationBankCode      *string  `json:"destinationBankCode"`
+	CreatedOn                *string  `json:"createdOn"`
+	CompletedOn              *string  `json:"completedOn"`
+}
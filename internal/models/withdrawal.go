@@ -11,16 +11,73 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	WithdrawalPending TransactionStatus = "pending"
+	// WithdrawalApproved is debited from the MOR_ wallet, its transfer to the merchant's bank wasn't accepted by the provider yet
+	WithdrawalApproved TransactionStatus = "approved"
+	// WithdrawalProcessing is a transfer the provider accepted and will confirm through its webhook
+	WithdrawalProcessing TransactionStatus = "processing"
+	WithdrawalSuccessful TransactionStatus = "successful"
+	// WithdrawalFailed is a transfer that failed or was reversed, its debit is given back to the MOR_ wallet
+	WithdrawalFailed    TransactionStatus = "failed"
+	WithdrawalRejected  TransactionStatus = "rejected"
+	WithdrawalCancelled TransactionStatus = "cancelled"
+)
+
+// WithdrawalTransitions are the statuses a withdrawal can move to from each status, a successful transfer only fails when the bank reverses it.
+// An approved withdrawal is only rejected before its transfer reference is set, see RejectWithdrawalService.
+var WithdrawalTransitions = map[TransactionStatus][]TransactionStatus{
+	WithdrawalPending:    {WithdrawalApproved, WithdrawalRejected, WithdrawalCancelled},
+	WithdrawalApproved:   {WithdrawalProcessing, WithdrawalSuccessful, WithdrawalFailed, WithdrawalRejected},
+	WithdrawalProcessing: {WithdrawalSuccessful, WithdrawalFailed},
+	WithdrawalSuccessful: {WithdrawalFailed},
+}
+
+type WithdrawalReversalStatus string
+
+var (
+	// WithdrawalReversalPending is a debited withdrawal that was turned down, its debit is credited back to the MOR_ wallet once the status is saved
+	WithdrawalReversalPending  WithdrawalReversalStatus = "pending"
+	WithdrawalReversalReversed WithdrawalReversalStatus = "reversed"
+)
+
+// WithdrawalDebitedStatuses are the statuses of withdrawals taken out of the MOR_ wallet
+var WithdrawalDebitedStatuses = []TransactionStatus{WithdrawalApproved, WithdrawalProcessing, WithdrawalSuccessful}
+
 type Withdrawal struct {
-	ID             uint                 `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
-	MerchantID     int64                `gorm:"column:merchant_id; type:int" json:"merchant_id"`
-	Merchant       external_models.User `gorm:"-" json:"merchant"`
-	Currency       string               `gorm:"column:currency; type:varchar(255)" json:"currency"`
-	Amount         float64              `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
-	WithdrawalDate time.Time            `gorm:"column:withdrawal_date; autoCreateTime" json:"withdrawal_date"`
-	Status         TransactionStatus    `gorm:"column:status; type:varchar(255)" json:"status"`
-	CreatedAt      time.Time            `gorm:"column:created_at; autoCreateTime" json:"created_at"`
-	UpdatedAt      time.Time            `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+	ID                uint                     `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID        int64                    `gorm:"column:merchant_id; type:int" json:"merchant_id"`
+	Merchant          external_models.User     `gorm:"-" json:"merchant"`
+	Currency          string                   `gorm:"column:currency; type:varchar(255)" json:"currency"`
+	Amount            float64                  `gorm:"column:amount; type:decimal(20,2)" json:"amount"`
+	WithdrawalDate    time.Time                `gorm:"column:withdrawal_date; autoCreateTime" json:"withdrawal_date"`
+	Status            TransactionStatus        `gorm:"column:status; type:varchar(255)" json:"status"`
+	Reason            string                   `gorm:"column:reason; type:varchar(255); comment: why the withdrawal was rejected, cancelled or failed" json:"reason"`
+	BankDetailID      int64                    `gorm:"column:bank_detail_id; type:int" json:"bank_detail_id"`
+	Provider          string                   `gorm:"column:provider; type:varchar(255); comment: provider disbursing the transfer, e.g. monnify" json:"provider"`
+	TransferReference string                   `gorm:"column:transfer_reference; type:varchar(255); index" json:"transfer_reference"`
+	ReversalStatus    WithdrawalReversalStatus `gorm:"column:reversal_status; type:varchar(255); index; comment: pending or reversed, for debited withdrawals turned down" json:"reversal_status"`
+	ApprovedAt        time.Time                `gorm:"column:approved_at" json:"approved_at"`
+	CompletedAt       time.Time                `gorm:"column:completed_at" json:"completed_at"`
+	CreatedAt         time.Time                `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time                `gorm:"column:updated_at; autoUpdateTime" json:"updated_at"`
+}
+
+// WithdrawalAccount is the row withdrawal requests of a merchant in a currency lock,
+// so concurrent requests check the balance left by the pending withdrawals one after the other
+type WithdrawalAccount struct {
+	ID         uint      `gorm:"column:id; type:uint; not null; primaryKey; unique; autoIncrement" json:"id"`
+	MerchantID int64     `gorm:"column:merchant_id; type:int; not null; uniqueIndex:idx_withdrawal_account_merchant_currency" json:"merchant_id"`
+	Currency   string    `gorm:"column:currency; type:varchar(255); not null; uniqueIndex:idx_withdrawal_account_merchant_currency" json:"currency"`
+	CreatedAt  time.Time `gorm:"column:created_at; autoCreateTime" json:"created_at"`
+}
+
+// WithdrawalBalance is what a merchant can still withdraw from a MOR_ wallet
+type WithdrawalBalance struct {
+	Currency           string  `json:"currency"`
+	WalletBalance      float64 `json:"wallet_balance"`
+	PendingWithdrawals float64 `json:"pending_withdrawals"`
+	Available          float64 `json:"available"`
 }
 
 type RequestWithdrawalRequest struct {
@@ -29,6 +86,14 @@ type RequestWithdrawalRequest struct {
 	WithdrawalDate int     `json:"withdrawal_date" validate:"required"`
 }
 
+type RejectWithdrawalRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type CancelWithdrawalRequest struct {
+	Reason string `json:"reason"`
+}
+
 type GetWithdrawalRequest struct {
 	Search         string `json:"search"`
 	CurrencyFilter string `json:"currency"`
@@ -49,6 +114,18 @@ func (w *Withdrawal) GetWithdrawalByID(db *gorm.DB) (int, error) {
 	return http.StatusOK, nil
 }
 
+func (w *Withdrawal) GetWithdrawalByTransferReference(db *gorm.DB) (int, error) {
+	err, nilErr := postgresql.SelectOneFromDb(db, &w, "transfer_reference = ?", w.TransferReference)
+	if nilErr != nil {
+		return http.StatusBadRequest, nilErr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
 func (w *Withdrawal) GetWithdrawals(db *gorm.DB, paginator *postgresql.Pagination, userIds []int, from int, to int) ([]Withdrawal, postgresql.PaginationResponse, error) {
 	var (
 		details    = []Withdrawal{}
@@ -109,3 +186,114 @@ func (w *Withdrawal) UpdateAllFields(db *gorm.DB) error {
 	_, err := postgresql.SaveAllFields(db, &w)
 	return err
 }
+
+// UpdateWithdrawalStatus applies updates to the withdrawal only while it is still in status from, it reports whether it was updated
+func (w *Withdrawal) UpdateWithdrawalStatus(db *gorm.DB, from TransactionStatus, updates map[string]interface{}) (bool, error) {
+	updates["updated_at"] = time.Now()
+	updated, err := postgresql.UpdateFieldsWhere(db, &Withdrawal{}, updates, "id = ? and status = ?", w.ID, from)
+	if err != nil {
+		return false, fmt.Errorf("withdrawal status update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetWithdrawalsToReverse returns the withdrawals whose debit is still to be credited back that weren't touched since updatedBefore, oldest first.
+// A withdrawal with a merchant only returns the merchant's withdrawals.
+func (w *Withdrawal) GetWithdrawalsToReverse(db *gorm.DB, updatedBefore time.Time) ([]Withdrawal, error) {
+	var (
+		details = []Withdrawal{}
+		query   = "reversal_status = ? and updated_at <= ?"
+		args    = []interface{}{WithdrawalReversalPending, updatedBefore}
+	)
+
+	if w.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, w.MerchantID)
+	}
+
+	err := postgresql.SelectAllFromDbOrderBy(db, "id", "asc", &details, query, args...)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// SettleWithdrawalReversal marks the debit of the withdrawal as credited back, it returns false when another run settled it first
+func (w *Withdrawal) SettleWithdrawalReversal(db *gorm.DB) (bool, error) {
+	updated, err := postgresql.UpdateFieldsWhere(db, &Withdrawal{}, map[string]interface{}{
+		"reversal_status": WithdrawalReversalReversed,
+		"updated_at":      time.Now(),
+	}, "id = ? and reversal_status = ?", w.ID, WithdrawalReversalPending)
+	if err != nil {
+		return false, fmt.Errorf("withdrawal reversal update failed: %v", err.Error())
+	}
+	return updated == 1, nil
+}
+
+// GetPendingWithdrawalsTotal sums the merchant's pending withdrawals in the currency
+func (w *Withdrawal) GetPendingWithdrawalsTotal(db *gorm.DB) (float64, error) {
+	var total float64
+	err := db.Model(&Withdrawal{}).Select("COALESCE(SUM(amount), 0)").Where("merchant_id = ? and upper(currency) = ? and status = ?",
+		w.MerchantID, w.Currency, WithdrawalPending).Scan(&total).Error
+	if err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+// GetWithdrawalTotals sums withdrawals debited from the MOR_ wallet and not credited back yet per merchant and currency
+func (w *Withdrawal) GetWithdrawalTotals(db *gorm.DB) ([]ReconciliationTotal, error) {
+	var (
+		details = []ReconciliationTotal{}
+		query   = "(status in (?) or reversal_status = ?)"
+		args    = []interface{}{WithdrawalDebitedStatuses, WithdrawalReversalPending}
+	)
+
+	if w.MerchantID != 0 {
+		query = addQuery(query, "merchant_id = ?", "and")
+		args = append(args, w.MerchantID)
+	}
+
+	err := db.Model(&Withdrawal{}).Select("merchant_id, UPPER(currency) as currency, SUM(amount) as amount").Where(query, args...).Group("merchant_id, UPPER(currency)").Find(&details).Error
+	if err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// GetStatementWithdrawals returns the merchant's withdrawals debited from the MOR_ wallet of the currency dated between start and end, end excluded
+func (w *Withdrawal) GetStatementWithdrawals(db *gorm.DB, start time.Time, end time.Time) ([]Withdrawal, error) {
+	details := []Withdrawal{}
+	err := postgresql.SelectAllFromDbOrderBy(db, "withdrawal_date", "asc", &details, "merchant_id = ? and upper(currency) = ? and status in (?) and withdrawal_date >= ? and withdrawal_date < ?",
+		w.MerchantID, w.Currency, WithdrawalDebitedStatuses, start, end)
+	if err != nil {
+		return details, err
+	}
+	return details, nil
+}
+
+// GetCompletedWithdrawalsTotal sums the merchant's withdrawals debited from the MOR_ wallet of the currency dated before the given time
+func (w *Withdrawal) GetCompletedWithdrawalsTotal(db *gorm.DB, before time.Time) (float64, error) {
+	var total float64
+	err := db.Model(&Withdrawal{}).Select("COALESCE(SUM(amount), 0)").Where("merchant_id = ? and upper(currency) = ? and status in (?) and withdrawal_date < ?",
+		w.MerchantID, w.Currency, WithdrawalDebitedStatuses, before).Scan(&total).Error
+	if err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+// LockWithdrawalAccount creates the merchant's withdrawal account in the currency if needed and locks it until the transaction in db ends
+func (w *WithdrawalAccount) LockWithdrawalAccount(db *gorm.DB) error {
+	_, err := postgresql.CreateOneRecordIfNotExists(db, &WithdrawalAccount{MerchantID: w.MerchantID, Currency: w.Currency})
+	if err != nil {
+		return fmt.Errorf("withdrawal account creation failed: %v", err.Error())
+	}
+
+	err, _ = postgresql.SelectOneFromDbForUpdate(db, &w, "merchant_id = ? and currency = ?", w.MerchantID, w.Currency)
+	if err != nil {
+		return fmt.Errorf("error locking withdrawal account: %v", err.Error())
+	}
+	return nil
+}
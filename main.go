@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/vesicash/mor-api/cronjobs"
+	"github.com/vesicash/mor-api/external/request"
 	"github.com/vesicash/mor-api/internal/config"
 	"github.com/vesicash/mor-api/internal/models/migrations"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/vesicash/mor-api/pkg/router"
+	"github.com/vesicash/mor-api/services/mor-api"
 	"github.com/vesicash/mor-api/utility"
 )
 
@@ -26,6 +29,16 @@ func main() {
 		migrations.RunAllMigrations(db)
 	}
 
+	mor.StartWebhookWorkers(request.ExternalRequest{Logger: logger, Test: false}, db)
+	mor.StartMerchantWebhookWorkers(request.ExternalRequest{Logger: logger, Test: false}, db)
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "dispute-reminders")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "merchant-statements")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "reconcile-wallets")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "release-reserves")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "resume-payouts")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "scheduled-payouts")
+	cronjobs.StartCronJob(request.ExternalRequest{Logger: logger, Test: false}, db, "settle-wallets")
+
 	r := router.Setup(logger, validatorRef, db, &configuration.App)
 	rM := router.SetupMetrics(&configuration.App)
 
@@ -0,0 +1,176 @@
+package mor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/middleware"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) UpdateApprovalPolicy(c *gin.Context) {
+	var (
+		req models.UpdateApprovalPolicyRequest
+	)
+
+	err := c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	policy, code, err := mor.UpdateApprovalPolicyService(base.ExtReq, base.Db, int(user.AccountID), req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", policy)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetApprovalPolicies(c *gin.Context) {
+	policies, code, err := mor.GetApprovalPoliciesService(base.ExtReq, base.Db)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", policies)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetApprovalRequests(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetApprovalRequestsRequest{
+			Action: c.Query("action"),
+			Status: c.Query("status"),
+		}
+	)
+
+	approvals, pagination, code, err := mor.GetApprovalRequestsService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", approvals, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetApprovalRequest(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	approvalID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	approval, code, err := mor.GetApprovalRequestService(base.ExtReq, base.Db, approvalID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", approval)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) ApproveApprovalRequest(c *gin.Context) {
+	base.decideApprovalRequest(c, mor.ApproveApprovalRequestService)
+}
+
+func (base *Controller) DeclineApprovalRequest(c *gin.Context) {
+	base.decideApprovalRequest(c, mor.DeclineApprovalRequestService)
+}
+
+func (base *Controller) decideApprovalRequest(c *gin.Context, decide func(request.ExternalRequest, postgresql.Databases, int, int, models.ApprovalDecisionRequest) (models.ApprovalRequest, int, error)) {
+	var (
+		id  = c.Param("id")
+		req models.ApprovalDecisionRequest
+	)
+
+	approvalID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	approval, code, err := decide(base.ExtReq, base.Db, approvalID, int(user.AccountID), req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, approval)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", approval)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+// approvalRequired answers with the approval request when the action was queued for approval instead of running
+func approvalRequired(c *gin.Context, err error) bool {
+	var approvalErr mor.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		return false
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusAccepted, err.Error(), approvalErr.Request)
+	c.JSON(http.StatusAccepted, rd)
+	return true
+}
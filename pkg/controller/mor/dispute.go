@@ -0,0 +1,287 @@
+package mor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) OpenDispute(c *gin.Context) {
+	var (
+		req models.OpenDisputeRequest
+	)
+
+	err := c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	dispute, code, err := mor.OpenDisputeService(base.ExtReq, base.Db, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", dispute)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetDispute(c *gin.Context) {
+	base.getDispute(c, 0)
+}
+
+func (base *Controller) GetMerchantDispute(c *gin.Context) {
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	base.getDispute(c, int(user.AccountID))
+}
+
+func (base *Controller) GetDisputes(c *gin.Context) {
+	req, err := getDisputesRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	base.getDisputes(c, req)
+}
+
+func (base *Controller) GetMerchantDisputes(c *gin.Context) {
+	req, err := getDisputesRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+	req.AccountID = int(user.AccountID)
+
+	base.getDisputes(c, req)
+}
+
+func (base *Controller) UpdateDisputeStatus(c *gin.Context) {
+	var (
+		id  = c.Param("id")
+		req models.UpdateDisputeStatusRequest
+	)
+
+	disputeID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	dispute, code, err := mor.UpdateDisputeStatusService(base.ExtReq, base.Db, disputeID, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", dispute)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) UploadDisputeEvidence(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	disputeID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		msg := fmt.Sprintf("file is required: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to read file", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to read file", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	evidence, code, err := mor.UploadDisputeEvidenceService(base.ExtReq, base.Db, disputeID, int(user.AccountID), fileHeader.Filename, content, c.PostForm("description"))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", evidence)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) SubmitDispute(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	disputeID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	dispute, code, err := mor.SubmitDisputeService(base.ExtReq, base.Db, disputeID, int(user.AccountID))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", dispute)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) getDispute(c *gin.Context, merchantID int) {
+	var (
+		id = c.Param("id")
+	)
+
+	disputeID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	dispute, code, err := mor.GetDisputeService(base.ExtReq, base.Db, disputeID, merchantID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", dispute)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) getDisputes(c *gin.Context, req models.GetDisputesRequest) {
+	paginator := postgresql.GetPagination(c)
+
+	disputes, pagination, code, err := mor.GetDisputesService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", disputes, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func getDisputesRequest(c *gin.Context) (models.GetDisputesRequest, error) {
+	var (
+		req = models.GetDisputesRequest{
+			Status: c.Query("status"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			return req, fmt.Errorf("invalid account_id: %v", err.Error())
+		}
+		req.AccountID = accountID
+	}
+
+	if c.Query("transaction_id") != "" {
+		transactionID, err := strconv.Atoi(c.Query("transaction_id"))
+		if err != nil {
+			return req, fmt.Errorf("invalid transaction_id: %v", err.Error())
+		}
+		req.TransactionID = transactionID
+	}
+
+	return req, nil
+}
@@ -0,0 +1,178 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) GetLedgerBalances(c *gin.Context) {
+	var (
+		req = models.GetLedgerRequest{
+			Account:  c.Query("account"),
+			Currency: c.Query("currency"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	balances, code, err := mor.GetLedgerBalancesService(base.ExtReq, base.Db, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", balances)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetLedgerStatement(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+	)
+
+	req, err := getLedgerStatementRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	postings, pagination, code, err := mor.GetLedgerStatementService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", postings, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetMerchantLedgerBalances(c *gin.Context) {
+	var (
+		req = models.GetLedgerRequest{
+			Currency: c.Query("currency"),
+		}
+	)
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	balances, code, err := mor.GetMerchantLedgerBalancesService(base.ExtReq, base.Db, req, int(user.AccountID))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", balances)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetMerchantLedgerStatement(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+	)
+
+	req, err := getLedgerStatementRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	postings, pagination, code, err := mor.GetMerchantLedgerStatementService(base.ExtReq, base.Db, paginator, req, int(user.AccountID))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", postings, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) CheckLedger(c *gin.Context) {
+	check, code, err := mor.CheckLedgerService(base.ExtReq, base.Db)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", check)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func getLedgerStatementRequest(c *gin.Context) (models.GetLedgerRequest, error) {
+	var (
+		req = models.GetLedgerRequest{
+			Account:  c.Query("account"),
+			Currency: c.Query("currency"),
+		}
+	)
+
+	if c.Query("from") != "" {
+		from, err := strconv.Atoi(c.Query("from"))
+		if err != nil {
+			return req, fmt.Errorf("invalid from: %v, must be timestamp interger", err.Error())
+		}
+		req.FromTime = from
+	}
+
+	if c.Query("to") != "" {
+		to, err := strconv.Atoi(c.Query("to"))
+		if err != nil {
+			return req, fmt.Errorf("invalid to: %v, must be timestamp interger", err.Error())
+		}
+		req.ToTime = to
+	}
+
+	return req, nil
+}
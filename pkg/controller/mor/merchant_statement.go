@@ -0,0 +1,130 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) GetStatement(c *gin.Context) {
+	req, err := getMerchantStatementRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	base.getMerchantStatement(c, req)
+}
+
+func (base *Controller) GetMerchantStatement(c *gin.Context) {
+	req, err := getMerchantStatementRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+	req.AccountID = int(user.AccountID)
+
+	base.getMerchantStatement(c, req)
+}
+
+func (base *Controller) GetStatements(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetMerchantStatementsRequest{
+			Currency: c.Query("currency"),
+			Period:   c.Query("period"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	statements, pagination, code, err := mor.GetMerchantStatementsService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", statements, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+// getMerchantStatement responds with the statement as json, or as a csv or pdf file when the format asks for one
+func (base *Controller) getMerchantStatement(c *gin.Context, req models.GetMerchantStatementRequest) {
+	err := base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	if req.Format == "csv" || req.Format == "pdf" {
+		data, contentType, name, code, err := mor.ExportMerchantStatementService(base.ExtReq, base.Db, req)
+		if err != nil {
+			rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+			c.JSON(code, rd)
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%v", name))
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+
+	statement, code, err := mor.GetMerchantStatementService(base.ExtReq, base.Db, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", statement)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func getMerchantStatementRequest(c *gin.Context) (models.GetMerchantStatementRequest, error) {
+	var (
+		req = models.GetMerchantStatementRequest{
+			Currency: c.Query("currency"),
+			Period:   c.Query("period"),
+			Format:   c.Query("format"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			return req, fmt.Errorf("invalid account_id: %v", err.Error())
+		}
+		req.AccountID = accountID
+	}
+
+	return req, nil
+}
@@ -0,0 +1,179 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) CreateMerchantWebhookEndpoint(c *gin.Context) {
+	var (
+		req models.CreateMerchantWebhookEndpointRequest
+	)
+
+	err := c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	endpoint, code, err := mor.CreateMerchantWebhookEndpointService(base.ExtReq, base.Db, int(user.AccountID), req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusCreated, "successfully created", endpoint)
+	c.JSON(http.StatusCreated, rd)
+
+}
+
+func (base *Controller) GetMerchantWebhookEndpoints(c *gin.Context) {
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	endpoints, code, err := mor.GetMerchantWebhookEndpointsService(base.ExtReq, base.Db, int(user.AccountID))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", endpoints)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) DeleteMerchantWebhookEndpoint(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	endpointID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	code, err := mor.DeleteMerchantWebhookEndpointService(base.ExtReq, base.Db, int(user.AccountID), endpointID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successfully deleted", nil)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) PingMerchantWebhookEndpoint(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	endpointID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	delivery, code, err := mor.PingMerchantWebhookEndpointService(base.ExtReq, base.Db, int(user.AccountID), endpointID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", delivery)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetMerchantWebhookDeliveries(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetMerchantWebhookDeliveriesRequest{
+			Event:  c.Query("event"),
+			Status: c.Query("status"),
+		}
+	)
+
+	if c.Query("endpoint_id") != "" {
+		endpointID, err := strconv.Atoi(c.Query("endpoint_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid endpoint_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.EndpointID = endpointID
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	deliveries, pagination, code, err := mor.GetMerchantWebhookDeliveriesService(base.ExtReq, base.Db, paginator, int(user.AccountID), req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", deliveries, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
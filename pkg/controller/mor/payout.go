@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/middleware"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services/mor-api"
 	"github.com/vesicash/mor-api/utility"
@@ -99,14 +100,347 @@ func (base *Controller) PayOutToWallets(c *gin.Context) {
 		return
 	}
 
-	msg, code, err := mor.PayOutToWalletsService(base.ExtReq, base.Db, req)
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	batch, msg, code, err := mor.PayOutToWalletsService(base.ExtReq, base.Db, req, int(user.AccountID))
+	if err != nil {
+		if approvalRequired(c, err) {
+			return
+		}
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, batch)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, msg, batch)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) ResumePayout(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	payoutID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	payout, code, err := mor.ResumePayoutService(base.ExtReq, base.Db, payoutID, int(user.AccountID))
+	if err != nil {
+		if approvalRequired(c, err) {
+			return
+		}
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", payout)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetPayoutBatches(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetPayoutBatchesRequest{
+			TriggeredBy: c.Query("triggered_by"),
+			Status:      c.Query("status"),
+		}
+	)
+
+	batches, pagination, code, err := mor.GetPayoutBatchesService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", batches, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetPayoutBatch(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	batchID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	batch, code, err := mor.GetPayoutBatchService(base.ExtReq, base.Db, batchID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", batch)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetPayoutBatchLines(c *gin.Context) {
+	var (
+		id        = c.Param("id")
+		paginator = postgresql.GetPagination(c)
+	)
+
+	batchID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	lines, pagination, code, err := mor.GetPayoutBatchLinesService(base.ExtReq, base.Db, paginator, batchID, c.Query("status"))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", lines, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) CancelPayoutBatch(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	batchID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	batch, code, err := mor.CancelPayoutBatchService(base.ExtReq, base.Db, batchID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "cancelled", batch)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) RetryPayoutBatch(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	batchID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	batch, code, err := mor.RetryPayoutBatchService(base.ExtReq, base.Db, batchID, int(user.AccountID))
+	if err != nil {
+		if approvalRequired(c, err) {
+			return
+		}
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", batch)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetPayoutReserves(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetPayoutReservesRequest{
+			Status: c.Query("status"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	reserves, pagination, code, err := mor.GetPayoutReservesService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", reserves, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetPayoutRuns(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+	)
+
+	runs, pagination, code, err := mor.GetPayoutRunsService(base.ExtReq, base.Db, paginator, c.Query("status"))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", runs, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) UpdatePayoutPolicy(c *gin.Context) {
+	var (
+		id  = c.Param("account_id")
+		req models.UpdatePayoutPolicyRequest
+	)
+
+	accountID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	setting, code, err := mor.UpdatePayoutPolicyService(base.ExtReq, base.Db, accountID, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", setting)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) HoldPayouts(c *gin.Context) {
+	var (
+		id  = c.Param("account_id")
+		req models.HoldPayoutsRequest
+	)
+
+	accountID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	setting, code, err := mor.HoldPayoutsService(base.ExtReq, base.Db, accountID, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", setting)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) LiftPayoutHold(c *gin.Context) {
+	var (
+		id = c.Param("account_id")
+	)
+
+	accountID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	setting, code, err := mor.LiftPayoutHoldService(base.ExtReq, base.Db, accountID)
 	if err != nil {
 		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
 		c.JSON(code, rd)
 		return
 	}
 
-	rd := utility.BuildSuccessResponse(http.StatusOK, msg, nil)
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", setting)
 	c.JSON(http.StatusOK, rd)
 
 }
@@ -0,0 +1,141 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) RunReconciliation(c *gin.Context) {
+	var (
+		req = models.RunReconciliationRequest{}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = int64(accountID)
+	}
+
+	run, code, err := mor.RunReconciliationService(base.ExtReq, base.Db, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", run)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetReconciliationRuns(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+	)
+
+	runs, pagination, code, err := mor.GetReconciliationRunsService(base.ExtReq, base.Db, paginator)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", runs, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetReconciliationReports(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetReconciliationReportsRequest{
+			Currency: c.Query("currency"),
+			Status:   c.Query("status"),
+		}
+	)
+
+	if c.Query("run_id") != "" {
+		runID, err := strconv.Atoi(c.Query("run_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid run_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.RunID = runID
+	}
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	reports, pagination, code, err := mor.GetReconciliationReportsService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", reports, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) ResolveReconciliationReport(c *gin.Context) {
+	var (
+		id  = c.Param("id")
+		req models.ResolveReconciliationReportRequest
+	)
+
+	reportID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	report, code, err := mor.ResolveReconciliationReportService(base.ExtReq, base.Db, reportID, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", report)
+	c.JSON(http.StatusOK, rd)
+
+}
@@ -0,0 +1,141 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func (base *Controller) RefundTransaction(c *gin.Context) {
+	base.refundTransaction(c, 0, models.RefundInitiatedByAdmin)
+}
+
+func (base *Controller) RefundMerchantTransaction(c *gin.Context) {
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	base.refundTransaction(c, int(user.AccountID), models.RefundInitiatedByMerchant)
+}
+
+func (base *Controller) GetRefunds(c *gin.Context) {
+	req, err := getRefundsRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	base.getRefunds(c, req)
+}
+
+func (base *Controller) GetMerchantRefunds(c *gin.Context) {
+	req, err := getRefundsRequest(c)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+	req.AccountID = int(user.AccountID)
+
+	base.getRefunds(c, req)
+}
+
+func (base *Controller) refundTransaction(c *gin.Context, merchantID int, initiatedBy models.RefundInitiator) {
+	var (
+		id  = c.Param("id")
+		req models.CreateRefundRequest
+	)
+
+	transactionID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	refund, code, err := mor.CreateRefundService(base.ExtReq, base.Db, transactionID, merchantID, initiatedBy, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", refund)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) getRefunds(c *gin.Context, req models.GetRefundsRequest) {
+	paginator := postgresql.GetPagination(c)
+
+	refunds, pagination, code, err := mor.GetRefundsService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", refunds, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func getRefundsRequest(c *gin.Context) (models.GetRefundsRequest, error) {
+	var (
+		req = models.GetRefundsRequest{
+			RecoveryStatus: c.Query("recovery_status"),
+		}
+	)
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			return req, fmt.Errorf("invalid account_id: %v", err.Error())
+		}
+		req.AccountID = accountID
+	}
+
+	if c.Query("transaction_id") != "" {
+		transactionID, err := strconv.Atoi(c.Query("transaction_id"))
+		if err != nil {
+			return req, fmt.Errorf("invalid transaction_id: %v", err.Error())
+		}
+		req.TransactionID = transactionID
+	}
+
+	return req, nil
+}
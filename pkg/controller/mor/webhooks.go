@@ -1,9 +1,13 @@
 package mor
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services/mor-api"
 	"github.com/vesicash/mor-api/utility"
 )
@@ -25,3 +29,120 @@ func (base *Controller) MerchantWebhooks(c *gin.Context) {
 	c.JSON(http.StatusOK, rd)
 
 }
+
+func (base *Controller) GetWebhookLogs(c *gin.Context) {
+	var (
+		paginator = postgresql.GetPagination(c)
+		req       = models.GetWebhookLogsRequest{
+			Search:   c.Query("search"),
+			Status:   c.Query("status"),
+			Provider: c.Query("provider"),
+		}
+	)
+
+	if c.Query("from") != "" {
+		from, err := strconv.Atoi(c.Query("from"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid from: %v, must be timestamp interger", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.FromTime = from
+	}
+
+	if c.Query("to") != "" {
+		to, err := strconv.Atoi(c.Query("to"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid to: %v, must be timestamp interger", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.ToTime = to
+	}
+
+	if c.Query("account_id") != "" {
+		accountID, err := strconv.Atoi(c.Query("account_id"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid account_id: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		req.AccountID = accountID
+	}
+
+	webhookLogs, pagination, code, err := mor.GetWebhookLogsService(base.ExtReq, base.Db, paginator, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", webhookLogs, pagination)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) GetWebhookLog(c *gin.Context) {
+	var (
+		id = c.Param("id")
+	)
+
+	webhookLogID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	webhookLog, code, err := mor.GetWebhookLogService(base.ExtReq, base.Db, webhookLogID)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", webhookLog)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) ReplayWebhookLog(c *gin.Context) {
+	var (
+		id     = c.Param("id")
+		dryRun = false
+	)
+
+	if c.Query("dry_run") != "" {
+		value, err := strconv.ParseBool(c.Query("dry_run"))
+		if err != nil {
+			msg := fmt.Sprintf("invalid dry_run: %v", err.Error())
+			rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+			c.JSON(http.StatusBadRequest, rd)
+			return
+		}
+		dryRun = value
+	}
+
+	webhookLogID, err := strconv.Atoi(id)
+	if err != nil {
+		msg := fmt.Sprintf("invalid id: %v", err.Error())
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	result, code, err := mor.ReplayWebhookLogService(base.ExtReq, base.Db, webhookLogID, dryRun)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", result)
+	c.JSON(http.StatusOK, rd)
+
+}
@@ -1,12 +1,15 @@
 package mor
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/middleware"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services/mor-api"
 	"github.com/vesicash/mor-api/utility"
@@ -51,6 +54,27 @@ func (base *Controller) RequestWithdrawal(c *gin.Context) {
 
 }
 
+func (base *Controller) GetWithdrawalBalance(c *gin.Context) {
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	balance, code, err := mor.GetWithdrawalBalanceService(base.ExtReq, base.Db, int(user.AccountID), c.Query("currency"))
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", balance)
+	c.JSON(http.StatusOK, rd)
+
+}
+
 func (base *Controller) GetWithdrawals(c *gin.Context) {
 	var (
 		paginator = postgresql.GetPagination(c)
@@ -107,8 +131,19 @@ func (base *Controller) CompleteWithdrawal(c *gin.Context) {
 		return
 	}
 
-	code, err := mor.CompleteWithdrawalService(base.ExtReq, base.Db, withdrawalID)
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	code, err := mor.CompleteWithdrawalService(base.ExtReq, base.Db, withdrawalID, int(user.AccountID))
 	if err != nil {
+		if approvalRequired(c, err) {
+			return
+		}
 		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
 		c.JSON(code, rd)
 		return
@@ -119,4 +154,122 @@ func (base *Controller) CompleteWithdrawal(c *gin.Context) {
 
 }
 
+func (base *Controller) ApproveWithdrawal(c *gin.Context) {
+	var (
+		withdrawalIDStr = c.Param("withdrawal_id")
+	)
+
+	withdrawalID, err := strconv.Atoi(withdrawalIDStr)
+	if err != nil {
+		err = fmt.Errorf("invalid id: %v", err)
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := middleware.GetBusinessAdmin(c)
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	withdrawal, code, err := mor.ApproveWithdrawalService(base.ExtReq, base.Db, withdrawalID, int(user.AccountID))
+	if err != nil {
+		if approvalRequired(c, err) {
+			return
+		}
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", withdrawal)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) RejectWithdrawal(c *gin.Context) {
+	var (
+		withdrawalIDStr = c.Param("withdrawal_id")
+		req             models.RejectWithdrawalRequest
+	)
+
+	withdrawalID, err := strconv.Atoi(withdrawalIDStr)
+	if err != nil {
+		err = fmt.Errorf("invalid id: %v", err)
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = c.ShouldBind(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	err = base.Validator.Struct(&req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Validation failed", utility.ValidationResponse(err, base.Validator), nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	withdrawal, code, err := mor.RejectWithdrawalService(base.ExtReq, base.Db, withdrawalID, req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", withdrawal)
+	c.JSON(http.StatusOK, rd)
+
+}
+
+func (base *Controller) CancelWithdrawal(c *gin.Context) {
+	var (
+		withdrawalIDStr = c.Param("withdrawal_id")
+		req             models.CancelWithdrawalRequest
+	)
+
+	withdrawalID, err := strconv.Atoi(withdrawalIDStr)
+	if err != nil {
+		err = fmt.Errorf("invalid id: %v", err)
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", err.Error(), err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	// the reason is optional, so is the body
+	err = c.ShouldBind(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		rd := utility.BuildErrorResponse(http.StatusBadRequest, "error", "Failed to parse request body", err, nil)
+		c.JSON(http.StatusBadRequest, rd)
+		return
+	}
+
+	user := models.MyIdentity
+	if user == nil {
+		msg := "error retrieving authenticated user"
+		rd := utility.BuildErrorResponse(http.StatusInternalServerError, "error", msg, fmt.Errorf(msg), nil)
+		c.JSON(http.StatusInternalServerError, rd)
+		return
+	}
+
+	withdrawal, code, err := mor.CancelWithdrawalService(base.ExtReq, base.Db, withdrawalID, int(user.AccountID), req)
+	if err != nil {
+		rd := utility.BuildErrorResponse(code, "error", err.Error(), err, nil)
+		c.JSON(code, rd)
+		return
+	}
+
+	rd := utility.BuildSuccessResponse(http.StatusOK, "successful", withdrawal)
+	c.JSON(http.StatusOK, rd)
+
+}
+
 // CompleteWithdrawal
@@ -20,6 +20,9 @@ const (
 	AuthType      AuthorizationType = "auth"
 	BusinessAdmin AuthorizationType = "business_admin"
 	Business      AuthorizationType = "business"
+
+	// businessAdminKey holds the business admin validated for the request on the gin context
+	businessAdminKey = "business_admin"
 )
 
 type (
@@ -159,9 +162,21 @@ func (at AuthorizationType) ValidateBusinessAdminType(c *gin.Context, extReq req
 	if !dataResponse.Status {
 		return dataResponse.Message, false
 	}
+
+	c.Set(businessAdminKey, &dataResponse.Data)
 	return "authorized", true
 }
 
+// GetBusinessAdmin returns the business admin validated for the request, nil when it didn't pass the BusinessAdmin authorization
+func GetBusinessAdmin(c *gin.Context) *external_models.User {
+	admin, ok := c.Get(businessAdminKey)
+	if !ok {
+		return nil
+	}
+	user, _ := admin.(*external_models.User)
+	return user
+}
+
 func (at AuthorizationType) ValidateApiType(c *gin.Context, extReq request.ExternalRequest) (string, bool) {
 	privateKey, publicKey, msg, status := at.getAccessTokens(c)
 	if !status {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func CreateOneRecord(db *gorm.DB, model interface{}) error {
@@ -16,3 +17,24 @@ func CreateOneRecord(db *gorm.DB, model interface{}) error {
 	}
 	return nil
 }
+
+// CreateOneRecordIfNotExists inserts model unless it conflicts with a unique constraint, it reports whether a row was inserted
+func CreateOneRecordIfNotExists(db *gorm.DB, model interface{}) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(model)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CreateMultipleRecords inserts a slice of models in batches of batchSize
+func CreateMultipleRecords(db *gorm.DB, models interface{}, batchSize int, length int) error {
+	result := db.CreateInBatches(models, batchSize)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(length) {
+		return fmt.Errorf("records creation failed")
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -184,6 +185,17 @@ func SelectOneFromDb(db *gorm.DB, receiver interface{}, query interface{}, args
 	}
 	return tx.Error, nil
 }
+
+// SelectOneFromDbForUpdate is SelectOneFromDb that locks the row until the surrounding transaction ends
+func SelectOneFromDbForUpdate(db *gorm.DB, receiver interface{}, query interface{}, args ...interface{}) (error, error) {
+
+	tx := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).First(receiver)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return tx.Error, tx.Error
+	}
+	return tx.Error, nil
+}
+
 func SelectLatestFromDb(db *gorm.DB, receiver interface{}, query interface{}, args ...interface{}) (error, error) {
 
 	tx := db.Order("id desc").Where(query, args...).First(receiver)
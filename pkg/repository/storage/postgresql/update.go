@@ -11,3 +11,12 @@ func SaveAllFields(db *gorm.DB, model interface{}) (*gorm.DB, error) {
 	}
 	return result, nil
 }
+
+// UpdateFieldsWhere updates the given columns on every row matching query and returns the number of rows changed
+func UpdateFieldsWhere(db *gorm.DB, model interface{}, updates interface{}, query interface{}, args ...interface{}) (int64, error) {
+	result := db.Model(model).Where(query, args...).Updates(updates)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
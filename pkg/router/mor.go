@@ -26,8 +26,20 @@ func Mor(r *gin.Engine, ApiVersion string, validator *validator.Validate, db pos
 		morAuthUrl.GET("/customers", mor.GetCustomers)
 		morAuthUrl.GET("/transactions/get", mor.GetMerchantTransactions)
 		morAuthUrl.GET("/transactions/summary/:account_id", mor.GetMerchantTransactionsSummary)
+		morAuthUrl.GET("/transactions/document/:id", mor.GetMerchantTransactionDocument)
+		morAuthUrl.POST("/transactions/refund/:id", mor.RefundMerchantTransaction)
+		morAuthUrl.GET("/refunds/get", mor.GetMerchantRefunds)
+		morAuthUrl.GET("/disputes/get", mor.GetMerchantDisputes)
+		morAuthUrl.GET("/disputes/get/:id", mor.GetMerchantDispute)
+		morAuthUrl.POST("/disputes/evidence/:id", mor.UploadDisputeEvidence)
+		morAuthUrl.POST("/disputes/submit/:id", mor.SubmitDispute)
 		morAuthUrl.GET("/payouts/get", mor.GetMerchantPayouts)
 		morAuthUrl.POST("/withdrawal/request", mor.RequestWithdrawal)
+		morAuthUrl.GET("/withdrawal/balance", mor.GetWithdrawalBalance)
+		morAuthUrl.PATCH("/withdrawal/cancel/:withdrawal_id", mor.CancelWithdrawal)
+		morAuthUrl.GET("/ledger/balances", mor.GetMerchantLedgerBalances)
+		morAuthUrl.GET("/ledger/statement", mor.GetMerchantLedgerStatement)
+		morAuthUrl.GET("/statements/get", mor.GetMerchantStatement)
 	}
 
 	morSettingsAuthUrl := r.Group(fmt.Sprintf("%v/settings", ApiVersion), middleware.Authorize(db, extReq, middleware.AuthType))
@@ -36,12 +48,28 @@ func Mor(r *gin.Engine, ApiVersion string, validator *validator.Validate, db pos
 		morSettingsAuthUrl.POST("/save", mor.SaveSettings)
 		morSettingsAuthUrl.POST("/payment-methods/:action", mor.EnableOrDisablePaymentMethods)
 		morSettingsAuthUrl.POST("/wallets/:action", mor.AddRemoveOrGetWallets)
+		morSettingsAuthUrl.POST("/payout-schedule", mor.UpdatePayoutSchedule)
+		morSettingsAuthUrl.POST("/settlement-currency", mor.UpdateSettlementCurrency)
 	}
 
 	paymentBusinessAdminUrl := r.Group(fmt.Sprintf("%v/admin", ApiVersion), middleware.Authorize(db, extReq, middleware.BusinessAdmin))
 	{
 		paymentBusinessAdminUrl.POST("/transaction/record", mor.RecordTransaction)
 		paymentBusinessAdminUrl.GET("/transaction/get/:id", mor.GetTransaction)
+		paymentBusinessAdminUrl.GET("/transaction/document/:id", mor.GetTransactionDocument)
+		paymentBusinessAdminUrl.POST("/transaction/document/email/:id", mor.EmailTransactionDocument)
+		paymentBusinessAdminUrl.POST("/transaction/refund/:id", mor.RefundTransaction)
+		paymentBusinessAdminUrl.GET("/refunds/get", mor.GetRefunds)
+		paymentBusinessAdminUrl.POST("/approval/policy", mor.UpdateApprovalPolicy)
+		paymentBusinessAdminUrl.GET("/approval/policies", mor.GetApprovalPolicies)
+		paymentBusinessAdminUrl.GET("/approvals/get", mor.GetApprovalRequests)
+		paymentBusinessAdminUrl.GET("/approval/get/:id", mor.GetApprovalRequest)
+		paymentBusinessAdminUrl.POST("/approval/approve/:id", mor.ApproveApprovalRequest)
+		paymentBusinessAdminUrl.POST("/approval/decline/:id", mor.DeclineApprovalRequest)
+		paymentBusinessAdminUrl.POST("/dispute/open", mor.OpenDispute)
+		paymentBusinessAdminUrl.GET("/dispute/get/:id", mor.GetDispute)
+		paymentBusinessAdminUrl.GET("/disputes/get", mor.GetDisputes)
+		paymentBusinessAdminUrl.POST("/dispute/status/:id", mor.UpdateDisputeStatus)
 		paymentBusinessAdminUrl.GET("/transactions/get", mor.GetTransactions)
 		paymentBusinessAdminUrl.GET("/transactions/summary", mor.GetTransactionsSummary)
 		paymentBusinessAdminUrl.GET("/transactions/summary/:account_id", mor.GetMerchantTransactionsSummary)
@@ -52,9 +80,61 @@ func Mor(r *gin.Engine, ApiVersion string, validator *validator.Validate, db pos
 		paymentBusinessAdminUrl.GET("/payout/get/:id", mor.GetPayout)
 		paymentBusinessAdminUrl.GET("/payouts/get", mor.GetPayouts)
 		paymentBusinessAdminUrl.POST("/payout/to-wallet", mor.PayOutToWallets)
+		paymentBusinessAdminUrl.POST("/payout/resume/:id", mor.ResumePayout)
+		paymentBusinessAdminUrl.GET("/payout/batches/get", mor.GetPayoutBatches)
+		paymentBusinessAdminUrl.GET("/payout/batches/get/:id", mor.GetPayoutBatch)
+		paymentBusinessAdminUrl.GET("/payout/batches/lines/:id", mor.GetPayoutBatchLines)
+		paymentBusinessAdminUrl.POST("/payout/batches/cancel/:id", mor.CancelPayoutBatch)
+		paymentBusinessAdminUrl.POST("/payout/batches/retry/:id", mor.RetryPayoutBatch)
+		paymentBusinessAdminUrl.GET("/payout/reserves/get", mor.GetPayoutReserves)
+		paymentBusinessAdminUrl.GET("/payout/runs/get", mor.GetPayoutRuns)
+		paymentBusinessAdminUrl.POST("/payout/policy/:account_id", mor.UpdatePayoutPolicy)
+		paymentBusinessAdminUrl.POST("/payout/hold/:account_id", mor.HoldPayouts)
+		paymentBusinessAdminUrl.DELETE("/payout/hold/:account_id", mor.LiftPayoutHold)
 
 		paymentBusinessAdminUrl.GET("/withdrawal/get-all", mor.GetWithdrawals)
+		paymentBusinessAdminUrl.PATCH("/withdrawal/approve/:withdrawal_id", mor.ApproveWithdrawal)
+		paymentBusinessAdminUrl.PATCH("/withdrawal/reject/:withdrawal_id", mor.RejectWithdrawal)
 		paymentBusinessAdminUrl.PATCH("/withdrawal/complete/:withdrawal_id", mor.CompleteWithdrawal)
+
+		paymentBusinessAdminUrl.GET("/ledger/balances", mor.GetLedgerBalances)
+		paymentBusinessAdminUrl.GET("/ledger/statement", mor.GetLedgerStatement)
+		paymentBusinessAdminUrl.GET("/ledger/check", mor.CheckLedger)
+
+		paymentBusinessAdminUrl.GET("/statements/get", mor.GetStatement)
+		paymentBusinessAdminUrl.GET("/statements/list", mor.GetStatements)
+
+		paymentBusinessAdminUrl.POST("/tax/rates/create", mor.CreateTaxRate)
+		paymentBusinessAdminUrl.GET("/tax/rates/get", mor.GetTaxRates)
+		paymentBusinessAdminUrl.POST("/tax/calculate", mor.CalculateTax)
+		paymentBusinessAdminUrl.GET("/tax/reports/get", mor.GetTaxReports)
+		paymentBusinessAdminUrl.GET("/tax/reports/lines", mor.GetTaxReportLines)
+		paymentBusinessAdminUrl.GET("/tax/reports/export", mor.ExportTaxReport)
+		paymentBusinessAdminUrl.POST("/tax/reports/close", mor.CloseTaxFiling)
+	}
+
+	morWebhooksAuthUrl := r.Group(fmt.Sprintf("%v/webhooks", ApiVersion), middleware.Authorize(db, extReq, middleware.AuthType))
+	{
+		morWebhooksAuthUrl.POST("/endpoints/create", mor.CreateMerchantWebhookEndpoint)
+		morWebhooksAuthUrl.GET("/endpoints/get", mor.GetMerchantWebhookEndpoints)
+		morWebhooksAuthUrl.DELETE("/endpoints/delete/:id", mor.DeleteMerchantWebhookEndpoint)
+		morWebhooksAuthUrl.POST("/endpoints/ping/:id", mor.PingMerchantWebhookEndpoint)
+		morWebhooksAuthUrl.GET("/deliveries/get", mor.GetMerchantWebhookDeliveries)
+	}
+
+	webhooksBusinessAdminUrl := r.Group(fmt.Sprintf("%v/admin/webhooks", ApiVersion), middleware.Authorize(db, extReq, middleware.BusinessAdmin))
+	{
+		webhooksBusinessAdminUrl.GET("/get", mor.GetWebhookLogs)
+		webhooksBusinessAdminUrl.GET("/get/:id", mor.GetWebhookLog)
+		webhooksBusinessAdminUrl.POST("/replay/:id", mor.ReplayWebhookLog)
+	}
+
+	reconciliationBusinessAdminUrl := r.Group(fmt.Sprintf("%v/admin/reconciliation", ApiVersion), middleware.Authorize(db, extReq, middleware.BusinessAdmin))
+	{
+		reconciliationBusinessAdminUrl.POST("/run", mor.RunReconciliation)
+		reconciliationBusinessAdminUrl.GET("/runs/get", mor.GetReconciliationRuns)
+		reconciliationBusinessAdminUrl.GET("/reports/get", mor.GetReconciliationReports)
+		reconciliationBusinessAdminUrl.PATCH("/reports/resolve/:id", mor.ResolveReconciliationReport)
 	}
 
 	morjobsUrl := r.Group(fmt.Sprintf("%v/jobs", ApiVersion))
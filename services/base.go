@@ -395,22 +395,28 @@ func GetRateByID(extReq request.ExternalRequest, rateID int) (external_models.Ra
 	return rate, nil
 }
 
-func CreateExchangeTransaction(extReq request.ExternalRequest, accountID, rateID int, initialAmount, finalAmount float64, status ExchangeTransactionStatus) error {
+func CreateExchangeTransaction(extReq request.ExternalRequest, accountID, rateID int, initialAmount, finalAmount float64, status ExchangeTransactionStatus, reference string) (external_models.ExchangeTransaction, error) {
 
-	_, err := extReq.SendExternalRequest(request.CreateExchangeTransaction, external_models.CreateExchangeTransactionRequest{
+	exchangeItf, err := extReq.SendExternalRequest(request.CreateExchangeTransaction, external_models.CreateExchangeTransactionRequest{
 		AccountID:     accountID,
 		InitialAmount: initialAmount,
 		FinalAmount:   finalAmount,
 		RateID:        rateID,
 		Status:        string(status),
+		Reference:     reference,
 	})
 
 	if err != nil {
 		extReq.Logger.Error(err.Error())
-		return err
+		return external_models.ExchangeTransaction{}, err
 	}
 
-	return nil
+	exchange, ok := exchangeItf.(external_models.ExchangeTransaction)
+	if !ok {
+		return external_models.ExchangeTransaction{}, fmt.Errorf("response data format error")
+	}
+
+	return exchange, nil
 }
 func GetUserCredentialByAccountIdAndType(extReq request.ExternalRequest, accountID int, iType string) (external_models.UsersCredential, error) {
 
@@ -497,6 +503,40 @@ func GetBank(extReq request.ExternalRequest, id int, name, code, country string)
 	return bank, nil
 }
 
+func RaveInitTransfer(extReq request.ExternalRequest, req external_models.RaveInitTransferRequest) (external_models.RaveInitTransferResponseData, error) {
+	transferItf, err := extReq.SendExternalRequest(request.RaveInitTransfer, req)
+	if err != nil {
+		return external_models.RaveInitTransferResponseData{}, err
+	}
+
+	transfer, ok := transferItf.(external_models.RaveInitTransferResponse)
+	if !ok {
+		return transfer.Data, fmt.Errorf("response data format error")
+	}
+	if !strings.EqualFold(transfer.Status, "success") {
+		return transfer.Data, fmt.Errorf("transfer not queued: %v", transfer.Message)
+	}
+
+	return transfer.Data, nil
+}
+
+func MonnifyInitTransfer(extReq request.ExternalRequest, req external_models.MonnifyInitTransferRequest) (external_models.MonnifyInitTransferResponseBody, error) {
+	transferItf, err := extReq.SendExternalRequest(request.MonnifyInitTransfer, req)
+	if err != nil {
+		return external_models.MonnifyInitTransferResponseBody{}, err
+	}
+
+	transfer, ok := transferItf.(external_models.MonnifyInitTransferResponse)
+	if !ok {
+		return transfer.ResponseBody, fmt.Errorf("response data format error")
+	}
+	if !transfer.RequestSuccessful {
+		return transfer.ResponseBody, fmt.Errorf("transfer not initiated: %v", transfer.ResponseMessage)
+	}
+
+	return transfer.ResponseBody, nil
+}
+
 func HasBvn(extReq request.ExternalRequest, accountID uint) bool {
 	userCredential, err := GetUserCredentialByAccountIdAndType(extReq, int(accountID), "bvn")
 	if err != nil {
@@ -686,3 +726,20 @@ func ToggleMORStatus(extReq request.ExternalRequest, accountId uint, status bool
 
 	return nil
 }
+
+func UploadFile(extReq request.ExternalRequest, name string, file []byte) (external_models.UploadFileResponseData, error) {
+	uploadItf, err := extReq.SendExternalRequest(request.UploadFile, external_models.UploadFileRequest{
+		PlaceHolderName: name,
+		File:            file,
+	})
+	if err != nil {
+		return external_models.UploadFileResponseData{}, err
+	}
+
+	upload, ok := uploadItf.(external_models.UploadFileResponseData)
+	if !ok {
+		return external_models.UploadFileResponseData{}, fmt.Errorf("response data format error")
+	}
+
+	return upload, nil
+}
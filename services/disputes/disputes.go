@@ -0,0 +1,340 @@
+package disputes
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+const (
+	// evidenceDays is the evidence deadline of a dispute opened without one
+	evidenceDays = 7
+	// NotificationOpened and NotificationReminder are the notification events besides status changes, which are named after the new status
+	NotificationOpened   = "opened"
+	NotificationReminder = "reminder"
+)
+
+// OpenDispute opens a dispute against a successful transaction and holds the disputed funds.
+// A transaction not paid out yet is held back from payouts, otherwise the amount is debited from the merchant's MOR_ wallet
+// when it can cover it. A dispute without an amount disputes whatever wasn't refunded yet.
+// A dry run records the hold as if the debit went through, without debiting the wallet or notifying the merchant.
+func OpenDispute(extReq request.ExternalRequest, db postgresql.Databases, transaction models.Transaction, dispute models.Dispute, dryRun bool) (models.Dispute, int, error) {
+	if transaction.Status != models.TransactionSuccessful {
+		return dispute, http.StatusBadRequest, fmt.Errorf("transaction %v is %v, only successful transactions can be disputed", transaction.Reference, transaction.Status)
+	}
+
+	disputable := roundAmount(transaction.Amount - transaction.RefundedAmount)
+	if dispute.Amount == 0 {
+		dispute.Amount = disputable
+	}
+	if dispute.Amount <= 0 || roundAmount(dispute.Amount) > disputable {
+		return dispute, http.StatusBadRequest, fmt.Errorf("dispute amount must be more than 0 and at most %v", disputable)
+	}
+
+	currency, err := getCurrency(extReq, transaction.CountryID)
+	if err != nil {
+		return dispute, http.StatusInternalServerError, err
+	}
+
+	dispute.TransactionID = int64(transaction.ID)
+	dispute.MerchantID = transaction.MerchantID
+	dispute.CountryID = transaction.CountryID
+	dispute.Status = models.DisputeOpened
+	dispute.HoldMethod = models.DisputeHoldPayout
+	if dispute.ProviderReference == "" {
+		dispute.ProviderReference = utility.RandomString(25)
+	}
+	if dispute.EvidenceDueAt.IsZero() {
+		dispute.EvidenceDueAt = time.Now().AddDate(0, 0, evidenceDays)
+	}
+
+	if transaction.PayoutID != 0 || transaction.IsPaidOut {
+		dispute.HoldMethod = getWalletHoldMethod(extReq, dispute, currency)
+	}
+
+	if dispute.HoldMethod == models.DisputeHoldWallet {
+		dispute.HoldStatus = models.DisputeHoldPending
+	}
+
+	err = dispute.CreateDispute(db.MOR)
+	if err != nil {
+		return dispute, http.StatusInternalServerError, err
+	}
+
+	if dispute.HoldMethod == models.DisputeHoldNone {
+		extReq.Logger.Error(fmt.Sprintf("dispute %v of transaction %v is not secured, the MOR_%v wallet of merchant %v can't cover %v", dispute.ID, transaction.Reference, currency, dispute.MerchantID, dispute.Amount))
+	}
+
+	if !dryRun {
+		Notify(extReq, dispute, transaction, currency, NotificationOpened)
+	}
+
+	return dispute, http.StatusOK, nil
+}
+
+// UpdateDisputeStatus moves the dispute along its lifecycle. A won dispute gives the held funds back to the merchant through SettleDispute,
+// a lost one pays them to the customer and takes the disputed amount off the transaction. A dry run doesn't notify the merchant.
+func UpdateDisputeStatus(extReq request.ExternalRequest, db postgresql.Databases, dispute *models.Dispute, status models.DisputeStatus, note string, dryRun bool) (int, error) {
+	if !status.In(models.DisputeTransitions[dispute.Status]) {
+		return http.StatusBadRequest, fmt.Errorf("dispute %v is %v, it can't move to %v", dispute.ID, dispute.Status, status)
+	}
+
+	transaction := models.Transaction{ID: uint(dispute.TransactionID)}
+	_, err := transaction.GetTransactionByID(db.MOR)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error getting transaction %v of dispute %v: %v", dispute.TransactionID, dispute.ID, err.Error())
+	}
+
+	currency, err := getCurrency(extReq, dispute.CountryID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	from := dispute.Status
+	updates := map[string]interface{}{"status": status}
+	if note != "" {
+		updates["note"] = note
+	}
+	switch status {
+	case models.DisputeSubmitted:
+		updates["submitted_at"] = time.Now()
+	case models.DisputeWon, models.DisputeLost:
+		updates["resolved_at"] = time.Now()
+	}
+	if status == models.DisputeWon && dispute.HeldAmount > 0 {
+		updates["hold_status"] = models.DisputeHoldReleasing
+	}
+
+	updated, err := dispute.UpdateDisputeStatus(db.MOR, from, updates)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !updated {
+		return http.StatusBadRequest, fmt.Errorf("dispute %v changed meanwhile, try again", dispute.ID)
+	}
+
+	code, err := dispute.GetDisputeByID(db.MOR)
+	if err != nil {
+		return code, err
+	}
+
+	// a hold settled while the dispute was being decided is released like the others
+	if status == models.DisputeWon && dispute.HoldStatus == models.DisputeHoldHeld {
+		_, err = dispute.UpdateDisputeHold(db.MOR, models.DisputeHoldHeld, []models.DisputeStatus{models.DisputeWon}, map[string]interface{}{"hold_status": models.DisputeHoldReleasing})
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		code, err = dispute.GetDisputeByID(db.MOR)
+		if err != nil {
+			return code, err
+		}
+	}
+
+	if status == models.DisputeLost {
+		err = reverseDisputedTransaction(db, *dispute, transaction, currency)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	if !dryRun {
+		Notify(extReq, *dispute, transaction, currency, string(status))
+	}
+
+	return http.StatusOK, nil
+}
+
+// SettleDispute makes the MOR_ wallet calls of a saved dispute, it debits a pending hold and credits back the hold of a won dispute.
+// Both are keyed on the dispute, so settling again after a failed update doesn't move the funds twice, and only the run that settles them records them.
+func SettleDispute(extReq request.ExternalRequest, db postgresql.Databases, dispute models.Dispute) (models.Dispute, int, error) {
+	if dispute.HoldStatus != models.DisputeHoldPending && dispute.HoldStatus != models.DisputeHoldReleasing {
+		return dispute, http.StatusOK, nil
+	}
+
+	currency, err := getCurrency(extReq, dispute.CountryID)
+	if err != nil {
+		return dispute, http.StatusInternalServerError, err
+	}
+
+	if dispute.HoldStatus == models.DisputeHoldPending {
+		err = settleDisputeHold(extReq, db, dispute, currency)
+		if err != nil {
+			return dispute, http.StatusInternalServerError, err
+		}
+
+		code, err := dispute.GetDisputeByID(db.MOR)
+		if err != nil {
+			return dispute, code, err
+		}
+	}
+
+	if dispute.HoldStatus == models.DisputeHoldReleasing {
+		err = settleDisputeRelease(extReq, db, dispute, currency)
+		if err != nil {
+			return dispute, http.StatusInternalServerError, err
+		}
+
+		code, err := dispute.GetDisputeByID(db.MOR)
+		if err != nil {
+			return dispute, code, err
+		}
+	}
+
+	return dispute, http.StatusOK, nil
+}
+
+// settleDisputeHold debits the disputed amount from the MOR_ wallet, the dispute isn't secured when the wallet can't cover it anymore.
+// A dispute decided before the debit was recorded has its hold released again.
+func settleDisputeHold(extReq request.ExternalRequest, db postgresql.Databases, dispute models.Dispute, currency string) error {
+	decided := []models.DisputeStatus{models.DisputeWon, models.DisputeLost}
+
+	_, err := services.DebitWallet(extReq, db, dispute.Amount, currency, int(dispute.MerchantID), "no", "yes", fmt.Sprintf("dispute-%v-hold", dispute.ID))
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error debiting mor wallet %v, amount %v, dispute %v, the dispute is not secured: %v", currency, dispute.Amount, dispute.ID, err.Error()))
+		_, err = dispute.UpdateDisputeHold(db.MOR, models.DisputeHoldPending, append(models.DisputeOpenStatuses, decided...), map[string]interface{}{
+			"hold_method": models.DisputeHoldNone,
+			"hold_status": "",
+		})
+		return err
+	}
+
+	return db.MOR.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"held_amount": dispute.Amount, "hold_status": models.DisputeHoldHeld}
+		held, err := dispute.UpdateDisputeHold(tx, models.DisputeHoldPending, models.DisputeOpenStatuses, updates)
+		if err != nil {
+			return err
+		}
+		if !held {
+			updates["hold_status"] = models.DisputeHoldReleasing
+			held, err = dispute.UpdateDisputeHold(tx, models.DisputeHoldPending, decided, updates)
+			if err != nil || !held {
+				return err
+			}
+		}
+
+		_, err = dispute.GetDisputeByID(tx)
+		if err != nil {
+			return err
+		}
+
+		txDb := db
+		txDb.MOR = tx
+		return ledger.RecordDisputeHold(txDb, dispute, currency)
+	})
+}
+
+// settleDisputeRelease credits the held amount of a decided dispute back to the MOR_ wallet
+func settleDisputeRelease(extReq request.ExternalRequest, db postgresql.Databases, dispute models.Dispute, currency string) error {
+	_, err := services.CreditWallet(extReq, db, dispute.HeldAmount, currency, int(dispute.MerchantID), false, "no", "yes", fmt.Sprintf("dispute-%v-release", dispute.ID))
+	if err != nil {
+		return fmt.Errorf("error releasing dispute %v to mor wallet %v: %v", dispute.ID, currency, err.Error())
+	}
+
+	return db.MOR.Transaction(func(tx *gorm.DB) error {
+		released, err := dispute.UpdateDisputeHold(tx, models.DisputeHoldReleasing, []models.DisputeStatus{models.DisputeWon, models.DisputeLost}, map[string]interface{}{
+			"hold_status": models.DisputeHoldReleased,
+		})
+		if err != nil || !released {
+			return err
+		}
+
+		txDb := db
+		txDb.MOR = tx
+		return ledger.RecordDisputeRelease(txDb, dispute, currency)
+	})
+}
+
+// Notify tells the merchant about the dispute, errors are only logged
+func Notify(extReq request.ExternalRequest, dispute models.Dispute, transaction models.Transaction, currency string, event string) {
+	_, err := extReq.SendExternalRequest(request.DisputeNotification, external_models.DisputeNotificationRequest{
+		AccountID:            int(dispute.MerchantID),
+		Event:                event,
+		DisputeID:            dispute.ID,
+		TransactionReference: transaction.Reference,
+		Status:               string(dispute.Status),
+		Reason:               dispute.Reason,
+		Currency:             currency,
+		Amount:               dispute.Amount,
+		EvidenceDueAt:        dispute.EvidenceDueAt,
+	})
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error sending %v notification for dispute %v: %v", event, dispute.ID, err.Error()))
+	}
+}
+
+// reverseDisputedTransaction takes the disputed amount off the transaction of a lost dispute like a refund, the transaction is reversed
+// once nothing is left. Funds held from the wallet pay the chargeback, otherwise it is recovered from the merchant's next payout
+// through a refund that reverses no fee or tax, the merchant owes the whole disputed amount.
+func reverseDisputedTransaction(db postgresql.Databases, dispute models.Dispute, transaction models.Transaction, currency string) error {
+	refund := models.Refund{
+		TransactionID:  dispute.TransactionID,
+		MerchantID:     dispute.MerchantID,
+		CountryID:      dispute.CountryID,
+		Reference:      fmt.Sprintf("dispute-%v-chargeback", dispute.ID),
+		Amount:         dispute.Amount,
+		RecoveryAmount: dispute.Amount,
+		RecoveryMethod: models.RefundRecoveryNextPayout,
+		RecoveryStatus: models.RefundRecoveryPending,
+		Reason:         dispute.Reason,
+		InitiatedBy:    models.RefundInitiatedByChargeback,
+	}
+
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		updated, err := transaction.AddChargebackAmount(tx, dispute.Amount)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return fmt.Errorf("transaction %v of dispute %v was refunded or changed meanwhile", transaction.Reference, dispute.ID)
+		}
+		if dispute.HeldAmount > 0 {
+			return nil
+		}
+		return refund.CreateRefund(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	if dispute.HeldAmount > 0 {
+		return ledger.RecordChargeback(db, dispute, currency)
+	}
+	return ledger.RecordRefund(db, refund, currency)
+}
+
+func getWalletHoldMethod(extReq request.ExternalRequest, dispute models.Dispute, currency string) models.DisputeHoldMethod {
+	morWallet := fmt.Sprintf("MOR_%v", currency)
+	wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, int(dispute.MerchantID), morWallet)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting %v wallet of merchant %v: %v", morWallet, dispute.MerchantID, err.Error()))
+		return models.DisputeHoldNone
+	}
+
+	if wallet.Available < dispute.Amount {
+		return models.DisputeHoldNone
+	}
+	return models.DisputeHoldWallet
+}
+
+func getCurrency(extReq request.ExternalRequest, countryID int64) (string, error) {
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(countryID))
+	if err != nil {
+		return "", fmt.Errorf("error getting country with id %v: %v", countryID, err.Error())
+	}
+	return strings.ToUpper(country.CurrencyCode), nil
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,116 @@
+package documents
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"os/exec"
+	"time"
+
+	"github.com/vesicash/mor-api/internal/models"
+)
+
+// wkhtmltopdfBinary converts the rendered html, it must be on the PATH, see the README prerequisites
+const wkhtmltopdfBinary = "wkhtmltopdf"
+
+var (
+	//go:embed templates/*.html
+	templateFiles embed.FS
+	templates     = template.Must(template.New("documents").Funcs(template.FuncMap{
+		"money": func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+		"date":  func(t time.Time) string { return t.Format("02 Jan 2006") },
+	}).ParseFS(templateFiles, "templates/*.html"))
+)
+
+type Party struct {
+	Name    string
+	Address string
+	Email   string
+	TaxID   string
+}
+
+type Line struct {
+	Item      string
+	Quantity  int64
+	UnitPrice float64
+	Total     float64
+}
+
+// Document is everything printed on an invoice or receipt, amounts are tax inclusive except Subtotal
+type Document struct {
+	Type                 models.TransactionDocumentType
+	Number               string
+	IssuedAt             time.Time
+	Seller               Party
+	Supplier             Party
+	Customer             Party
+	TransactionReference string
+	TransactionDate      time.Time
+	PaymentMethod        models.PaymentMethod
+	Currency             string
+	Lines                []Line
+	Subtotal             float64
+	TaxCategory          models.TaxCategory
+	TaxRate              float64
+	TaxFee               float64
+	TaxReverseCharge     bool
+	Total                float64
+}
+
+// Statement is a merchant statement as printed, Statement.Lines are listed in order
+type Statement struct {
+	Seller    Party
+	Merchant  Party
+	Statement models.MerchantStatement
+}
+
+// RenderHTML fills the template of the document's type
+func RenderHTML(document Document) ([]byte, error) {
+	return render(fmt.Sprintf("%v.html", document.Type), document)
+}
+
+// RenderStatementPDF renders the merchant statement to html and converts it with wkhtmltopdf
+func RenderStatementPDF(statement Statement) ([]byte, error) {
+	html, err := render("statement.html", statement)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertHTMLToPDF(html)
+}
+
+// RenderPDF renders the document to html and converts it with wkhtmltopdf
+func RenderPDF(document Document) ([]byte, error) {
+	html, err := RenderHTML(document)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertHTMLToPDF(html)
+}
+
+func ConvertHTMLToPDF(html []byte) ([]byte, error) {
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+		cmd    = exec.Command(wkhtmltopdfBinary, "--quiet", "--encoding", "utf-8", "-", "-")
+	)
+
+	cmd.Stdin = bytes.NewReader(html)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("pdf conversion failed: %v %v", err.Error(), stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func render(name string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return nil, fmt.Errorf("rendering %v failed: %v", name, err.Error())
+	}
+	return buf.Bytes(), nil
+}
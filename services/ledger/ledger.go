@@ -0,0 +1,287 @@
+package ledger
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+type posting struct {
+	account models.LedgerAccount
+	debit   float64
+	credit  float64
+}
+
+// RecordTransactionCapture writes the capture of a successful transaction and its processing fee and tax.
+// The fee and tax are costs of the MoR until the payout deducts them from the merchant, see RecordPayoutDeductions.
+func RecordTransactionCapture(db postgresql.Databases, transaction models.Transaction, currency string) error {
+	var (
+		reference   = fmt.Sprintf("transaction:%v", transaction.ID)
+		description = fmt.Sprintf("transaction %v", transaction.Reference)
+	)
+
+	err := writeJournalEntry(db.MOR, reference+":capture", models.JournalTransactionCapture, transaction.MerchantID, currency, description, []posting{
+		{account: models.LedgerProviderClearing, debit: transaction.Amount},
+		{account: models.LedgerMerchantPending, credit: transaction.Amount},
+	})
+	if err != nil {
+		return err
+	}
+
+	if transaction.ProcessingFee > 0 {
+		err := writeJournalEntry(db.MOR, reference+":processing_fee", models.JournalProcessingFee, transaction.MerchantID, currency, "processing fee for "+description, []posting{
+			{account: models.LedgerFeeExpense, debit: transaction.ProcessingFee},
+			{account: models.LedgerProviderClearing, credit: transaction.ProcessingFee},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if transaction.TaxFee > 0 {
+		err := writeJournalEntry(db.MOR, reference+":tax", models.JournalTax, transaction.MerchantID, currency, "tax for "+description, []posting{
+			{account: models.LedgerTaxExpense, debit: transaction.TaxFee},
+			{account: models.LedgerTaxPayable, credit: transaction.TaxFee},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordTransactionReversal takes a refunded or reversed transaction back out of the merchant's pending balance, it does nothing when the capture was never recorded
+func RecordTransactionReversal(db postgresql.Databases, transaction models.Transaction) error {
+	var (
+		reference = fmt.Sprintf("transaction:%v", transaction.ID)
+		capture   = models.JournalEntry{Reference: reference + ":capture"}
+	)
+
+	found, err := capture.GetJournalEntryByReference(db.MOR)
+	if err != nil || !found {
+		return err
+	}
+
+	return writeJournalEntry(db.MOR, reference+":reversal", models.JournalTransactionReversal, transaction.MerchantID, capture.Currency, fmt.Sprintf("%v of transaction %v", transaction.Status, transaction.Reference), []posting{
+		{account: models.LedgerMerchantPending, debit: transaction.Amount},
+		{account: models.LedgerProviderClearing, credit: transaction.Amount},
+	})
+}
+
+// RecordPayout moves a payout from the merchant's pending balance to their MOR_ wallet, or to fx clearing when it is converted
+func RecordPayout(db postgresql.Databases, payout models.Payout, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("payout:%v", payout.ID), models.JournalPayout, payout.MerchantID, currency, fmt.Sprintf("payout %v to MOR_%v wallet", payout.Reference, getPayoutWalletCurrency(payout, currency)), []posting{
+		{account: models.LedgerMerchantPending, debit: payout.Amount},
+		{account: getPayoutWalletAccount(payout), credit: payout.Amount},
+	})
+}
+
+// RecordPayoutConversion gives a converted payout out of fx clearing in the settlement currency,
+// the fx fee is kept out of what reaches the merchant's MOR_ wallet
+func RecordPayoutConversion(db postgresql.Databases, payout models.Payout, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("payout:%v:fx", payout.ID), models.JournalFxConversion, payout.MerchantID, payout.SettlementCurrency, fmt.Sprintf("payout %v converted from %v at %v", payout.Reference, strings.ToUpper(currency), payout.FxRate), []posting{
+		{account: models.LedgerFxClearing, debit: roundAmount(payout.SettlementAmount + payout.FxFee)},
+		{account: models.LedgerMerchantWallet, credit: payout.SettlementAmount},
+		{account: models.LedgerFxFeeIncome, credit: payout.FxFee},
+	})
+}
+
+// RecordPayoutDeductions charges the processing fees and tax of the transactions paid out to the merchant's pending balance
+func RecordPayoutDeductions(db postgresql.Databases, payout models.Payout, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("payout:%v:deductions", payout.ID), models.JournalPayoutDeduction, payout.MerchantID, currency, fmt.Sprintf("processing fees and tax deducted from payout %v", payout.Reference), []posting{
+		{account: models.LedgerMerchantPending, debit: payout.ProcessingFee + payout.TaxFee},
+		{account: models.LedgerFeeExpense, credit: payout.ProcessingFee},
+		{account: models.LedgerTaxExpense, credit: payout.TaxFee},
+	})
+}
+
+// RecordReserveHold moves the rolling reserve held back from a payout from the merchant's pending balance to their reserve
+func RecordReserveHold(db postgresql.Databases, payout models.Payout, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("payout:%v:reserve", payout.ID), models.JournalReserveHold, payout.MerchantID, currency, fmt.Sprintf("reserve held from payout %v", payout.Reference), []posting{
+		{account: models.LedgerMerchantPending, debit: payout.ReserveAmount},
+		{account: models.LedgerMerchantReserve, credit: payout.ReserveAmount},
+	})
+}
+
+// RecordReserveRelease moves a released reserve from the merchant's reserve to their MOR_ wallet, payout is the release payout
+func RecordReserveRelease(db postgresql.Databases, payout models.Payout, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("reserve:%v:release", payout.ReserveID), models.JournalReserveRelease, payout.MerchantID, currency, fmt.Sprintf("reserve released by payout %v to MOR_%v wallet", payout.Reference, getPayoutWalletCurrency(payout, currency)), []posting{
+		{account: models.LedgerMerchantReserve, debit: payout.Amount},
+		{account: getPayoutWalletAccount(payout), credit: payout.Amount},
+	})
+}
+
+// RecordWithdrawal moves an approved withdrawal out of the merchant's MOR_ wallet
+func RecordWithdrawal(db postgresql.Databases, withdrawal models.Withdrawal) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("withdrawal:%v", withdrawal.ID), models.JournalWithdrawal, withdrawal.MerchantID, withdrawal.Currency, fmt.Sprintf("withdrawal %v from MOR_%v wallet", withdrawal.ID, withdrawal.Currency), []posting{
+		{account: models.LedgerMerchantWallet, debit: withdrawal.Amount},
+		{account: models.LedgerSettlementBank, credit: withdrawal.Amount},
+	})
+}
+
+// RecordWithdrawalReversal gives a rejected or failed withdrawal back to the merchant's MOR_ wallet
+func RecordWithdrawalReversal(db postgresql.Databases, withdrawal models.Withdrawal) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("withdrawal:%v:reversal", withdrawal.ID), models.JournalWithdrawalReversal, withdrawal.MerchantID, withdrawal.Currency, fmt.Sprintf("withdrawal %v %v, returned to MOR_%v wallet", withdrawal.ID, withdrawal.Status, withdrawal.Currency), []posting{
+		{account: models.LedgerSettlementBank, debit: withdrawal.Amount},
+		{account: models.LedgerMerchantWallet, credit: withdrawal.Amount},
+	})
+}
+
+// RecordRefund pays a refund back out of the provider clearing account. The tax and processing fee of the refunded share are reversed,
+// the rest is taken from the merchant's MOR_ wallet or, when it is recovered from a payout, from their pending balance.
+func RecordRefund(db postgresql.Databases, refund models.Refund, currency string) error {
+	merchantAccount := models.LedgerMerchantPending
+	if refund.RecoveryMethod == models.RefundRecoveryWallet {
+		merchantAccount = models.LedgerMerchantWallet
+	}
+
+	return writeJournalEntry(db.MOR, fmt.Sprintf("refund:%v", refund.ID), models.JournalRefund, refund.MerchantID, currency, fmt.Sprintf("refund %v of transaction %v", refund.Reference, refund.TransactionID), []posting{
+		{account: merchantAccount, debit: refund.RecoveryAmount},
+		{account: models.LedgerTaxPayable, debit: refund.TaxFee},
+		{account: models.LedgerFeeExpense, debit: refund.ProcessingFee},
+		{account: models.LedgerProviderClearing, credit: refund.Amount},
+	})
+}
+
+// RecordDisputeHold moves the disputed amount debited from the merchant's MOR_ wallet to the dispute hold
+func RecordDisputeHold(db postgresql.Databases, dispute models.Dispute, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("dispute:%v:hold", dispute.ID), models.JournalDisputeHold, dispute.MerchantID, currency, fmt.Sprintf("dispute %v of transaction %v held from MOR_%v wallet", dispute.ID, dispute.TransactionID, currency), []posting{
+		{account: models.LedgerMerchantWallet, debit: dispute.HeldAmount},
+		{account: models.LedgerDisputeHold, credit: dispute.HeldAmount},
+	})
+}
+
+// RecordDisputeRelease gives a decided dispute's hold back to the merchant's MOR_ wallet, a lost dispute's hold only when it was settled after the decision
+func RecordDisputeRelease(db postgresql.Databases, dispute models.Dispute, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("dispute:%v:release", dispute.ID), models.JournalDisputeRelease, dispute.MerchantID, currency, fmt.Sprintf("dispute %v %v, released to MOR_%v wallet", dispute.ID, dispute.Status, currency), []posting{
+		{account: models.LedgerDisputeHold, debit: dispute.HeldAmount},
+		{account: models.LedgerMerchantWallet, credit: dispute.HeldAmount},
+	})
+}
+
+// RecordChargeback pays a lost dispute's hold back out of the provider clearing account
+func RecordChargeback(db postgresql.Databases, dispute models.Dispute, currency string) error {
+	return writeJournalEntry(db.MOR, fmt.Sprintf("dispute:%v:chargeback", dispute.ID), models.JournalChargeback, dispute.MerchantID, currency, fmt.Sprintf("chargeback of dispute %v of transaction %v", dispute.ID, dispute.TransactionID), []posting{
+		{account: models.LedgerDisputeHold, debit: dispute.HeldAmount},
+		{account: models.LedgerProviderClearing, credit: dispute.HeldAmount},
+	})
+}
+
+// CheckLedger verifies that debits equal credits for every currency and every journal entry
+func CheckLedger(db postgresql.Databases) (models.LedgerCheck, error) {
+	var (
+		ledgerPosting = models.LedgerPosting{}
+		check         = models.LedgerCheck{Balanced: true}
+		err           error
+	)
+
+	check.Currencies, err = ledgerPosting.GetLedgerTotals(db.MOR)
+	if err != nil {
+		return check, err
+	}
+
+	for _, c := range check.Currencies {
+		if toMinorUnits(c.Debit) != toMinorUnits(c.Credit) {
+			check.Balanced = false
+		}
+	}
+
+	check.UnbalancedEntries, err = ledgerPosting.GetUnbalancedJournalEntryIDs(db.MOR)
+	if err != nil {
+		return check, err
+	}
+
+	if len(check.UnbalancedEntries) > 0 {
+		check.Balanced = false
+	}
+
+	return check, nil
+}
+
+// writeJournalEntry appends a balanced entry, an entry whose reference already exists is left as it is so callers can retry safely
+func writeJournalEntry(db *gorm.DB, reference string, entryType models.JournalEntryType, merchantID int64, currency string, description string, postings []posting) error {
+	var (
+		debits  int64
+		credits int64
+	)
+
+	for _, p := range postings {
+		debits += toMinorUnits(p.debit)
+		credits += toMinorUnits(p.credit)
+	}
+
+	if debits != credits {
+		return fmt.Errorf("journal entry %v is unbalanced, debits %v credits %v", reference, float64(debits)/100, float64(credits)/100)
+	}
+
+	if debits == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		entry := models.JournalEntry{
+			Reference:   reference,
+			EntryType:   entryType,
+			MerchantID:  merchantID,
+			Currency:    strings.ToUpper(currency),
+			Description: description,
+		}
+
+		created, err := entry.CreateJournalEntryIfNotExists(tx)
+		if err != nil || !created {
+			return err
+		}
+
+		for _, p := range postings {
+			if toMinorUnits(p.debit) == 0 && toMinorUnits(p.credit) == 0 {
+				continue
+			}
+
+			ledgerPosting := models.LedgerPosting{
+				JournalEntryID: int64(entry.ID),
+				EntryType:      entryType,
+				Account:        p.account,
+				MerchantID:     merchantID,
+				Currency:       entry.Currency,
+				Debit:          p.debit,
+				Credit:         p.credit,
+				Description:    description,
+			}
+
+			err := ledgerPosting.CreateLedgerPosting(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getPayoutWalletAccount is where a payout is credited in its own currency
+func getPayoutWalletAccount(payout models.Payout) models.LedgerAccount {
+	if payout.SettlementCurrency != "" {
+		return models.LedgerFxClearing
+	}
+	return models.LedgerMerchantWallet
+}
+
+func getPayoutWalletCurrency(payout models.Payout, currency string) string {
+	if payout.SettlementCurrency != "" {
+		return payout.SettlementCurrency
+	}
+	return currency
+}
+
+func roundAmount(amount float64) float64 {
+	return float64(toMinorUnits(amount)) / 100
+}
+
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
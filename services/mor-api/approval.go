@@ -0,0 +1,421 @@
+package mor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"gorm.io/gorm"
+)
+
+// ApprovalRequiredError is returned instead of running an action that needs more approvals than the admin requesting it,
+// the action runs once other admins approved the request
+type ApprovalRequiredError struct {
+	Request models.ApprovalRequest
+}
+
+func (e ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("%v needs approval from %v distinct admins, approval request %v is waiting for %v more", e.Request.Action, e.Request.RequiredApprovals, e.Request.ID, e.Request.RequiredApprovals-e.Request.Approvals)
+}
+
+// UpdateApprovalPolicyService sets how many admins must approve the action above the threshold in the currency,
+// a policy without currency applies to every currency without a policy of its own
+func UpdateApprovalPolicyService(extReq request.ExternalRequest, db postgresql.Databases, adminID int, req models.UpdateApprovalPolicyRequest) (models.ApprovalPolicy, int, error) {
+	policy := models.ApprovalPolicy{Action: req.Action, Currency: normalizeWithdrawalCurrency(req.Currency)}
+
+	code, err := policy.GetApprovalPolicyByActionAndCurrency(db.MOR)
+	if err != nil && code == http.StatusInternalServerError {
+		return policy, code, err
+	}
+
+	policy.Threshold = req.Threshold
+	policy.RequiredApprovals = req.RequiredApprovals
+	policy.UpdatedBy = int64(adminID)
+
+	if policy.ID == 0 {
+		err = policy.CreateApprovalPolicy(db.MOR)
+	} else {
+		err = policy.UpdateAllFields(db.MOR)
+	}
+	if err != nil {
+		return policy, http.StatusInternalServerError, err
+	}
+
+	return policy, http.StatusOK, nil
+}
+
+func GetApprovalPoliciesService(extReq request.ExternalRequest, db postgresql.Databases) ([]models.ApprovalPolicy, int, error) {
+	policy := models.ApprovalPolicy{}
+	policies, err := policy.GetApprovalPolicies(db.MOR)
+	if err != nil {
+		return policies, http.StatusInternalServerError, err
+	}
+	return policies, http.StatusOK, nil
+}
+
+func GetApprovalRequestsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetApprovalRequestsRequest) ([]models.ApprovalRequest, postgresql.PaginationResponse, int, error) {
+	var (
+		approval = models.ApprovalRequest{
+			Action: models.ApprovalAction(req.Action),
+			Status: models.ApprovalRequestStatus(req.Status),
+		}
+	)
+
+	approvals, pagination, err := approval.GetApprovalRequests(db.MOR, paginator)
+	if err != nil {
+		return approvals, pagination, http.StatusInternalServerError, err
+	}
+
+	return approvals, pagination, http.StatusOK, nil
+}
+
+func GetApprovalRequestService(extReq request.ExternalRequest, db postgresql.Databases, approvalID int) (models.ApprovalRequest, int, error) {
+	return getApprovalRequest(db, approvalID)
+}
+
+// ApproveApprovalRequestService records the admin's approval and runs the action once the request has all the approvals it needs.
+// The request stays locked while the approval is counted, so the action runs only once.
+func ApproveApprovalRequestService(extReq request.ExternalRequest, db postgresql.Databases, approvalID int, adminID int, req models.ApprovalDecisionRequest) (models.ApprovalRequest, int, error) {
+	approval := models.ApprovalRequest{ID: uint(approvalID)}
+
+	code, err := decideApprovalRequest(db, &approval, adminID, models.ApprovalDecisionApprove, req.Note)
+	if err != nil {
+		return approval, code, err
+	}
+
+	if approval.Status == models.ApprovalRequestApproved {
+		code, err = executeApprovalRequest(extReq, db, &approval)
+		if err != nil {
+			approval, _, _ = getApprovalRequest(db, approvalID)
+			return approval, code, err
+		}
+	}
+
+	return getApprovalRequest(db, approvalID)
+}
+
+// DeclineApprovalRequestService turns the approval request down, its action doesn't run
+func DeclineApprovalRequestService(extReq request.ExternalRequest, db postgresql.Databases, approvalID int, adminID int, req models.ApprovalDecisionRequest) (models.ApprovalRequest, int, error) {
+	approval := models.ApprovalRequest{ID: uint(approvalID)}
+
+	code, err := decideApprovalRequest(db, &approval, adminID, models.ApprovalDecisionDecline, req.Note)
+	if err != nil {
+		return approval, code, err
+	}
+
+	return getApprovalRequest(db, approvalID)
+}
+
+// requireApproval lets the action run when its policy needs one admin. Otherwise it queues an approval request
+// counting the requesting admin's approval, or returns the one already waiting for the resource, as an ApprovalRequiredError.
+func requireApproval(db postgresql.Databases, approval models.ApprovalRequest) (int, error) {
+	if approval.RequiredApprovals <= 1 {
+		return http.StatusOK, nil
+	}
+
+	if approval.ResourceID != 0 {
+		pending := models.ApprovalRequest{Action: approval.Action, ResourceID: approval.ResourceID}
+		code, err := pending.GetPendingApprovalRequestByResource(db.MOR)
+		if err == nil {
+			return http.StatusAccepted, ApprovalRequiredError{Request: pending}
+		}
+		if code == http.StatusInternalServerError {
+			return code, err
+		}
+	}
+
+	approval.Status = models.ApprovalRequestPending
+	approval.Approvals = 1
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		err := approval.CreateApprovalRequest(tx)
+		if err != nil {
+			return err
+		}
+
+		decision := models.ApprovalDecision{ApprovalRequestID: int64(approval.ID), AdminID: approval.RequestedBy, Decision: models.ApprovalDecisionApprove, Note: "requested"}
+		return decision.CreateApprovalDecision(tx)
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusAccepted, ApprovalRequiredError{Request: approval}
+}
+
+// getRequiredApprovals returns how many admins must approve the action moving amount in the currency,
+// the currency's policy is used before the one for every currency and an action without a policy needs one admin
+func getRequiredApprovals(db postgresql.Databases, action models.ApprovalAction, amount float64, currency string) (int, error) {
+	for _, c := range []string{strings.ToUpper(currency), ""} {
+		policy := models.ApprovalPolicy{Action: action, Currency: c}
+		code, err := policy.GetApprovalPolicyByActionAndCurrency(db.MOR)
+		if err != nil {
+			if code == http.StatusInternalServerError {
+				return 0, err
+			}
+			continue
+		}
+
+		if amount > policy.Threshold {
+			return policy.RequiredApprovals, nil
+		}
+		return 1, nil
+	}
+
+	return 1, nil
+}
+
+// decideApprovalRequest records the admin's decision on a pending approval request, each admin decides once.
+// An approval that completes the required count moves the request to approved, a decline moves it to declined.
+func decideApprovalRequest(db postgresql.Databases, approval *models.ApprovalRequest, adminID int, decisionType models.ApprovalDecisionType, note string) (int, error) {
+	code := http.StatusInternalServerError
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		c, err := approval.LockApprovalRequest(tx)
+		if err != nil {
+			if c != http.StatusInternalServerError {
+				code = c
+				return fmt.Errorf("approval request with id %v not found", approval.ID)
+			}
+			return err
+		}
+
+		if approval.Status != models.ApprovalRequestPending {
+			code = http.StatusBadRequest
+			return fmt.Errorf("approval request %v is %v, only pending requests can be decided", approval.ID, approval.Status)
+		}
+
+		decision := models.ApprovalDecision{ApprovalRequestID: int64(approval.ID)}
+		decisions, err := decision.GetApprovalDecisionsByRequestID(tx)
+		if err != nil {
+			return err
+		}
+		for _, d := range decisions {
+			if d.AdminID == int64(adminID) {
+				code = http.StatusBadRequest
+				return fmt.Errorf("admin %v already decided on approval request %v, each approval must come from a different admin", adminID, approval.ID)
+			}
+		}
+
+		decision.AdminID = int64(adminID)
+		decision.Decision = decisionType
+		decision.Note = note
+		err = decision.CreateApprovalDecision(tx)
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if decisionType == models.ApprovalDecisionDecline {
+			updates["status"] = models.ApprovalRequestDeclined
+			updates["reason"] = note
+		} else {
+			approvals, err := decision.CountApprovals(tx)
+			if err != nil {
+				return err
+			}
+			updates["approvals"] = int(approvals)
+			if int(approvals) >= approval.RequiredApprovals {
+				updates["status"] = models.ApprovalRequestApproved
+			}
+		}
+
+		updated, err := approval.UpdateApprovalRequestStatus(tx, models.ApprovalRequestPending, updates)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			code = http.StatusBadRequest
+			return fmt.Errorf("approval request %v changed meanwhile, try again", approval.ID)
+		}
+
+		_, err = approval.GetApprovalRequestByID(tx)
+		return err
+	})
+	if err != nil {
+		return code, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// executeApprovalRequest runs the action of a fully approved request, a request whose action failed has to be requested again
+func executeApprovalRequest(extReq request.ExternalRequest, db postgresql.Databases, approval *models.ApprovalRequest) (int, error) {
+	var (
+		code = http.StatusOK
+		err  error
+	)
+
+	switch approval.Action {
+	case models.ApprovalWithdrawalApprove, models.ApprovalWithdrawalComplete:
+		var withdrawal models.Withdrawal
+		withdrawal, code, err = getWithdrawal(db, int(approval.ResourceID), 0)
+		if err != nil {
+			break
+		}
+		if approval.Action == models.ApprovalWithdrawalApprove {
+			code, err = approveWithdrawal(extReq, db, &withdrawal)
+		} else {
+			code, err = updateWithdrawalStatus(extReq, db, &withdrawal, models.WithdrawalSuccessful, "", false)
+		}
+	case models.ApprovalPayoutToWallet, models.ApprovalPayoutBatchRetry:
+		var payload models.PayoutApprovalPayload
+		err = json.Unmarshal([]byte(approval.Payload), &payload)
+		if err != nil {
+			code = http.StatusInternalServerError
+			break
+		}
+		var (
+			merchants []int
+			pinned    map[int][]uint
+		)
+		merchants, pinned, err = getPinnedPayoutTransactions(db, payload)
+		if err != nil {
+			code = http.StatusInternalServerError
+			break
+		}
+		if approval.Action == models.ApprovalPayoutToWallet {
+			_, _, code, err = payOutToWallets(extReq, db, merchants, pinned)
+			break
+		}
+		var batch models.PayoutBatch
+		batch, _, code, err = getRetryablePayoutBatch(db, int(approval.ResourceID))
+		if err != nil || len(merchants) == 0 {
+			break
+		}
+		_, code, err = retryPayoutBatch(extReq, db, batch, merchants, pinned)
+	case models.ApprovalPayoutResume:
+		var payout models.Payout
+		payout, code, err = getResumablePayout(db, int(approval.ResourceID))
+		if err != nil {
+			break
+		}
+		code, err = resumePayout(extReq, db, &payout)
+	default:
+		code, err = http.StatusBadRequest, fmt.Errorf("approval request %v has unknown action %v", approval.ID, approval.Action)
+	}
+
+	updates := map[string]interface{}{
+		"status":      models.ApprovalRequestExecuted,
+		"executed_at": time.Now(),
+	}
+	if err != nil {
+		updates["status"] = models.ApprovalRequestFailed
+		updates["reason"] = err.Error()
+	}
+
+	_, updateErr := approval.UpdateApprovalRequestStatus(db.MOR, models.ApprovalRequestApproved, updates)
+	if updateErr != nil {
+		extReq.Logger.Error(fmt.Sprintf("error updating approval request %v: %v", approval.ID, updateErr.Error()))
+	}
+
+	return code, err
+}
+
+// getPayoutApprovals returns the approvals a manual payout of the payable totals needs, from the currency needing the most approvals.
+// It returns that currency and its amount.
+func getPayoutApprovals(extReq request.ExternalRequest, db postgresql.Databases, totals []models.ReconciliationTotal) (int, float64, string, error) {
+	var (
+		amounts  = map[string]float64{}
+		required = 1
+		amount   float64
+		currency string
+	)
+
+	for _, t := range totals {
+		country, err := services.GetCountryByID(extReq, extReq.Logger, int(t.CountryID))
+		if err != nil {
+			return required, amount, currency, fmt.Errorf("error getting country with id %v: %v", t.CountryID, err.Error())
+		}
+		amounts[strings.ToUpper(country.CurrencyCode)] += t.Amount
+	}
+
+	for c, a := range amounts {
+		a = roundAmount(a)
+		r, err := getRequiredApprovals(db, models.ApprovalPayoutToWallet, a, c)
+		if err != nil {
+			return required, amount, currency, err
+		}
+		if r > required || currency == "" {
+			required, amount, currency = r, a, c
+		}
+	}
+
+	return required, amount, currency, nil
+}
+
+// getPayoutApprovalPayload reads the payable transactions a manual payout to the merchants would pay out and their totals per country
+func getPayoutApprovalPayload(db postgresql.Databases, merchants []int) (models.PayoutApprovalPayload, error) {
+	var (
+		transaction = models.Transaction{}
+		payload     = models.PayoutApprovalPayload{Merchants: merchants}
+		err         error
+	)
+
+	payload.TransactionIDs, err = transaction.GetPayableTransactionIDs(db.MOR, merchants)
+	if err != nil {
+		return payload, err
+	}
+
+	payload.Totals, err = transaction.GetPayableTransactionTotals(db.MOR, merchants)
+	if err != nil {
+		return payload, err
+	}
+
+	return payload, nil
+}
+
+// getPinnedPayoutTransactions groups the transactions an approved payout was requested for by merchant,
+// it returns the merchants that had any in the order they were requested
+func getPinnedPayoutTransactions(db postgresql.Databases, payload models.PayoutApprovalPayload) ([]int, map[int][]uint, error) {
+	var (
+		transaction = models.Transaction{}
+		merchants   = []int{}
+		pinned      = map[int][]uint{}
+	)
+
+	ids := []int64{}
+	for _, id := range payload.TransactionIDs {
+		ids = append(ids, int64(id))
+	}
+
+	transactions, err := transaction.GetTransactionsByIDs(db.MOR, ids)
+	if err != nil {
+		return merchants, pinned, err
+	}
+
+	for _, trx := range transactions {
+		pinned[int(trx.MerchantID)] = append(pinned[int(trx.MerchantID)], trx.ID)
+	}
+
+	for _, m := range payload.Merchants {
+		if len(pinned[m]) > 0 {
+			merchants = append(merchants, m)
+		}
+	}
+
+	return merchants, pinned, nil
+}
+
+func getApprovalRequest(db postgresql.Databases, approvalID int) (models.ApprovalRequest, int, error) {
+	approval := models.ApprovalRequest{ID: uint(approvalID)}
+	code, err := approval.GetApprovalRequestByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return approval, code, err
+		}
+		return approval, code, fmt.Errorf("approval request with id %v not found", approvalID)
+	}
+
+	decision := models.ApprovalDecision{ApprovalRequestID: int64(approval.ID)}
+	approval.Decisions, err = decision.GetApprovalDecisionsByRequestID(db.MOR)
+	if err != nil {
+		return approval, http.StatusInternalServerError, err
+	}
+
+	return approval, http.StatusOK, nil
+}
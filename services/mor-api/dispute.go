@@ -0,0 +1,250 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/disputes"
+)
+
+const (
+	// disputeReminderWindow is how long before the evidence deadline merchants start getting reminders
+	disputeReminderWindow = 72 * time.Hour
+	// disputeReminderInterval is the least time between two reminders of a dispute
+	disputeReminderInterval = 24 * time.Hour
+	// disputeManualProvider is the provider of disputes opened by an admin
+	disputeManualProvider = "manual"
+)
+
+// SendDisputeReminders is the dispute reminder cronjob, merchants are reminded daily of disputes whose evidence is due within three days
+func SendDisputeReminders(extReq request.ExternalRequest, db postgresql.Databases) {
+	var (
+		dispute        = models.Dispute{}
+		now            = time.Now()
+		remindedBefore = now.Add(-disputeReminderInterval)
+		currencies     = map[int64]string{}
+	)
+
+	dueDisputes, err := dispute.GetDisputesToRemind(db.MOR, now, now.Add(disputeReminderWindow), remindedBefore)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting disputes to remind: %v", err.Error()))
+		return
+	}
+
+	for _, d := range dueDisputes {
+		claimed, err := d.ClaimDisputeReminder(db.MOR, remindedBefore)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error claiming reminder of dispute %v: %v", d.ID, err.Error()))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		transaction := models.Transaction{ID: uint(d.TransactionID)}
+		_, err = transaction.GetTransactionByID(db.MOR)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error getting transaction %v of dispute %v: %v", d.TransactionID, d.ID, err.Error()))
+			continue
+		}
+
+		currency, ok := currencies[d.CountryID]
+		if !ok {
+			country, err := services.GetCountryByID(extReq, extReq.Logger, int(d.CountryID))
+			if err != nil {
+				extReq.Logger.Error(fmt.Sprintf("error getting country with id %v: %v", d.CountryID, err.Error()))
+				continue
+			}
+			currency = strings.ToUpper(country.CurrencyCode)
+			currencies[d.CountryID] = currency
+		}
+
+		disputes.Notify(extReq, d, transaction, currency, disputes.NotificationReminder)
+	}
+}
+
+// OpenDisputeService opens a dispute an admin was told about outside of the provider webhooks
+func OpenDisputeService(extReq request.ExternalRequest, db postgresql.Databases, req models.OpenDisputeRequest) (models.Dispute, int, error) {
+	var (
+		transaction = models.Transaction{ID: uint(req.TransactionID)}
+		dispute     = models.Dispute{
+			Provider:          strings.ToLower(strings.TrimSpace(req.Provider)),
+			ProviderReference: strings.TrimSpace(req.ProviderReference),
+			Reason:            req.Reason,
+			Amount:            roundAmount(req.Amount),
+		}
+	)
+
+	code, err := transaction.GetTransactionByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return dispute, code, err
+		}
+		return dispute, code, fmt.Errorf("transaction with id %v not found", req.TransactionID)
+	}
+
+	if dispute.Provider == "" {
+		dispute.Provider = disputeManualProvider
+	}
+
+	if req.EvidenceDueAt != 0 {
+		dispute.EvidenceDueAt = time.Unix(int64(req.EvidenceDueAt), 0)
+		if dispute.EvidenceDueAt.Before(time.Now()) {
+			return dispute, http.StatusBadRequest, fmt.Errorf("evidence_due_at is in the past")
+		}
+	}
+
+	if dispute.ProviderReference != "" {
+		existing := models.Dispute{Provider: dispute.Provider, ProviderReference: dispute.ProviderReference}
+		code, err := existing.GetDisputeByProviderReference(db.MOR)
+		if err == nil {
+			return existing, http.StatusBadRequest, fmt.Errorf("dispute %v of %v is already open as dispute %v", dispute.ProviderReference, dispute.Provider, existing.ID)
+		}
+		if code == http.StatusInternalServerError {
+			return dispute, code, err
+		}
+	}
+
+	dispute, code, err = disputes.OpenDispute(extReq, db, transaction, dispute, false)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	return disputes.SettleDispute(extReq, db, dispute)
+}
+
+// GetDisputeService returns the dispute with its evidence.
+// merchantID restricts the lookup to the merchant's own disputes, 0 allows any dispute.
+func GetDisputeService(extReq request.ExternalRequest, db postgresql.Databases, disputeID int, merchantID int) (models.Dispute, int, error) {
+	dispute, code, err := getDispute(db, disputeID, merchantID)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	evidence := models.DisputeEvidence{DisputeID: int64(dispute.ID)}
+	dispute.Evidence, err = evidence.GetDisputeEvidence(db.MOR)
+	if err != nil {
+		return dispute, http.StatusInternalServerError, err
+	}
+
+	return dispute, http.StatusOK, nil
+}
+
+func GetDisputesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetDisputesRequest) ([]models.Dispute, postgresql.PaginationResponse, int, error) {
+	var (
+		dispute = models.Dispute{
+			MerchantID:    int64(req.AccountID),
+			TransactionID: int64(req.TransactionID),
+			Status:        models.DisputeStatus(req.Status),
+		}
+	)
+
+	disputeList, pagination, err := dispute.GetDisputes(db.MOR, paginator)
+	if err != nil {
+		return disputeList, pagination, http.StatusInternalServerError, err
+	}
+
+	return disputeList, pagination, http.StatusOK, nil
+}
+
+// UpdateDisputeStatusService records the provider's request for evidence or its decision on the dispute
+func UpdateDisputeStatusService(extReq request.ExternalRequest, db postgresql.Databases, disputeID int, req models.UpdateDisputeStatusRequest) (models.Dispute, int, error) {
+	dispute, code, err := getDispute(db, disputeID, 0)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	code, err = disputes.UpdateDisputeStatus(extReq, db, &dispute, req.Status, req.Note, false)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	return disputes.SettleDispute(extReq, db, dispute)
+}
+
+// UploadDisputeEvidenceService stores a file the merchant supports the dispute with through the upload service,
+// evidence is taken until the dispute is submitted or its deadline passes
+func UploadDisputeEvidenceService(extReq request.ExternalRequest, db postgresql.Databases, disputeID int, merchantID int, fileName string, file []byte, description string) (models.DisputeEvidence, int, error) {
+	evidence := models.DisputeEvidence{DisputeID: int64(disputeID), FileName: fileName, Description: description}
+
+	dispute, code, err := getDispute(db, disputeID, merchantID)
+	if err != nil {
+		return evidence, code, err
+	}
+
+	err = checkDisputeTakesEvidence(dispute)
+	if err != nil {
+		return evidence, http.StatusBadRequest, err
+	}
+
+	upload, err := services.UploadFile(extReq, fileName, file)
+	if err != nil {
+		return evidence, http.StatusInternalServerError, fmt.Errorf("error uploading evidence: %v", err.Error())
+	}
+	evidence.FileUrl = upload.FileUrl
+
+	err = evidence.CreateDisputeEvidence(db.MOR)
+	if err != nil {
+		return evidence, http.StatusInternalServerError, err
+	}
+
+	return evidence, http.StatusOK, nil
+}
+
+// SubmitDisputeService hands the merchant's evidence over for the response to the provider
+func SubmitDisputeService(extReq request.ExternalRequest, db postgresql.Databases, disputeID int, merchantID int) (models.Dispute, int, error) {
+	dispute, code, err := GetDisputeService(extReq, db, disputeID, merchantID)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	err = checkDisputeTakesEvidence(dispute)
+	if err != nil {
+		return dispute, http.StatusBadRequest, err
+	}
+
+	if len(dispute.Evidence) == 0 {
+		return dispute, http.StatusBadRequest, fmt.Errorf("upload evidence before submitting dispute %v", dispute.ID)
+	}
+
+	code, err = disputes.UpdateDisputeStatus(extReq, db, &dispute, models.DisputeSubmitted, "", false)
+	if err != nil {
+		return dispute, code, err
+	}
+
+	return dispute, http.StatusOK, nil
+}
+
+func getDispute(db postgresql.Databases, disputeID int, merchantID int) (models.Dispute, int, error) {
+	dispute := models.Dispute{ID: uint(disputeID)}
+	code, err := dispute.GetDisputeByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return dispute, code, err
+		}
+		return dispute, code, fmt.Errorf("dispute with id %v not found", disputeID)
+	}
+
+	if merchantID != 0 && dispute.MerchantID != int64(merchantID) {
+		return dispute, http.StatusBadRequest, fmt.Errorf("dispute with id %v not found", disputeID)
+	}
+
+	return dispute, http.StatusOK, nil
+}
+
+func checkDisputeTakesEvidence(dispute models.Dispute) error {
+	if !dispute.Status.In([]models.DisputeStatus{models.DisputeOpened, models.DisputeEvidenceRequired}) {
+		return fmt.Errorf("dispute %v is %v, it no longer takes evidence", dispute.ID, dispute.Status)
+	}
+
+	if !dispute.EvidenceDueAt.IsZero() && time.Now().After(dispute.EvidenceDueAt) {
+		return fmt.Errorf("evidence of dispute %v was due %v", dispute.ID, dispute.EvidenceDueAt.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/ledger"
+)
+
+func GetLedgerBalancesService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetLedgerRequest) ([]models.LedgerBalance, int, error) {
+	var (
+		ledgerPosting = models.LedgerPosting{
+			Account:    models.LedgerAccount(req.Account),
+			MerchantID: int64(req.AccountID),
+			Currency:   strings.ToUpper(req.Currency),
+		}
+	)
+
+	balances, err := ledgerPosting.GetLedgerBalances(db.MOR)
+	if err != nil {
+		return balances, http.StatusInternalServerError, err
+	}
+
+	return balances, http.StatusOK, nil
+}
+
+func GetLedgerStatementService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetLedgerRequest) ([]models.LedgerPosting, postgresql.PaginationResponse, int, error) {
+	var (
+		ledgerPosting = models.LedgerPosting{
+			Account:    models.LedgerAccount(req.Account),
+			MerchantID: int64(req.AccountID),
+			Currency:   strings.ToUpper(req.Currency),
+		}
+	)
+
+	if ledgerPosting.Account == "" || ledgerPosting.Currency == "" {
+		return []models.LedgerPosting{}, postgresql.PaginationResponse{}, http.StatusBadRequest, fmt.Errorf("account and currency are required")
+	}
+
+	postings, pagination, err := ledgerPosting.GetLedgerStatement(db.MOR, paginator, req.FromTime, req.ToTime)
+	if err != nil {
+		return postings, pagination, http.StatusInternalServerError, err
+	}
+
+	return postings, pagination, http.StatusOK, nil
+}
+
+// GetMerchantLedgerBalancesService returns the balances of the accounts that hold money owed to the merchant
+func GetMerchantLedgerBalancesService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetLedgerRequest, merchantID int) ([]models.LedgerBalance, int, error) {
+	var (
+		merchantBalances = []models.LedgerBalance{}
+	)
+
+	req.AccountID = merchantID
+	balances, code, err := GetLedgerBalancesService(extReq, db, req)
+	if err != nil {
+		return balances, code, err
+	}
+
+	for _, b := range balances {
+		if b.Account.In(models.MerchantLedgerAccounts) {
+			merchantBalances = append(merchantBalances, b)
+		}
+	}
+
+	return merchantBalances, http.StatusOK, nil
+}
+
+func GetMerchantLedgerStatementService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetLedgerRequest, merchantID int) ([]models.LedgerPosting, postgresql.PaginationResponse, int, error) {
+	if !models.LedgerAccount(req.Account).In(models.MerchantLedgerAccounts) {
+		return []models.LedgerPosting{}, postgresql.PaginationResponse{}, http.StatusBadRequest, fmt.Errorf("account must be one of %v", models.MerchantLedgerAccounts)
+	}
+
+	req.AccountID = merchantID
+	return GetLedgerStatementService(extReq, db, paginator, req)
+}
+
+func CheckLedgerService(extReq request.ExternalRequest, db postgresql.Databases) (models.LedgerCheck, int, error) {
+	check, err := ledger.CheckLedger(db)
+	if err != nil {
+		return check, http.StatusInternalServerError, err
+	}
+
+	if !check.Balanced {
+		extReq.Logger.Error(fmt.Sprintf("ledger check failed, currencies: %v, unbalanced journal entries: %v", check.Currencies, check.UnbalancedEntries))
+	}
+
+	return check, http.StatusOK, nil
+}
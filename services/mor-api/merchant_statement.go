@@ -0,0 +1,406 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/documents"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+var (
+	merchantStatementHeader = []string{"date", "kind", "reference", "amount", "processing_fee", "tax_fee", "wallet_amount", "balance"}
+)
+
+// GenerateMerchantStatements is the month-end statement cronjob, it stores last month's statement of every merchant and currency that doesn't have one yet
+func GenerateMerchantStatements(extReq request.ExternalRequest, db postgresql.Databases) {
+	var (
+		now        = time.Now().UTC()
+		end        = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		start      = end.AddDate(0, -1, 0)
+		period     = start.Format("2006-01")
+		currencies = map[int64]string{}
+	)
+
+	accounts, err := models.GetMerchantStatementAccounts(db.MOR, start, end)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting merchant statement accounts: %v", err.Error()))
+		return
+	}
+
+	for _, a := range accounts {
+		currency, ok := currencies[a.CountryID]
+		if !ok {
+			country, err := services.GetCountryByID(extReq, extReq.Logger, int(a.CountryID))
+			if err != nil {
+				extReq.Logger.Error(fmt.Sprintf("error getting country with id %v: %v", a.CountryID, err.Error()))
+				continue
+			}
+			currency = strings.ToUpper(country.CurrencyCode)
+			currencies[a.CountryID] = currency
+		}
+
+		_, err := generateMerchantStatement(db, a.MerchantID, a.CountryID, currency, period, start, end)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error generating %v %v statement for merchant %v: %v", period, currency, a.MerchantID, err.Error()))
+		}
+	}
+}
+
+// GetMerchantStatementService returns the merchant's statement for the currency and period with its lines
+func GetMerchantStatementService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetMerchantStatementRequest) (models.MerchantStatement, int, error) {
+	return getMerchantStatement(extReq, db, req)
+}
+
+// GetMerchantStatementsService lists the generated statements
+func GetMerchantStatementsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetMerchantStatementsRequest) ([]models.MerchantStatement, postgresql.PaginationResponse, int, error) {
+	var (
+		statement = models.MerchantStatement{
+			MerchantID: int64(req.AccountID),
+			Currency:   normalizeStatementCurrency(req.Currency),
+			Period:     strings.ToUpper(strings.TrimSpace(req.Period)),
+		}
+	)
+
+	statements, pagination, err := statement.GetMerchantStatements(db.MOR, paginator)
+	if err != nil {
+		return statements, pagination, http.StatusInternalServerError, err
+	}
+
+	return statements, pagination, http.StatusOK, nil
+}
+
+// ExportMerchantStatementService returns the statement as a csv or pdf file with its content type and name
+func ExportMerchantStatementService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetMerchantStatementRequest) ([]byte, string, string, int, error) {
+	statement, code, err := getMerchantStatement(extReq, db, req)
+	if err != nil {
+		return nil, "", "", code, err
+	}
+
+	name := fmt.Sprintf("statement-%v-%v-%v", statement.MerchantID, statement.Currency, statement.Period)
+
+	if req.Format == "pdf" {
+		user, err := services.GetUserWithAccountID(extReq, int(statement.MerchantID))
+		if err != nil {
+			return nil, "", "", http.StatusInternalServerError, err
+		}
+
+		data, err := documents.RenderStatementPDF(documents.Statement{
+			Seller:    documents.Party{Name: config.GetConfig().LegalEntity.Name},
+			Merchant:  documents.Party{Name: strings.TrimSpace(user.Lastname + " " + user.Firstname), Email: user.EmailAddress},
+			Statement: statement,
+		})
+		if err != nil {
+			return nil, "", "", http.StatusInternalServerError, err
+		}
+		return data, "application/pdf", name + ".pdf", http.StatusOK, nil
+	}
+
+	rows := [][]string{{statement.PeriodStart.Format(time.RFC3339), "opening_balance", "", "", "", "", "", formatReportAmount(statement.OpeningBalance)}}
+	for _, l := range statement.Lines {
+		rows = append(rows, []string{
+			l.Date.UTC().Format(time.RFC3339), string(l.Kind), l.Reference, formatReportAmount(l.Amount), formatReportAmount(l.ProcessingFee),
+			formatReportAmount(l.TaxFee), formatReportAmount(l.WalletAmount), formatReportAmount(l.Balance),
+		})
+	}
+	rows = append(rows, []string{statement.PeriodEnd.Format(time.RFC3339), "closing_balance", "", "", "", "", "", formatReportAmount(statement.ClosingBalance)})
+
+	data, err := utility.WriteCSV(merchantStatementHeader, rows)
+	if err != nil {
+		return nil, "", "", http.StatusInternalServerError, err
+	}
+	return data, utility.CSVContentType, name + ".csv", http.StatusOK, nil
+}
+
+// getMerchantStatement reads a generated statement back as it was stored, other periods are worked out from the records
+func getMerchantStatement(extReq request.ExternalRequest, db postgresql.Databases, req models.GetMerchantStatementRequest) (models.MerchantStatement, int, error) {
+	var (
+		statement = models.MerchantStatement{
+			MerchantID: int64(req.AccountID),
+			Currency:   normalizeStatementCurrency(req.Currency),
+			Period:     strings.ToUpper(strings.TrimSpace(req.Period)),
+		}
+	)
+
+	if req.AccountID == 0 {
+		return statement, http.StatusBadRequest, fmt.Errorf("account_id is required")
+	}
+
+	start, end, err := parseReportPeriod(statement.Period)
+	if err != nil {
+		return statement, http.StatusBadRequest, err
+	}
+
+	code, err := statement.GetMerchantStatementByMerchantCurrencyAndPeriod(db.MOR)
+	if err == nil {
+		line := models.MerchantStatementLine{StatementID: int64(statement.ID)}
+		statement.Lines, err = line.GetMerchantStatementLines(db.MOR)
+		if err != nil {
+			return statement, http.StatusInternalServerError, err
+		}
+		return statement, http.StatusOK, nil
+	}
+	if code == http.StatusInternalServerError {
+		return statement, code, err
+	}
+
+	country, err := services.GetCountryByCurrency(extReq, extReq.Logger, statement.Currency)
+	if err != nil {
+		return statement, http.StatusBadRequest, fmt.Errorf("invalid currency %v", statement.Currency)
+	}
+
+	statement, err = buildMerchantStatement(db, statement.MerchantID, int64(country.ID), statement.Currency, statement.Period, start, end)
+	if err != nil {
+		return statement, http.StatusInternalServerError, err
+	}
+
+	return statement, http.StatusOK, nil
+}
+
+// generateMerchantStatement stores the merchant's statement for the period, a statement already stored is returned as it is
+func generateMerchantStatement(db postgresql.Databases, merchantID int64, countryID int64, currency string, period string, start time.Time, end time.Time) (models.MerchantStatement, error) {
+	statement := models.MerchantStatement{MerchantID: merchantID, Currency: currency, Period: period}
+	code, err := statement.GetMerchantStatementByMerchantCurrencyAndPeriod(db.MOR)
+	if err == nil {
+		return statement, nil
+	}
+	if code == http.StatusInternalServerError {
+		return statement, err
+	}
+
+	statement, err = buildMerchantStatement(db, merchantID, countryID, currency, period, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	statement.GeneratedAt = time.Now()
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		err := statement.CreateMerchantStatement(tx)
+		if err != nil {
+			return err
+		}
+
+		for i := range statement.Lines {
+			statement.Lines[i].StatementID = int64(statement.ID)
+		}
+		return models.CreateMerchantStatementLines(tx, statement.Lines)
+	})
+	if err != nil {
+		return statement, err
+	}
+
+	return statement, nil
+}
+
+// buildMerchantStatement works out the statement from the merchant's transactions, payouts, withdrawals, refunds and disputes.
+// Only payouts, withdrawals, refunds recovered from the wallet and dispute holds and releases move the MOR_ wallet,
+// transactions are listed for the fees and tax they carry, refunds recovered from payouts and chargebacks for the record.
+func buildMerchantStatement(db postgresql.Databases, merchantID int64, countryID int64, currency string, period string, start time.Time, end time.Time) (models.MerchantStatement, error) {
+	var (
+		transaction = models.Transaction{MerchantID: merchantID, CountryID: countryID}
+		payout      = models.Payout{MerchantID: merchantID, CountryID: countryID}
+		withdrawal  = models.Withdrawal{MerchantID: merchantID, Currency: currency}
+		refund      = models.Refund{MerchantID: merchantID, CountryID: countryID}
+		dispute     = models.Dispute{MerchantID: merchantID, CountryID: countryID}
+		statement   = models.MerchantStatement{
+			MerchantID:  merchantID,
+			CountryID:   countryID,
+			Currency:    currency,
+			Period:      period,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Lines:       []models.MerchantStatementLine{},
+		}
+	)
+
+	credited, err := payout.GetCreditedPayoutsTotal(db.MOR, currency, start)
+	if err != nil {
+		return statement, err
+	}
+
+	withdrawn, err := withdrawal.GetCompletedWithdrawalsTotal(db.MOR, start)
+	if err != nil {
+		return statement, err
+	}
+
+	recovered, err := refund.GetWalletRecoveriesTotal(db.MOR, start)
+	if err != nil {
+		return statement, err
+	}
+	held, err := dispute.GetWalletHeldTotal(db.MOR, start)
+	if err != nil {
+		return statement, err
+	}
+	statement.OpeningBalance = roundAmount(credited - withdrawn - recovered - held)
+
+	transactions, err := transaction.GetStatementTransactions(db.MOR, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, t := range transactions {
+		statement.TransactionsCount++
+		statement.TransactionsAmount += t.Amount
+		statement.ProcessingFee += t.ProcessingFee
+		statement.TaxFee += t.TaxFee
+		statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+			Kind:          models.MerchantStatementLineTransaction,
+			SourceID:      int64(t.ID),
+			Reference:     t.Reference,
+			Date:          t.TransactionDate,
+			Amount:        t.Amount,
+			ProcessingFee: t.ProcessingFee,
+			TaxFee:        t.TaxFee,
+		})
+	}
+
+	payouts, err := payout.GetStatementPayouts(db.MOR, currency, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, p := range payouts {
+		amount := p.Amount
+		if p.SettlementCurrency != "" {
+			amount = p.SettlementAmount
+		}
+
+		statement.PayoutsCount++
+		statement.PayoutsAmount += amount
+		statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+			Kind:         models.MerchantStatementLinePayout,
+			SourceID:     int64(p.ID),
+			Reference:    p.Reference,
+			Date:         p.CreatedAt,
+			Amount:       amount,
+			WalletAmount: amount,
+		})
+	}
+
+	withdrawals, err := withdrawal.GetStatementWithdrawals(db.MOR, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, w := range withdrawals {
+		statement.WithdrawalsCount++
+		statement.WithdrawalsAmount += w.Amount
+		statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+			Kind:         models.MerchantStatementLineWithdrawal,
+			SourceID:     int64(w.ID),
+			Reference:    strconv.Itoa(int(w.ID)),
+			Date:         w.WithdrawalDate,
+			Amount:       w.Amount,
+			WalletAmount: -w.Amount,
+		})
+	}
+
+	refunds, err := refund.GetStatementRefunds(db.MOR, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, r := range refunds {
+		// a refund recovered from a payout is already taken off the payout's credit
+		var walletAmount float64
+		if r.RecoveryMethod == models.RefundRecoveryWallet && r.RecoveryStatus == models.RefundRecoveryRecovered {
+			walletAmount = r.RecoveredAmount
+		}
+
+		statement.RefundsCount++
+		statement.RefundsAmount += walletAmount
+		statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+			Kind:          models.MerchantStatementLineRefund,
+			SourceID:      int64(r.ID),
+			Reference:     r.Reference,
+			Date:          r.CreatedAt,
+			Amount:        r.Amount,
+			ProcessingFee: r.ProcessingFee,
+			TaxFee:        r.TaxFee,
+			WalletAmount:  -walletAmount,
+		})
+	}
+
+	disputes, err := dispute.GetStatementDisputes(db.MOR, start, end)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, d := range disputes {
+		if d.HeldAmount > 0 && !d.CreatedAt.Before(start) && d.CreatedAt.Before(end) {
+			statement.DisputeHoldsAmount += d.HeldAmount
+			statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+				Kind:         models.MerchantStatementLineDisputeHold,
+				SourceID:     int64(d.ID),
+				Reference:    d.ProviderReference,
+				Date:         d.CreatedAt,
+				Amount:       d.HeldAmount,
+				WalletAmount: -d.HeldAmount,
+			})
+		}
+
+		if d.ResolvedAt.Before(start) || !d.ResolvedAt.Before(end) {
+			continue
+		}
+
+		switch {
+		case d.Status == models.DisputeWon && d.HeldAmount > 0:
+			statement.DisputeHoldsAmount -= d.HeldAmount
+			statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+				Kind:         models.MerchantStatementLineDisputeRelease,
+				SourceID:     int64(d.ID),
+				Reference:    d.ProviderReference,
+				Date:         d.ResolvedAt,
+				Amount:       d.HeldAmount,
+				WalletAmount: d.HeldAmount,
+			})
+		case d.Status == models.DisputeLost:
+			statement.ChargebacksCount++
+			statement.ChargebacksAmount += d.Amount
+			statement.Lines = append(statement.Lines, models.MerchantStatementLine{
+				Kind:      models.MerchantStatementLineChargeback,
+				SourceID:  int64(d.ID),
+				Reference: d.ProviderReference,
+				Date:      d.ResolvedAt,
+				Amount:    d.Amount,
+			})
+		}
+	}
+
+	sort.SliceStable(statement.Lines, func(i, j int) bool {
+		return statement.Lines[i].Date.Before(statement.Lines[j].Date)
+	})
+
+	balance := statement.OpeningBalance
+	for i, l := range statement.Lines {
+		balance = roundAmount(balance + l.WalletAmount)
+		statement.Lines[i].Balance = balance
+	}
+
+	statement.TransactionsAmount = roundAmount(statement.TransactionsAmount)
+	statement.ProcessingFee = roundAmount(statement.ProcessingFee)
+	statement.TaxFee = roundAmount(statement.TaxFee)
+	statement.PayoutsAmount = roundAmount(statement.PayoutsAmount)
+	statement.WithdrawalsAmount = roundAmount(statement.WithdrawalsAmount)
+	statement.RefundsAmount = roundAmount(statement.RefundsAmount)
+	statement.ChargebacksAmount = roundAmount(statement.ChargebacksAmount)
+	statement.DisputeHoldsAmount = roundAmount(statement.DisputeHoldsAmount)
+	statement.ClosingBalance = roundAmount(statement.OpeningBalance + statement.PayoutsAmount - statement.WithdrawalsAmount - statement.RefundsAmount - statement.DisputeHoldsAmount)
+
+	return statement, nil
+}
+
+// normalizeStatementCurrency accepts the currency or its wallet name, NGN or MOR_NGN
+func normalizeStatementCurrency(currency string) string {
+	return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(currency)), "MOR_")
+}
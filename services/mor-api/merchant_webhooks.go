@@ -0,0 +1,321 @@
+package mor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/utility"
+)
+
+const (
+	merchantWebhookMaxAttempts     = 8
+	merchantWebhookTimeout         = 10 * time.Second
+	merchantWebhookMaxResponseSize = 2048
+)
+
+var (
+	merchantWebhookQueue  = make(chan uint, 1000)
+	merchantWebhookClient = &http.Client{Timeout: merchantWebhookTimeout}
+)
+
+func CreateMerchantWebhookEndpointService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, req models.CreateMerchantWebhookEndpointRequest) (models.MerchantWebhookEndpoint, int, error) {
+	var (
+		endpoint = models.MerchantWebhookEndpoint{
+			MerchantID: int64(accountID),
+			URL:        req.URL,
+			Secret:     "whsec_" + utility.RandomString(32),
+			IsActive:   true,
+		}
+	)
+
+	for _, event := range req.Events {
+		if !event.In(models.MerchantWebhookEvents) {
+			return endpoint, http.StatusBadRequest, fmt.Errorf("event %v is not supported, events must be one of %v", event, models.MerchantWebhookEvents)
+		}
+		if !event.In(endpoint.Events) {
+			endpoint.Events = append(endpoint.Events, event)
+		}
+	}
+
+	err := endpoint.CreateMerchantWebhookEndpoint(db.MOR)
+	if err != nil {
+		return endpoint, http.StatusInternalServerError, err
+	}
+
+	return endpoint, http.StatusCreated, nil
+}
+
+func GetMerchantWebhookEndpointsService(extReq request.ExternalRequest, db postgresql.Databases, accountID int) ([]models.MerchantWebhookEndpoint, int, error) {
+	var (
+		endpoint = models.MerchantWebhookEndpoint{MerchantID: int64(accountID)}
+	)
+
+	endpoints, err := endpoint.GetMerchantWebhookEndpointsByMerchantID(db.MOR)
+	if err != nil {
+		return endpoints, http.StatusInternalServerError, err
+	}
+
+	return endpoints, http.StatusOK, nil
+}
+
+func DeleteMerchantWebhookEndpointService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, endpointID int) (int, error) {
+	var (
+		endpoint = models.MerchantWebhookEndpoint{ID: uint(endpointID), MerchantID: int64(accountID)}
+	)
+
+	code, err := endpoint.GetMerchantWebhookEndpointByIDAndMerchantID(db.MOR)
+	if err != nil {
+		return code, err
+	}
+
+	err = endpoint.Delete(db.MOR)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// PingMerchantWebhookEndpointService sends a ping event to the endpoint straight away and returns the delivery, a failed ping is not retried
+func PingMerchantWebhookEndpointService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, endpointID int) (models.MerchantWebhookDelivery, int, error) {
+	var (
+		endpoint = models.MerchantWebhookEndpoint{ID: uint(endpointID), MerchantID: int64(accountID)}
+	)
+
+	code, err := endpoint.GetMerchantWebhookEndpointByIDAndMerchantID(db.MOR)
+	if err != nil {
+		return models.MerchantWebhookDelivery{}, code, err
+	}
+
+	delivery, err := createMerchantWebhookDelivery(db, endpoint, models.MerchantWebhookPing, map[string]interface{}{"endpoint_id": endpoint.ID})
+	if err != nil {
+		return delivery, http.StatusInternalServerError, err
+	}
+
+	err = ProcessMerchantWebhookDelivery(extReq, db, delivery.ID)
+	if err != nil {
+		return delivery, http.StatusInternalServerError, err
+	}
+
+	code, err = delivery.GetMerchantWebhookDeliveryByID(db.MOR)
+	if err != nil {
+		return delivery, code, err
+	}
+
+	return delivery, http.StatusOK, nil
+}
+
+func GetMerchantWebhookDeliveriesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, accountID int, req models.GetMerchantWebhookDeliveriesRequest) ([]models.MerchantWebhookDelivery, postgresql.PaginationResponse, int, error) {
+	var (
+		delivery = models.MerchantWebhookDelivery{
+			MerchantID: int64(accountID),
+			EndpointID: int64(req.EndpointID),
+			Event:      models.MerchantWebhookEvent(req.Event),
+			Status:     models.MerchantWebhookDeliveryStatus(req.Status),
+		}
+	)
+
+	deliveries, pagination, err := delivery.GetMerchantWebhookDeliveries(db.MOR, paginator)
+	if err != nil {
+		return deliveries, pagination, http.StatusInternalServerError, err
+	}
+
+	return deliveries, pagination, http.StatusOK, nil
+}
+
+// NotifyMerchantWebhookEvent queues the event for every active endpoint of the merchant subscribed to it.
+// Failures are logged and never returned, a webhook must not fail the operation that raised it.
+func NotifyMerchantWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, merchantID int64, event models.MerchantWebhookEvent, data interface{}) {
+	endpoint := models.MerchantWebhookEndpoint{MerchantID: merchantID}
+	endpoints, err := endpoint.GetMerchantWebhookEndpointsByMerchantID(db.MOR)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("merchant webhook error, getting endpoints for merchant %v: %v", merchantID, err.Error()))
+		return
+	}
+
+	for _, e := range endpoints {
+		if !e.IsActive || !event.In(e.Events) {
+			continue
+		}
+
+		delivery, err := createMerchantWebhookDelivery(db, e, event, data)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("merchant webhook error, queueing %v for endpoint %v: %v", event, e.ID, err.Error()))
+			continue
+		}
+
+		enqueueMerchantWebhookDelivery(delivery.ID)
+	}
+}
+
+// StartMerchantWebhookWorkers runs the pool that delivers merchant webhooks and the scheduler that feeds it retries
+func StartMerchantWebhookWorkers(extReq request.ExternalRequest, db postgresql.Databases) {
+	utility.LogAndPrint(extReq.Logger, fmt.Sprintf("starting %v merchant webhook workers", webhookWorkers))
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for deliveryID := range merchantWebhookQueue {
+				err := ProcessMerchantWebhookDelivery(extReq, db, deliveryID)
+				if err != nil {
+					extReq.Logger.Error(fmt.Sprintf("merchant webhook error, processing delivery %v: %v", deliveryID, err.Error()))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			EnqueueDueMerchantWebhookDeliveries(extReq, db)
+			time.Sleep(webhookPollInterval)
+		}
+	}()
+}
+
+// EnqueueDueMerchantWebhookDeliveries queues pending and retrying deliveries whose next attempt is due
+func EnqueueDueMerchantWebhookDeliveries(extReq request.ExternalRequest, db postgresql.Databases) {
+	delivery := models.MerchantWebhookDelivery{}
+	deliveries, err := delivery.GetDueMerchantWebhookDeliveries(db.MOR, time.Now().Add(-webhookProcessingTimeout))
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("merchant webhook error, getting due deliveries: %v", err.Error()))
+		return
+	}
+
+	for _, d := range deliveries {
+		enqueueMerchantWebhookDelivery(d.ID)
+	}
+}
+
+// ProcessMerchantWebhookDelivery makes one attempt at a delivery, failed attempts are rescheduled with exponential backoff until they are marked failed
+func ProcessMerchantWebhookDelivery(extReq request.ExternalRequest, db postgresql.Databases, deliveryID uint) error {
+	var (
+		delivery = models.MerchantWebhookDelivery{ID: deliveryID}
+	)
+
+	claimed, err := delivery.ClaimMerchantWebhookDelivery(db.MOR, time.Now().Add(-webhookProcessingTimeout))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+
+	_, err = delivery.GetMerchantWebhookDeliveryByID(db.MOR)
+	if err != nil {
+		return err
+	}
+
+	endpoint := models.MerchantWebhookEndpoint{ID: uint(delivery.EndpointID)}
+	code, err := endpoint.GetMerchantWebhookEndpointByID(db.MOR)
+	switch {
+	case err != nil && code == http.StatusInternalServerError:
+		delivery.Status = models.MerchantWebhookDeliveryRetrying
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(getWebhookRetryDelay(delivery.Attempts))
+		return delivery.UpdateAllFields(db.MOR)
+	case err != nil:
+		delivery.Status = models.MerchantWebhookDeliveryFailed
+		delivery.LastError = fmt.Sprintf("endpoint %v no longer exists", delivery.EndpointID)
+		return delivery.UpdateAllFields(db.MOR)
+	case !endpoint.IsActive:
+		delivery.Status = models.MerchantWebhookDeliveryFailed
+		delivery.LastError = fmt.Sprintf("endpoint %v is not active", delivery.EndpointID)
+		return delivery.UpdateAllFields(db.MOR)
+	}
+
+	delivery.ResponseCode, delivery.ResponseBody, err = sendMerchantWebhookDelivery(endpoint, delivery)
+	switch {
+	case err == nil:
+		delivery.Status = models.MerchantWebhookDeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = time.Now()
+	case delivery.Event == models.MerchantWebhookPing || delivery.Attempts >= merchantWebhookMaxAttempts:
+		extReq.Logger.Error(fmt.Sprintf("merchant webhook error, delivery %v to endpoint %v failed after %v attempts: %v", delivery.ID, endpoint.ID, delivery.Attempts, err.Error()))
+		delivery.Status = models.MerchantWebhookDeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = models.MerchantWebhookDeliveryRetrying
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(getWebhookRetryDelay(delivery.Attempts))
+	}
+
+	return delivery.UpdateAllFields(db.MOR)
+}
+
+func createMerchantWebhookDelivery(db postgresql.Databases, endpoint models.MerchantWebhookEndpoint, event models.MerchantWebhookEvent, data interface{}) (models.MerchantWebhookDelivery, error) {
+	delivery := models.MerchantWebhookDelivery{
+		EndpointID:    int64(endpoint.ID),
+		MerchantID:    endpoint.MerchantID,
+		Event:         event,
+		Status:        models.MerchantWebhookDeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+
+	err := delivery.CreateMerchantWebhookDelivery(db.MOR)
+	if err != nil {
+		return delivery, err
+	}
+
+	// the payload carries the delivery id so merchants can drop deliveries they have already handled
+	payload, err := json.Marshal(models.MerchantWebhookPayload{
+		ID:        delivery.ID,
+		Event:     event,
+		CreatedAt: delivery.CreatedAt,
+		Data:      data,
+	})
+	if err != nil {
+		return delivery, err
+	}
+
+	delivery.Payload = string(payload)
+	err = delivery.UpdateAllFields(db.MOR)
+	if err != nil {
+		return delivery, err
+	}
+
+	return delivery, nil
+}
+
+func sendMerchantWebhookDelivery(endpoint models.MerchantWebhookEndpoint, delivery models.MerchantWebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Mor-Event", string(delivery.Event))
+	req.Header.Set("X-Mor-Delivery", strconv.Itoa(int(delivery.ID)))
+	req.Header.Set("X-Mor-Signature", utility.Sha256Hmac(endpoint.Secret, body))
+
+	resp, err := merchantWebhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, merchantWebhookMaxResponseSize))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(responseBody), fmt.Errorf("endpoint responded with status %v", resp.StatusCode)
+	}
+
+	return resp.StatusCode, string(responseBody), nil
+}
+
+// the queue is best effort, deliveries that don't fit are picked up by the scheduler
+func enqueueMerchantWebhookDelivery(deliveryID uint) {
+	select {
+	case merchantWebhookQueue <- deliveryID:
+	default:
+	}
+}
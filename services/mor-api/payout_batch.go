@@ -0,0 +1,320 @@
+package mor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"gorm.io/gorm"
+)
+
+// ProcessPayoutBatch pays out the batch's pending lines one merchant at a time and stops before the next line once the batch is cancelled
+func ProcessPayoutBatch(extReq request.ExternalRequest, db postgresql.Databases, batch models.PayoutBatch) (models.PayoutBatch, error) {
+	var (
+		line = models.PayoutBatchLine{BatchID: int64(batch.ID), Status: models.PayoutBatchLinePending}
+	)
+
+	started, err := batch.UpdatePayoutBatchStatus(db.MOR, models.PayoutBatchRunning, []models.PayoutBatchStatus{models.PayoutBatchPending})
+	if err != nil {
+		extReq.Logger.Error(err.Error())
+		return batch, err
+	}
+	if !started {
+		return batch, fmt.Errorf("payout batch %v was already started or cancelled", batch.ID)
+	}
+
+	lines, _, err := line.GetPayoutBatchLines(db.MOR, nil)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting lines of payout batch %v: %v", batch.ID, err.Error()))
+		return batch, err
+	}
+
+	for _, l := range lines {
+		current := models.PayoutBatch{ID: batch.ID}
+		_, err := current.GetPayoutBatchByID(db.MOR)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error getting payout batch %v: %v", batch.ID, err.Error()))
+			return batch, err
+		}
+		if current.Status == models.PayoutBatchCancelled {
+			break
+		}
+
+		processPayoutBatchLine(extReq, db, &l)
+		err = l.UpdateAllFields(db.MOR)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error updating line %v of payout batch %v: %v", l.ID, batch.ID, err.Error()))
+		}
+
+		err = batch.UpdatePayoutBatchCounts(db.MOR)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error counting lines of payout batch %v: %v", batch.ID, err.Error()))
+		}
+	}
+
+	_, err = batch.UpdatePayoutBatchStatus(db.MOR, models.PayoutBatchCompleted, []models.PayoutBatchStatus{models.PayoutBatchRunning})
+	if err != nil {
+		extReq.Logger.Error(err.Error())
+		return batch, err
+	}
+
+	err = batch.UpdatePayoutBatchCounts(db.MOR)
+	if err != nil {
+		return batch, err
+	}
+
+	_, err = batch.GetPayoutBatchByID(db.MOR)
+	return batch, err
+}
+
+func GetPayoutBatchesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetPayoutBatchesRequest) ([]models.PayoutBatch, postgresql.PaginationResponse, int, error) {
+	var (
+		batch = models.PayoutBatch{
+			TriggeredBy: models.PayoutBatchTrigger(req.TriggeredBy),
+			Status:      models.PayoutBatchStatus(req.Status),
+		}
+	)
+
+	batches, pagination, err := batch.GetPayoutBatches(db.MOR, paginator)
+	if err != nil {
+		return batches, pagination, http.StatusInternalServerError, err
+	}
+
+	return batches, pagination, http.StatusOK, nil
+}
+
+func GetPayoutBatchService(extReq request.ExternalRequest, db postgresql.Databases, batchID int) (models.PayoutBatch, int, error) {
+	var (
+		batch = models.PayoutBatch{ID: uint(batchID)}
+	)
+
+	code, err := batch.GetPayoutBatchByID(db.MOR)
+	if err != nil {
+		return batch, code, err
+	}
+
+	return batch, http.StatusOK, nil
+}
+
+func GetPayoutBatchLinesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, batchID int, status string) ([]models.PayoutBatchLine, postgresql.PaginationResponse, int, error) {
+	var (
+		line = models.PayoutBatchLine{BatchID: int64(batchID), Status: models.PayoutBatchLineStatus(status)}
+	)
+
+	lines, pagination, err := line.GetPayoutBatchLines(db.MOR, &paginator)
+	if err != nil {
+		return lines, pagination, http.StatusInternalServerError, err
+	}
+
+	return lines, pagination, http.StatusOK, nil
+}
+
+// CancelPayoutBatchService stops a batch that hasn't finished, merchants already paid out stay paid
+func CancelPayoutBatchService(extReq request.ExternalRequest, db postgresql.Databases, batchID int) (models.PayoutBatch, int, error) {
+	var (
+		batch = models.PayoutBatch{ID: uint(batchID)}
+		line  = models.PayoutBatchLine{BatchID: int64(batchID)}
+	)
+
+	code, err := batch.GetPayoutBatchByID(db.MOR)
+	if err != nil {
+		return batch, code, err
+	}
+
+	cancelled, err := batch.UpdatePayoutBatchStatus(db.MOR, models.PayoutBatchCancelled, []models.PayoutBatchStatus{models.PayoutBatchPending, models.PayoutBatchRunning})
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+	if !cancelled {
+		return batch, http.StatusBadRequest, fmt.Errorf("payout batch is already %v", batch.Status)
+	}
+
+	err = line.CancelPendingPayoutBatchLines(db.MOR)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	err = batch.UpdatePayoutBatchCounts(db.MOR)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	_, err = batch.GetPayoutBatchByID(db.MOR)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	return batch, http.StatusOK, nil
+}
+
+// RetryPayoutBatchService starts a new batch for the merchants whose lines failed in a finished batch.
+// A retry above the payout_to_wallet policy's threshold is queued for approval by other admins instead, for the payable transactions resolved now.
+func RetryPayoutBatchService(extReq request.ExternalRequest, db postgresql.Databases, batchID int, adminID int) (models.PayoutBatch, int, error) {
+	batch, merchantIDs, code, err := getRetryablePayoutBatch(db, batchID)
+	if err != nil {
+		return batch, code, err
+	}
+
+	approvalPayload, err := getPayoutApprovalPayload(db, merchantIDs)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	required, amount, currency, err := getPayoutApprovals(extReq, db, approvalPayload.Totals)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	payload, err := json.Marshal(approvalPayload)
+	if err != nil {
+		return batch, http.StatusInternalServerError, err
+	}
+
+	code, err = requireApproval(db, models.ApprovalRequest{
+		Action:            models.ApprovalPayoutBatchRetry,
+		ResourceID:        int64(batch.ID),
+		Payload:           string(payload),
+		Amount:            amount,
+		Currency:          currency,
+		RequiredApprovals: required,
+		RequestedBy:       int64(adminID),
+	})
+	if err != nil {
+		return batch, code, err
+	}
+
+	return retryPayoutBatch(extReq, db, batch, merchantIDs, nil)
+}
+
+// getRetryablePayoutBatch returns a finished batch with the merchants whose lines failed
+func getRetryablePayoutBatch(db postgresql.Databases, batchID int) (models.PayoutBatch, []int, int, error) {
+	var (
+		batch       = models.PayoutBatch{ID: uint(batchID)}
+		line        = models.PayoutBatchLine{BatchID: int64(batchID), Status: models.PayoutBatchLineFailed}
+		merchantIDs = []int{}
+	)
+
+	code, err := batch.GetPayoutBatchByID(db.MOR)
+	if err != nil {
+		return batch, merchantIDs, code, err
+	}
+
+	if batch.Status == models.PayoutBatchPending || batch.Status == models.PayoutBatchRunning {
+		return batch, merchantIDs, http.StatusBadRequest, fmt.Errorf("payout batch is still %v", batch.Status)
+	}
+
+	lines, _, err := line.GetPayoutBatchLines(db.MOR, nil)
+	if err != nil {
+		return batch, merchantIDs, http.StatusInternalServerError, err
+	}
+
+	for _, l := range lines {
+		merchantIDs = append(merchantIDs, int(l.MerchantID))
+	}
+
+	if len(merchantIDs) == 0 {
+		return batch, merchantIDs, http.StatusBadRequest, fmt.Errorf("payout batch has no failed lines")
+	}
+
+	return batch, merchantIDs, http.StatusOK, nil
+}
+
+// retryPayoutBatch pays the merchants out in a retry batch of the batch, only their pinned transactions when pinned is set
+func retryPayoutBatch(extReq request.ExternalRequest, db postgresql.Databases, batch models.PayoutBatch, merchantIDs []int, pinned map[int][]uint) (models.PayoutBatch, int, error) {
+	retry, err := createPayoutBatch(db, models.PayoutBatchRetry, int64(batch.ID), merchantIDs, pinned)
+	if err != nil {
+		return retry, http.StatusInternalServerError, err
+	}
+
+	if len(merchantIDs) > payoutSyncLimit {
+		go ProcessPayoutBatch(extReq, db, retry)
+		return retry, http.StatusOK, nil
+	}
+
+	retry, err = ProcessPayoutBatch(extReq, db, retry)
+	if err != nil {
+		return retry, http.StatusInternalServerError, err
+	}
+
+	return retry, http.StatusOK, nil
+}
+
+// createPayoutBatch stores a pending batch with a pending line per merchant, pinned holds the transactions each line is limited to
+func createPayoutBatch(db postgresql.Databases, trigger models.PayoutBatchTrigger, retryOfID int64, accountIDs []int, pinned map[int][]uint) (models.PayoutBatch, error) {
+	var (
+		batch = models.PayoutBatch{
+			TriggeredBy: trigger,
+			RetryOfID:   retryOfID,
+			Status:      models.PayoutBatchPending,
+			Total:       len(accountIDs),
+		}
+	)
+
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		err := batch.CreatePayoutBatch(tx)
+		if err != nil {
+			return err
+		}
+
+		lines := []models.PayoutBatchLine{}
+		for _, accountID := range accountIDs {
+			lines = append(lines, models.PayoutBatchLine{
+				BatchID:        int64(batch.ID),
+				MerchantID:     int64(accountID),
+				Status:         models.PayoutBatchLinePending,
+				PayoutIDs:      []int64{},
+				TransactionIDs: pinned[accountID],
+			})
+		}
+
+		return models.CreatePayoutBatchLines(tx, lines)
+	})
+
+	return batch, err
+}
+
+// processPayoutBatchLine pays out the line's merchant and records the outcome on the line
+func processPayoutBatchLine(extReq request.ExternalRequest, db postgresql.Databases, line *models.PayoutBatchLine) {
+	payouts, code, err := payoutToUser(extReq, db, int(line.MerchantID), line.TransactionIDs)
+	line.Code = code
+	line.ProcessedAt = time.Now()
+	line.PayoutIDs = []int64{}
+	for _, p := range payouts {
+		line.PayoutIDs = append(line.PayoutIDs, int64(p.ID))
+	}
+
+	if errors.As(err, &payoutHoldError{}) {
+		line.Status = models.PayoutBatchLineSkipped
+		line.Reason = err.Error()
+		return
+	}
+
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("payout batch %v, error paying out merchant %v: %v", line.BatchID, line.MerchantID, err.Error()))
+		line.Status = models.PayoutBatchLineFailed
+		line.Reason = err.Error()
+		return
+	}
+
+	if len(payouts) == 0 {
+		line.Status = models.PayoutBatchLineSkipped
+		line.Reason = "no unpaid transactions"
+		return
+	}
+
+	// a payout whose wallet credit failed is retried by the resume-payouts cronjob, so the line still succeeded
+	reasons := []string{}
+	for _, p := range payouts {
+		if p.Status != models.PayoutSettled {
+			reasons = append(reasons, fmt.Sprintf("payout %v is %v: %v", p.Reference, p.Status, p.LastError))
+		}
+	}
+	line.Status = models.PayoutBatchLineSuccess
+	line.Reason = strings.Join(reasons, "; ")
+}
@@ -0,0 +1,67 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/utility"
+)
+
+// UpdateSettlementCurrencyService sets the MOR_ wallet currency the merchant's payouts are converted into,
+// an empty currency pays each currency into its own wallet again
+func UpdateSettlementCurrencyService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, req models.UpdateSettlementCurrencyRequest) (models.Setting, int, error) {
+	currency := normalizeStatementCurrency(req.Currency)
+
+	setting, code, err := getOrCreateMerchantSetting(extReq, db, accountID)
+	if err != nil {
+		return setting, code, err
+	}
+
+	if currency != "" && !utility.InStringSlice(currency, setting.CurrencyCodes) {
+		return setting, http.StatusBadRequest, fmt.Errorf("add a MOR_%v wallet before settling payouts in %v", currency, currency)
+	}
+
+	setting.SettlementCurrency = currency
+	err = setting.UpdateSettlementCurrency(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+// lockPayoutRate fixes the rate and fee of a payout converted into the merchant's settlement currency before its wallet is credited,
+// so retries credit the same amount. A payout already in the settlement currency isn't converted.
+func lockPayoutRate(extReq request.ExternalRequest, db postgresql.Databases, payout *models.Payout, currency string) error {
+	if payout.SettlementCurrency == "" || payout.FxRateID != 0 {
+		return nil
+	}
+
+	currency = strings.ToUpper(currency)
+	if strings.EqualFold(payout.SettlementCurrency, currency) {
+		payout.SettlementCurrency = ""
+		payout.FxFeeRate = 0
+		return payout.UpdateAllFields(db.MOR)
+	}
+
+	rate, err := services.GetRateByCurrencies(extReq, currency, payout.SettlementCurrency)
+	if err != nil {
+		return fmt.Errorf("error getting rate for currencies %v -> %v: %v", currency, payout.SettlementCurrency, err.Error())
+	}
+	if rate.ID <= 0 || rate.InitialAmount <= 0 || rate.Amount <= 0 {
+		return fmt.Errorf("no rate for currencies %v -> %v", currency, payout.SettlementCurrency)
+	}
+
+	converted := roundAmount(payout.Amount * rate.Amount / rate.InitialAmount)
+	payout.FxRateID = rate.ID
+	payout.FxRate = rate.Amount / rate.InitialAmount
+	payout.FxFee = roundAmount(converted * payout.FxFeeRate / 100)
+	payout.SettlementAmount = roundAmount(converted - payout.FxFee)
+
+	return payout.UpdateAllFields(db.MOR)
+}
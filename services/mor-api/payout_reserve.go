@@ -0,0 +1,190 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+// ReleasePayoutReserves is the reserve release cronjob, every reserve past its release date is paid out to the merchant's MOR_ wallet
+func ReleasePayoutReserves(extReq request.ExternalRequest, db postgresql.Databases) {
+	var (
+		reserve = models.PayoutReserve{}
+	)
+
+	reserves, err := reserve.GetDuePayoutReserves(db.MOR, time.Now())
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting due payout reserves: %v", err.Error()))
+		return
+	}
+
+	for _, r := range reserves {
+		err := releasePayoutReserve(extReq, db, r)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error releasing payout reserve %v: %v", r.ID, err.Error()))
+		}
+	}
+}
+
+func GetPayoutReservesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetPayoutReservesRequest) ([]models.PayoutReserve, postgresql.PaginationResponse, int, error) {
+	var (
+		reserve = models.PayoutReserve{
+			MerchantID: int64(req.AccountID),
+			Status:     models.PayoutReserveStatus(req.Status),
+		}
+	)
+
+	reserves, pagination, err := reserve.GetPayoutReserves(db.MOR, paginator)
+	if err != nil {
+		return reserves, pagination, http.StatusInternalServerError, err
+	}
+
+	return reserves, pagination, http.StatusOK, nil
+}
+
+// UpdatePayoutPolicyService sets the merchant's rolling reserve and minimum transaction age, reserves already held keep their release date
+func UpdatePayoutPolicyService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, req models.UpdatePayoutPolicyRequest) (models.Setting, int, error) {
+	setting, code, err := getOrCreateMerchantSetting(extReq, db, accountID)
+	if err != nil {
+		return setting, code, err
+	}
+
+	setting.ReserveRate = req.ReserveRate
+	setting.ReserveDays = req.ReserveDays
+	setting.PayoutDelayDays = req.PayoutDelayDays
+	setting.FxFeeRate = req.FxFeeRate
+	err = setting.UpdatePayoutPolicy(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+// HoldPayoutsService stops payouts to the merchant until the hold is lifted, reserve releases are held too
+func HoldPayoutsService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, req models.HoldPayoutsRequest) (models.Setting, int, error) {
+	setting, code, err := getOrCreateMerchantSetting(extReq, db, accountID)
+	if err != nil {
+		return setting, code, err
+	}
+
+	setting.PayoutHold = true
+	setting.PayoutHoldReason = req.Reason
+	setting.PayoutHeldAt = time.Now()
+	err = setting.UpdatePayoutHold(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+func LiftPayoutHoldService(extReq request.ExternalRequest, db postgresql.Databases, accountID int) (models.Setting, int, error) {
+	setting, code, err := getOrCreateMerchantSetting(extReq, db, accountID)
+	if err != nil {
+		return setting, code, err
+	}
+
+	if !setting.PayoutHold {
+		return setting, http.StatusBadRequest, fmt.Errorf("payouts are not on hold")
+	}
+
+	setting.PayoutHold = false
+	setting.PayoutHoldReason = ""
+	setting.PayoutHeldAt = time.Time{}
+	err = setting.UpdatePayoutHold(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+func getOrCreateMerchantSetting(extReq request.ExternalRequest, db postgresql.Databases, accountID int) (models.Setting, int, error) {
+	var (
+		setting = models.Setting{AccountID: int64(accountID)}
+	)
+
+	code, err := setting.GetSettingByAccountID(db.MOR)
+	if err == nil {
+		return setting, http.StatusOK, nil
+	}
+	if code == http.StatusInternalServerError {
+		return setting, code, err
+	}
+
+	err = setting.CreateSetting(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+// releasePayoutReserve pays a due reserve out with a release payout, the payout is created and the reserve
+// marked released together so the reserve is paid out once, then the payout goes through processPayout.
+// A reserve waits while its own payout hasn't settled or the merchant's payouts are on hold.
+func releasePayoutReserve(extReq request.ExternalRequest, db postgresql.Databases, reserve models.PayoutReserve) error {
+	var (
+		source  = models.Payout{ID: uint(reserve.PayoutID)}
+		setting = models.Setting{AccountID: reserve.MerchantID}
+		payout  = models.Payout{
+			MerchantID: reserve.MerchantID,
+			Reference:  utility.RandomString(25),
+			Amount:     reserve.Amount,
+			ReserveID:  int64(reserve.ID),
+			CountryID:  reserve.CountryID,
+			Status:     models.PayoutPending,
+		}
+	)
+
+	_, err := source.GetPayoutByID(db.MOR)
+	if err != nil {
+		return err
+	}
+	if source.Status != models.PayoutSettled {
+		return nil
+	}
+
+	code, err := setting.GetSettingByAccountID(db.MOR)
+	if err != nil && code == http.StatusInternalServerError {
+		return err
+	}
+	if setting.PayoutHold {
+		return nil
+	}
+	payout.SettlementCurrency = setting.SettlementCurrency
+	payout.FxFeeRate = setting.FxFeeRate
+
+	alreadyReleased := false
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		err := payout.CreatePayout(tx)
+		if err != nil {
+			return err
+		}
+
+		released, err := reserve.ReleasePayoutReserve(tx, payout.ID)
+		if err != nil {
+			return err
+		}
+		if !released {
+			alreadyReleased = true
+			return fmt.Errorf("payout reserve %v was already released", reserve.ID)
+		}
+		return nil
+	})
+	if alreadyReleased {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return processPayout(extReq, db, &payout)
+}
@@ -0,0 +1,215 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+)
+
+// RunScheduledPayouts is the payout cronjob, it pays out every merchant whose payout schedule is due and logs the run
+func RunScheduledPayouts(extReq request.ExternalRequest, db postgresql.Databases) {
+	var (
+		setting     = models.Setting{}
+		now         = time.Now().UTC()
+		due         = []int{}
+		dueSettings = map[int64]models.Setting{}
+		run         = models.PayoutRun{Status: models.PayoutRunRunning, Failures: []models.PayoutRunFailure{}}
+	)
+
+	err := run.CreatePayoutRun(db.MOR)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error logging payout run: %v", err.Error()))
+		return
+	}
+
+	settings, err := setting.GetSettingsWithAutomaticPayouts(db.MOR)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting payout schedules: %v", err.Error()))
+		completePayoutRun(extReq, db, &run, err)
+		return
+	}
+
+	for _, s := range settings {
+		isDue, err := isPayoutDue(db, s, now)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error checking payout schedule for merchant %v: %v", s.AccountID, err.Error()))
+			run.Failures = append(run.Failures, models.PayoutRunFailure{MerchantID: s.AccountID, Error: err.Error()})
+			continue
+		}
+		if isDue {
+			due = append(due, int(s.AccountID))
+			dueSettings[s.AccountID] = s
+		}
+	}
+	run.MerchantsChecked = len(settings)
+	run.MerchantsDue = len(due)
+
+	if len(due) == 0 {
+		completePayoutRun(extReq, db, &run, nil)
+		return
+	}
+
+	batch, err := createPayoutBatch(db, models.PayoutBatchScheduled, 0, due, nil)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error creating scheduled payout batch: %v", err.Error()))
+		completePayoutRun(extReq, db, &run, err)
+		return
+	}
+	run.BatchID = int64(batch.ID)
+
+	batch, err = ProcessPayoutBatch(extReq, db, batch)
+	if err != nil {
+		completePayoutRun(extReq, db, &run, err)
+		return
+	}
+
+	line := models.PayoutBatchLine{BatchID: int64(batch.ID)}
+	lines, _, err := line.GetPayoutBatchLines(db.MOR, nil)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting lines of payout batch %v: %v", batch.ID, err.Error()))
+		completePayoutRun(extReq, db, &run, err)
+		return
+	}
+
+	for _, l := range lines {
+		if l.Status != models.PayoutBatchLineSuccess {
+			delete(dueSettings, l.MerchantID)
+		}
+	}
+
+	for _, s := range dueSettings {
+		s.LastPayoutAt = now
+		err := s.UpdateLastPayoutAt(db.MOR)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error updating last payout time for merchant %v: %v", s.AccountID, err.Error()))
+		}
+	}
+
+	completePayoutRun(extReq, db, &run, nil)
+}
+
+func GetPayoutRunsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, status string) ([]models.PayoutRun, postgresql.PaginationResponse, int, error) {
+	var (
+		run = models.PayoutRun{Status: models.PayoutRunStatus(status)}
+	)
+
+	runs, pagination, err := run.GetPayoutRuns(db.MOR, paginator)
+	if err != nil {
+		return runs, pagination, http.StatusInternalServerError, err
+	}
+
+	return runs, pagination, http.StatusOK, nil
+}
+
+// completePayoutRun closes the run log, a run that stopped on err is failed
+func completePayoutRun(extReq request.ExternalRequest, db postgresql.Databases, run *models.PayoutRun, err error) {
+	run.Status = models.PayoutRunCompleted
+	if err != nil {
+		run.Status = models.PayoutRunFailed
+		run.Error = err.Error()
+	}
+	run.CompletedAt = time.Now()
+
+	err = run.UpdateAllFields(db.MOR)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error updating payout run %v: %v", run.ID, err.Error()))
+	}
+}
+
+func UpdatePayoutScheduleService(extReq request.ExternalRequest, db postgresql.Databases, user external_models.User, req models.UpdatePayoutScheduleRequest) (models.Setting, int, error) {
+	var (
+		setting = models.Setting{AccountID: int64(user.AccountID)}
+	)
+
+	code, err := setting.GetSettingByAccountID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return models.Setting{}, code, err
+		}
+
+		err := setting.CreateSetting(db.MOR)
+		if err != nil {
+			return models.Setting{}, http.StatusInternalServerError, err
+		}
+	}
+
+	setting.PayoutSchedule = req.Schedule
+	setting.PayoutThreshold = 0
+	if req.Schedule == models.PayoutScheduleThreshold {
+		setting.PayoutThreshold = req.Threshold
+	}
+
+	// only the schedule is written, reserve, hold and settlement currency may be saved by other requests meanwhile
+	err = setting.UpdatePayoutSchedule(db.MOR)
+	if err != nil {
+		return setting, http.StatusInternalServerError, err
+	}
+
+	code, err = setting.GetSettingByAccountID(db.MOR)
+	if err != nil {
+		return setting, code, err
+	}
+
+	return setting, http.StatusOK, nil
+}
+
+// isPayoutDue reports whether the merchant isn't on hold, has something old enough to pay out and either hasn't had
+// a scheduled payout this day, week or month, or would be paid at least their threshold in some currency.
+// The threshold is compared with what the payout pays, after fees, tax, the rolling reserve and refund recoveries.
+func isPayoutDue(db postgresql.Databases, setting models.Setting, now time.Time) (bool, error) {
+	var (
+		transaction               = models.Transaction{MerchantID: setting.AccountID}
+		currenciesTransactionsMap = map[int64][]models.Transaction{}
+	)
+
+	if setting.PayoutHold {
+		return false, nil
+	}
+
+	transactions, err := transaction.GetPayableTransactions(db.MOR, setting.GetPayoutMaturity(now))
+	if err != nil {
+		return false, err
+	}
+
+	if len(transactions) == 0 {
+		return false, nil
+	}
+
+	if setting.PayoutSchedule != models.PayoutScheduleThreshold {
+		return setting.LastPayoutAt.Before(getPayoutPeriodStart(setting.PayoutSchedule, now)), nil
+	}
+
+	for _, trx := range transactions {
+		currenciesTransactionsMap[trx.CountryID] = append(currenciesTransactionsMap[trx.CountryID], trx)
+	}
+
+	for countryID, trxs := range currenciesTransactionsMap {
+		payout, _, _, err := getPendingPayout(db.MOR, setting, countryID, trxs)
+		if err != nil {
+			return false, err
+		}
+		if payout.Amount > 0 && payout.Amount >= setting.PayoutThreshold {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getPayoutPeriodStart returns the start of the day, week (from monday) or month that now falls in
+func getPayoutPeriodStart(schedule models.PayoutSchedule, now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch schedule {
+	case models.PayoutScheduleWeekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case models.PayoutScheduleMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	default:
+		return day
+	}
+}
@@ -1,110 +1,557 @@
 package mor
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/vesicash/mor-api/external/request"
 	"github.com/vesicash/mor-api/internal/models"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
 	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
 )
 
-func PayOutToWalletsService(extReq request.ExternalRequest, db postgresql.Databases, req models.PayoutToWalletRequest) (string, int, error) {
-	if len(req.Merchants) > 0 && len(req.Merchants) < 10 {
-		code, err := PayoutToUsers(extReq, db, req.Merchants)
+const (
+	// payoutMaxAttempts is how many times a wallet credit is tried before the payout is failed for review
+	payoutMaxAttempts = 5
+	// payoutResumeAfter is how long a payout sits untouched before the recovery job resumes it
+	payoutResumeAfter = 5 * time.Minute
+	// payoutSyncLimit is the most merchants paid out within the request, larger batches run in the background
+	payoutSyncLimit = 10
+)
+
+// PayOutToWalletsService starts a payout batch, small batches are paid out within the request and larger ones in the background.
+// A payout above its approval policy's threshold is queued for approval by other admins instead, for the merchants and payable transactions resolved now.
+func PayOutToWalletsService(extReq request.ExternalRequest, db postgresql.Databases, req models.PayoutToWalletRequest, adminID int) (models.PayoutBatch, string, int, error) {
+	var (
+		merchants   = req.Merchants
+		transaction = models.Transaction{}
+	)
+
+	if req.All {
+		accountIDs, err := transaction.GetMerchantIDsWithPayableTransactions(db.MOR)
 		if err != nil {
-			return "", code, err
+			return models.PayoutBatch{}, "", http.StatusInternalServerError, err
 		}
+		merchants = accountIDs
+	}
+
+	if len(merchants) == 0 {
+		return models.PayoutBatch{}, "no merchants to pay out", http.StatusOK, nil
+	}
+
+	approvalPayload, err := getPayoutApprovalPayload(db, merchants)
+	if err != nil {
+		return models.PayoutBatch{}, "", http.StatusInternalServerError, err
+	}
+
+	required, amount, currency, err := getPayoutApprovals(extReq, db, approvalPayload.Totals)
+	if err != nil {
+		return models.PayoutBatch{}, "", http.StatusInternalServerError, err
+	}
+
+	payload, err := json.Marshal(approvalPayload)
+	if err != nil {
+		return models.PayoutBatch{}, "", http.StatusInternalServerError, err
+	}
 
-		return "payout successful", http.StatusOK, nil
-	} else if len(req.Merchants) > 10 {
-		go PayoutToUsers(extReq, db, req.Merchants)
-		return "payout started", http.StatusOK, nil
+	code, err := requireApproval(db, models.ApprovalRequest{
+		Action:            models.ApprovalPayoutToWallet,
+		Payload:           string(payload),
+		Amount:            amount,
+		Currency:          currency,
+		RequiredApprovals: required,
+		RequestedBy:       int64(adminID),
+	})
+	if err != nil {
+		return models.PayoutBatch{}, "", code, err
 	}
-	return "", http.StatusOK, nil
+
+	return payOutToWallets(extReq, db, merchants, nil)
 }
 
-func PayoutToUsers(extReq request.ExternalRequest, db postgresql.Databases, accountIDs []int) (int, error) {
-	for _, accountID := range accountIDs {
-		code, err := PayoutToUser(extReq, db, accountID)
-		if err != nil {
-			return code, err
+// payOutToWallets pays the merchants out in a new manual payout batch, only their pinned transactions when pinned is set
+func payOutToWallets(extReq request.ExternalRequest, db postgresql.Databases, merchants []int, pinned map[int][]uint) (models.PayoutBatch, string, int, error) {
+	if len(merchants) == 0 {
+		return models.PayoutBatch{}, "no merchants to pay out", http.StatusOK, nil
+	}
+
+	batch, err := createPayoutBatch(db, models.PayoutBatchManual, 0, merchants, pinned)
+	if err != nil {
+		return batch, "", http.StatusInternalServerError, err
+	}
+
+	if len(merchants) > payoutSyncLimit {
+		go ProcessPayoutBatch(extReq, db, batch)
+		return batch, "payout started", http.StatusOK, nil
+	}
+
+	batch, err = ProcessPayoutBatch(extReq, db, batch)
+	if err != nil {
+		return batch, "", http.StatusInternalServerError, err
+	}
+
+	if batch.Failed > 0 {
+		line := models.PayoutBatchLine{BatchID: int64(batch.ID), Status: models.PayoutBatchLineFailed}
+		lines, _, err := line.GetPayoutBatchLines(db.MOR, nil)
+		if err == nil && len(lines) > 0 {
+			return batch, "", lines[0].Code, fmt.Errorf(lines[0].Reason)
 		}
 	}
-	return http.StatusOK, nil
+
+	return batch, "payout successful", http.StatusOK, nil
+}
+
+// payoutHoldError is returned for a merchant whose payouts an admin put on hold
+type payoutHoldError struct {
+	reason string
+}
+
+func (e payoutHoldError) Error() string {
+	return fmt.Sprintf("payouts are on hold: %v", e.reason)
 }
 
-func PayoutToUser(extReq request.ExternalRequest, db postgresql.Databases, accountID int) (int, error) {
+// PayoutToUser pays the merchant's unpaid transactions into their MOR_ wallets, one payout per currency,
+// converted into the merchant's settlement currency when they chose one.
+// Transactions are reserved for a pending payout first, the wallet is credited with the payout reference
+// so a retry can't credit twice, and the transactions are marked as paid out once the credit went through.
+// A merchant on hold is not paid out, transactions younger than their payout delay wait for a later payout
+// and the rolling reserve is held back from each payout until the reserve-release cronjob pays it out.
+func PayoutToUser(extReq request.ExternalRequest, db postgresql.Databases, accountID int) ([]models.Payout, int, error) {
+	return payoutToUser(extReq, db, accountID, nil)
+}
+
+// payoutToUser is PayoutToUser paying only the transactions in transactionIDs that are still payable when it is set
+func payoutToUser(extReq request.ExternalRequest, db postgresql.Databases, accountID int, transactionIDs []uint) ([]models.Payout, int, error) {
 	var (
-		IsPaidOut                 = false
-		currenciesTransactionsMap = map[int][]models.Transaction{}
+		setting = models.Setting{AccountID: int64(accountID)}
 	)
 
 	_, err := services.GetUserWithAccountID(extReq, accountID)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return []models.Payout{}, http.StatusBadRequest, err
+	}
+
+	code, err := setting.GetSettingByAccountID(db.MOR)
+	if err != nil && code == http.StatusInternalServerError {
+		return []models.Payout{}, code, err
+	}
+
+	if setting.PayoutHold {
+		return []models.Payout{}, http.StatusBadRequest, payoutHoldError{reason: setting.PayoutHoldReason}
 	}
 
-	transaction := models.Transaction{MerchantID: int64(accountID), IsPaidOut: IsPaidOut, Status: models.TransactionSuccessful}
-	transactions, err := transaction.GetTransactionsAll(db.MOR, &IsPaidOut)
+	payouts, err := createPendingPayouts(db, setting, transactionIDs)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return payouts, http.StatusInternalServerError, err
 	}
 
-	for _, tx := range transactions {
-		txs := currenciesTransactionsMap[int(tx.CountryID)]
-		txs = append(txs, tx)
-		currenciesTransactionsMap[int(tx.CountryID)] = txs
+	for i := range payouts {
+		err := processPayout(extReq, db, &payouts[i])
+		if err != nil {
+			return payouts, http.StatusInternalServerError, err
+		}
 	}
 
-	for countryID, trxs := range currenciesTransactionsMap {
-		country, err := services.GetCountryByID(extReq, extReq.Logger, countryID)
+	return payouts, http.StatusOK, nil
+}
+
+// ResumePayouts is the payout recovery cronjob, it carries on with payouts stuck before settlement
+func ResumePayouts(extReq request.ExternalRequest, db postgresql.Databases) {
+	var (
+		payout      = models.Payout{}
+		staleBefore = time.Now().Add(-payoutResumeAfter)
+	)
+
+	payouts, err := payout.GetPayoutsToResume(db.MOR, staleBefore)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting payouts to resume: %v", err.Error()))
+		return
+	}
+
+	for _, p := range payouts {
+		claimed, err := p.ClaimPayout(db.MOR, staleBefore)
 		if err != nil {
-			extReq.Logger.Error(fmt.Sprintf("error getting country with id %v: %v", countryID, err.Error()))
+			extReq.Logger.Error(err.Error())
 			continue
 		}
+		if !claimed {
+			continue
+		}
+
+		err = processPayout(extReq, db, &p)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error resuming payout %v: %v", p.ID, err.Error()))
+		}
+	}
+}
+
+// ResumePayoutService carries on with a payout that hasn't settled, a failed payout is retried with the same reference.
+// A payout above the payout_to_wallet policy's threshold is queued for approval by other admins instead.
+func ResumePayoutService(extReq request.ExternalRequest, db postgresql.Databases, payoutID int, adminID int) (models.Payout, int, error) {
+	payout, code, err := getResumablePayout(db, payoutID)
+	if err != nil {
+		return payout, code, err
+	}
+
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(payout.CountryID))
+	if err != nil {
+		return payout, http.StatusInternalServerError, fmt.Errorf("error getting country with id %v: %v", payout.CountryID, err.Error())
+	}
+	currency := strings.ToUpper(country.CurrencyCode)
+
+	required, err := getRequiredApprovals(db, models.ApprovalPayoutToWallet, payout.Amount, currency)
+	if err != nil {
+		return payout, http.StatusInternalServerError, err
+	}
+
+	code, err = requireApproval(db, models.ApprovalRequest{
+		Action:            models.ApprovalPayoutResume,
+		ResourceID:        int64(payout.ID),
+		Amount:            payout.Amount,
+		Currency:          currency,
+		RequiredApprovals: required,
+		RequestedBy:       int64(adminID),
+	})
+	if err != nil {
+		return payout, code, err
+	}
+
+	code, err = resumePayout(extReq, db, &payout)
+	if err != nil {
+		return payout, code, err
+	}
+
+	return payout, http.StatusOK, nil
+}
+
+// getResumablePayout returns the payout when it can be resumed, a failed payout is set back to pending with its attempts reset
+func getResumablePayout(db postgresql.Databases, payoutID int) (models.Payout, int, error) {
+	var (
+		payout = models.Payout{ID: uint(payoutID)}
+	)
+
+	code, err := payout.GetPayoutByID(db.MOR)
+	if err != nil {
+		return payout, code, err
+	}
+
+	switch payout.Status {
+	case models.PayoutPending, models.PayoutWalletCredited:
+	case models.PayoutFailed:
+		transaction := models.Transaction{PayoutID: int64(payout.ID)}
+		reserved, err := transaction.CountTransactionsByPayoutID(db.MOR)
+		if err != nil {
+			return payout, http.StatusInternalServerError, err
+		}
+		if reserved == 0 && payout.ReserveID == 0 {
+			return payout, http.StatusBadRequest, fmt.Errorf("payout has no reserved transactions")
+		}
+		payout.Status = models.PayoutPending
+		payout.Attempts = 0
+	default:
+		return payout, http.StatusBadRequest, fmt.Errorf("payout is already %v", payout.Status)
+	}
+
+	return payout, http.StatusOK, nil
+}
+
+func resumePayout(extReq request.ExternalRequest, db postgresql.Databases, payout *models.Payout) (int, error) {
+	err := processPayout(extReq, db, payout)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// createPendingPayouts reserves the merchant's payable transactions, grouped by country, for new pending payouts, only the ones in transactionIDs when it is set.
+// Each payout is the transactions' gross less their processing fees and tax, with the rolling reserve held back from that net.
+func createPendingPayouts(db postgresql.Databases, setting models.Setting, transactionIDs []uint) ([]models.Payout, error) {
+	var (
+		accountID = setting.AccountID
+		now       = time.Now()
+		payouts   = []models.Payout{}
+	)
+
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		var (
+			transaction               = models.Transaction{MerchantID: accountID}
+			currenciesTransactionsMap = map[int64][]models.Transaction{}
+			countryIDs                = []int64{}
+		)
+
+		transactions, err := transaction.GetPayableTransactions(tx, setting.GetPayoutMaturity(now))
+		if err != nil {
+			return err
+		}
 
-		var totalAmount float64
+		pinned := map[uint]bool{}
+		for _, id := range transactionIDs {
+			pinned[id] = true
+		}
 
-		for _, trx := range trxs {
-			totalAmount += trx.Amount
+		for _, trx := range transactions {
+			if len(pinned) > 0 && !pinned[trx.ID] {
+				continue
+			}
+			if _, ok := currenciesTransactionsMap[trx.CountryID]; !ok {
+				countryIDs = append(countryIDs, trx.CountryID)
+			}
+			currenciesTransactionsMap[trx.CountryID] = append(currenciesTransactionsMap[trx.CountryID], trx)
 		}
 
-		payout := models.Payout{
-			MerchantID: int64(accountID),
-			Reference:  utility.RandomString(25),
-			Amount:     totalAmount,
-			CountryID:  int64(countryID),
-			Status:     models.TransactionSuccessful,
+		for _, countryID := range countryIDs {
+			var (
+				trxs = currenciesTransactionsMap[countryID]
+				ids  = []uint{}
+			)
+
+			for _, trx := range trxs {
+				ids = append(ids, trx.ID)
+			}
+
+			payout, refunds, recoveries, err := getPendingPayout(tx, setting, countryID, trxs)
+			if err != nil {
+				return err
+			}
+
+			err = payout.CreatePayout(tx)
+			if err != nil {
+				return err
+			}
+
+			for i, r := range refunds[:len(recoveries)] {
+				recovered, err := r.RecoverFromPayout(tx, payout.ID, recoveries[i])
+				if err != nil {
+					return err
+				}
+				if !recovered {
+					return fmt.Errorf("refund %v was recovered by another payout, try again", r.Reference)
+				}
+			}
+
+			reserved, err := transaction.ReserveTransactionsForPayout(tx, ids, payout.ID)
+			if err != nil {
+				return err
+			}
+			if reserved != int64(len(ids)) {
+				return fmt.Errorf("transactions for payout %v were reserved by another payout, try again", payout.Reference)
+			}
+
+			if payout.ReserveAmount > 0 {
+				reserve := models.PayoutReserve{
+					MerchantID: accountID,
+					PayoutID:   int64(payout.ID),
+					CountryID:  countryID,
+					Amount:     payout.ReserveAmount,
+					Status:     models.PayoutReserveHeld,
+					ReleaseAt:  now.AddDate(0, 0, setting.ReserveDays),
+				}
+				err = reserve.CreatePayoutReserve(tx)
+				if err != nil {
+					return err
+				}
+			}
+
+			payouts = append(payouts, payout)
 		}
 
-		_, err = services.CreditWallet(extReq, db, totalAmount, country.CurrencyCode, accountID, false, "no", "yes", "")
+		return nil
+	})
+
+	return payouts, err
+}
+
+// getPendingPayout works out the payout of the merchant's payable transactions in a country: their gross less processing fees and tax,
+// less the rolling reserve and what the refunds waiting for recovery take. It returns those refunds and the amount recovered of each it reached.
+func getPendingPayout(tx *gorm.DB, setting models.Setting, countryID int64, transactions []models.Transaction) (models.Payout, []models.Refund, []float64, error) {
+	var (
+		reserveAmount float64
+	)
+
+	gross, processingFee, taxFee, net := getPayoutBreakdown(transactions)
+	if net < 0 {
+		return models.Payout{}, nil, nil, fmt.Errorf("processing fees and tax of %v exceed the %v paid in country %v", roundAmount(processingFee+taxFee), gross, countryID)
+	}
+
+	if setting.ReserveRate > 0 {
+		reserveAmount = roundAmount(net * setting.ReserveRate / 100)
+	}
+
+	refund := models.Refund{MerchantID: setting.AccountID, CountryID: countryID}
+	refunds, err := refund.GetPendingPayoutRecoveries(tx)
+	if err != nil {
+		return models.Payout{}, nil, nil, err
+	}
+	recoveries, refundDeduction := getPayoutRefundRecoveries(refunds, roundAmount(net-reserveAmount))
+
+	payout := models.Payout{
+		MerchantID:         setting.AccountID,
+		Reference:          utility.RandomString(25),
+		GrossAmount:        gross,
+		ProcessingFee:      processingFee,
+		TaxFee:             taxFee,
+		NetAmount:          net,
+		Amount:             roundAmount(net - reserveAmount - refundDeduction),
+		ReserveAmount:      reserveAmount,
+		RefundDeduction:    refundDeduction,
+		CountryID:          countryID,
+		Status:             models.PayoutPending,
+		SettlementCurrency: setting.SettlementCurrency,
+		FxFeeRate:          setting.FxFeeRate,
+	}
+
+	return payout, refunds, recoveries, nil
+}
+
+// getPayoutBreakdown sums the transactions' amounts, processing fees and tax, net is what is owed to the merchant
+func getPayoutBreakdown(transactions []models.Transaction) (float64, float64, float64, float64) {
+	var (
+		gross         float64
+		processingFee float64
+		taxFee        float64
+	)
+
+	for _, trx := range transactions {
+		gross += trx.Amount
+		processingFee += trx.ProcessingFee
+		taxFee += trx.TaxFee
+	}
+
+	gross, processingFee, taxFee = roundAmount(gross), roundAmount(processingFee), roundAmount(taxFee)
+	return gross, processingFee, taxFee, roundAmount(gross - processingFee - taxFee)
+}
+
+// getPayoutRefundRecoveries shares what the payout can spare between the refunds waiting for recovery, oldest first.
+// It returns the amount recovered of each refund it reached and their total.
+func getPayoutRefundRecoveries(refunds []models.Refund, available float64) ([]float64, float64) {
+	var (
+		recoveries = []float64{}
+		total      float64
+	)
+
+	for _, r := range refunds {
+		if available <= 0 {
+			break
+		}
+
+		amount := math.Min(roundAmount(r.RecoveryAmount-r.RecoveredAmount), available)
+		recoveries = append(recoveries, amount)
+		total += amount
+		available = roundAmount(available - amount)
+	}
+
+	return recoveries, roundAmount(total)
+}
+
+// processPayout moves a payout as far through pending, wallet_credited and settled as it can.
+// Wallet errors are kept on the payout for the next attempt, only local errors are returned.
+func processPayout(extReq request.ExternalRequest, db postgresql.Databases, payout *models.Payout) error {
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(payout.CountryID))
+	if err != nil {
+		payout.LastError = fmt.Sprintf("error getting country with id %v: %v", payout.CountryID, err.Error())
+		extReq.Logger.Error(fmt.Sprintf("payout %v: %v", payout.Reference, payout.LastError))
+		return payout.UpdateAllFields(db.MOR)
+	}
+
+	if payout.Status == models.PayoutPending {
+		err = lockPayoutRate(extReq, db, payout, country.CurrencyCode)
+		if err != nil {
+			payout.LastError = err.Error()
+			extReq.Logger.Error(fmt.Sprintf("payout %v: %v", payout.Reference, payout.LastError))
+			return payout.UpdateAllFields(db.MOR)
+		}
+
+		walletCurrency, walletAmount := country.CurrencyCode, payout.Amount
+		if payout.SettlementCurrency != "" {
+			walletCurrency, walletAmount = payout.SettlementCurrency, payout.SettlementAmount
+		}
+
+		payout.Attempts++
+		// a payout fully held back as reserve or recovering refunds has nothing to credit
+		if walletAmount > 0 {
+			_, err = services.CreditWallet(extReq, db, walletAmount, walletCurrency, int(payout.MerchantID), false, "no", "yes", payout.Reference)
+			// the credit is keyed on the payout reference, so a retry after a failed exchange record doesn't credit twice
+			if err == nil && payout.SettlementCurrency != "" {
+				err = recordPayoutExchange(extReq, payout)
+			}
+		}
 		if err != nil {
-			payout.Status = models.TransactionFailed
-			extReq.Logger.Error(fmt.Sprintf("error crediting mor wallet %v, amount %v: %v", country.CurrencyCode, totalAmount, err.Error()))
+			payout.LastError = err.Error()
+			if payout.Attempts >= payoutMaxAttempts {
+				payout.Status = models.PayoutFailed
+			}
+			extReq.Logger.Error(fmt.Sprintf("error crediting mor wallet %v, amount %v, payout %v: %v", walletCurrency, walletAmount, payout.Reference, err.Error()))
+			return payout.UpdateAllFields(db.MOR)
 		}
 
-		err = payout.CreatePayout(db.MOR)
+		payout.Status = models.PayoutWalletCredited
+		payout.LastError = ""
+		err = payout.UpdateAllFields(db.MOR)
 		if err != nil {
-			return http.StatusInternalServerError, err
+			return err
 		}
+	}
 
-		if payout.Status != models.TransactionSuccessful {
-			continue
+	if payout.Status != models.PayoutWalletCredited {
+		return nil
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		transaction := models.Transaction{}
+		err := transaction.SettlePayoutTransactions(tx, payout.ID)
+		if err != nil {
+			return err
 		}
 
-		for _, trx := range trxs {
-			trx.IsPaidOut = true
-			trx.PayoutID = int64(payout.ID)
-			err := trx.UpdateAllFields(db.MOR)
-			if err != nil {
-				return http.StatusInternalServerError, err
+		if payout.ReserveID != 0 {
+			err = ledger.RecordReserveRelease(postgresql.Databases{MOR: tx}, *payout, country.CurrencyCode)
+		} else {
+			err = ledger.RecordPayout(postgresql.Databases{MOR: tx}, *payout, country.CurrencyCode)
+			if err == nil {
+				err = ledger.RecordPayoutDeductions(postgresql.Databases{MOR: tx}, *payout, country.CurrencyCode)
+			}
+			if err == nil && payout.ReserveAmount > 0 {
+				err = ledger.RecordReserveHold(postgresql.Databases{MOR: tx}, *payout, country.CurrencyCode)
 			}
 		}
+		if err == nil && payout.SettlementCurrency != "" {
+			err = ledger.RecordPayoutConversion(postgresql.Databases{MOR: tx}, *payout, country.CurrencyCode)
+		}
+		if err != nil {
+			return err
+		}
 
+		payout.Status = models.PayoutSettled
+		return payout.UpdateAllFields(tx)
+	})
+	if err != nil {
+		return err
 	}
 
-	return http.StatusOK, nil
+	payout.Currency = country.CurrencyCode
+	NotifyMerchantWebhookEvent(extReq, db, payout.MerchantID, models.MerchantWebhookPayoutCompleted, *payout)
+
+	return nil
+}
+
+// recordPayoutExchange records the conversion of a payout as an exchange transaction and keeps the reference the exchange was recorded with,
+// a payout whose exchange wasn't recorded isn't credited so the attempt is retried
+func recordPayoutExchange(extReq request.ExternalRequest, payout *models.Payout) error {
+	exchange, err := services.CreateExchangeTransaction(extReq, int(payout.MerchantID), int(payout.FxRateID), payout.Amount, payout.SettlementAmount, services.ExchangeTransactionCompleted, payout.Reference)
+	if err != nil {
+		return err
+	}
+	if exchange.ID == 0 || exchange.Reference == "" {
+		return fmt.Errorf("exchange transaction of payout %v was not recorded", payout.Reference)
+	}
+
+	payout.ExchangeReference = exchange.Reference
+	return nil
 }
@@ -0,0 +1,306 @@
+package mor
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+)
+
+// reconciliationSlackLimit caps how many discrepancies are listed in one slack alert
+const reconciliationSlackLimit = 20
+
+type reconciliationKey struct {
+	merchantID int64
+	currency   string
+}
+
+// ReconcileWallets is the reconciliation cronjob, it checks every merchant's MOR_ wallets
+func ReconcileWallets(extReq request.ExternalRequest, db postgresql.Databases) {
+	_, _, err := RunReconciliationService(extReq, db, models.RunReconciliationRequest{})
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("wallet reconciliation failed: %v", err.Error()))
+	}
+}
+
+// RunReconciliationService compares local payout and withdrawal totals with the remote MOR_ wallet balances
+// and stores a report for every wallet that doesn't match
+func RunReconciliationService(extReq request.ExternalRequest, db postgresql.Databases, req models.RunReconciliationRequest) (models.ReconciliationRun, int, error) {
+	var (
+		run = models.ReconciliationRun{MerchantID: req.AccountID, Status: models.ReconciliationRunning}
+	)
+
+	err := run.CreateReconciliationRun(db.MOR)
+	if err != nil {
+		return run, http.StatusInternalServerError, err
+	}
+
+	reports, walletsChecked, err := reconcileWallets(extReq, db, req.AccountID)
+	run.CompletedAt = time.Now()
+	if err != nil {
+		run.Status = models.ReconciliationFailed
+		run.Error = err.Error()
+		if updateErr := run.UpdateAllFields(db.MOR); updateErr != nil {
+			extReq.Logger.Error(fmt.Sprintf("error updating reconciliation run %v: %v", run.ID, updateErr.Error()))
+		}
+		return run, http.StatusInternalServerError, err
+	}
+
+	for i := range reports {
+		reports[i].RunID = int64(run.ID)
+		err := reports[i].CreateReconciliationReport(db.MOR)
+		if err != nil {
+			return run, http.StatusInternalServerError, err
+		}
+	}
+
+	run.Status = models.ReconciliationCompleted
+	run.WalletsChecked = walletsChecked
+	run.DiscrepancyCount = len(reports)
+	err = run.UpdateAllFields(db.MOR)
+	if err != nil {
+		return run, http.StatusInternalServerError, err
+	}
+
+	if len(reports) > 0 {
+		notifyReconciliationDiscrepancies(extReq, run, reports)
+	}
+
+	return run, http.StatusOK, nil
+}
+
+func GetReconciliationRunsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination) ([]models.ReconciliationRun, postgresql.PaginationResponse, int, error) {
+	var (
+		run = models.ReconciliationRun{}
+	)
+
+	runs, pagination, err := run.GetReconciliationRuns(db.MOR, paginator)
+	if err != nil {
+		return runs, pagination, http.StatusInternalServerError, err
+	}
+
+	return runs, pagination, http.StatusOK, nil
+}
+
+func GetReconciliationReportsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetReconciliationReportsRequest) ([]models.ReconciliationReport, postgresql.PaginationResponse, int, error) {
+	var (
+		report = models.ReconciliationReport{
+			RunID:      int64(req.RunID),
+			MerchantID: int64(req.AccountID),
+			Currency:   strings.ToUpper(req.Currency),
+			Status:     models.ReconciliationReportStatus(req.Status),
+		}
+	)
+
+	reports, pagination, err := report.GetReconciliationReports(db.MOR, paginator)
+	if err != nil {
+		return reports, pagination, http.StatusInternalServerError, err
+	}
+
+	return reports, pagination, http.StatusOK, nil
+}
+
+func ResolveReconciliationReportService(extReq request.ExternalRequest, db postgresql.Databases, id int, req models.ResolveReconciliationReportRequest) (models.ReconciliationReport, int, error) {
+	var (
+		report = models.ReconciliationReport{ID: uint(id)}
+	)
+
+	code, err := report.GetReconciliationReportByID(db.MOR)
+	if err != nil {
+		return report, code, err
+	}
+
+	if report.Status == models.ReconciliationReportResolved {
+		return report, http.StatusBadRequest, fmt.Errorf("reconciliation report already resolved")
+	}
+
+	report.Status = models.ReconciliationReportResolved
+	report.ResolutionNote = req.Note
+	report.ResolvedAt = time.Now()
+	err = report.UpdateAllFields(db.MOR)
+	if err != nil {
+		return report, http.StatusInternalServerError, err
+	}
+
+	return report, http.StatusOK, nil
+}
+
+// reconcileWallets returns the discrepancies found and the number of wallets checked.
+// Transactions marked as paid out must add up to the payouts with their reserves, and the MOR_ wallet must hold
+// the payouts, reserve releases included, less the withdrawals, refunds and dispute holds taken from it.
+// A payout converted into a settlement currency counts towards the gross of its own currency and the wallet of the settlement currency.
+func reconcileWallets(extReq request.ExternalRequest, db postgresql.Databases, merchantID int64) ([]models.ReconciliationReport, int, error) {
+	var (
+		transaction = models.Transaction{MerchantID: merchantID}
+		payout      = models.Payout{MerchantID: merchantID}
+		withdrawal  = models.Withdrawal{MerchantID: merchantID}
+		refund      = models.Refund{MerchantID: merchantID}
+		dispute     = models.Dispute{MerchantID: merchantID}
+		totals      = map[reconciliationKey]*models.ReconciliationReport{}
+		currencies  = map[int64]string{}
+		reports     = []models.ReconciliationReport{}
+	)
+
+	getCurrency := func(countryID int64) (string, error) {
+		if currency, ok := currencies[countryID]; ok {
+			return currency, nil
+		}
+		country, err := services.GetCountryByID(extReq, extReq.Logger, int(countryID))
+		if err != nil {
+			return "", fmt.Errorf("error getting country with id %v: %v", countryID, err.Error())
+		}
+		currencies[countryID] = strings.ToUpper(country.CurrencyCode)
+		return currencies[countryID], nil
+	}
+
+	getTotal := func(merchantID int64, currency string) *models.ReconciliationReport {
+		key := reconciliationKey{merchantID: merchantID, currency: currency}
+		if _, ok := totals[key]; !ok {
+			totals[key] = &models.ReconciliationReport{MerchantID: merchantID, Currency: currency, Status: models.ReconciliationReportOpen}
+		}
+		return totals[key]
+	}
+
+	transactionTotals, err := transaction.GetPaidOutTransactionTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, t := range transactionTotals {
+		currency, err := getCurrency(t.CountryID)
+		if err != nil {
+			return reports, 0, err
+		}
+		getTotal(t.MerchantID, currency).PaidOutTransactions += t.Amount
+	}
+
+	payoutTotals, err := payout.GetPayoutTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, p := range payoutTotals {
+		currency, err := getCurrency(p.CountryID)
+		if err != nil {
+			return reports, 0, err
+		}
+		total := getTotal(p.MerchantID, currency)
+		total.Payouts += p.Amount
+		total.PayoutsGross += p.Gross
+	}
+
+	convertedTotals, err := payout.GetConvertedPayoutTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, p := range convertedTotals {
+		getTotal(p.MerchantID, p.Currency).Payouts += p.Amount
+	}
+
+	withdrawalTotals, err := withdrawal.GetWithdrawalTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, w := range withdrawalTotals {
+		getTotal(w.MerchantID, w.Currency).Withdrawals += w.Amount
+	}
+
+	refundTotals, err := refund.GetWalletRecoveryTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, r := range refundTotals {
+		currency, err := getCurrency(r.CountryID)
+		if err != nil {
+			return reports, 0, err
+		}
+		getTotal(r.MerchantID, currency).RefundRecoveries += r.Amount
+	}
+
+	disputeTotals, err := dispute.GetWalletHoldTotals(db.MOR)
+	if err != nil {
+		return reports, 0, err
+	}
+
+	for _, d := range disputeTotals {
+		currency, err := getCurrency(d.CountryID)
+		if err != nil {
+			return reports, 0, err
+		}
+		getTotal(d.MerchantID, currency).DisputeHolds += d.Amount
+	}
+
+	keys := make([]reconciliationKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].merchantID != keys[j].merchantID {
+			return keys[i].merchantID < keys[j].merchantID
+		}
+		return keys[i].currency < keys[j].currency
+	})
+
+	for _, key := range keys {
+		report := totals[key]
+		report.ExpectedBalance = roundAmount(report.Payouts - report.Withdrawals - report.RefundRecoveries - report.DisputeHolds)
+
+		if roundAmount(report.PaidOutTransactions) != roundAmount(report.PayoutsGross) {
+			report.Issues = append(report.Issues, fmt.Sprintf("paid out transactions total %v but payouts before reserves total %v", roundAmount(report.PaidOutTransactions), roundAmount(report.PayoutsGross)))
+		}
+
+		morWallet := fmt.Sprintf("MOR_%v", report.Currency)
+		wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, int(report.MerchantID), morWallet)
+		if err != nil {
+			report.Issues = append(report.Issues, fmt.Sprintf("error getting %v wallet: %v", morWallet, err.Error()))
+		} else {
+			report.WalletBalance = roundAmount(wallet.Available)
+			report.Difference = roundAmount(report.WalletBalance - report.ExpectedBalance)
+			if report.Difference != 0 {
+				report.Issues = append(report.Issues, fmt.Sprintf("%v wallet holds %v but payouts less withdrawals, refunds and dispute holds is %v", morWallet, report.WalletBalance, report.ExpectedBalance))
+			}
+		}
+
+		if len(report.Issues) > 0 {
+			reports = append(reports, *report)
+		}
+	}
+
+	return reports, len(keys), nil
+}
+
+func notifyReconciliationDiscrepancies(extReq request.ExternalRequest, run models.ReconciliationRun, reports []models.ReconciliationReport) {
+	lines := []string{}
+	for i, r := range reports {
+		if i == reconciliationSlackLimit {
+			lines = append(lines, fmt.Sprintf("... and %v more", len(reports)-reconciliationSlackLimit))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("Merchant %v (%v): %v", r.MerchantID, r.Currency, strings.Join(r.Issues, "; ")))
+	}
+
+	err := SlackNotify(extReq, config.GetConfig().Slack.ReconciliationChannelID, `
+	MOR WALLET RECONCILIATION RUN `+fmt.Sprintf("%v", run.ID)+`
+	Wallets Checked: `+fmt.Sprintf("%v", run.WalletsChecked)+`
+	Discrepancies: `+fmt.Sprintf("%v", run.DiscrepancyCount)+`
+	`+strings.Join(lines, "\n\t")+`
+	`)
+	if err != nil && !extReq.Test {
+		extReq.Logger.Error("error sending notification to slack: ", err.Error())
+	}
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
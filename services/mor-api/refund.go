@@ -0,0 +1,74 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/refunds"
+)
+
+// CreateRefundService refunds a successful transaction in full, or partly when an amount is given.
+// merchantID restricts the refund to the merchant's own transactions, 0 allows any transaction.
+func CreateRefundService(extReq request.ExternalRequest, db postgresql.Databases, transactionID int, merchantID int, initiatedBy models.RefundInitiator, req models.CreateRefundRequest) (models.Refund, int, error) {
+	var (
+		transaction = models.Transaction{ID: uint(transactionID)}
+		refund      = models.Refund{}
+	)
+
+	code, err := transaction.GetTransactionByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return refund, code, err
+		}
+		return refund, code, fmt.Errorf("transaction with id %v not found", transactionID)
+	}
+
+	if merchantID != 0 && transaction.MerchantID != int64(merchantID) {
+		return refund, http.StatusBadRequest, fmt.Errorf("transaction with id %v not found", transactionID)
+	}
+
+	if transaction.Status != models.TransactionSuccessful {
+		return refund, http.StatusBadRequest, fmt.Errorf("transaction %v is %v, only successful transactions can be refunded", transaction.Reference, transaction.Status)
+	}
+
+	dispute := models.Dispute{TransactionID: int64(transaction.ID)}
+	disputes, err := dispute.CountOpenDisputesByTransactionID(db.MOR)
+	if err != nil {
+		return refund, http.StatusInternalServerError, err
+	}
+	if disputes > 0 {
+		return refund, http.StatusBadRequest, fmt.Errorf("transaction %v has an open dispute, it can't be refunded until the dispute is decided", transaction.Reference)
+	}
+
+	amount := roundAmount(req.Amount)
+	if amount == 0 {
+		amount = roundAmount(transaction.Amount - transaction.RefundedAmount)
+	}
+
+	refund, code, err = refunds.CreateRefund(extReq, db, transaction, models.Refund{Amount: amount, Reason: req.Reason, InitiatedBy: initiatedBy}, false)
+	if err != nil {
+		return refund, code, err
+	}
+
+	return refunds.SettleRefund(extReq, db, refund)
+}
+
+func GetRefundsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetRefundsRequest) ([]models.Refund, postgresql.PaginationResponse, int, error) {
+	var (
+		refund = models.Refund{
+			MerchantID:     int64(req.AccountID),
+			TransactionID:  int64(req.TransactionID),
+			RecoveryStatus: models.RefundRecoveryStatus(req.RecoveryStatus),
+		}
+	)
+
+	refunds, pagination, err := refund.GetRefunds(db.MOR, paginator)
+	if err != nil {
+		return refunds, pagination, http.StatusInternalServerError, err
+	}
+
+	return refunds, pagination, http.StatusOK, nil
+}
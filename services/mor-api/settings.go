@@ -244,6 +244,10 @@ func AddRemoveOrGetWalletsService(extReq request.ExternalRequest, db postgresql.
 	}
 
 	setting.CurrencyCodes = currencies
+	// payouts can't settle into a wallet that was removed
+	if !utility.InStringSlice(setting.SettlementCurrency, currencies) {
+		setting.SettlementCurrency = ""
+	}
 	err = setting.UpdateAllFields(db.MOR)
 	if err != nil {
 		return map[string]external_models.WalletBalance{}, http.StatusInternalServerError, err
@@ -324,7 +328,7 @@ func handleRemoveCurrencies(extReq request.ExternalRequest, db postgresql.Databa
 
 			convertedBalance := multiplier * initialBalance
 
-			err = services.CreateExchangeTransaction(extReq, accountID, int(rate.ID), initialBalance, convertedBalance, "completed")
+			_, err = services.CreateExchangeTransaction(extReq, accountID, int(rate.ID), initialBalance, convertedBalance, "completed", "")
 			if err != nil {
 				return availableCurrencies, err
 			}
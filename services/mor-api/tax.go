@@ -0,0 +1,77 @@
+package mor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/tax"
+)
+
+// CreateTaxRateService adds a rate to the country's rate table, it replaces the category's current rate from its effective date
+func CreateTaxRateService(extReq request.ExternalRequest, db postgresql.Databases, req models.CreateTaxRateRequest) (models.TaxRate, int, error) {
+	var (
+		rate = models.TaxRate{
+			CountryID:     int64(req.Country),
+			Category:      req.Category,
+			Name:          req.Name,
+			Rate:          req.Rate,
+			EffectiveFrom: time.Unix(int64(req.EffectiveFrom), 0),
+		}
+	)
+
+	_, err := services.GetCountryByID(extReq, extReq.Logger, req.Country)
+	if err != nil {
+		return rate, http.StatusBadRequest, err
+	}
+
+	err = rate.CreateTaxRate(db.MOR)
+	if err != nil {
+		return rate, http.StatusInternalServerError, err
+	}
+
+	return rate, http.StatusOK, nil
+}
+
+func GetTaxRatesService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetTaxRatesRequest) ([]models.TaxRate, postgresql.PaginationResponse, int, error) {
+	var (
+		rate = models.TaxRate{
+			CountryID: int64(req.Country),
+			Category:  models.TaxCategory(req.Category),
+		}
+	)
+
+	rates, pagination, err := rate.GetTaxRates(db.MOR, paginator)
+	if err != nil {
+		return rates, pagination, http.StatusInternalServerError, err
+	}
+
+	return rates, pagination, http.StatusOK, nil
+}
+
+// CalculateTaxService previews the tax on a sale without recording it, the time defaults to now
+func CalculateTaxService(extReq request.ExternalRequest, db postgresql.Databases, req models.CalculateTaxRequest) (models.TaxCalculation, int, error) {
+	at := time.Now()
+	if req.Time != 0 {
+		at = time.Unix(int64(req.Time), 0)
+	}
+
+	calculation, found, err := tax.CalculateTax(extReq, db, int64(req.Country), req.Category, req.Amount, req.CustomerTaxID, at)
+	if err != nil {
+		if errors.As(err, &tax.InvalidTaxIDError{}) {
+			return calculation, http.StatusBadRequest, err
+		}
+		return calculation, http.StatusInternalServerError, err
+	}
+
+	if !found {
+		return calculation, http.StatusBadRequest, fmt.Errorf("no %v tax rate for country %v", calculation.Category, req.Country)
+	}
+
+	return calculation, http.StatusOK, nil
+}
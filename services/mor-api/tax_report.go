@@ -0,0 +1,376 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+var (
+	taxReportHeader = []string{"country_id", "currency", "period", "status", "sales_count", "gross_sales", "taxable_amount", "exempt_amount",
+		"reverse_charge_amount", "tax_collected", "refund_count", "refunded_amount", "refunded_tax", "net_tax"}
+	taxReportLinesHeader = []string{"country_id", "currency", "period", "kind", "date", "transaction_id", "reference", "merchant_id", "amount",
+		"taxable_amount", "tax_fee", "tax_category", "tax_rate", "tax_rate_id", "reverse_charge", "customer_tax_id"}
+)
+
+// GetTaxReportsService returns the filing of every country with sales or refunds in the period, or of the requested country
+func GetTaxReportsService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetTaxReportRequest) ([]models.TaxFiling, int, error) {
+	filings, _, code, err := getTaxFilings(extReq, db, req)
+	if err != nil {
+		return filings, code, err
+	}
+
+	return filings, http.StatusOK, nil
+}
+
+// GetTaxReportLinesService returns the audit trail of the period, one line per sale and per refund
+func GetTaxReportLinesService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetTaxReportRequest) ([]models.TaxFilingLine, int, error) {
+	_, lines, code, err := getTaxFilings(extReq, db, req)
+	if err != nil {
+		return lines, code, err
+	}
+
+	return lines, http.StatusOK, nil
+}
+
+// ExportTaxReportService returns the report, or its audit trail when req.Lines is set, as a csv or xlsx file with its content type and name
+func ExportTaxReportService(extReq request.ExternalRequest, db postgresql.Databases, req models.GetTaxReportRequest) ([]byte, string, string, int, error) {
+	var (
+		header     = taxReportHeader
+		rows       = [][]string{}
+		currencies = map[int64]string{}
+		name       = "tax-report-" + req.Period
+	)
+
+	filings, lines, code, err := getTaxFilings(extReq, db, req)
+	if err != nil {
+		return nil, "", "", code, err
+	}
+
+	for _, f := range filings {
+		currencies[f.CountryID] = f.Currency
+		rows = append(rows, []string{
+			strconv.Itoa(int(f.CountryID)), f.Currency, f.Period, string(f.Status), strconv.Itoa(f.SalesCount), formatReportAmount(f.GrossSales),
+			formatReportAmount(f.TaxableAmount), formatReportAmount(f.ExemptAmount), formatReportAmount(f.ReverseChargeAmount), formatReportAmount(f.TaxCollected),
+			strconv.Itoa(f.RefundCount), formatReportAmount(f.RefundedAmount), formatReportAmount(f.RefundedTax), formatReportAmount(f.NetTax),
+		})
+	}
+
+	if req.Lines {
+		header = taxReportLinesHeader
+		name = "tax-report-lines-" + req.Period
+		rows = [][]string{}
+		for _, l := range lines {
+			rows = append(rows, []string{
+				strconv.Itoa(int(l.CountryID)), currencies[l.CountryID], req.Period, string(l.Kind), l.Date.UTC().Format(time.RFC3339), strconv.Itoa(int(l.TransactionID)),
+				l.Reference, strconv.Itoa(int(l.MerchantID)), formatReportAmount(l.Amount), formatReportAmount(l.TaxableAmount), formatReportAmount(l.TaxFee),
+				string(l.TaxCategory), formatReportAmount(l.TaxRate), strconv.Itoa(int(l.TaxRateID)), strconv.FormatBool(l.ReverseCharge), l.CustomerTaxID,
+			})
+		}
+	}
+
+	if req.Format == "xlsx" {
+		data, err := utility.WriteXLSX("tax report", header, rows)
+		if err != nil {
+			return nil, "", "", http.StatusInternalServerError, err
+		}
+		return data, utility.XLSXContentType, name + ".xlsx", http.StatusOK, nil
+	}
+
+	data, err := utility.WriteCSV(header, rows)
+	if err != nil {
+		return nil, "", "", http.StatusInternalServerError, err
+	}
+	return data, utility.CSVContentType, name + ".csv", http.StatusOK, nil
+}
+
+// CloseTaxFilingService stores the country's filing for a period that has ended along with its audit trail,
+// reports read the stored filing from then on, refunds made later are reported in the period they happen in
+func CloseTaxFilingService(extReq request.ExternalRequest, db postgresql.Databases, req models.CloseTaxFilingRequest) (models.TaxFiling, int, error) {
+	req.Period = strings.ToUpper(strings.TrimSpace(req.Period))
+	var (
+		filing = models.TaxFiling{CountryID: int64(req.Country), Period: req.Period}
+	)
+
+	start, end, err := parseReportPeriod(req.Period)
+	if err != nil {
+		return filing, http.StatusBadRequest, err
+	}
+
+	if end.After(time.Now()) {
+		return filing, http.StatusBadRequest, fmt.Errorf("period %v has not ended", req.Period)
+	}
+
+	code, err := filing.GetTaxFilingByCountryAndPeriod(db.MOR)
+	if err == nil {
+		return filing, http.StatusBadRequest, fmt.Errorf("tax filing for period %v is already closed", req.Period)
+	}
+	if code == http.StatusInternalServerError {
+		return filing, code, err
+	}
+
+	filing, lines, err := buildTaxFiling(extReq, db, int64(req.Country), req.Period, start, end)
+	if err != nil {
+		return filing, http.StatusInternalServerError, err
+	}
+
+	filing.Status = models.TaxFilingClosed
+	filing.ClosedAt = time.Now()
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		err := filing.CreateTaxFiling(tx)
+		if err != nil {
+			return err
+		}
+
+		for i := range lines {
+			lines[i].FilingID = int64(filing.ID)
+		}
+		return models.CreateTaxFilingLines(tx, lines)
+	})
+	if err != nil {
+		return filing, http.StatusInternalServerError, err
+	}
+
+	return filing, http.StatusOK, nil
+}
+
+// getTaxFilings returns the filings of the period with their lines, closed filings are read back as they were stored
+func getTaxFilings(extReq request.ExternalRequest, db postgresql.Databases, req models.GetTaxReportRequest) ([]models.TaxFiling, []models.TaxFilingLine, int, error) {
+	var (
+		transaction = models.Transaction{}
+		filings     = []models.TaxFiling{}
+		lines       = []models.TaxFilingLine{}
+		countryIDs  = []int64{int64(req.Country)}
+	)
+
+	req.Period = strings.ToUpper(strings.TrimSpace(req.Period))
+	start, end, err := parseReportPeriod(req.Period)
+	if err != nil {
+		return filings, lines, http.StatusBadRequest, err
+	}
+
+	if req.Country == 0 {
+		countryIDs, err = transaction.GetCountryIDsWithTaxActivity(db.MOR, start, end)
+		if err != nil {
+			return filings, lines, http.StatusInternalServerError, err
+		}
+	}
+
+	for _, countryID := range countryIDs {
+		filing := models.TaxFiling{CountryID: countryID, Period: req.Period}
+		code, err := filing.GetTaxFilingByCountryAndPeriod(db.MOR)
+		if err == nil {
+			line := models.TaxFilingLine{FilingID: int64(filing.ID)}
+			filingLines, err := line.GetTaxFilingLines(db.MOR)
+			if err != nil {
+				return filings, lines, http.StatusInternalServerError, err
+			}
+			filings = append(filings, filing)
+			lines = append(lines, filingLines...)
+			continue
+		}
+		if code == http.StatusInternalServerError {
+			return filings, lines, code, err
+		}
+
+		filing, filingLines, err := buildTaxFiling(extReq, db, countryID, req.Period, start, end)
+		if err != nil {
+			return filings, lines, http.StatusInternalServerError, err
+		}
+		filings = append(filings, filing)
+		lines = append(lines, filingLines...)
+	}
+
+	return filings, lines, http.StatusOK, nil
+}
+
+// buildTaxFiling works out an open filing from the country's sales and refunds in the period
+func buildTaxFiling(extReq request.ExternalRequest, db postgresql.Databases, countryID int64, period string, start time.Time, end time.Time) (models.TaxFiling, []models.TaxFilingLine, error) {
+	var (
+		transaction = models.Transaction{CountryID: countryID}
+		lines       = []models.TaxFilingLine{}
+		filing      = models.TaxFiling{
+			CountryID:   countryID,
+			Period:      period,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Status:      models.TaxFilingOpen,
+		}
+	)
+
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(countryID))
+	if err != nil {
+		return filing, lines, fmt.Errorf("error getting country with id %v: %v", countryID, err.Error())
+	}
+	filing.Currency = strings.ToUpper(country.CurrencyCode)
+
+	sales, err := transaction.GetTaxSales(db.MOR, start, end)
+	if err != nil {
+		return filing, lines, err
+	}
+
+	for _, trx := range sales {
+		line := getTaxFilingLine(trx, models.TaxFilingLineSale, trx.TransactionDate)
+		filing.SalesCount++
+		filing.GrossSales += trx.Amount
+		switch {
+		case trx.TaxReverseCharge:
+			filing.ReverseChargeAmount += trx.Amount
+		case trx.TaxCategory == models.TaxCategoryExempt:
+			filing.ExemptAmount += trx.Amount
+		default:
+			filing.TaxableAmount += line.TaxableAmount
+			filing.TaxCollected += trx.TaxFee
+		}
+		lines = append(lines, line)
+	}
+
+	refunds, err := transaction.GetTaxRefunds(db.MOR, start, end)
+	if err != nil {
+		return filing, lines, err
+	}
+
+	for _, trx := range refunds {
+		line := getTaxFilingLine(trx, models.TaxFilingLineRefund, trx.RefundedAt)
+		filing.RefundCount++
+		filing.RefundedAmount += trx.Amount
+		filing.RefundedTax += line.TaxFee
+		lines = append(lines, line)
+	}
+
+	refundLines, err := getTaxRefundLines(db, countryID, start, end)
+	if err != nil {
+		return filing, lines, err
+	}
+
+	for _, line := range refundLines {
+		filing.RefundCount++
+		filing.RefundedAmount += line.Amount
+		filing.RefundedTax += line.TaxFee
+		lines = append(lines, line)
+	}
+
+	filing.GrossSales = roundAmount(filing.GrossSales)
+	filing.TaxableAmount = roundAmount(filing.TaxableAmount)
+	filing.ExemptAmount = roundAmount(filing.ExemptAmount)
+	filing.ReverseChargeAmount = roundAmount(filing.ReverseChargeAmount)
+	filing.TaxCollected = roundAmount(filing.TaxCollected)
+	filing.RefundedAmount = roundAmount(filing.RefundedAmount)
+	filing.RefundedTax = roundAmount(filing.RefundedTax)
+	filing.NetTax = roundAmount(filing.TaxCollected - filing.RefundedTax)
+
+	return filing, lines, nil
+}
+
+// getTaxRefundLines reports the refunds made through the refund api, each at its share of the transaction's tax
+func getTaxRefundLines(db postgresql.Databases, countryID int64, start time.Time, end time.Time) ([]models.TaxFilingLine, error) {
+	var (
+		refund         = models.Refund{CountryID: countryID}
+		transaction    = models.Transaction{}
+		transactionIDs = []int64{}
+		transactions   = map[int64]models.Transaction{}
+		lines          = []models.TaxFilingLine{}
+	)
+
+	refunds, err := refund.GetTaxRefunds(db.MOR, start, end)
+	if err != nil {
+		return lines, err
+	}
+
+	for _, r := range refunds {
+		transactionIDs = append(transactionIDs, r.TransactionID)
+	}
+
+	refunded, err := transaction.GetTransactionsByIDs(db.MOR, transactionIDs)
+	if err != nil {
+		return lines, err
+	}
+
+	for _, trx := range refunded {
+		transactions[int64(trx.ID)] = trx
+	}
+
+	for _, r := range refunds {
+		trx, ok := transactions[r.TransactionID]
+		if !ok {
+			return lines, fmt.Errorf("transaction %v of refund %v not found", r.TransactionID, r.Reference)
+		}
+		trx.Amount = r.Amount
+		trx.TaxFee = r.TaxFee
+		lines = append(lines, getTaxFilingLine(trx, models.TaxFilingLineRefund, r.CreatedAt))
+	}
+
+	return lines, nil
+}
+
+// getTaxFilingLine records the transaction as it was taxed, exempt and reverse charged sales have no taxable amount
+func getTaxFilingLine(trx models.Transaction, kind models.TaxFilingLineKind, date time.Time) models.TaxFilingLine {
+	line := models.TaxFilingLine{
+		CountryID:     trx.CountryID,
+		TransactionID: int64(trx.ID),
+		Reference:     trx.Reference,
+		MerchantID:    trx.MerchantID,
+		Kind:          kind,
+		Date:          date,
+		Amount:        trx.Amount,
+		TaxCategory:   trx.TaxCategory,
+		TaxRateID:     trx.TaxRateID,
+		TaxRate:       trx.TaxRate,
+		ReverseCharge: trx.TaxReverseCharge,
+		CustomerTaxID: trx.CustomerTaxID,
+	}
+
+	if !trx.TaxReverseCharge && trx.TaxCategory != models.TaxCategoryExempt {
+		line.TaxFee = trx.TaxFee
+		line.TaxableAmount = roundAmount(trx.Amount - trx.TaxFee)
+	}
+
+	return line
+}
+
+// parseReportPeriod returns the start and exclusive end in UTC of a year (2024), quarter (2024-Q1) or month (2024-01)
+func parseReportPeriod(period string) (time.Time, time.Time, error) {
+	period = strings.ToUpper(strings.TrimSpace(period))
+	invalid := fmt.Errorf("invalid period %v, use 2024, 2024-Q1 or 2024-01", period)
+
+	if len(period) == 4 {
+		year, err := strconv.Atoi(period)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalid
+		}
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	}
+
+	if len(period) == 7 && period[4:6] == "-Q" {
+		year, err := strconv.Atoi(period[:4])
+		if err != nil {
+			return time.Time{}, time.Time{}, invalid
+		}
+		quarter, err := strconv.Atoi(period[6:])
+		if err != nil || quarter < 1 || quarter > 4 {
+			return time.Time{}, time.Time{}, invalid
+		}
+		start := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0), nil
+	}
+
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return time.Time{}, time.Time{}, invalid
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+func formatReportAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
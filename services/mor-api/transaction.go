@@ -1,6 +1,7 @@
 package mor
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,6 +12,8 @@ import (
 	"github.com/vesicash/mor-api/internal/models"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
+	"github.com/vesicash/mor-api/services/tax"
 )
 
 func RecordTransactionService(extReq request.ExternalRequest, db postgresql.Databases, req models.RecordTransactionRequest) (models.Transaction, int, error) {
@@ -29,10 +32,21 @@ func RecordTransactionService(extReq request.ExternalRequest, db postgresql.Data
 	transaction.CountryID = int64(req.Country)
 	transaction.Amount = req.Amount
 	transaction.TaxFee = req.TaxFee
+	transaction.TaxCategory = req.TaxCategory
+	transaction.CustomerTaxID = req.CustomerTaxID
 	transaction.ProcessingFee = req.ProcessingFee
 	transaction.TransactionDate = time.Unix(int64(req.TransactionCreatedAt), 0)
 	transaction.Status = models.TransactionSuccessful
-	err := transaction.CreateTransaction(db.MOR)
+
+	err := tax.ApplyTransactionTax(extReq, db, &transaction)
+	if err != nil {
+		if errors.As(err, &tax.InvalidTaxIDError{}) {
+			return transaction, http.StatusBadRequest, err
+		}
+		return transaction, http.StatusInternalServerError, err
+	}
+
+	err = transaction.CreateTransaction(db.MOR)
 	if err != nil {
 		return transaction, http.StatusInternalServerError, err
 	}
@@ -42,6 +56,13 @@ func RecordTransactionService(extReq request.ExternalRequest, db postgresql.Data
 		return transaction, http.StatusInternalServerError, err
 	}
 
+	err = ledger.RecordTransactionCapture(db, transaction, transaction.Currency)
+	if err != nil {
+		return transaction, http.StatusInternalServerError, err
+	}
+
+	NotifyMerchantWebhookEvent(extReq, db, transaction.MerchantID, models.MerchantWebhookTransactionRecorded, transaction)
+
 	return transaction, http.StatusOK, nil
 }
 
@@ -0,0 +1,290 @@
+package mor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/documents"
+	"gorm.io/gorm"
+)
+
+var (
+	transactionDocumentPrefixes = map[models.TransactionDocumentType]string{
+		models.TransactionDocumentInvoice: "INV",
+		models.TransactionDocumentReceipt: "RCT",
+	}
+	// transactionDocumentStatuses are the transaction statuses a document can be issued for, a receipt needs the payment to have been collected
+	transactionDocumentStatuses = map[models.TransactionDocumentType][]models.TransactionStatus{
+		models.TransactionDocumentInvoice: {models.TransactionPending, models.TransactionSuccessful, models.TransactionRefunded, models.TransactionReversed},
+		models.TransactionDocumentReceipt: {models.TransactionSuccessful, models.TransactionRefunded, models.TransactionReversed},
+	}
+)
+
+// GetTransactionDocumentService returns the invoice or receipt of a transaction as a pdf and its file name, issuing it on the first request.
+// merchantID restricts the lookup to the merchant's own transactions, 0 allows any transaction.
+func GetTransactionDocumentService(extReq request.ExternalRequest, db postgresql.Databases, transactionID int, merchantID int, req models.GetTransactionDocumentRequest) ([]byte, string, int, error) {
+	transaction, code, err := getDocumentTransaction(db, transactionID, merchantID)
+	if err != nil {
+		return nil, "", code, err
+	}
+
+	document, code, err := issueTransactionDocument(extReq, db, transaction, req.Type)
+	if err != nil {
+		return nil, "", code, err
+	}
+
+	doc, err := buildTransactionDocument(extReq, db, transaction, document)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	pdf, err := documents.RenderPDF(doc)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	return pdf, fmt.Sprintf("%v.pdf", document.Number), http.StatusOK, nil
+}
+
+// EmailTransactionDocumentService sends the invoice or receipt of a transaction through the notification service,
+// to the customer unless another address is given
+func EmailTransactionDocumentService(extReq request.ExternalRequest, db postgresql.Databases, transactionID int, req models.EmailTransactionDocumentRequest) (models.TransactionDocument, int, error) {
+	transaction, code, err := getDocumentTransaction(db, transactionID, 0)
+	if err != nil {
+		return models.TransactionDocument{}, code, err
+	}
+
+	email := req.Email
+	if email == "" {
+		customer := models.Customer{ID: uint(transaction.CustomerID)}
+		code, err := customer.GetCustomerByID(db.MOR)
+		if code == http.StatusInternalServerError {
+			return models.TransactionDocument{}, code, err
+		}
+		email = customer.Email
+	}
+
+	if email == "" {
+		return models.TransactionDocument{}, http.StatusBadRequest, fmt.Errorf("transaction %v has no customer email, provide one", transaction.ID)
+	}
+
+	document, code, err := issueTransactionDocument(extReq, db, transaction, req.Type)
+	if err != nil {
+		return document, code, err
+	}
+
+	doc, err := buildTransactionDocument(extReq, db, transaction, document)
+	if err != nil {
+		return document, http.StatusInternalServerError, err
+	}
+
+	pdf, err := documents.RenderPDF(doc)
+	if err != nil {
+		return document, http.StatusInternalServerError, err
+	}
+
+	_, err = extReq.SendExternalRequest(request.TransactionDocumentNotification, external_models.TransactionDocumentNotificationRequest{
+		Email:                email,
+		DocumentType:         string(document.DocumentType),
+		Number:               document.Number,
+		TransactionReference: transaction.Reference,
+		MerchantName:         doc.Supplier.Name,
+		Currency:             document.Currency,
+		Amount:               document.Amount,
+		FileName:             fmt.Sprintf("%v.pdf", document.Number),
+		FileContent:          base64.StdEncoding.EncodeToString(pdf),
+	})
+	if err != nil {
+		return document, http.StatusInternalServerError, err
+	}
+
+	document.EmailedTo = email
+	document.EmailedAt = time.Now()
+	err = document.UpdateAllFields(db.MOR)
+	if err != nil {
+		return document, http.StatusInternalServerError, err
+	}
+
+	return document, http.StatusOK, nil
+}
+
+func getDocumentTransaction(db postgresql.Databases, transactionID int, merchantID int) (models.Transaction, int, error) {
+	transaction := models.Transaction{ID: uint(transactionID)}
+	code, err := transaction.GetTransactionByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return transaction, code, err
+		}
+		return transaction, code, fmt.Errorf("transaction with id %v not found", transactionID)
+	}
+
+	if merchantID != 0 && transaction.MerchantID != int64(merchantID) {
+		return transaction, http.StatusBadRequest, fmt.Errorf("transaction with id %v not found", transactionID)
+	}
+
+	return transaction, http.StatusOK, nil
+}
+
+// issueTransactionDocument returns the transaction's document of the type, numbering a new one when none was issued yet.
+// The number is taken in the same database transaction that saves the document, so a failed issue doesn't burn a number.
+func issueTransactionDocument(extReq request.ExternalRequest, db postgresql.Databases, transaction models.Transaction, documentType models.TransactionDocumentType) (models.TransactionDocument, int, error) {
+	var (
+		entity   = config.GetConfig().LegalEntity
+		document = models.TransactionDocument{TransactionID: int64(transaction.ID), DocumentType: documentType}
+	)
+
+	code, err := document.GetTransactionDocumentByTransactionIDAndType(db.MOR)
+	if err == nil {
+		return document, http.StatusOK, nil
+	}
+	if code == http.StatusInternalServerError {
+		return document, code, err
+	}
+
+	if !transaction.Status.In(transactionDocumentStatuses[documentType]) {
+		return document, http.StatusBadRequest, fmt.Errorf("cannot issue a %v for a %v transaction", documentType, transaction.Status)
+	}
+
+	if entity.Code == "" || entity.Name == "" {
+		return document, http.StatusInternalServerError, fmt.Errorf("legal entity is not configured")
+	}
+
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(transaction.CountryID))
+	if err != nil {
+		return document, http.StatusInternalServerError, err
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		sequence := models.DocumentSequence{Entity: entity.Code, DocumentType: documentType}
+		number, err := sequence.NextDocumentNumber(tx)
+		if err != nil {
+			return err
+		}
+
+		document = models.TransactionDocument{
+			TransactionID: int64(transaction.ID),
+			DocumentType:  documentType,
+			MerchantID:    transaction.MerchantID,
+			Entity:        entity.Code,
+			Sequence:      number,
+			Number:        fmt.Sprintf("%v-%v-%06d", entity.Code, transactionDocumentPrefixes[documentType], number),
+			Currency:      country.CurrencyCode,
+			Amount:        transaction.Amount,
+			TaxFee:        transaction.TaxFee,
+		}
+		return document.CreateTransactionDocument(tx)
+	})
+	if err != nil {
+		// a concurrent request may have issued it first
+		existing := models.TransactionDocument{TransactionID: int64(transaction.ID), DocumentType: documentType}
+		if _, getErr := existing.GetTransactionDocumentByTransactionIDAndType(db.MOR); getErr == nil {
+			return existing, http.StatusOK, nil
+		}
+		return document, http.StatusInternalServerError, err
+	}
+
+	return document, http.StatusOK, nil
+}
+
+// buildTransactionDocument lays out the transaction for its document, the legal entity sells on behalf of the merchant who supplies.
+// Lines come from the payment orders, a transaction without any is billed as one line of its description.
+func buildTransactionDocument(extReq request.ExternalRequest, db postgresql.Databases, transaction models.Transaction, document models.TransactionDocument) (documents.Document, error) {
+	var (
+		entity  = config.GetConfig().LegalEntity
+		orders  []models.PaymentOrder
+		details models.Transaction
+		err     error
+	)
+
+	details, err = GetMorTransactionDetails(extReq, db, transaction)
+	if err != nil {
+		return documents.Document{}, err
+	}
+
+	customer := models.Customer{ID: uint(transaction.CustomerID)}
+	code, err := customer.GetCustomerByID(db.MOR)
+	if code == http.StatusInternalServerError {
+		return documents.Document{}, err
+	}
+
+	paymentOrder := models.PaymentOrder{TransactionID: int64(transaction.ID)}
+	orders, err = paymentOrder.GetPaymentOrdersByTransactionID(db.MOR)
+	if err != nil {
+		return documents.Document{}, err
+	}
+
+	doc := documents.Document{
+		Type:     document.DocumentType,
+		Number:   document.Number,
+		IssuedAt: document.CreatedAt,
+		Seller: documents.Party{
+			Name:    entity.Name,
+			Address: entity.Address,
+			Email:   entity.Email,
+			TaxID:   entity.TaxID,
+		},
+		Supplier: documents.Party{
+			Name:  strings.TrimSpace(details.MerchantName),
+			Email: details.MerchantEmail,
+		},
+		Customer: documents.Party{
+			Name:    strings.TrimSpace(details.CustomerName),
+			Address: strings.Join(nonEmpty(customer.Address, customer.City, customer.State), ", "),
+			Email:   customer.Email,
+			TaxID:   transaction.CustomerTaxID,
+		},
+		TransactionReference: transaction.Reference,
+		TransactionDate:      transaction.TransactionDate,
+		PaymentMethod:        transaction.PaymentMethod,
+		Currency:             document.Currency,
+		TaxCategory:          transaction.TaxCategory,
+		TaxRate:              transaction.TaxRate,
+		TaxFee:               transaction.TaxFee,
+		TaxReverseCharge:     transaction.TaxReverseCharge,
+		Total:                transaction.Amount,
+		Subtotal:             math.Round((transaction.Amount-transaction.TaxFee)*100) / 100,
+	}
+
+	for _, o := range orders {
+		doc.Lines = append(doc.Lines, documents.Line{
+			Item:      o.Item,
+			Quantity:  o.Quantity,
+			UnitPrice: o.UnitPrice,
+			Total:     math.Round(float64(o.Quantity)*o.UnitPrice*100) / 100,
+		})
+	}
+
+	if len(doc.Lines) == 0 {
+		doc.Lines = []documents.Line{{Item: transaction.Description, Quantity: 1, UnitPrice: transaction.Amount, Total: transaction.Amount}}
+	}
+
+	if doc.TransactionDate.IsZero() {
+		doc.TransactionDate = transaction.CreatedAt
+	}
+
+	if doc.TaxCategory == "" {
+		doc.TaxCategory = models.TaxCategoryStandard
+	}
+
+	return doc, nil
+}
+
+func nonEmpty(values ...string) []string {
+	result := []string{}
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			result = append(result, strings.TrimSpace(v))
+		}
+	}
+	return result
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/vesicash/mor-api/external/request"
 	"github.com/vesicash/mor-api/internal/models"
@@ -25,6 +26,11 @@ func GetMerchantTransactionsSummaryService(extReq request.ExternalRequest, db po
 		return []models.TransactionSummary{}, http.StatusInternalServerError, err
 	}
 
+	summaries, err = addPayoutDetailsToSummaries(db, accountID, summaries)
+	if err != nil {
+		return []models.TransactionSummary{}, http.StatusInternalServerError, err
+	}
+
 	summaries, err = GetTransactionsSummariesDetails(extReq, db, summaries)
 	if err != nil {
 		return []models.TransactionSummary{}, http.StatusInternalServerError, err
@@ -33,6 +39,68 @@ func GetMerchantTransactionsSummaryService(extReq request.ExternalRequest, db po
 	return summaries, http.StatusOK, nil
 }
 
+// addPayoutDetailsToSummaries adds what the merchant can be paid out now after fees and tax, their held reserves, payout hold and
+// reserve policy to the unpaid totals, a currency with held reserves and nothing unpaid gets its own summary
+func addPayoutDetailsToSummaries(db postgresql.Databases, accountID int, summaries []models.TransactionSummary) ([]models.TransactionSummary, error) {
+	var (
+		setting     = models.Setting{AccountID: int64(accountID)}
+		transaction = models.Transaction{MerchantID: int64(accountID)}
+		reserve     = models.PayoutReserve{MerchantID: int64(accountID)}
+		indexes     = map[int64]int{}
+	)
+
+	code, err := setting.GetSettingByAccountID(db.MOR)
+	if err != nil && code == http.StatusInternalServerError {
+		return summaries, err
+	}
+
+	getSummary := func(countryID int64) *models.TransactionSummary {
+		if _, ok := indexes[countryID]; !ok {
+			indexes[countryID] = len(summaries)
+			summaries = append(summaries, models.TransactionSummary{CountryID: countryID})
+		}
+		return &summaries[indexes[countryID]]
+	}
+
+	for i, summary := range summaries {
+		indexes[summary.CountryID] = i
+	}
+
+	transactions, err := transaction.GetPayableTransactions(db.MOR, setting.GetPayoutMaturity(time.Now()))
+	if err != nil {
+		return summaries, err
+	}
+
+	for _, trx := range transactions {
+		getSummary(trx.CountryID).Payable += trx.Amount - trx.ProcessingFee - trx.TaxFee
+	}
+
+	reserves, err := reserve.GetHeldPayoutReserves(db.MOR)
+	if err != nil {
+		return summaries, err
+	}
+
+	for _, r := range reserves {
+		summary := getSummary(r.CountryID)
+		summary.Reserved += r.Amount
+		if summary.NextReleaseAt.IsZero() {
+			summary.NextReleaseAt = r.ReleaseAt
+		}
+	}
+
+	for i := range summaries {
+		summaries[i].Payable = roundAmount(summaries[i].Payable)
+		summaries[i].Reserved = roundAmount(summaries[i].Reserved)
+		summaries[i].PayoutHold = setting.PayoutHold
+		summaries[i].PayoutHoldReason = setting.PayoutHoldReason
+		summaries[i].ReserveRate = setting.ReserveRate
+		summaries[i].ReserveDays = setting.ReserveDays
+		summaries[i].PayoutDelayDays = setting.PayoutDelayDays
+	}
+
+	return summaries, nil
+}
+
 func GetTransactionsSummaryService(extReq request.ExternalRequest, db postgresql.Databases) ([]models.TransactionSummary, int, error) {
 	var (
 		transaction = models.Transaction{}
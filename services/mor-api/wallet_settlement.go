@@ -0,0 +1,66 @@
+package mor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/disputes"
+	"github.com/vesicash/mor-api/services/refunds"
+)
+
+const (
+	// walletSettleAfter is how long a MOR_ wallet call left for after a commit waits before the recovery job makes it
+	walletSettleAfter = 5 * time.Minute
+)
+
+// SettleWalletCalls is the recovery cronjob of the MOR_ wallet calls left for after a commit, it makes the refund debits,
+// dispute holds and releases and withdrawal reversals whose settlement failed or never ran
+func SettleWalletCalls(extReq request.ExternalRequest, db postgresql.Databases) {
+	settleWalletCalls(extReq, db, 0, time.Now().Add(-walletSettleAfter))
+}
+
+// settleWalletCalls makes the MOR_ wallet calls of the refunds, disputes and withdrawals waiting on them that weren't touched since updatedBefore,
+// merchantID 0 settles every merchant's. Errors are only logged, what is left is settled by the recovery cronjob.
+func settleWalletCalls(extReq request.ExternalRequest, db postgresql.Databases, merchantID int64, updatedBefore time.Time) {
+	var (
+		refund     = models.Refund{MerchantID: merchantID}
+		dispute    = models.Dispute{MerchantID: merchantID}
+		withdrawal = models.Withdrawal{MerchantID: merchantID}
+	)
+
+	refundList, err := refund.GetWalletRecoveriesToSettle(db.MOR, updatedBefore)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting refunds to settle: %v", err.Error()))
+	}
+	for _, r := range refundList {
+		_, _, err = refunds.SettleRefund(extReq, db, r)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error settling refund %v: %v", r.Reference, err.Error()))
+		}
+	}
+
+	disputeList, err := dispute.GetDisputesToSettle(db.MOR, updatedBefore)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting disputes to settle: %v", err.Error()))
+	}
+	for _, d := range disputeList {
+		_, _, err = disputes.SettleDispute(extReq, db, d)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error settling dispute %v: %v", d.ID, err.Error()))
+		}
+	}
+
+	withdrawals, err := withdrawal.GetWithdrawalsToReverse(db.MOR, updatedBefore)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting withdrawals to reverse: %v", err.Error()))
+	}
+	for i := range withdrawals {
+		_, err = reverseWithdrawal(extReq, db, &withdrawals[i])
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error reversing withdrawal %v: %v", withdrawals[i].ID, err.Error()))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package mor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/utility"
+)
+
+const (
+	webhookWorkers           = 5
+	webhookMaxAttempts       = 8
+	webhookRetryBaseDelay    = 30 * time.Second
+	webhookRetryMaxDelay     = 6 * time.Hour
+	webhookPollInterval      = time.Minute
+	webhookProcessingTimeout = 15 * time.Minute
+)
+
+var webhookQueue = make(chan uint, 1000)
+
+// StartWebhookWorkers runs the webhook worker pool and the scheduler that feeds it retries and missed deliveries
+func StartWebhookWorkers(extReq request.ExternalRequest, db postgresql.Databases) {
+	utility.LogAndPrint(extReq.Logger, fmt.Sprintf("starting %v webhook workers", webhookWorkers))
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for webhookLogID := range webhookQueue {
+				err := ProcessWebhookLog(extReq, db, webhookLogID)
+				if err != nil {
+					extReq.Logger.Error(fmt.Sprintf("webhook log error, processing webhook log %v: %v", webhookLogID, err.Error()))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			EnqueueDueWebhookLogs(extReq, db)
+			time.Sleep(webhookPollInterval)
+		}
+	}()
+}
+
+// EnqueueDueWebhookLogs queues pending and retrying deliveries whose next attempt is due
+func EnqueueDueWebhookLogs(extReq request.ExternalRequest, db postgresql.Databases) {
+	webhookLog := models.WebhookLog{}
+	webhookLogs, err := webhookLog.GetDueWebhookLogs(db.MOR, time.Now().Add(-webhookProcessingTimeout))
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("webhook log error, getting due webhook logs: %v", err.Error()))
+		return
+	}
+
+	for _, w := range webhookLogs {
+		enqueueWebhookLog(w.ID)
+	}
+}
+
+// ProcessWebhookLog makes one attempt at a delivery, failed attempts are rescheduled with exponential backoff until they are dead-lettered
+func ProcessWebhookLog(extReq request.ExternalRequest, db postgresql.Databases, webhookLogID uint) error {
+	var (
+		webhookLog   = models.WebhookLog{ID: webhookLogID}
+		permanentErr webhookPermanentError
+	)
+
+	claimed, err := webhookLog.ClaimWebhookLog(db.MOR, time.Now().Add(-webhookProcessingTimeout))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+
+	_, err = webhookLog.GetWebhookLogByID(db.MOR)
+	if err != nil {
+		return err
+	}
+
+	err = applyWebhookLog(extReq, db, webhookLog)
+	switch {
+	case err == nil:
+		webhookLog.Status = models.WebhookLogProcessed
+		webhookLog.LastError = ""
+		webhookLog.ProcessedAt = time.Now()
+	case errors.As(err, &permanentErr) || webhookLog.Attempts >= webhookMaxAttempts:
+		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v, dead-lettering webhook log %v after %v attempts: %v", webhookLog.Provider, webhookLog.ID, webhookLog.Attempts, err.Error()))
+		webhookLog.Status = models.WebhookLogDeadLetter
+		webhookLog.LastError = err.Error()
+	default:
+		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v, attempt %v of webhook log %v failed: %v", webhookLog.Provider, webhookLog.Attempts, webhookLog.ID, err.Error()))
+		webhookLog.Status = models.WebhookLogRetrying
+		webhookLog.LastError = err.Error()
+		webhookLog.NextAttemptAt = time.Now().Add(getWebhookRetryDelay(webhookLog.Attempts))
+	}
+
+	return webhookLog.UpdateAllFields(db.MOR)
+}
+
+// the queue is best effort, deliveries that don't fit are picked up by the scheduler
+func enqueueWebhookLog(webhookLogID uint) {
+	select {
+	case webhookQueue <- webhookLogID:
+	default:
+	}
+}
+
+func getWebhookRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(webhookRetryBaseDelay) * math.Pow(2, float64(attempts-1)))
+	if delay <= 0 || delay > webhookRetryMaxDelay {
+		return webhookRetryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,190 @@
+package mor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services/providers"
+	"gorm.io/gorm"
+)
+
+var errWebhookDryRun = errors.New("webhook replay dry run")
+
+type webhookEventState struct {
+	transactions []models.Transaction
+	customer     *models.Customer
+}
+
+// ReplayWebhookLogService re-runs a stored delivery through its provider, a dry run reports the changes it would make and rolls them back.
+// A dry run never calls wallets or notifies the merchant, so it is safe to run against any delivery.
+func ReplayWebhookLogService(extReq request.ExternalRequest, db postgresql.Databases, webhookLogID int, dryRun bool) (models.WebhookReplayResult, int, error) {
+	var (
+		webhookLog = models.WebhookLog{ID: uint(webhookLogID)}
+		result     = models.WebhookReplayResult{DryRun: dryRun, Changes: []models.WebhookReplayChange{}}
+	)
+
+	code, err := webhookLog.GetWebhookLogByID(db.MOR)
+	if err != nil {
+		return result, code, err
+	}
+
+	if dryRun {
+		changes, duplicate, err := dryRunWebhookLog(extReq, db, webhookLog)
+		if err != nil {
+			var permanentErr webhookPermanentError
+			if errors.As(err, &permanentErr) {
+				return result, http.StatusBadRequest, err
+			}
+			return result, http.StatusInternalServerError, err
+		}
+		result.WebhookLog = webhookLog
+		result.Changes = changes
+		result.Duplicate = duplicate
+		return result, http.StatusOK, nil
+	}
+
+	if webhookLog.Status == models.WebhookLogPending || webhookLog.Status == models.WebhookLogProcessing {
+		return result, http.StatusBadRequest, fmt.Errorf("webhook log %v is %v, wait for the webhook workers to finish with it", webhookLog.ID, webhookLog.Status)
+	}
+
+	extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, replaying webhook log %v", webhookLog.Provider, webhookLog.ID))
+	webhookLog.Status = models.WebhookLogPending
+	webhookLog.Attempts = 0
+	webhookLog.NextAttemptAt = time.Now()
+	err = webhookLog.UpdateAllFields(db.MOR)
+	if err != nil {
+		return result, http.StatusInternalServerError, err
+	}
+
+	err = ProcessWebhookLog(extReq, db, webhookLog.ID)
+	if err != nil {
+		return result, http.StatusInternalServerError, err
+	}
+
+	code, err = webhookLog.GetWebhookLogByID(db.MOR)
+	if err != nil {
+		return result, code, err
+	}
+	result.WebhookLog = webhookLog
+
+	return result, http.StatusOK, nil
+}
+
+func dryRunWebhookLog(extReq request.ExternalRequest, db postgresql.Databases, webhookLog models.WebhookLog) ([]models.WebhookReplayChange, bool, error) {
+	var (
+		changes   = []models.WebhookReplayChange{}
+		duplicate bool
+	)
+
+	provider, event, eventKey, err := parseWebhookLog(webhookLog)
+	if err != nil {
+		return changes, duplicate, err
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		before, err := getWebhookEventState(tx, webhookLog.MerchantID, event)
+		if err != nil {
+			return err
+		}
+
+		err = applyWebhookEvent(extReq, db, tx, provider, webhookLog, event, eventKey, true)
+		if errors.Is(err, errDuplicateWebhook) {
+			duplicate = true
+			return errWebhookDryRun
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := getWebhookEventState(tx, webhookLog.MerchantID, event)
+		if err != nil {
+			return err
+		}
+
+		changes = getWebhookReplayChanges(before, after)
+		return errWebhookDryRun
+	})
+	if err != nil && !errors.Is(err, errWebhookDryRun) {
+		return changes, duplicate, err
+	}
+
+	return changes, duplicate, nil
+}
+
+// getWebhookEventState reads the transactions and customer an event can touch
+func getWebhookEventState(tx *gorm.DB, merchantID int64, event providers.WebhookEvent) (webhookEventState, error) {
+	var (
+		state       = webhookEventState{}
+		transaction = models.Transaction{MerchantID: merchantID, Reference: event.Reference}
+		err         error
+	)
+
+	state.transactions, err = transaction.GetTransactionsAll(tx, nil)
+	if err != nil {
+		return state, err
+	}
+
+	if event.Type != providers.WebhookEventCharge {
+		return state, nil
+	}
+
+	customer := models.Customer{AccountID: merchantID}
+	if event.Customer != nil {
+		customer.Email = event.Customer.Email
+	}
+
+	code, err := customer.GetCustomerByAccountIDAndEmail(tx)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return state, err
+		}
+		return state, nil
+	}
+	state.customer = &customer
+
+	return state, nil
+}
+
+func getWebhookReplayChanges(before, after webhookEventState) []models.WebhookReplayChange {
+	var (
+		changes            = []models.WebhookReplayChange{}
+		beforeTransactions = map[uint]models.Transaction{}
+	)
+
+	for _, t := range before.transactions {
+		beforeTransactions[t.ID] = t
+	}
+
+	for _, t := range after.transactions {
+		previous, ok := beforeTransactions[t.ID]
+		if !ok {
+			changes = append(changes, models.WebhookReplayChange{Model: "transaction", Action: "create", After: t})
+			continue
+		}
+
+		previous.UpdatedAt = t.UpdatedAt
+		if !reflect.DeepEqual(previous, t) {
+			changes = append(changes, models.WebhookReplayChange{Model: "transaction", Action: "update", Before: beforeTransactions[t.ID], After: t})
+		}
+	}
+
+	if after.customer != nil {
+		if before.customer == nil {
+			changes = append(changes, models.WebhookReplayChange{Model: "customer", Action: "create", After: *after.customer})
+		} else {
+			previous := *before.customer
+			previous.UpdatedAt = after.customer.UpdatedAt
+			if !reflect.DeepEqual(previous, *after.customer) {
+				changes = append(changes, models.WebhookReplayChange{Model: "customer", Action: "update", Before: *before.customer, After: *after.customer})
+			}
+		}
+	}
+
+	return changes
+}
@@ -1,76 +1,188 @@
 package mor
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vesicash/mor-api/external/request"
-	"github.com/vesicash/mor-api/internal/config"
 	"github.com/vesicash/mor-api/internal/models"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services/providers"
-	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
 )
 
+var errDuplicateWebhook = errors.New("duplicate webhook delivery")
+
+// webhookPermanentError marks delivery failures that retrying can't fix, they are dead-lettered straight away
+type webhookPermanentError struct {
+	error
+}
+
+// MerchantWebhooksService verifies and persists a delivery, the webhook workers apply it to the merchant afterwards
 func MerchantWebhooksService(c *gin.Context, extReq request.ExternalRequest, db postgresql.Databases, requestBody []byte) (int, error) {
 	var (
-		err error
+		accountIDStr = c.Param("account_id")
+		webhookLog   = models.WebhookLog{
+			Status:        models.WebhookLogPending,
+			NextAttemptAt: time.Now(),
+		}
 	)
 
-	provider, err := GetProvider(c, requestBody)
-	if err != nil {
-		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v %v", provider, err.Error()))
-		return http.StatusInternalServerError, err
+	provider := providers.DetectWebhookProvider(c)
+	if provider == nil {
+		webhookLog.Status = models.WebhookLogIgnored
+		err := logWebhookData(extReq, db, &webhookLog, requestBody)
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("webhook log error %v", err.Error()))
+		}
+		return http.StatusOK, nil
 	}
 
-	logWebhookData(extReq, db, provider, requestBody)
+	webhookLog.Provider = provider.Name()
+	err := provider.VerifySignature(c, requestBody)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v %v", webhookLog.Provider, err.Error()))
+		return http.StatusUnauthorized, err
+	}
 
-	switch strings.ToLower(provider) {
-	case "flutterwave":
-		err = providers.HandleFlutterwaveMerchantWebhook(c, extReq, db, requestBody)
-	case "e-transact":
-		err = providers.HandleETransactMerchantWebhook(c, extReq, db, requestBody)
-	case "paystack":
-		err = providers.HandlePaystackMerchantWebhook(c, extReq, db, requestBody)
-	default:
-		err = providers.HandleDefaultMerchantWebhook(c, extReq, db, requestBody)
+	accountID, err := strconv.Atoi(accountIDStr)
+	if err != nil {
+		webhookLog.Status = models.WebhookLogIgnored
+		webhookLog.LastError = fmt.Sprintf("incorrect account_id: %v", err.Error())
+		logErr := logWebhookData(extReq, db, &webhookLog, requestBody)
+		if logErr != nil {
+			extReq.Logger.Error(fmt.Sprintf("webhook log error for %v %v", webhookLog.Provider, logErr.Error()))
+		}
+		return http.StatusBadRequest, fmt.Errorf("incorrect account_id: %v", err.Error())
 	}
+	webhookLog.MerchantID = int64(accountID)
 
+	err = logWebhookData(extReq, db, &webhookLog, requestBody)
 	if err != nil {
-		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v %v", provider, err.Error()))
+		extReq.Logger.Error(fmt.Sprintf("webhook log error for %v %v", webhookLog.Provider, err.Error()))
 		return http.StatusInternalServerError, err
 	}
 
+	enqueueWebhookLog(webhookLog.ID)
+
 	return http.StatusOK, nil
 }
 
-func GetProvider(c *gin.Context, requestBody []byte) (string, error) {
+func GetWebhookLogsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetWebhookLogsRequest) ([]models.WebhookLog, postgresql.PaginationResponse, int, error) {
 	var (
-		provider string
+		webhookLog = models.WebhookLog{
+			Status:     models.WebhookLogStatus(req.Status),
+			Provider:   req.Provider,
+			MerchantID: int64(req.AccountID),
+		}
 	)
 
-	if utility.GetHeader(c, "verif-hash") != "" {
-		provider = "flutterwave"
-		if config.GetConfig().Rave.WebhookSecret != utility.GetHeader(c, "verif-hash") {
-			return provider, fmt.Errorf("secret key doesn't match")
-		}
+	webhookLogs, pagination, err := webhookLog.GetWebhookLogs(db.MOR, paginator, req.Search, req.FromTime, req.ToTime)
+	if err != nil {
+		return webhookLogs, pagination, http.StatusInternalServerError, err
 	}
 
-	// flutterwave, e-transact, paystack
-	return provider, nil
+	return webhookLogs, pagination, http.StatusOK, nil
 }
 
-func logWebhookData(extReq request.ExternalRequest, db postgresql.Databases, provider string, requestBody []byte) error {
-	extReq.Logger.Info(fmt.Sprintf("webhook log info for %v %v", provider, string(requestBody)))
-	webhookLog := models.WebhookLog{
-		Log:      string(requestBody),
-		Provider: provider,
+func GetWebhookLogService(extReq request.ExternalRequest, db postgresql.Databases, webhookLogID int) (models.WebhookLog, int, error) {
+	var (
+		webhookLog = models.WebhookLog{ID: uint(webhookLogID)}
+	)
+
+	code, err := webhookLog.GetWebhookLogByID(db.MOR)
+	if err != nil {
+		return webhookLog, code, err
+	}
+
+	return webhookLog, http.StatusOK, nil
+}
+
+// applyWebhookLog parses a persisted delivery and applies it to the merchant exactly once
+func applyWebhookLog(extReq request.ExternalRequest, db postgresql.Databases, webhookLog models.WebhookLog) error {
+	provider, event, eventKey, err := parseWebhookLog(webhookLog)
+	if err != nil {
+		return err
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		return applyWebhookEvent(extReq, db, tx, provider, webhookLog, event, eventKey, false)
+	})
+	if errors.Is(err, errDuplicateWebhook) {
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, duplicate delivery of %v for merchant %v ignored", webhookLog.Provider, eventKey, webhookLog.MerchantID))
+		return nil
 	}
-	err := webhookLog.CreateWebhookLog(db.Payment)
 	if err != nil {
 		return err
 	}
+
+	// the wallet calls the event needs are only made once it committed, so a rolled back event never moved the merchant's funds
+	settleWalletCalls(extReq, db, webhookLog.MerchantID, time.Now())
 	return nil
 }
+
+// parseWebhookLog returns the provider of a persisted delivery, its normalized event and the event's idempotency key
+func parseWebhookLog(webhookLog models.WebhookLog) (providers.WebhookProvider, providers.WebhookEvent, string, error) {
+	provider, err := providers.GetWebhookProviderByName(webhookLog.Provider)
+	if err != nil {
+		return nil, providers.WebhookEvent{}, "", webhookPermanentError{err}
+	}
+
+	event, err := provider.Parse([]byte(webhookLog.Log))
+	if err != nil {
+		return nil, providers.WebhookEvent{}, "", webhookPermanentError{err}
+	}
+
+	eventKey := event.IdempotencyKey()
+	if eventKey == "" {
+		return nil, providers.WebhookEvent{}, "", webhookPermanentError{fmt.Errorf("%v webhook event %v has no id or reference", webhookLog.Provider, event.ProviderEvent)}
+	}
+
+	return provider, event, eventKey, nil
+}
+
+// applyWebhookEvent marks the event processed and applies it within tx, it returns errDuplicateWebhook when the event was already processed.
+// A dry run leaves wallets and notifications alone, its caller rolls tx back.
+func applyWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, tx *gorm.DB, provider providers.WebhookProvider, webhookLog models.WebhookLog, event providers.WebhookEvent, eventKey string, dryRun bool) error {
+	processedWebhook := models.ProcessedWebhook{
+		Provider:     webhookLog.Provider,
+		EventKey:     eventKey,
+		MerchantID:   webhookLog.MerchantID,
+		WebhookLogID: int64(webhookLog.ID),
+	}
+
+	created, err := processedWebhook.CreateProcessedWebhookIfNotExists(tx)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errDuplicateWebhook
+	}
+
+	txDb := db
+	txDb.MOR = tx
+
+	// transfers of withdrawals are ours, not the merchant's, so they don't go to the provider's default mapping
+	if event.Type == providers.WebhookEventTransfer && event.Reference != "" {
+		withdrawal := models.Withdrawal{TransferReference: event.Reference}
+		code, err := withdrawal.GetWithdrawalByTransferReference(tx)
+		if err == nil {
+			return applyWithdrawalTransferEvent(extReq, txDb, withdrawal, event, dryRun)
+		}
+		if code == http.StatusInternalServerError {
+			return err
+		}
+	}
+
+	return provider.Apply(extReq, txDb, int(webhookLog.MerchantID), event, dryRun)
+}
+
+func logWebhookData(extReq request.ExternalRequest, db postgresql.Databases, webhookLog *models.WebhookLog, requestBody []byte) error {
+	extReq.Logger.Info(fmt.Sprintf("webhook log info for %v %v", webhookLog.Provider, string(requestBody)))
+	webhookLog.Log = string(requestBody)
+	return webhookLog.CreateWebhookLog(db.MOR)
+}
@@ -14,12 +14,16 @@ import (
 	"github.com/vesicash/mor-api/internal/models"
 	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
 	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
+	"gorm.io/gorm"
 )
 
+// RequestWithdrawalService reserves the amount for a withdrawal from the merchant's MOR_ wallet.
+// The merchant's withdrawal account in the currency stays locked from the balance check until the withdrawal is created,
+// so concurrent requests and approvals debiting the wallet can't withdraw more than the wallet holds.
 func RequestWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, user external_models.User, req models.RequestWithdrawalRequest) (int, error) {
 	var (
-		withdrawal            = models.Withdrawal{MerchantID: int64(user.AccountID), Status: models.TransactionPending}
-		withdrawalSum float64 = 0
+		withdrawal = models.Withdrawal{MerchantID: int64(user.AccountID), Status: models.WithdrawalPending}
 	)
 
 	checkTime := int(time.Now().Add(8736 * time.Hour).Unix())
@@ -27,38 +31,45 @@ func RequestWithdrawalService(extReq request.ExternalRequest, db postgresql.Data
 		return http.StatusBadRequest, fmt.Errorf("invalid timestamp, time must not be more than 1 year after today")
 	}
 
-	req.Currency = strings.ToUpper(strings.ReplaceAll(strings.ToUpper(req.Currency), "MOR_", ""))
-	req.Currency = strings.ToUpper(strings.ReplaceAll(strings.ToUpper(req.Currency), "ESCROW_", ""))
-	morWallet := strings.ToUpper(fmt.Sprintf("MOR_%v", req.Currency))
-
-	wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, int(user.AccountID), morWallet)
-	if err != nil {
-		return http.StatusBadRequest, err
+	if req.Amount <= 0 {
+		return http.StatusBadRequest, fmt.Errorf("amount must be more than 0")
 	}
 
-	withdrawals, _, err := withdrawal.GetWithdrawals(db.MOR, nil, []int{}, 0, 0)
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
+	withdrawal.Amount = req.Amount
+	withdrawal.Currency = normalizeWithdrawalCurrency(req.Currency)
+	withdrawal.WithdrawalDate = time.Unix(int64(req.WithdrawalDate), 0)
 
-	for _, w := range withdrawals {
-		withdrawalSum += w.Amount
-	}
+	code := http.StatusInternalServerError
+	err := db.MOR.Transaction(func(tx *gorm.DB) error {
+		account := models.WithdrawalAccount{MerchantID: withdrawal.MerchantID, Currency: withdrawal.Currency}
+		err := account.LockWithdrawalAccount(tx)
+		if err != nil {
+			return err
+		}
 
-	remainingBalance := wallet.Available - withdrawalSum
-	if remainingBalance < req.Amount {
-		return http.StatusBadRequest, fmt.Errorf("insufficient wallet balance")
-	}
+		wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, int(user.AccountID), fmt.Sprintf("MOR_%v", withdrawal.Currency))
+		if err != nil {
+			code = http.StatusBadRequest
+			return err
+		}
 
-	withdrawal.Amount = req.Amount
-	withdrawal.Currency = req.Currency
-	withdrawal.WithdrawalDate = time.Unix(int64(req.WithdrawalDate), 0)
+		balance, err := getWithdrawalBalance(tx, withdrawal.MerchantID, withdrawal.Currency, wallet.Available)
+		if err != nil {
+			return err
+		}
+		if balance.Available < withdrawal.Amount {
+			code = http.StatusBadRequest
+			return fmt.Errorf("insufficient wallet balance, %v %v available to withdraw", balance.Available, balance.Currency)
+		}
 
-	err = withdrawal.CreateWithdrawal(db.MOR)
+		return withdrawal.CreateWithdrawal(tx)
+	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return code, err
 	}
 
+	NotifyMerchantWebhookEvent(extReq, db, withdrawal.MerchantID, models.MerchantWebhookWithdrawalRequested, withdrawal)
+
 	err = SlackNotify(extReq, config.GetConfig().Slack.WithdrawalChannelID, `
 	MOR WITHDRAWAL REQUEST FROM (`+strconv.Itoa(int(user.AccountID))+`) `+fmt.Sprintf("%v %v", user.Lastname, user.Firstname)+`
 	Currency: `+withdrawal.Currency+`
@@ -72,15 +83,34 @@ func RequestWithdrawalService(extReq request.ExternalRequest, db postgresql.Data
 	return http.StatusOK, nil
 }
 
+// GetWithdrawalBalanceService returns what the merchant can withdraw from the MOR_ wallet of the currency,
+// which is the wallet's available balance less the merchant's pending withdrawals in the currency
+func GetWithdrawalBalanceService(extReq request.ExternalRequest, db postgresql.Databases, accountID int, currency string) (models.WithdrawalBalance, int, error) {
+	currency = normalizeWithdrawalCurrency(currency)
+	if currency == "" {
+		return models.WithdrawalBalance{}, http.StatusBadRequest, fmt.Errorf("currency is required")
+	}
+
+	wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, accountID, fmt.Sprintf("MOR_%v", currency))
+	if err != nil {
+		return models.WithdrawalBalance{}, http.StatusBadRequest, err
+	}
+
+	balance, err := getWithdrawalBalance(db.MOR, int64(accountID), currency, wallet.Available)
+	if err != nil {
+		return balance, http.StatusInternalServerError, err
+	}
+
+	return balance, http.StatusOK, nil
+}
+
 func GetWithdrawalsService(extReq request.ExternalRequest, db postgresql.Databases, paginator postgresql.Pagination, req models.GetWithdrawalRequest) ([]models.Withdrawal, postgresql.PaginationResponse, int, error) {
 	var (
-		withdrawal = models.Withdrawal{Status: models.TransactionPending}
+		withdrawal = models.Withdrawal{Status: models.WithdrawalPending}
 	)
 
 	if req.CurrencyFilter != "" {
-		req.CurrencyFilter = strings.ToUpper(strings.ReplaceAll(strings.ToUpper(req.CurrencyFilter), "MOR_", ""))
-		req.CurrencyFilter = strings.ToUpper(strings.ReplaceAll(strings.ToUpper(req.CurrencyFilter), "ESCROW_", ""))
-		withdrawal.Currency = req.CurrencyFilter
+		withdrawal.Currency = normalizeWithdrawalCurrency(req.CurrencyFilter)
 	}
 
 	if req.Status != "" {
@@ -108,28 +138,176 @@ func GetWithdrawalsService(extReq request.ExternalRequest, db postgresql.Databas
 	return withdrawals, pagination, http.StatusOK, nil
 }
 
-func CompleteWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, withdrawalID int) (int, error) {
-	var (
-		withdrawal = models.Withdrawal{ID: uint(withdrawalID)}
-	)
+// ApproveWithdrawalService debits a pending withdrawal from the merchant's MOR_ wallet and transfers it to the merchant's bank,
+// approving a withdrawal whose transfer couldn't be started retries the transfer.
+// A withdrawal above its approval policy's threshold is queued for approval by other admins instead.
+func ApproveWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, withdrawalID int, adminID int) (models.Withdrawal, int, error) {
+	withdrawal, code, err := getWithdrawal(db, withdrawalID, 0)
+	if err != nil {
+		return withdrawal, code, err
+	}
 
-	code, err := withdrawal.GetWithdrawalByID(db.MOR)
+	if !withdrawal.Status.In([]models.TransactionStatus{models.WithdrawalPending, models.WithdrawalApproved}) {
+		return withdrawal, http.StatusBadRequest, fmt.Errorf("withdrawal %v is %v, only pending withdrawals can be approved", withdrawal.ID, withdrawal.Status)
+	}
+
+	code, err = requireWithdrawalApproval(db, models.ApprovalWithdrawalApprove, withdrawal, adminID)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	code, err = approveWithdrawal(extReq, db, &withdrawal)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	return withdrawal, http.StatusOK, nil
+}
+
+// RejectWithdrawalService turns down a withdrawal before its transfer starts, an approved withdrawal is credited back to the MOR_ wallet
+func RejectWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, withdrawalID int, req models.RejectWithdrawalRequest) (models.Withdrawal, int, error) {
+	withdrawal, code, err := getWithdrawal(db, withdrawalID, 0)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	// the provider may already have the transfer, crediting the wallet back could pay the merchant twice
+	if withdrawal.TransferReference != "" {
+		return withdrawal, http.StatusBadRequest, fmt.Errorf("withdrawal %v transfer %v was started, approve it again to retry or wait for the provider to fail it", withdrawal.ID, withdrawal.TransferReference)
+	}
+
+	code, err = updateWithdrawalStatus(extReq, db, &withdrawal, models.WithdrawalRejected, req.Reason, false)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	code, err = reverseWithdrawal(extReq, db, &withdrawal)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	return withdrawal, http.StatusOK, nil
+}
+
+// CancelWithdrawalService lets the merchant call off a withdrawal that wasn't approved yet
+func CancelWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, withdrawalID int, merchantID int, req models.CancelWithdrawalRequest) (models.Withdrawal, int, error) {
+	withdrawal, code, err := getWithdrawal(db, withdrawalID, merchantID)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	if withdrawal.Status != models.WithdrawalPending {
+		return withdrawal, http.StatusBadRequest, fmt.Errorf("withdrawal %v is %v, only pending withdrawals can be cancelled", withdrawal.ID, withdrawal.Status)
+	}
+
+	code, err = updateWithdrawalStatus(extReq, db, &withdrawal, models.WithdrawalCancelled, req.Reason, false)
+	if err != nil {
+		return withdrawal, code, err
+	}
+
+	return withdrawal, http.StatusOK, nil
+}
+
+// CompleteWithdrawalService confirms the transfer of an approved withdrawal by hand, for transfers whose provider webhook never arrived.
+// A withdrawal above its approval policy's threshold is queued for approval by other admins instead.
+func CompleteWithdrawalService(extReq request.ExternalRequest, db postgresql.Databases, withdrawalID int, adminID int) (int, error) {
+	withdrawal, code, err := getWithdrawal(db, withdrawalID, 0)
 	if err != nil {
 		return code, err
 	}
 
-	_, err = services.DebitWallet(extReq, db, withdrawal.Amount, withdrawal.Currency, int(withdrawal.MerchantID), "no", "yes", "")
+	if !models.WithdrawalSuccessful.In(models.WithdrawalTransitions[withdrawal.Status]) {
+		return http.StatusBadRequest, fmt.Errorf("withdrawal %v is %v, it can't move to %v", withdrawal.ID, withdrawal.Status, models.WithdrawalSuccessful)
+	}
+
+	code, err = requireWithdrawalApproval(db, models.ApprovalWithdrawalComplete, withdrawal, adminID)
+	if err != nil {
+		return code, err
+	}
+
+	return updateWithdrawalStatus(extReq, db, &withdrawal, models.WithdrawalSuccessful, "", false)
+}
+
+// approveWithdrawal debits a pending withdrawal, records it in the ledger and starts its transfer
+func approveWithdrawal(extReq request.ExternalRequest, db postgresql.Databases, withdrawal *models.Withdrawal) (int, error) {
+	switch withdrawal.Status {
+	case models.WithdrawalPending:
+		code, err := debitWithdrawal(extReq, db, withdrawal)
+		if err != nil {
+			return code, err
+		}
+	case models.WithdrawalApproved:
+	default:
+		return http.StatusBadRequest, fmt.Errorf("withdrawal %v is %v, only pending withdrawals can be approved", withdrawal.ID, withdrawal.Status)
+	}
+
+	err := ledger.RecordWithdrawal(db, *withdrawal)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 
-	withdrawal.Status = models.TransactionSuccessful
-	err = withdrawal.UpdateAllFields(db.MOR)
+	return disburseWithdrawal(extReq, db, withdrawal)
+}
+
+// requireWithdrawalApproval queues the action on the withdrawal for approval when its amount needs more than one admin
+func requireWithdrawalApproval(db postgresql.Databases, action models.ApprovalAction, withdrawal models.Withdrawal, adminID int) (int, error) {
+	required, err := getRequiredApprovals(db, action, withdrawal.Amount, withdrawal.Currency)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 
-	return http.StatusOK, nil
+	return requireApproval(db, models.ApprovalRequest{
+		Action:            action,
+		ResourceID:        int64(withdrawal.ID),
+		Amount:            withdrawal.Amount,
+		Currency:          withdrawal.Currency,
+		RequiredApprovals: required,
+		RequestedBy:       int64(adminID),
+	})
+}
+
+func getWithdrawal(db postgresql.Databases, withdrawalID int, merchantID int) (models.Withdrawal, int, error) {
+	withdrawal := models.Withdrawal{ID: uint(withdrawalID)}
+	code, err := withdrawal.GetWithdrawalByID(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return withdrawal, code, err
+		}
+		return withdrawal, code, fmt.Errorf("withdrawal with id %v not found", withdrawalID)
+	}
+
+	if merchantID != 0 && withdrawal.MerchantID != int64(merchantID) {
+		return withdrawal, http.StatusBadRequest, fmt.Errorf("withdrawal with id %v not found", withdrawalID)
+	}
+
+	return withdrawal, http.StatusOK, nil
+}
+
+func getWithdrawalBalance(db *gorm.DB, merchantID int64, currency string, walletBalance float64) (models.WithdrawalBalance, error) {
+	withdrawal := models.Withdrawal{MerchantID: merchantID, Currency: currency}
+	pending, err := withdrawal.GetPendingWithdrawalsTotal(db)
+	if err != nil {
+		return models.WithdrawalBalance{}, err
+	}
+
+	available := roundAmount(walletBalance - pending)
+	if available < 0 {
+		available = 0
+	}
+
+	return models.WithdrawalBalance{
+		Currency:           currency,
+		WalletBalance:      walletBalance,
+		PendingWithdrawals: pending,
+		Available:          available,
+	}, nil
+}
+
+// normalizeWithdrawalCurrency turns a currency or a MOR_/ESCROW_ wallet name into the bare currency code
+func normalizeWithdrawalCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	currency = strings.ReplaceAll(currency, "MOR_", "")
+	return strings.ReplaceAll(currency, "ESCROW_", "")
 }
 
 func GetMorWithdrawalsDetails(extReq request.ExternalRequest, db postgresql.Databases, withdrawals []models.Withdrawal) ([]models.Withdrawal, error) {
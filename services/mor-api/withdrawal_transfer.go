@@ -0,0 +1,271 @@
+package mor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
+	"github.com/vesicash/mor-api/services/providers"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+const (
+	// withdrawalProviderMonnify and withdrawalProviderFlutterwave disburse withdrawals, they are named like their webhook providers
+	withdrawalProviderMonnify     = "monnify"
+	withdrawalProviderFlutterwave = "flutterwave"
+)
+
+// debitWithdrawal debits the pending withdrawal from the MOR_ wallet and then approves it. The withdrawal counts as pending until it is approved,
+// so withdrawal requests never see the wallet debited while the withdrawal is left out. The debit is keyed on the withdrawal, so approving again
+// after a failed update doesn't debit twice, and a withdrawal rejected or cancelled while it was debited gets the debit back.
+func debitWithdrawal(extReq request.ExternalRequest, db postgresql.Databases, withdrawal *models.Withdrawal) (int, error) {
+	reference := fmt.Sprintf("withdrawal-%v", withdrawal.ID)
+	_, err := services.DebitWallet(extReq, db, withdrawal.Amount, withdrawal.Currency, int(withdrawal.MerchantID), "no", "yes", reference)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error debiting mor wallet %v for withdrawal %v: %v", withdrawal.Currency, withdrawal.ID, err.Error())
+	}
+
+	updated, err := withdrawal.UpdateWithdrawalStatus(db.MOR, models.WithdrawalPending, map[string]interface{}{
+		"status":      models.WithdrawalApproved,
+		"approved_at": time.Now(),
+	})
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("withdrawal %v was debited with reference %v but not approved, approve it again or reconcile the debit: %v", withdrawal.ID, reference, err.Error()))
+		return http.StatusInternalServerError, err
+	}
+	if updated {
+		return withdrawal.GetWithdrawalByID(db.MOR)
+	}
+
+	code, err := withdrawal.GetWithdrawalByID(db.MOR)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("withdrawal %v changed after its debit %v, reconcile the debit: %v", withdrawal.ID, reference, err.Error()))
+		return code, err
+	}
+	// an approval that ran meanwhile owns the debit, anything else turned the withdrawal down
+	if !withdrawal.Status.In(models.WithdrawalDebitedStatuses) {
+		_, err = services.CreditWallet(extReq, db, withdrawal.Amount, withdrawal.Currency, int(withdrawal.MerchantID), false, "no", "yes", fmt.Sprintf("withdrawal-%v-reversal", withdrawal.ID))
+		if err != nil {
+			extReq.Logger.Error(fmt.Sprintf("error returning debit %v of withdrawal %v, reconcile the debit: %v", reference, withdrawal.ID, err.Error()))
+		}
+	}
+
+	return http.StatusBadRequest, fmt.Errorf("withdrawal %v changed meanwhile, try again", withdrawal.ID)
+}
+
+// disburseWithdrawal transfers an approved withdrawal to the merchant's bank account in its currency.
+// The transfer reference is stored before the transfer starts, so retrying a transfer the provider may have received reuses it
+// and the provider turns the duplicate down instead of paying the merchant twice.
+func disburseWithdrawal(extReq request.ExternalRequest, db postgresql.Databases, withdrawal *models.Withdrawal) (int, error) {
+	bankDetail, err := services.GetBankDetail(extReq, int(withdrawal.BankDetailID), int(withdrawal.MerchantID), "", withdrawal.Currency)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("error getting %v bank account of merchant %v: %v", withdrawal.Currency, withdrawal.MerchantID, err.Error())
+	}
+
+	bank, err := services.GetBank(extReq, bankDetail.BankID, "", "", "")
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error getting bank with id %v: %v", bankDetail.BankID, err.Error())
+	}
+
+	if withdrawal.TransferReference == "" {
+		updates := map[string]interface{}{
+			"bank_detail_id":     bankDetail.ID,
+			"provider":           getWithdrawalProvider(withdrawal.Currency),
+			"transfer_reference": utility.RandomString(25),
+		}
+		updated, err := withdrawal.UpdateWithdrawalStatus(db.MOR, models.WithdrawalApproved, updates)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !updated {
+			return http.StatusBadRequest, fmt.Errorf("withdrawal %v changed meanwhile, try again", withdrawal.ID)
+		}
+
+		code, err := withdrawal.GetWithdrawalByID(db.MOR)
+		if err != nil {
+			return code, err
+		}
+	}
+
+	var (
+		status    string
+		narration = fmt.Sprintf("MOR withdrawal %v", withdrawal.ID)
+	)
+	switch withdrawal.Provider {
+	case withdrawalProviderMonnify:
+		transfer, err := services.MonnifyInitTransfer(extReq, external_models.MonnifyInitTransferRequest{
+			Amount:                   withdrawal.Amount,
+			Reference:                withdrawal.TransferReference,
+			Narration:                narration,
+			DestinationBankCode:      bank.Code,
+			DestinationAccountNumber: bankDetail.AccountNo,
+			Currency:                 withdrawal.Currency,
+			SourceAccountNumber:      config.GetConfig().Monnify.MonnifyDisbursementAccount,
+			DestinationAccountName:   bankDetail.AccountName,
+		})
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("error starting monnify transfer %v of withdrawal %v, approve it again to retry: %v", withdrawal.TransferReference, withdrawal.ID, err.Error())
+		}
+		status = transfer.Status
+	default:
+		transfer, err := services.RaveInitTransfer(extReq, external_models.RaveInitTransferRequest{
+			AccountBank:     bank.Code,
+			AccountNumber:   bankDetail.AccountNo,
+			Amount:          withdrawal.Amount,
+			Narration:       narration,
+			Currency:        withdrawal.Currency,
+			BeneficiaryName: bankDetail.AccountName,
+			Reference:       withdrawal.TransferReference,
+			DebitCurrency:   withdrawal.Currency,
+			CallbackUrl:     fmt.Sprintf("%v/v2/webhook/%v", config.GetConfig().App.Url, withdrawal.MerchantID),
+		})
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("error starting flutterwave transfer %v of withdrawal %v, approve it again to retry: %v", withdrawal.TransferReference, withdrawal.ID, err.Error())
+		}
+		status = transfer.Status
+	}
+
+	code, err := updateWithdrawalStatus(extReq, db, withdrawal, getWithdrawalTransferStatus(status), "", false)
+	if err != nil {
+		return code, err
+	}
+
+	return reverseWithdrawal(extReq, db, withdrawal)
+}
+
+// updateWithdrawalStatus moves the withdrawal along its lifecycle. A debited withdrawal that is rejected or whose transfer failed
+// is marked for reversal, reverseWithdrawal credits it back to the MOR_ wallet once the caller's transaction committed.
+// A dry run updates the withdrawal without notifying the merchant.
+func updateWithdrawalStatus(extReq request.ExternalRequest, db postgresql.Databases, withdrawal *models.Withdrawal, status models.TransactionStatus, reason string, dryRun bool) (int, error) {
+	if withdrawal.Status == status {
+		return http.StatusOK, nil
+	}
+	if !status.In(models.WithdrawalTransitions[withdrawal.Status]) {
+		return http.StatusBadRequest, fmt.Errorf("withdrawal %v is %v, it can't move to %v", withdrawal.ID, withdrawal.Status, status)
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if reason != "" {
+		updates["reason"] = reason
+	}
+	if withdrawal.Status.In(models.WithdrawalDebitedStatuses) && !status.In(models.WithdrawalDebitedStatuses) {
+		updates["reversal_status"] = models.WithdrawalReversalPending
+	}
+	if status.In([]models.TransactionStatus{models.WithdrawalSuccessful, models.WithdrawalFailed}) {
+		updates["completed_at"] = time.Now()
+	}
+
+	updated, err := withdrawal.UpdateWithdrawalStatus(db.MOR, withdrawal.Status, updates)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !updated {
+		return http.StatusBadRequest, fmt.Errorf("withdrawal %v changed meanwhile, try again", withdrawal.ID)
+	}
+
+	code, err := withdrawal.GetWithdrawalByID(db.MOR)
+	if err != nil {
+		return code, err
+	}
+
+	if dryRun {
+		return http.StatusOK, nil
+	}
+
+	switch status {
+	case models.WithdrawalSuccessful:
+		NotifyMerchantWebhookEvent(extReq, db, withdrawal.MerchantID, models.MerchantWebhookWithdrawalCompleted, *withdrawal)
+	case models.WithdrawalFailed:
+		NotifyMerchantWebhookEvent(extReq, db, withdrawal.MerchantID, models.MerchantWebhookWithdrawalFailed, *withdrawal)
+	case models.WithdrawalRejected:
+		NotifyMerchantWebhookEvent(extReq, db, withdrawal.MerchantID, models.MerchantWebhookWithdrawalRejected, *withdrawal)
+	}
+
+	return http.StatusOK, nil
+}
+
+// reverseWithdrawal credits the debit of a withdrawal marked for reversal back to the MOR_ wallet. The credit is keyed on the withdrawal,
+// so reversing again after a failed update can't credit twice, and only the run that settles the reversal records it.
+func reverseWithdrawal(extReq request.ExternalRequest, db postgresql.Databases, withdrawal *models.Withdrawal) (int, error) {
+	if withdrawal.ReversalStatus != models.WithdrawalReversalPending {
+		return http.StatusOK, nil
+	}
+
+	_, err := services.CreditWallet(extReq, db, withdrawal.Amount, withdrawal.Currency, int(withdrawal.MerchantID), false, "no", "yes", fmt.Sprintf("withdrawal-%v-reversal", withdrawal.ID))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error returning withdrawal %v to mor wallet %v: %v", withdrawal.ID, withdrawal.Currency, err.Error())
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		reversed, err := withdrawal.SettleWithdrawalReversal(tx)
+		if err != nil || !reversed {
+			return err
+		}
+
+		txDb := db
+		txDb.MOR = tx
+		return ledger.RecordWithdrawalReversal(txDb, *withdrawal)
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return withdrawal.GetWithdrawalByID(db.MOR)
+}
+
+// applyWithdrawalTransferEvent applies the provider's outcome of a withdrawal's transfer, outdated events are ignored
+func applyWithdrawalTransferEvent(extReq request.ExternalRequest, db postgresql.Databases, withdrawal models.Withdrawal, event providers.WebhookEvent, dryRun bool) error {
+	if !strings.EqualFold(withdrawal.Provider, event.Provider) {
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, transfer %v belongs to withdrawal %v disbursed by %v, ignored", event.Provider, event.Reference, withdrawal.ID, withdrawal.Provider))
+		return nil
+	}
+
+	var (
+		status = models.WithdrawalProcessing
+		reason string
+	)
+	switch event.Status {
+	case models.TransactionSuccessful:
+		status = models.WithdrawalSuccessful
+	case models.TransactionFailed, models.TransactionReversed:
+		status = models.WithdrawalFailed
+		reason = event.Description
+	}
+
+	if status != withdrawal.Status && !status.In(models.WithdrawalTransitions[withdrawal.Status]) {
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, withdrawal %v is %v, transfer %v status %v ignored", event.Provider, withdrawal.ID, withdrawal.Status, event.Reference, event.Status))
+		return nil
+	}
+
+	_, err := updateWithdrawalStatus(extReq, db, &withdrawal, status, reason, dryRun)
+	return err
+}
+
+// getWithdrawalProvider disburses naira through Monnify and every other currency through Flutterwave
+func getWithdrawalProvider(currency string) string {
+	if strings.EqualFold(currency, "NGN") {
+		return withdrawalProviderMonnify
+	}
+	return withdrawalProviderFlutterwave
+}
+
+// getWithdrawalTransferStatus maps the status a provider gives a transfer it just accepted, most are still in progress
+func getWithdrawalTransferStatus(status string) models.TransactionStatus {
+	switch strings.ToUpper(status) {
+	case "SUCCESS", "SUCCESSFUL", "COMPLETED":
+		return models.WithdrawalSuccessful
+	case "FAILED", "REVERSED":
+		return models.WithdrawalFailed
+	default:
+		return models.WithdrawalProcessing
+	}
+}
@@ -0,0 +1,349 @@
+package providers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/disputes"
+	"github.com/vesicash/mor-api/services/ledger"
+	"github.com/vesicash/mor-api/services/refunds"
+	"github.com/vesicash/mor-api/services/tax"
+)
+
+type WebhookEventType string
+
+var (
+	WebhookEventCharge     WebhookEventType = "charge"
+	WebhookEventChargeback WebhookEventType = "chargeback"
+	WebhookEventRefund     WebhookEventType = "refund"
+	WebhookEventTransfer   WebhookEventType = "transfer"
+)
+
+// WebhookProvider is implemented by every payment collector that can notify us about merchant payments.
+// Providers register themselves with RegisterWebhookProvider from an init function.
+type WebhookProvider interface {
+	// Name is the provider name stored on webhook logs, e.g. "flutterwave"
+	Name() string
+	// Detect reports whether the request was sent by this provider
+	Detect(c *gin.Context) bool
+	// VerifySignature checks that the request body was signed by this provider
+	VerifySignature(c *gin.Context, requestBody []byte) error
+	// Parse converts the provider payload into a normalized event
+	Parse(requestBody []byte) (WebhookEvent, error)
+	// Apply writes the normalized event to the database for the merchant with accountID.
+	// A dry run only writes to db, it never moves money in wallets or notifies anyone.
+	Apply(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent, dryRun bool) error
+}
+
+type WebhookEvent struct {
+	Provider      string
+	Type          WebhookEventType
+	ProviderEvent string
+	EventID       string
+	Reference     string
+	Description   string
+	Amount        float64
+	ProcessingFee float64
+	Currency      string
+	PaymentMethod models.PaymentMethod
+	// Status is the status of the transaction, or of the refund itself for refund events
+	Status     models.TransactionStatus
+	OccurredAt time.Time
+	Customer   *WebhookEventCustomer
+	// DisputeStatus is the provider's decision on a chargeback, empty while it is only opened
+	DisputeStatus models.DisputeStatus
+	// DueAt is when evidence against a chargeback is due
+	DueAt time.Time
+}
+
+// IdempotencyKey identifies a delivery of this event for a merchant, retried deliveries share the same key
+func (e WebhookEvent) IdempotencyKey() string {
+	id := e.EventID
+	if id == "" {
+		id = e.Reference
+	}
+	if id == "" {
+		return ""
+	}
+	return e.ProviderEvent + ":" + id
+}
+
+type WebhookEventCustomer struct {
+	Email       string
+	Firstname   string
+	Lastname    string
+	PhoneNumber string
+}
+
+var (
+	webhookProviders      = []WebhookProvider{}
+	webhookProvidersMutex sync.RWMutex
+)
+
+func RegisterWebhookProvider(provider WebhookProvider) {
+	webhookProvidersMutex.Lock()
+	defer webhookProvidersMutex.Unlock()
+
+	for _, p := range webhookProviders {
+		if p.Name() == provider.Name() {
+			panic("providers: RegisterWebhookProvider called twice for provider " + provider.Name())
+		}
+	}
+	webhookProviders = append(webhookProviders, provider)
+}
+
+// DetectWebhookProvider returns the registered provider that sent the request, or nil when none matches
+func DetectWebhookProvider(c *gin.Context) WebhookProvider {
+	webhookProvidersMutex.RLock()
+	defer webhookProvidersMutex.RUnlock()
+
+	for _, p := range webhookProviders {
+		if p.Detect(c) {
+			return p
+		}
+	}
+	return nil
+}
+
+func GetWebhookProviderByName(name string) (WebhookProvider, error) {
+	webhookProvidersMutex.RLock()
+	defer webhookProvidersMutex.RUnlock()
+
+	for _, p := range webhookProviders {
+		if strings.EqualFold(p.Name(), name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook provider %v not found", name)
+}
+
+// webhookApplier gives providers the default mapping of normalized events into customers and transactions
+type webhookApplier struct{}
+
+func (webhookApplier) Apply(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent, dryRun bool) error {
+	switch event.Type {
+	case WebhookEventCharge:
+		return applyChargeWebhookEvent(extReq, db, accountID, event)
+	case WebhookEventChargeback:
+		return applyChargebackWebhookEvent(extReq, db, accountID, event, dryRun)
+	case WebhookEventRefund:
+		return applyRefundWebhookEvent(extReq, db, accountID, event, dryRun)
+	case WebhookEventTransfer:
+		return applyTransferWebhookEvent(extReq, db, accountID, event)
+	default:
+		return fmt.Errorf("webhook event type %v, not supported", event.Type)
+	}
+}
+
+func applyChargeWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent) error {
+	var (
+		customer = models.Customer{AccountID: int64(accountID)}
+	)
+
+	if event.Customer != nil {
+		customer.Email = event.Customer.Email
+		customer.Firstname = event.Customer.Firstname
+		customer.Lastname = event.Customer.Lastname
+		customer.PhoneNumber = event.Customer.PhoneNumber
+	}
+
+	err := getOrCreateCustomer(db, &customer)
+	if err != nil {
+		return err
+	}
+
+	paymentHistory, err := getWebhookEventPaymentHistory(extReq, db, accountID, event)
+	if err != nil {
+		return err
+	}
+	paymentHistory.CustomerID = int64(customer.ID)
+
+	err = paymentHistory.CreateTransaction(db.MOR)
+	if err != nil {
+		return err
+	}
+
+	if paymentHistory.Status == models.TransactionSuccessful {
+		err = ledger.RecordTransactionCapture(db, paymentHistory, event.Currency)
+		if err != nil {
+			return err
+		}
+
+		customer.NumberOfPayments += 1
+		if !event.OccurredAt.IsZero() {
+			customer.LastPaymentMadeAt = event.OccurredAt
+		}
+	}
+
+	err = customer.UpdateAllFields(db.MOR)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// applyRefundWebhookEvent records a completed provider refund of event.Amount like a refund made through the refund api,
+// the transaction is only refunded in full once its whole amount was refunded
+func applyRefundWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent, dryRun bool) error {
+	transaction := models.Transaction{MerchantID: int64(accountID), Reference: event.Reference}
+	code, err := transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return err
+		}
+		return fmt.Errorf("transaction with reference %v not found", event.Reference)
+	}
+
+	if event.Status != models.TransactionSuccessful {
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, refund %v of transaction %v is %v, ignored", event.Provider, event.EventID, event.Reference, event.Status))
+		return nil
+	}
+
+	amount := event.Amount
+	if amount == 0 {
+		amount = transaction.Amount - transaction.RefundedAmount
+	}
+
+	// a refund taking more than is left was already recorded, like a refund made through the refund api that the provider only confirms
+	if transaction.Status != models.TransactionSuccessful || math.Round((transaction.Amount-transaction.RefundedAmount-amount)*100) < 0 {
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, transaction %v is %v with %v refunded, refund %v of %v ignored", event.Provider, event.Reference, transaction.Status, transaction.RefundedAmount, event.EventID, amount))
+		return nil
+	}
+
+	refund := models.Refund{Amount: amount, Reason: event.Description, InitiatedBy: models.RefundInitiatedByProvider}
+	if event.EventID != "" {
+		refund.Reference = fmt.Sprintf("%v-%v", event.Provider, event.EventID)
+	}
+
+	_, _, err = refunds.CreateRefund(extReq, db, transaction, refund, dryRun)
+	return err
+}
+
+// applyChargebackWebhookEvent opens a dispute for the first event of a chargeback and moves it along with the later ones
+func applyChargebackWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent, dryRun bool) error {
+	transaction := models.Transaction{MerchantID: int64(accountID), Reference: event.Reference}
+	code, err := transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return err
+		}
+		return fmt.Errorf("transaction with reference %v not found", event.Reference)
+	}
+
+	providerReference := event.EventID
+	if providerReference == "" {
+		providerReference = event.Reference
+	}
+
+	dispute := models.Dispute{Provider: event.Provider, ProviderReference: providerReference}
+	code, err = dispute.GetDisputeByProviderReference(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return err
+		}
+
+		dispute = models.Dispute{
+			Provider:          event.Provider,
+			ProviderReference: providerReference,
+			Reason:            event.Description,
+			Amount:            event.Amount,
+			EvidenceDueAt:     event.DueAt,
+		}
+		dispute, _, err = disputes.OpenDispute(extReq, db, transaction, dispute, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	if event.DisputeStatus == "" || event.DisputeStatus == dispute.Status {
+		return nil
+	}
+
+	if !event.DisputeStatus.In(models.DisputeTransitions[dispute.Status]) {
+		extReq.Logger.Info(fmt.Sprintf("dispute %v is %v, ignoring %v chargeback event %v", dispute.ID, dispute.Status, event.Provider, event.ProviderEvent))
+		return nil
+	}
+
+	_, err = disputes.UpdateDisputeStatus(extReq, db, &dispute, event.DisputeStatus, event.Description, dryRun)
+	return err
+}
+
+// applyTransferWebhookEvent updates the status of a transfer recorded as a transaction, transfers we don't know about are ignored
+func applyTransferWebhookEvent(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent) error {
+	transaction := models.Transaction{MerchantID: int64(accountID), Reference: event.Reference}
+	code, err := transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return err
+		}
+		extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, transfer %v matches no transaction of merchant %v, ignored", event.Provider, event.Reference, accountID))
+		return nil
+	}
+
+	transaction.Status = event.Status
+	return transaction.UpdateAllFields(db.MOR)
+}
+
+func getWebhookEventPaymentHistory(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent) (models.Transaction, error) {
+	var (
+		paymentHistory = models.Transaction{
+			MerchantID:      int64(accountID),
+			Reference:       event.Reference,
+			Description:     event.Description,
+			Amount:          event.Amount,
+			ProcessingFee:   event.ProcessingFee,
+			PaymentMethod:   event.PaymentMethod,
+			Status:          event.Status,
+			TransactionDate: event.OccurredAt,
+		}
+	)
+
+	if paymentHistory.Status == "" {
+		paymentHistory.Status = models.TransactionPending
+	}
+
+	if event.Currency != "" {
+		country, err := services.GetCountryByCurrency(extReq, extReq.Logger, strings.ToUpper(event.Currency))
+		if err != nil {
+			return models.Transaction{}, fmt.Errorf("%v webhook log error, error getting country for currency %v, %v", event.Provider, event.Currency, err.Error())
+		}
+		paymentHistory.CountryID = int64(country.ID)
+
+		err = tax.ApplyTransactionTax(extReq, db, &paymentHistory)
+		if err != nil {
+			return models.Transaction{}, fmt.Errorf("%v webhook log error, error calculating tax, %v", event.Provider, err.Error())
+		}
+	}
+
+	return paymentHistory, nil
+}
+
+func getOrCreateCustomer(db postgresql.Databases, customer *models.Customer) error {
+	code, err := customer.GetCustomerByAccountIDAndEmail(db.MOR)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return err
+		}
+
+		err := customer.CreateCustomer(db.MOR)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWebhookTime reads an RFC 3339 timestamp of a webhook, with or without fractional seconds and in any offset
+func parseWebhookTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
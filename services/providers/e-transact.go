@@ -1,11 +1,168 @@
 package providers
 
 import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"strings"
+
 	"github.com/gin-gonic/gin"
-	"github.com/vesicash/mor-api/external/request"
-	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/utility"
 )
 
-func HandleETransactMerchantWebhook(c *gin.Context, extReq request.ExternalRequest, db postgresql.Databases, requestBody []byte) error {
+type eTransactProvider struct {
+	webhookApplier
+}
+
+func init() {
+	RegisterWebhookProvider(eTransactProvider{})
+}
+
+func (eTransactProvider) Name() string {
+	return "e-transact"
+}
+
+func (eTransactProvider) Detect(c *gin.Context) bool {
+	return utility.GetHeader(c, "x-etranzact-signature") != ""
+}
+
+func (eTransactProvider) VerifySignature(c *gin.Context, requestBody []byte) error {
+	signature := utility.Sha256Hmac(config.GetConfig().ETranzact.SecretKey, requestBody)
+	if !hmac.Equal([]byte(signature), []byte(utility.GetHeader(c, "x-etranzact-signature"))) {
+		return fmt.Errorf("signature doesn't match")
+	}
 	return nil
 }
+
+func (p eTransactProvider) Parse(requestBody []byte) (WebhookEvent, error) {
+	var (
+		req models.ETransactWebhookRequest
+	)
+
+	err := json.Unmarshal(requestBody, &req)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	switch req.Event {
+	case "payment.notification":
+		return getETransactEventForPaymentNotification(p.Name(), req)
+	default:
+		return WebhookEvent{}, fmt.Errorf("event type %v, not implemented", req.Event)
+	}
+}
+
+func getETransactEventForPaymentNotification(provider string, req models.ETransactWebhookRequest) (WebhookEvent, error) {
+	var (
+		data  models.ETransactWebhookRequestData
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventCharge,
+			ProviderEvent: req.Event,
+		}
+	)
+
+	if req.Data != nil {
+		data = *req.Data
+	}
+
+	if data.Customer != nil {
+		event.Customer = &WebhookEventCustomer{}
+		if data.Customer.Email != nil {
+			event.Customer.Email = *data.Customer.Email
+		}
+		if data.Customer.PhoneNumber != nil {
+			event.Customer.PhoneNumber = *data.Customer.PhoneNumber
+		}
+		if data.Customer.FirstName != nil {
+			event.Customer.Firstname = *data.Customer.FirstName
+		}
+		if data.Customer.LastName != nil {
+			event.Customer.Lastname = *data.Customer.LastName
+		}
+	}
+
+	if data.TransactionID != nil {
+		event.EventID = *data.TransactionID
+	}
+
+	if data.Reference != nil {
+		event.Reference = *data.Reference
+	} else if data.TransactionID != nil {
+		event.Reference = *data.TransactionID
+	}
+
+	if data.Description != nil {
+		event.Description = *data.Description
+	}
+
+	if data.Amount != nil {
+		event.Amount = *data.Amount
+	}
+
+	if data.Fee != nil {
+		event.ProcessingFee = *data.Fee
+	}
+
+	if data.Channel != nil {
+		event.PaymentMethod = getETransactPaymentMethod(*data.Channel)
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	if data.PaidAt != nil {
+		t, err := parseWebhookTime(*data.PaidAt)
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("eTranzact webhook log error, error parsing data.PaidAt, %v, %v", *data.PaidAt, err.Error())
+		}
+		event.OccurredAt = t
+	}
+
+	event.Status = getETransactPaymentStatus(data)
+
+	return event, nil
+}
+
+// eTranzact reports "0" (or "00") as the response code of an approved payment
+func getETransactPaymentStatus(data models.ETransactWebhookRequestData) models.TransactionStatus {
+	if data.ResponseCode != nil {
+		switch *data.ResponseCode {
+		case "0", "00":
+			return models.TransactionSuccessful
+		default:
+			return models.TransactionFailed
+		}
+	}
+
+	if data.Status != nil {
+		switch strings.ToLower(*data.Status) {
+		case "successful", "success":
+			return models.TransactionSuccessful
+		case "failed":
+			return models.TransactionFailed
+		}
+	}
+
+	return models.TransactionPending
+}
+
+func getETransactPaymentMethod(channel string) models.PaymentMethod {
+	switch strings.ToLower(channel) {
+	case "card":
+		return models.CardMethod
+	case "account":
+		return models.AccountMethod
+	case "transfer", "bank_transfer":
+		return models.BankTransferMethod
+	case "ussd":
+		return models.UssdMethod
+	case "qr":
+		return models.NqrMethod
+	default:
+		return models.PaymentMethod(channel)
+	}
+}
@@ -3,133 +3,359 @@ package providers
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
 	"github.com/vesicash/mor-api/internal/models"
-	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/utility"
 )
 
-func HandleFlutterwaveMerchantWebhook(c *gin.Context, extReq request.ExternalRequest, db postgresql.Databases, requestBody []byte) error {
+type flutterwaveProvider struct {
+	webhookApplier
+}
+
+func init() {
+	RegisterWebhookProvider(flutterwaveProvider{})
+}
+
+func (flutterwaveProvider) Name() string {
+	return "flutterwave"
+}
+
+func (flutterwaveProvider) Detect(c *gin.Context) bool {
+	return utility.GetHeader(c, "verif-hash") != ""
+}
+
+func (flutterwaveProvider) VerifySignature(c *gin.Context, requestBody []byte) error {
+	if config.GetConfig().Rave.WebhookSecret != utility.GetHeader(c, "verif-hash") {
+		return fmt.Errorf("secret key doesn't match")
+	}
+	return nil
+}
+
+func (p flutterwaveProvider) Parse(requestBody []byte) (WebhookEvent, error) {
 	var (
-		req            models.FlutterwaveWebhookRequest
-		data           models.FlutterwaveWebhookRequestData
-		customer       models.Customer
-		paymentHistory models.Transaction
-		accountIDStr   = c.Param("account_id")
+		req models.FlutterwaveWebhookRequestEvent
 	)
 
-	accountID, err := strconv.Atoi(accountIDStr)
+	err := json.Unmarshal(requestBody, &req)
 	if err != nil {
-		return fmt.Errorf("incorrect account_id: %v", err.Error())
+		return WebhookEvent{}, err
 	}
 
-	err = json.Unmarshal(requestBody, &req)
-	if err != nil {
-		return err
+	switch {
+	case req.Event == "charge.completed":
+		var chargeReq models.FlutterwaveWebhookRequest
+		err := json.Unmarshal(requestBody, &chargeReq)
+		if err != nil {
+			return WebhookEvent{}, err
+		}
+		return getFlutterwaveEventForChargeCompleted(p.Name(), chargeReq)
+	case req.Event == "transfer.completed":
+		return getFlutterwaveEventForTransferCompleted(p.Name(), req)
+	case req.Event == "refund.completed":
+		return getFlutterwaveEventForRefundCompleted(p.Name(), req)
+	case strings.HasPrefix(req.Event, "chargeback."):
+		return getFlutterwaveEventForChargeback(p.Name(), req)
+	default:
+		return WebhookEvent{}, fmt.Errorf("event type %v, not implemented", req.Event)
 	}
+}
+
+func getFlutterwaveEventForChargeCompleted(provider string, req models.FlutterwaveWebhookRequest) (WebhookEvent, error) {
+	var (
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventCharge,
+			ProviderEvent: req.Event,
+			Status:        models.TransactionPending,
+		}
+		data models.FlutterwaveWebhookRequestData
+	)
 
 	if req.Data != nil {
 		data = *req.Data
 	}
 
 	if data.Customer != nil {
-		customer.AccountID = int64(accountID)
+		event.Customer = &WebhookEventCustomer{}
 		if data.Customer.Email != nil {
-			customer.Email = *data.Customer.Email
+			event.Customer.Email = *data.Customer.Email
 		}
 		if data.Customer.PhoneNumber != nil {
-			customer.PhoneNumber = *data.Customer.PhoneNumber
+			event.Customer.PhoneNumber = *data.Customer.PhoneNumber
 		}
 
 		if data.Customer.Name != nil {
-			namesSlice := strings.Split(*data.Customer.Name, "")
-			customer.Lastname = namesSlice[0]
+			namesSlice := strings.SplitN(strings.TrimSpace(*data.Customer.Name), " ", 2)
+			event.Customer.Lastname = namesSlice[0]
 			if len(namesSlice) > 1 {
-				customer.Firstname = namesSlice[1]
+				event.Customer.Firstname = namesSlice[1]
 			}
 		}
+	}
 
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
 	}
 
-	code, err := customer.GetCustomerByAccountIDAndEmail(db.MOR)
-	if err != nil {
-		if code == http.StatusInternalServerError {
-			return err
-		}
+	if data.TxRef != nil {
+		event.Reference = *data.TxRef
+	}
+	if data.Narration != nil {
+		event.Description = *data.Narration
+	}
 
-		err := customer.CreateCustomer(db.MOR)
+	if data.Amount != nil {
+		event.Amount = *data.Amount
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	if data.PaymentType != nil {
+		event.PaymentMethod = getFlutterwavePaymentMethod(*data.PaymentType)
+	}
+
+	if data.CreatedAt != nil {
+		t, err := parseWebhookTime(*data.CreatedAt)
 		if err != nil {
-			return err
+			return WebhookEvent{}, fmt.Errorf("Flutterwave webhhook log error, error parsing data.DateCreated, %v, %v", *data.CreatedAt, err.Error())
 		}
+		event.OccurredAt = t
 	}
 
-	switch req.Event {
-	case "charge.completed":
-		paymentHistory, err = getFlutterwavePaymentHistoryForChargeCompleted(req, &customer)
-	default:
-		return fmt.Errorf("event type %v, not implemented", req.Event)
+	if data.Status != nil {
+		switch *data.Status {
+		case "successful":
+			event.Status = models.TransactionSuccessful
+		case "failed":
+			event.Status = models.TransactionFailed
+		}
 	}
 
-	if err != nil {
-		return err
+	return event, nil
+}
+
+func getFlutterwaveEventForTransferCompleted(provider string, req models.FlutterwaveWebhookRequestEvent) (WebhookEvent, error) {
+	var (
+		data  models.FlutterwaveWebhookRequestTransfer
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventTransfer,
+			ProviderEvent: req.Event,
+			Status:        models.TransactionPending,
+		}
+		rawData = req.Data
+	)
+
+	// older integrations send the transfer under "transfer" instead of "data"
+	if len(req.Transfer) > 0 && string(req.Transfer) != "null" {
+		rawData = req.Transfer
 	}
 
-	err = paymentHistory.CreateTransaction(db.MOR)
+	err := json.Unmarshal(rawData, &data)
 	if err != nil {
-		return err
+		return WebhookEvent{}, err
+	}
+
+	if data.Reference == nil {
+		return WebhookEvent{}, fmt.Errorf("Flutterwave webhook log error, transfer has no reference")
 	}
+	event.Reference = *data.Reference
 
-	err = customer.UpdateAllFields(db.MOR)
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
+	}
+
+	if data.Narration != nil {
+		event.Description = *data.Narration
+	}
+
+	if data.Amount != nil {
+		event.Amount = *data.Amount
+	}
+
+	if data.Fee != nil {
+		event.ProcessingFee = *data.Fee
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	createdAt := data.CreatedAt
+	if createdAt == nil {
+		createdAt = data.DateCreated
+	}
+	if createdAt != nil {
+		t, err := parseWebhookTime(*createdAt)
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("Flutterwave webhhook log error, error parsing data.CreatedAt, %v, %v", *createdAt, err.Error())
+		}
+		event.OccurredAt = t
+	}
+
+	if data.Status != nil {
+		event.Status = getFlutterwaveTransferStatus(*data.Status)
+	}
+
+	return event, nil
+}
+
+func getFlutterwaveEventForRefundCompleted(provider string, req models.FlutterwaveWebhookRequestEvent) (WebhookEvent, error) {
+	var (
+		data  models.FlutterwaveWebhookRequestRefund
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventRefund,
+			ProviderEvent: req.Event,
+		}
+	)
+
+	err := json.Unmarshal(req.Data, &data)
 	if err != nil {
-		return err
+		return WebhookEvent{}, err
 	}
 
-	return nil
+	if data.TxRef == nil {
+		return WebhookEvent{}, fmt.Errorf("Flutterwave webhook log error, refund has no tx_ref")
+	}
+	event.Reference = *data.TxRef
+
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
+	}
+
+	if data.Comments != nil {
+		event.Description = *data.Comments
+	}
+
+	if data.AmountRefunded != nil {
+		event.Amount = *data.AmountRefunded
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	// a failed refund leaves the original transaction as it was
+	if data.Status != nil && strings.EqualFold(*data.Status, "completed") {
+		event.Status = models.TransactionSuccessful
+	}
+
+	return event, nil
 }
 
-func getFlutterwavePaymentHistoryForChargeCompleted(req models.FlutterwaveWebhookRequest, customer *models.Customer) (models.Transaction, error) {
+func getFlutterwaveEventForChargeback(provider string, req models.FlutterwaveWebhookRequestEvent) (WebhookEvent, error) {
 	var (
-		paymentHistory = models.Transaction{
-			CustomerID: int64(customer.ID),
+		data  models.FlutterwaveWebhookRequestChargeback
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventChargeback,
+			ProviderEvent: req.Event,
 		}
-		data = *req.Data
 	)
 
-	if data.TxRef != nil {
-		paymentHistory.Reference = *data.TxRef
+	err := json.Unmarshal(req.Data, &data)
+	if err != nil {
+		return WebhookEvent{}, err
 	}
-	if data.Narration != nil {
-		paymentHistory.Description = *data.Narration
+
+	if data.TxRef == nil {
+		return WebhookEvent{}, fmt.Errorf("Flutterwave webhook log error, chargeback has no tx_ref")
+	}
+	event.Reference = *data.TxRef
+
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
+	}
+
+	if data.Comment != nil {
+		event.Description = *data.Comment
 	}
 
 	if data.Amount != nil {
-		paymentHistory.Amount = *data.Amount
+		event.Amount = *data.Amount
 	}
 
-	if data.PaymentType != nil {
-		// paymentHistory.PaymentMethod = *data.PaymentType
+	if data.Currency != nil {
+		event.Currency = *data.Currency
 	}
 
-	if data.CreatedAt != nil {
-		t, err := time.Parse("2006-01-02T15:04:05.000Z", *data.CreatedAt)
-		if err != nil {
-			return models.Transaction{}, fmt.Errorf("Flutterwave webhhook log error, error parsing data.DateCreated, %v, %v", *data.CreatedAt, err.Error())
+	// the transaction is only reversed once the chargeback is settled against the merchant,
+	// until then the dispute stays open with the funds held
+	if data.Status != nil {
+		switch strings.ToLower(*data.Status) {
+		case "accepted", "lost":
+			event.DisputeStatus = models.DisputeLost
+		case "won":
+			event.DisputeStatus = models.DisputeWon
+		case "declined":
+			event.DisputeStatus = models.DisputeSubmitted
 		}
-		customer.LastPaymentMadeAt = t
 	}
 
-	if data.Status != nil {
-		if *data.Status == "successful" {
-			// paymentHistory.Status = paymentHistorySuccessful
+	if data.DueDate != nil && *data.DueDate != "" {
+		t, err := parseWebhookTime(*data.DueDate)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", *data.DueDate)
+			if err != nil {
+				return WebhookEvent{}, fmt.Errorf("Flutterwave webhook log error, error parsing data.DueDate, %v, %v", *data.DueDate, err.Error())
+			}
 		}
+		event.DueAt = t
 	}
 
-	customer.NumberOfPayments += 1
+	return event, nil
+}
 
-	return paymentHistory, nil
+func getFlutterwavePaymentMethod(paymentType string) models.PaymentMethod {
+	switch strings.ToLower(paymentType) {
+	case "card":
+		return models.CardMethod
+	case "account", "account-ach-us":
+		return models.AccountMethod
+	case "bank_transfer", "banktransfer":
+		return models.BankTransferMethod
+	case "ussd":
+		return models.UssdMethod
+	case "mpesa":
+		return models.MpesaMethod
+	case "mobilemoneygh", "mobilemoneyghana":
+		return models.MobileMoneyGhanaMethod
+	case "mobilemoneyfranco", "mobilemoneyfr":
+		return models.MobileMoneyFrancoMethod
+	case "mobilemoneyug", "mobilemoneyuganda":
+		return models.MobileMoneyUgandaMethod
+	case "mobilemoneyrw", "mobilemoneyrwanda":
+		return models.MobileMoneyRwandaMethod
+	case "mobilemoneyzm", "mobilemoneyzambia":
+		return models.MobileMoneyZambiaMethod
+	case "barter":
+		return models.BarterMethod
+	case "qr", "nqr":
+		return models.NqrMethod
+	case "credit":
+		return models.CreditMethod
+	default:
+		return models.PaymentMethod(paymentType)
+	}
+}
+
+func getFlutterwaveTransferStatus(status string) models.TransactionStatus {
+	switch strings.ToLower(status) {
+	case "successful":
+		return models.TransactionSuccessful
+	case "failed":
+		return models.TransactionFailed
+	case "reversed":
+		return models.TransactionReversed
+	default:
+		return models.TransactionPending
+	}
 }
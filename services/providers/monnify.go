@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/utility"
+)
+
+// monnifyProvider only takes the outcome of transfers we disburse through Monnify, the withdrawals they pay are settled before Apply
+type monnifyProvider struct{}
+
+func init() {
+	RegisterWebhookProvider(monnifyProvider{})
+}
+
+func (monnifyProvider) Name() string {
+	return "monnify"
+}
+
+func (monnifyProvider) Detect(c *gin.Context) bool {
+	return utility.GetHeader(c, "monnify-signature") != ""
+}
+
+func (monnifyProvider) VerifySignature(c *gin.Context, requestBody []byte) error {
+	signature := utility.Sha512Hmac(config.GetConfig().Monnify.MonnifySecret, requestBody)
+	if !hmac.Equal([]byte(signature), []byte(utility.GetHeader(c, "monnify-signature"))) {
+		return fmt.Errorf("signature doesn't match")
+	}
+	return nil
+}
+
+func (p monnifyProvider) Parse(requestBody []byte) (WebhookEvent, error) {
+	var (
+		req models.MonnifyWebhookRequest
+	)
+
+	err := json.Unmarshal(requestBody, &req)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	switch req.EventType {
+	case "SUCCESSFUL_DISBURSEMENT":
+		return getMonnifyEventForDisbursement(p.Name(), req, models.TransactionSuccessful)
+	case "FAILED_DISBURSEMENT":
+		return getMonnifyEventForDisbursement(p.Name(), req, models.TransactionFailed)
+	case "REVERSED_DISBURSEMENT":
+		return getMonnifyEventForDisbursement(p.Name(), req, models.TransactionReversed)
+	default:
+		return WebhookEvent{}, fmt.Errorf("event type %v, not implemented", req.EventType)
+	}
+}
+
+// Apply only sees disbursements that match no withdrawal, they aren't ours to track and retrying them can't change that
+func (monnifyProvider) Apply(extReq request.ExternalRequest, db postgresql.Databases, accountID int, event WebhookEvent, dryRun bool) error {
+	extReq.Logger.Info(fmt.Sprintf("webhook log info for %v, %v %v matches no withdrawal, ignored", event.Provider, event.ProviderEvent, event.Reference))
+	return nil
+}
+
+func getMonnifyEventForDisbursement(provider string, req models.MonnifyWebhookRequest, status models.TransactionStatus) (WebhookEvent, error) {
+	var (
+		data  models.MonnifyWebhookRequestDisbursement
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventTransfer,
+			ProviderEvent: req.EventType,
+			Status:        status,
+		}
+	)
+
+	err := json.Unmarshal(req.EventData, &data)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	if data.Reference == nil {
+		return WebhookEvent{}, fmt.Errorf("Monnify webhook log error, disbursement has no reference")
+	}
+	event.Reference = *data.Reference
+
+	if data.TransactionReference != nil {
+		event.EventID = *data.TransactionReference
+	}
+
+	if data.TransactionDescription != nil {
+		event.Description = *data.TransactionDescription
+	}
+
+	if data.Amount != nil {
+		event.Amount = *data.Amount
+	}
+
+	if data.Fee != nil {
+		event.ProcessingFee = *data.Fee
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	occurredAt := data.CompletedOn
+	if occurredAt == nil {
+		occurredAt = data.CreatedOn
+	}
+	if occurredAt != nil {
+		t, err := time.Parse("2006-01-02T15:04:05.000-0700", *occurredAt)
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("Monnify webhook log error, error parsing eventData.completedOn, %v, %v", *occurredAt, err.Error())
+		}
+		event.OccurredAt = t
+	}
+
+	return event, nil
+}
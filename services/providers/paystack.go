@@ -1,11 +1,267 @@
 package providers
 
 import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/gin-gonic/gin"
-	"github.com/vesicash/mor-api/external/request"
-	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/utility"
 )
 
-func HandlePaystackMerchantWebhook(c *gin.Context, extReq request.ExternalRequest, db postgresql.Databases, requestBody []byte) error {
+type paystackProvider struct {
+	webhookApplier
+}
+
+func init() {
+	RegisterWebhookProvider(paystackProvider{})
+}
+
+func (paystackProvider) Name() string {
+	return "paystack"
+}
+
+func (paystackProvider) Detect(c *gin.Context) bool {
+	return utility.GetHeader(c, "x-paystack-signature") != ""
+}
+
+func (paystackProvider) VerifySignature(c *gin.Context, requestBody []byte) error {
+	signature := utility.Sha512Hmac(config.GetConfig().Paystack.SecretKey, requestBody)
+	if !hmac.Equal([]byte(signature), []byte(utility.GetHeader(c, "x-paystack-signature"))) {
+		return fmt.Errorf("signature doesn't match")
+	}
 	return nil
 }
+
+func (p paystackProvider) Parse(requestBody []byte) (WebhookEvent, error) {
+	var (
+		req models.PaystackWebhookRequest
+	)
+
+	err := json.Unmarshal(requestBody, &req)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	switch {
+	case req.Event == "charge.success":
+		return getPaystackEventForChargeSuccess(p.Name(), req)
+	case req.Event == "refund.processed":
+		return getPaystackEventForRefundProcessed(p.Name(), req)
+	case strings.HasPrefix(req.Event, "transfer."):
+		return getPaystackEventForTransfer(p.Name(), req)
+	default:
+		return WebhookEvent{}, fmt.Errorf("event type %v, not implemented", req.Event)
+	}
+}
+
+func getPaystackEventForChargeSuccess(provider string, req models.PaystackWebhookRequest) (WebhookEvent, error) {
+	var (
+		data  models.PaystackWebhookRequestCharge
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventCharge,
+			ProviderEvent: req.Event,
+			Status:        models.TransactionPending,
+		}
+	)
+
+	err := json.Unmarshal(req.Data, &data)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	if data.Customer != nil {
+		event.Customer = &WebhookEventCustomer{}
+		if data.Customer.Email != nil {
+			event.Customer.Email = *data.Customer.Email
+		}
+		if data.Customer.Phone != nil {
+			event.Customer.PhoneNumber = *data.Customer.Phone
+		}
+		if data.Customer.FirstName != nil {
+			event.Customer.Firstname = *data.Customer.FirstName
+		}
+		if data.Customer.LastName != nil {
+			event.Customer.Lastname = *data.Customer.LastName
+		}
+	}
+
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
+	}
+
+	if data.Reference != nil {
+		event.Reference = *data.Reference
+	}
+
+	if data.GatewayResponse != nil {
+		event.Description = *data.GatewayResponse
+	}
+
+	if data.Amount != nil {
+		event.Amount = getPaystackAmount(*data.Amount)
+	}
+
+	if data.Fees != nil {
+		event.ProcessingFee = getPaystackAmount(*data.Fees)
+	}
+
+	if data.Channel != nil {
+		event.PaymentMethod = getPaystackPaymentMethod(*data.Channel)
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	if data.PaidAt != nil {
+		t, err := parseWebhookTime(*data.PaidAt)
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("Paystack webhook log error, error parsing data.PaidAt, %v, %v", *data.PaidAt, err.Error())
+		}
+		event.OccurredAt = t
+	}
+
+	if data.Status != nil && *data.Status == "success" {
+		event.Status = models.TransactionSuccessful
+	}
+
+	return event, nil
+}
+
+func getPaystackEventForRefundProcessed(provider string, req models.PaystackWebhookRequest) (WebhookEvent, error) {
+	var (
+		data  models.PaystackWebhookRequestRefund
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventRefund,
+			ProviderEvent: req.Event,
+			Status:        models.TransactionPending,
+		}
+	)
+
+	err := json.Unmarshal(req.Data, &data)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	if data.TransactionReference == nil {
+		return WebhookEvent{}, fmt.Errorf("Paystack webhook log error, refund has no transaction_reference")
+	}
+	event.Reference = *data.TransactionReference
+
+	if data.RefundReference != nil {
+		event.EventID = *data.RefundReference
+	}
+
+	if data.Amount != nil {
+		amount, err := data.Amount.Float64()
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("Paystack webhook log error, error parsing data.Amount, %v, %v", *data.Amount, err.Error())
+		}
+		event.Amount = getPaystackAmount(amount)
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	// the status of the refund, whether it refunds the transaction in full depends on what was refunded before
+	if data.Status != nil && *data.Status == "processed" {
+		event.Status = models.TransactionSuccessful
+	}
+
+	return event, nil
+}
+
+func getPaystackEventForTransfer(provider string, req models.PaystackWebhookRequest) (WebhookEvent, error) {
+	var (
+		data  models.PaystackWebhookRequestTransfer
+		event = WebhookEvent{
+			Provider:      provider,
+			Type:          WebhookEventTransfer,
+			ProviderEvent: req.Event,
+			Status:        models.TransactionPending,
+		}
+	)
+
+	err := json.Unmarshal(req.Data, &data)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	if data.Reference == nil {
+		return WebhookEvent{}, fmt.Errorf("Paystack webhook log error, transfer has no reference")
+	}
+	event.Reference = *data.Reference
+
+	if data.ID != nil {
+		event.EventID = strconv.Itoa(*data.ID)
+	}
+
+	if data.Status != nil {
+		event.Status = getPaystackTransferStatus(*data.Status)
+	}
+
+	if data.Reason != nil {
+		event.Description = *data.Reason
+	}
+
+	if data.Amount != nil {
+		event.Amount = getPaystackAmount(*data.Amount)
+	}
+
+	if data.Currency != nil {
+		event.Currency = *data.Currency
+	}
+
+	if data.CreatedAt != nil {
+		t, err := parseWebhookTime(*data.CreatedAt)
+		if err != nil {
+			return WebhookEvent{}, fmt.Errorf("Paystack webhook log error, error parsing data.CreatedAt, %v, %v", *data.CreatedAt, err.Error())
+		}
+		event.OccurredAt = t
+	}
+
+	return event, nil
+}
+
+// paystack sends amounts in the currency's subunit (kobo, pesewas, cents)
+func getPaystackAmount(amount float64) float64 {
+	return amount / 100
+}
+
+func getPaystackPaymentMethod(channel string) models.PaymentMethod {
+	switch strings.ToLower(channel) {
+	case "card":
+		return models.CardMethod
+	case "bank":
+		return models.AccountMethod
+	case "bank_transfer", "dedicated_nuban":
+		return models.BankTransferMethod
+	case "ussd":
+		return models.UssdMethod
+	case "qr":
+		return models.NqrMethod
+	default:
+		return models.PaymentMethod(channel)
+	}
+}
+
+func getPaystackTransferStatus(status string) models.TransactionStatus {
+	switch strings.ToLower(status) {
+	case "success":
+		return models.TransactionSuccessful
+	case "failed":
+		return models.TransactionFailed
+	case "reversed":
+		return models.TransactionReversed
+	default:
+		return models.TransactionPending
+	}
+}
@@ -0,0 +1,209 @@
+package refunds
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+	"github.com/vesicash/mor-api/services/ledger"
+	"github.com/vesicash/mor-api/utility"
+	"gorm.io/gorm"
+)
+
+// CreateRefund refunds refund.Amount of a successful transaction, the transaction is refunded in full once its whole amount was refunded.
+// A refund without a reference is given a random one. A refund recovered from the MOR_ wallet is only saved, SettleRefund debits the wallet
+// once the caller's transaction committed. A dry run records the refund without notifying the merchant.
+func CreateRefund(extReq request.ExternalRequest, db postgresql.Databases, transaction models.Transaction, refund models.Refund, dryRun bool) (models.Refund, int, error) {
+	refundable := roundAmount(transaction.Amount - transaction.RefundedAmount)
+	amount := roundAmount(refund.Amount)
+	if amount <= 0 || amount > refundable {
+		return refund, http.StatusBadRequest, fmt.Errorf("refund amount must be more than 0 and at most %v", refundable)
+	}
+
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(transaction.CountryID))
+	if err != nil {
+		return refund, http.StatusInternalServerError, fmt.Errorf("error getting country with id %v: %v", transaction.CountryID, err.Error())
+	}
+	currency := strings.ToUpper(country.CurrencyCode)
+
+	breakdown, err := getRefundBreakdown(db, transaction, amount)
+	if err != nil {
+		return refund, http.StatusInternalServerError, err
+	}
+	if refund.Reference != "" {
+		breakdown.Reference = refund.Reference
+	}
+	breakdown.Reason = refund.Reason
+	breakdown.InitiatedBy = refund.InitiatedBy
+	refund = breakdown
+
+	refund.RecoveryMethod = getRefundRecoveryMethod(extReq, transaction, refund, currency)
+	if refund.RecoveryAmount <= 0 {
+		// the fee and tax reversed cover the whole refund, there is nothing to take from the merchant
+		refund.RecoveryStatus = models.RefundRecoveryRecovered
+		refund.RecoveredAt = time.Now()
+	}
+
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		updated, err := transaction.AddRefundedAmount(tx, amount)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return fmt.Errorf("transaction %v was refunded or changed meanwhile, try again", transaction.Reference)
+		}
+		return refund.CreateRefund(tx)
+	})
+	if err != nil {
+		return refund, http.StatusInternalServerError, err
+	}
+
+	// the wallet debit is left to SettleRefund, so it is never made inside a transaction the refund could still be rolled back with
+	if refund.RecoveryMethod == models.RefundRecoveryWallet && refund.RecoveryStatus == models.RefundRecoveryPending {
+		return refund, http.StatusOK, nil
+	}
+
+	err = ledger.RecordRefund(db, refund, currency)
+	if err != nil {
+		return refund, http.StatusInternalServerError, err
+	}
+
+	if !dryRun {
+		notifyRefund(extReq, transaction, refund)
+	}
+
+	return refund, http.StatusOK, nil
+}
+
+// SettleRefund debits the MOR_ wallet for a saved refund recovered from it, a refund the wallet can't cover is recovered from the next payout.
+// The debit is keyed on the refund, so settling again after a failed update doesn't debit twice, and only the run that settles it records it.
+func SettleRefund(extReq request.ExternalRequest, db postgresql.Databases, refund models.Refund) (models.Refund, int, error) {
+	if refund.RecoveryMethod != models.RefundRecoveryWallet || refund.RecoveryStatus != models.RefundRecoveryPending {
+		return refund, http.StatusOK, nil
+	}
+
+	transaction := models.Transaction{ID: uint(refund.TransactionID)}
+	_, err := transaction.GetTransactionByID(db.MOR)
+	if err != nil {
+		return refund, http.StatusInternalServerError, fmt.Errorf("error getting transaction %v of refund %v: %v", refund.TransactionID, refund.Reference, err.Error())
+	}
+
+	country, err := services.GetCountryByID(extReq, extReq.Logger, int(refund.CountryID))
+	if err != nil {
+		return refund, http.StatusInternalServerError, fmt.Errorf("error getting country with id %v: %v", refund.CountryID, err.Error())
+	}
+	currency := strings.ToUpper(country.CurrencyCode)
+
+	_, err = services.DebitWallet(extReq, db, refund.RecoveryAmount, currency, int(refund.MerchantID), "no", "yes", refund.Reference)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error debiting mor wallet %v, amount %v, refund %v, recovering from the next payout: %v", currency, refund.RecoveryAmount, refund.Reference, err.Error()))
+	}
+	debited := err == nil
+
+	var settled bool
+	err = db.MOR.Transaction(func(tx *gorm.DB) error {
+		var err error
+		settled, err = refund.SettleWalletRecovery(tx, debited)
+		if err != nil || !settled {
+			return err
+		}
+
+		_, err = refund.GetRefundByID(tx)
+		if err != nil {
+			return err
+		}
+
+		txDb := db
+		txDb.MOR = tx
+		return ledger.RecordRefund(txDb, refund, currency)
+	})
+	if err != nil {
+		return refund, http.StatusInternalServerError, err
+	}
+
+	if !settled {
+		code, err := refund.GetRefundByID(db.MOR)
+		return refund, code, err
+	}
+
+	notifyRefund(extReq, transaction, refund)
+	return refund, http.StatusOK, nil
+}
+
+// notifyRefund tells the merchant about the refund, errors are only logged
+func notifyRefund(extReq request.ExternalRequest, transaction models.Transaction, refund models.Refund) {
+	_, err := extReq.SendExternalRequest(request.SuccessfulRefundNotification, external_models.OnlyTransactionIDAndAccountIDRequest{
+		TransactionID: transaction.Reference,
+		AccountID:     int(transaction.MerchantID),
+	})
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error sending refund notification for refund %v: %v", refund.Reference, err.Error()))
+	}
+}
+
+// getRefundBreakdown reverses the processing fee and tax in proportion to the amount refunded,
+// the refund that empties the transaction takes whatever the earlier refunds left so rounding never leaves a remainder
+func getRefundBreakdown(db postgresql.Databases, transaction models.Transaction, amount float64) (models.Refund, error) {
+	var (
+		refund = models.Refund{
+			TransactionID:  int64(transaction.ID),
+			MerchantID:     transaction.MerchantID,
+			CountryID:      transaction.CountryID,
+			Reference:      utility.RandomString(25),
+			Amount:         amount,
+			RecoveryStatus: models.RefundRecoveryPending,
+		}
+		refundedFee float64
+		refundedTax float64
+	)
+
+	if roundAmount(transaction.Amount-transaction.RefundedAmount-amount) == 0 {
+		earlier, err := refund.GetRefundsByTransactionID(db.MOR)
+		if err != nil {
+			return refund, err
+		}
+		for _, r := range earlier {
+			refundedFee += r.ProcessingFee
+			refundedTax += r.TaxFee
+		}
+		refund.ProcessingFee = roundAmount(transaction.ProcessingFee - refundedFee)
+		refund.TaxFee = roundAmount(transaction.TaxFee - refundedTax)
+	} else {
+		refund.ProcessingFee = roundAmount(transaction.ProcessingFee * amount / transaction.Amount)
+		refund.TaxFee = roundAmount(transaction.TaxFee * amount / transaction.Amount)
+	}
+
+	refund.RecoveryAmount = roundAmount(amount - refund.ProcessingFee - refund.TaxFee)
+	return refund, nil
+}
+
+// getRefundRecoveryMethod takes the refund back from the MOR_ wallet when the transaction was already paid out and the wallet can cover it,
+// otherwise it is deducted from the merchant's next payout, which is the payout of the transaction itself when it wasn't paid out yet
+func getRefundRecoveryMethod(extReq request.ExternalRequest, transaction models.Transaction, refund models.Refund, currency string) models.RefundRecoveryMethod {
+	if transaction.PayoutID == 0 && !transaction.IsPaidOut {
+		return models.RefundRecoveryNextPayout
+	}
+
+	morWallet := fmt.Sprintf("MOR_%v", currency)
+	wallet, err := services.GetWalletBalanceByAccountIdAndCurrency(extReq, int(transaction.MerchantID), morWallet)
+	if err != nil {
+		extReq.Logger.Error(fmt.Sprintf("error getting %v wallet of merchant %v: %v", morWallet, transaction.MerchantID, err.Error()))
+		return models.RefundRecoveryNextPayout
+	}
+
+	if wallet.Available < refund.RecoveryAmount {
+		return models.RefundRecoveryNextPayout
+	}
+	return models.RefundRecoveryWallet
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,123 @@
+package tax
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	"github.com/vesicash/mor-api/services"
+)
+
+var (
+	// taxIDPatterns are the VAT/GST registration number formats by country code, other countries get defaultTaxIDPattern
+	taxIDPatterns = map[string]*regexp.Regexp{
+		"NG": regexp.MustCompile(`^\d{8}-?\d{4}$`),
+		"GH": regexp.MustCompile(`^[CGPQV]\d{10}$`),
+		"KE": regexp.MustCompile(`^[AP]\d{9}[A-Z]$`),
+		"ZA": regexp.MustCompile(`^4\d{9}$`),
+		"GB": regexp.MustCompile(`^GB(\d{9}|\d{12})$`),
+	}
+	defaultTaxIDPattern = regexp.MustCompile(`^[A-Z0-9]{5,20}$`)
+)
+
+// InvalidTaxIDError is returned for a customer tax id that doesn't match its country's format
+type InvalidTaxIDError struct {
+	TaxID string
+}
+
+func (e InvalidTaxIDError) Error() string {
+	return fmt.Sprintf("invalid customer tax id %v", e.TaxID)
+}
+
+// CalculateTax works out the tax included in amount for a sale in the country at the given time.
+// Exempt products and b2b sales to a customer with a valid tax id, which are reverse charged, carry no tax.
+// found is false when the country has no rate for the category, the caller decides what to fall back to.
+func CalculateTax(extReq request.ExternalRequest, db postgresql.Databases, countryID int64, category models.TaxCategory, amount float64, customerTaxID string, at time.Time) (models.TaxCalculation, bool, error) {
+	var (
+		calculation = models.TaxCalculation{
+			CountryID:     countryID,
+			Category:      category,
+			Amount:        amount,
+			CustomerTaxID: normalizeTaxID(customerTaxID),
+		}
+	)
+
+	if calculation.Category == "" {
+		calculation.Category = models.TaxCategoryStandard
+	}
+
+	if calculation.CustomerTaxID != "" {
+		country, err := services.GetCountryByID(extReq, extReq.Logger, int(countryID))
+		if err != nil {
+			return calculation, false, err
+		}
+
+		if !IsValidTaxID(country.CountryCode, calculation.CustomerTaxID) {
+			return calculation, false, InvalidTaxIDError{TaxID: customerTaxID}
+		}
+		calculation.ReverseCharge = true
+		return calculation, true, nil
+	}
+
+	if calculation.Category == models.TaxCategoryExempt {
+		return calculation, true, nil
+	}
+
+	rate := models.TaxRate{CountryID: countryID, Category: calculation.Category}
+	code, err := rate.GetEffectiveTaxRate(db.MOR, at)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			return calculation, false, err
+		}
+		return calculation, false, nil
+	}
+
+	calculation.TaxRateID = int64(rate.ID)
+	calculation.Name = rate.Name
+	calculation.Rate = rate.Rate
+	calculation.Tax = math.Round(amount*rate.Rate/(100+rate.Rate)*100) / 100
+	return calculation, true, nil
+}
+
+// ApplyTransactionTax stores the calculated tax on the transaction, the tax fee it already has is kept when no rate applies
+func ApplyTransactionTax(extReq request.ExternalRequest, db postgresql.Databases, transaction *models.Transaction) error {
+	at := transaction.TransactionDate
+	if at.Unix() <= 0 {
+		at = time.Now()
+	}
+
+	calculation, found, err := CalculateTax(extReq, db, transaction.CountryID, transaction.TaxCategory, transaction.Amount, transaction.CustomerTaxID, at)
+	if err != nil {
+		return err
+	}
+
+	transaction.TaxCategory = calculation.Category
+	transaction.CustomerTaxID = calculation.CustomerTaxID
+	if !found {
+		return nil
+	}
+
+	transaction.TaxFee = calculation.Tax
+	transaction.TaxRateID = calculation.TaxRateID
+	transaction.TaxRate = calculation.Rate
+	transaction.TaxReverseCharge = calculation.ReverseCharge
+	return nil
+}
+
+func IsValidTaxID(countryCode string, taxID string) bool {
+	pattern, ok := taxIDPatterns[strings.ToUpper(countryCode)]
+	if !ok {
+		pattern = defaultTaxIDPattern
+	}
+	return pattern.MatchString(normalizeTaxID(taxID))
+}
+
+func normalizeTaxID(taxID string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(taxID), " ", ""))
+}
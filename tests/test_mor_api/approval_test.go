@@ -0,0 +1,292 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/middleware"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestApprovals(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	var (
+		accountID = uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+		maker     = &external_models.User{AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))}
+		checker   = &external_models.User{AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))}
+		currency  = "GHS"
+	)
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "ghana",
+		CountryCode:  "GH",
+		CurrencyCode: currency,
+	}
+	auth_mocks.BankDetail = &external_models.BankDetail{
+		ID:          uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID:   int(accountID),
+		BankID:      1,
+		AccountName: "test user",
+		AccountNo:   "0690000040",
+		Country:     "GH",
+		Currency:    currency,
+	}
+
+	createWithdrawal := func(amount float64) models.Withdrawal {
+		withdrawal := models.Withdrawal{MerchantID: int64(accountID), Currency: currency, Amount: amount, WithdrawalDate: time.Now(), Status: models.WithdrawalPending}
+		err := withdrawal.CreateWithdrawal(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return withdrawal
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"), middleware.Authorize(db, extReq, middleware.BusinessAdmin))
+	{
+		paymentUrl.POST("/approval/policy", mor.UpdateApprovalPolicy)
+		paymentUrl.GET("/approvals/get", mor.GetApprovalRequests)
+		paymentUrl.GET("/approval/get/:id", mor.GetApprovalRequest)
+		paymentUrl.POST("/approval/approve/:id", mor.ApproveApprovalRequest)
+		paymentUrl.POST("/approval/decline/:id", mor.DeclineApprovalRequest)
+		paymentUrl.PATCH("/withdrawal/approve/:withdrawal_id", mor.ApproveWithdrawal)
+		paymentUrl.POST("/payout/to-wallet", mor.PayOutToWallets)
+		paymentUrl.POST("/payout/resume/:id", mor.ResumePayout)
+	}
+
+	send := func(admin *external_models.User, method string, path string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+
+		req, err := http.NewRequest(method, path, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("v-private-key", utility.RandomString(20))
+		req.Header.Set("v-public-key", utility.RandomString(20))
+
+		auth_mocks.ValidateAuthorizationRes = &external_models.ValidateAuthorizationDataModel{Status: true, Message: "authorized", Data: *admin}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	getApproval := func(t *testing.T, rr *httptest.ResponseRecorder, code int) map[string]interface{} {
+		tst.AssertStatusCode(t, rr.Code, code)
+
+		data := tst.ParseResponse(rr)
+		approval, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("approval request missing from response: %v", data)
+		}
+		return approval
+	}
+
+	getWithdrawal := func(t *testing.T, id uint) models.Withdrawal {
+		withdrawal := models.Withdrawal{ID: id}
+		_, err := withdrawal.GetWithdrawalByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return withdrawal
+	}
+
+	t.Run("policy needs an approval", func(t *testing.T) {
+		rr := send(maker, http.MethodPost, "/v2/admin/approval/policy", models.UpdateApprovalPolicyRequest{Action: models.ApprovalWithdrawalApprove, Currency: currency, Threshold: 500})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK policies", func(t *testing.T) {
+		for _, action := range []models.ApprovalAction{models.ApprovalWithdrawalApprove, models.ApprovalPayoutToWallet} {
+			rr := send(maker, http.MethodPost, "/v2/admin/approval/policy", models.UpdateApprovalPolicyRequest{Action: action, Currency: currency, Threshold: 500, RequiredApprovals: 2})
+			tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("OK withdrawal below threshold needs one admin", func(t *testing.T) {
+		withdrawal := createWithdrawal(400)
+		rr := send(maker, http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status == models.WithdrawalPending {
+			t.Errorf("withdrawal below threshold was not approved")
+		}
+	})
+
+	t.Run("OK withdrawal above threshold needs two admins", func(t *testing.T) {
+		withdrawal := createWithdrawal(1000)
+		approval := getApproval(t, send(maker, http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil), http.StatusAccepted)
+		if approval["status"] != string(models.ApprovalRequestPending) || approval["approvals"] != float64(1) || approval["required_approvals"] != float64(2) {
+			t.Fatalf("wrong approval request: %v", approval)
+		}
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalPending {
+			t.Fatalf("withdrawal was approved by one admin: status %v", withdrawal.Status)
+		}
+
+		again := getApproval(t, send(maker, http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil), http.StatusAccepted)
+		if again["id"] != approval["id"] {
+			t.Errorf("approving again queued another request: %v and %v", approval["id"], again["id"])
+		}
+
+		rr := send(maker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+
+		approval = getApproval(t, send(checker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), models.ApprovalDecisionRequest{Note: "checked bank account"}), http.StatusOK)
+		if approval["status"] != string(models.ApprovalRequestExecuted) {
+			t.Errorf("wrong status: got %v expected %v", approval["status"], models.ApprovalRequestExecuted)
+		}
+		if decisions, ok := approval["decisions"].([]interface{}); !ok || len(decisions) != 2 {
+			t.Errorf("expected two decisions: %v", approval["decisions"])
+		}
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status == models.WithdrawalPending {
+			t.Errorf("approved withdrawal still pending")
+		}
+
+		other := &external_models.User{AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))}
+		rr = send(other, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK payout declined then approved", func(t *testing.T) {
+		transaction := models.Transaction{
+			MerchantID: int64(accountID),
+			Reference:  utility.RandomString(20),
+			Amount:     5000,
+			CountryID:  int64(auth_mocks.Country.ID),
+			Status:     models.TransactionSuccessful,
+		}
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payout := models.PayoutToWalletRequest{Merchants: []int{int(accountID)}}
+		approval := getApproval(t, send(maker, http.MethodPost, "/v2/admin/payout/to-wallet", payout), http.StatusAccepted)
+		if approval["amount"] != float64(5000) || approval["currency"] != currency {
+			t.Fatalf("wrong approval request: %v", approval)
+		}
+
+		approval = getApproval(t, send(checker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/decline/%v", approval["id"]), models.ApprovalDecisionRequest{Note: "wait for month end"}), http.StatusOK)
+		if approval["status"] != string(models.ApprovalRequestDeclined) || approval["reason"] != "wait for month end" {
+			t.Errorf("wrong declined approval request: %v", approval)
+		}
+
+		rr := send(maker, http.MethodGet, "/v2/admin/approvals/get?status=declined&action=payout_to_wallet", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		if _, err = transaction.GetTransactionByID(db.MOR); err != nil || transaction.IsPaidOut {
+			t.Fatalf("declined payout paid out transaction: %v", err)
+		}
+
+		approval = getApproval(t, send(maker, http.MethodPost, "/v2/admin/payout/to-wallet", payout), http.StatusAccepted)
+		approval = getApproval(t, send(checker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), nil), http.StatusOK)
+		if approval["status"] != string(models.ApprovalRequestExecuted) {
+			t.Errorf("wrong status: got %v expected %v", approval["status"], models.ApprovalRequestExecuted)
+		}
+
+		if _, err = transaction.GetTransactionByID(db.MOR); err != nil || !transaction.IsPaidOut {
+			t.Errorf("approved payout didn't pay out transaction: %v", err)
+		}
+	})
+
+	t.Run("OK payout pays only the approved transactions", func(t *testing.T) {
+		createTransaction := func(amount float64) models.Transaction {
+			transaction := models.Transaction{
+				MerchantID: int64(accountID),
+				Reference:  utility.RandomString(20),
+				Amount:     amount,
+				CountryID:  int64(auth_mocks.Country.ID),
+				Status:     models.TransactionSuccessful,
+			}
+			err := transaction.CreateTransaction(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return transaction
+		}
+
+		transaction := createTransaction(5000)
+		approval := getApproval(t, send(maker, http.MethodPost, "/v2/admin/payout/to-wallet", models.PayoutToWalletRequest{Merchants: []int{int(accountID)}}), http.StatusAccepted)
+
+		var payload models.PayoutApprovalPayload
+		err := json.Unmarshal([]byte(fmt.Sprint(approval["payload"])), &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload.TransactionIDs) != 1 || payload.TransactionIDs[0] != transaction.ID || len(payload.Totals) != 1 || payload.Totals[0].Amount != 5000 {
+			t.Fatalf("wrong approval payload: %+v", payload)
+		}
+
+		later := createTransaction(3000)
+
+		approval = getApproval(t, send(checker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), nil), http.StatusOK)
+		if approval["status"] != string(models.ApprovalRequestExecuted) {
+			t.Errorf("wrong status: got %v expected %v", approval["status"], models.ApprovalRequestExecuted)
+		}
+
+		if _, err = transaction.GetTransactionByID(db.MOR); err != nil || !transaction.IsPaidOut {
+			t.Errorf("approved payout didn't pay out transaction: %v", err)
+		}
+		if _, err = later.GetTransactionByID(db.MOR); err != nil || later.IsPaidOut || later.PayoutID != 0 {
+			t.Errorf("approved payout paid out a transaction that became payable later: %v", err)
+		}
+	})
+
+	t.Run("OK payout resume needs two admins", func(t *testing.T) {
+		payout := models.Payout{
+			MerchantID: int64(accountID),
+			Reference:  utility.RandomString(25),
+			Amount:     1000,
+			CountryID:  int64(auth_mocks.Country.ID),
+			Status:     models.PayoutWalletCredited,
+		}
+		err := payout.CreatePayout(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		approval := getApproval(t, send(maker, http.MethodPost, fmt.Sprintf("/v2/admin/payout/resume/%v", payout.ID), nil), http.StatusAccepted)
+		if approval["action"] != string(models.ApprovalPayoutResume) || approval["required_approvals"] != float64(2) {
+			t.Fatalf("wrong approval request: %v", approval)
+		}
+		if _, err = payout.GetPayoutByID(db.MOR); err != nil || payout.Status != models.PayoutWalletCredited {
+			t.Fatalf("payout was resumed by one admin: status %v, %v", payout.Status, err)
+		}
+
+		approval = getApproval(t, send(checker, http.MethodPost, fmt.Sprintf("/v2/admin/approval/approve/%v", approval["id"]), nil), http.StatusOK)
+		if approval["status"] != string(models.ApprovalRequestExecuted) {
+			t.Errorf("wrong status: got %v expected %v", approval["status"], models.ApprovalRequestExecuted)
+		}
+		if _, err = payout.GetPayoutByID(db.MOR); err != nil || payout.Status != models.PayoutSettled {
+			t.Errorf("wrong payout status: got %q expected %q, %v", payout.Status, models.PayoutSettled, err)
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestDisputes(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+	models.MyIdentity = &external_models.User{AccountID: accountID}
+
+	createTransaction := func(transaction models.Transaction) models.Transaction {
+		transaction.MerchantID = int64(accountID)
+		transaction.CountryID = int64(auth_mocks.Country.ID)
+		transaction.Reference = utility.RandomString(20)
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transaction
+	}
+
+	unpaid := createTransaction(models.Transaction{Amount: 5000, ProcessingFee: 50, TaxFee: 375, Status: models.TransactionSuccessful})
+	paidOut := createTransaction(models.Transaction{Amount: 3000, ProcessingFee: 30, TaxFee: 225, Status: models.TransactionSuccessful, IsPaidOut: true, PayoutID: 1})
+	failed := createTransaction(models.Transaction{Amount: 3000, Status: models.TransactionFailed})
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.POST("/dispute/open", mor.OpenDispute)
+		paymentUrl.GET("/dispute/get/:id", mor.GetDispute)
+		paymentUrl.POST("/dispute/status/:id", mor.UpdateDisputeStatus)
+	}
+	merchantUrl := r.Group("v2")
+	{
+		merchantUrl.GET("/disputes/get", mor.GetMerchantDisputes)
+		merchantUrl.POST("/disputes/evidence/:id", mor.UploadDisputeEvidence)
+		merchantUrl.POST("/disputes/submit/:id", mor.SubmitDispute)
+		merchantUrl.POST("/transactions/refund/:id", mor.RefundMerchantTransaction)
+	}
+
+	send := func(method string, path string, query string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(method, URI.String(), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	getDispute := func(t *testing.T, rr *httptest.ResponseRecorder) map[string]interface{} {
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		dispute, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("dispute missing from response: %v", data)
+		}
+		return dispute
+	}
+
+	checkDispute := func(t *testing.T, dispute map[string]interface{}, expected map[string]interface{}) {
+		for key, value := range expected {
+			if dispute[key] != value {
+				t.Errorf("wrong %v: got %v expected %v", key, dispute[key], value)
+			}
+		}
+	}
+
+	getTransaction := func(t *testing.T, id uint) models.Transaction {
+		transaction := models.Transaction{ID: id}
+		_, err := transaction.GetTransactionByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transaction
+	}
+
+	var unpaidDisputeID, paidOutDisputeID float64
+
+	t.Run("failed transaction", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(failed.ID), Reason: "fraud"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK open dispute on unpaid transaction", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(unpaid.ID), Reason: "item not received"})
+		dispute := getDispute(t, rr)
+		checkDispute(t, dispute, map[string]interface{}{
+			"amount":      float64(5000),
+			"status":      string(models.DisputeOpened),
+			"hold_method": string(models.DisputeHoldPayout),
+			"held_amount": float64(0),
+			"provider":    "manual",
+		})
+		unpaidDisputeID = dispute["id"].(float64)
+	})
+
+	t.Run("disputed transaction can't be refunded", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/transactions/refund/%v", unpaid.ID), "", models.CreateRefundRequest{})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK disputed transaction held back from payouts", func(t *testing.T) {
+		payouts, _, err := morService.PayoutToUser(extReq, db, int(accountID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payouts) != 0 {
+			t.Errorf("disputed transaction was paid out: %+v", payouts)
+		}
+	})
+
+	t.Run("OK open dispute on paid out transaction", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(paidOut.ID), Amount: 1000, Reason: "duplicate", Provider: "flutterwave", ProviderReference: utility.RandomString(10)})
+		dispute := getDispute(t, rr)
+		checkDispute(t, dispute, map[string]interface{}{
+			"amount":      float64(1000),
+			"hold_method": string(models.DisputeHoldWallet),
+			"held_amount": float64(1000),
+		})
+		paidOutDisputeID = dispute["id"].(float64)
+	})
+
+	t.Run("submit without evidence", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/disputes/submit/%v", paidOutDisputeID), "", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK upload evidence", func(t *testing.T) {
+		var b bytes.Buffer
+		writer := multipart.NewWriter(&b)
+		part, err := writer.CreateFormFile("file", "receipt.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("%PDF-1.4 delivery receipt"))
+		writer.WriteField("description", "signed delivery receipt")
+		writer.Close()
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v2/disputes/evidence/%v", paidOutDisputeID), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		evidence := getDispute(t, rr)
+		checkDispute(t, evidence, map[string]interface{}{
+			"file_name":   "receipt.pdf",
+			"description": "signed delivery receipt",
+		})
+		if evidence["file_url"] == "" {
+			t.Errorf("evidence has no file url: %v", evidence)
+		}
+	})
+
+	t.Run("OK submit", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/disputes/submit/%v", paidOutDisputeID), "", nil)
+		dispute := getDispute(t, rr)
+		checkDispute(t, dispute, map[string]interface{}{"status": string(models.DisputeSubmitted)})
+
+		evidence, ok := dispute["evidence"].([]interface{})
+		if !ok || len(evidence) != 1 {
+			t.Errorf("expected one piece of evidence: %v", dispute["evidence"])
+		}
+	})
+
+	t.Run("OK won", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/dispute/status/%v", paidOutDisputeID), "", models.UpdateDisputeStatusRequest{Status: models.DisputeWon, Note: "evidence accepted"})
+		checkDispute(t, getDispute(t, rr), map[string]interface{}{"status": string(models.DisputeWon), "note": "evidence accepted"})
+
+		if transaction := getTransaction(t, paidOut.ID); transaction.Status != models.TransactionSuccessful {
+			t.Errorf("won dispute changed transaction status to %v", transaction.Status)
+		}
+	})
+
+	t.Run("won dispute is final", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/dispute/status/%v", paidOutDisputeID), "", models.UpdateDisputeStatusRequest{Status: models.DisputeLost})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK lost", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/dispute/status/%v", unpaidDisputeID), "", models.UpdateDisputeStatusRequest{Status: models.DisputeLost})
+		checkDispute(t, getDispute(t, rr), map[string]interface{}{"status": string(models.DisputeLost)})
+
+		transaction := getTransaction(t, unpaid.ID)
+		if transaction.Status != models.TransactionReversed || transaction.RefundedAt.IsZero() {
+			t.Errorf("wrong transaction of lost dispute: status %v reversed at %v", transaction.Status, transaction.RefundedAt)
+		}
+	})
+
+	t.Run("OK list", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/disputes/get", "", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		disputes, ok := data["data"].([]interface{})
+		if !ok || len(disputes) != 2 {
+			t.Errorf("expected two disputes: %v", data)
+		}
+	})
+
+	t.Run("OK partial lost", func(t *testing.T) {
+		transaction := createTransaction(models.Transaction{Amount: 4000, ProcessingFee: 40, TaxFee: 300, RefundedAmount: 1000, Status: models.TransactionSuccessful})
+
+		rr := send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(transaction.ID), Amount: 3500, Reason: "fraud"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+
+		rr = send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(transaction.ID), Amount: 1500, Reason: "fraud"})
+		disputeID := getDispute(t, rr)["id"].(float64)
+
+		rr = send(http.MethodPost, fmt.Sprintf("/v2/admin/dispute/status/%v", disputeID), "", models.UpdateDisputeStatusRequest{Status: models.DisputeLost})
+		checkDispute(t, getDispute(t, rr), map[string]interface{}{"status": string(models.DisputeLost)})
+
+		transaction = getTransaction(t, transaction.ID)
+		if transaction.Status != models.TransactionSuccessful || transaction.RefundedAmount != 2500 {
+			t.Errorf("wrong transaction of partly lost dispute: status %v refunded %v", transaction.Status, transaction.RefundedAmount)
+		}
+
+		refund := models.Refund{TransactionID: int64(transaction.ID)}
+		refunds, err := refund.GetRefundsByTransactionID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refunds) != 1 || refunds[0].InitiatedBy != models.RefundInitiatedByChargeback || refunds[0].RecoveryAmount != 1500 ||
+			refunds[0].RecoveryMethod != models.RefundRecoveryNextPayout || refunds[0].RecoveryStatus != models.RefundRecoveryPending {
+			t.Errorf("expected a chargeback of 1500 recovered from the next payout: %+v", refunds)
+		}
+	})
+
+	t.Run("OK reminder", func(t *testing.T) {
+		transaction := createTransaction(models.Transaction{Amount: 2000, Status: models.TransactionSuccessful})
+		rr := send(http.MethodPost, "/v2/admin/dispute/open", "", models.OpenDisputeRequest{TransactionID: int64(transaction.ID), Reason: "fraud", EvidenceDueAt: int(time.Now().Add(48 * time.Hour).Unix())})
+		disputeID := getDispute(t, rr)["id"].(float64)
+
+		morService.SendDisputeReminders(extReq, db)
+
+		dispute := models.Dispute{ID: uint(disputeID)}
+		_, err := dispute.GetDisputeByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dispute.LastReminderAt.IsZero() {
+			t.Errorf("dispute due in two days was not reminded")
+		}
+
+		claimed, err := dispute.ClaimDisputeReminder(db.MOR, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed {
+			t.Errorf("dispute reminded twice within a day")
+		}
+	})
+
+	t.Run("OK ledger balanced", func(t *testing.T) {
+		check, _, err := morService.CheckLedgerService(extReq, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check.Balanced {
+			t.Errorf("ledger is not balanced: %+v", check)
+		}
+	})
+}
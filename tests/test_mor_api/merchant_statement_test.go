@@ -0,0 +1,250 @@
+package test_mor_api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestMerchantStatements(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := int64(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	// last month, so the month-end cronjob generates the same statement
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	period := start.Format("2006-01")
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+	countryID := int64(auth_mocks.Country.ID)
+
+	transactions := []models.Transaction{
+		{Amount: 10750, ProcessingFee: 100, TaxFee: 750, Status: models.TransactionSuccessful, TransactionDate: start.AddDate(0, 0, 1)},
+		{Amount: 3000, Status: models.TransactionFailed, TransactionDate: start.AddDate(0, 0, 1)},
+		{Amount: 2000, RefundedAmount: 2000, Status: models.TransactionRefunded, TransactionDate: start.AddDate(0, 0, 1)},
+	}
+	for _, trx := range transactions {
+		trx.MerchantID = accountID
+		trx.CountryID = countryID
+		trx.Reference = utility.RandomString(20)
+		err := trx.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payouts := []models.Payout{
+		{Amount: 1000, Status: models.PayoutSettled, CreatedAt: start.AddDate(0, 0, -10)},
+		{Amount: 9000, Status: models.PayoutSettled, CreatedAt: start.AddDate(0, 0, 2)},
+		{Amount: 4000, Status: models.TransactionFailed, CreatedAt: start.AddDate(0, 0, 2)},
+	}
+	for _, payout := range payouts {
+		payout.MerchantID = accountID
+		payout.CountryID = countryID
+		payout.Reference = utility.RandomString(20)
+		err := payout.CreatePayout(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withdrawals := []models.Withdrawal{
+		{Amount: 200, Status: models.TransactionSuccessful, WithdrawalDate: start.AddDate(0, 0, -5)},
+		{Amount: 500, Status: models.TransactionSuccessful, WithdrawalDate: start.AddDate(0, 0, 3)},
+		{Amount: 700, Status: models.TransactionPending, WithdrawalDate: start.AddDate(0, 0, 3)},
+	}
+	for _, withdrawal := range withdrawals {
+		withdrawal.MerchantID = accountID
+		withdrawal.Currency = "NGN"
+		err := withdrawal.CreateWithdrawal(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refund := models.Refund{
+		MerchantID:     accountID,
+		CountryID:      countryID,
+		Reference:      utility.RandomString(25),
+		Amount:         2000,
+		RecoveryAmount: 2000,
+		RecoveryMethod: models.RefundRecoveryNextPayout,
+		RecoveryStatus: models.RefundRecoveryPending,
+		InitiatedBy:    models.RefundInitiatedByMerchant,
+		CreatedAt:      start.AddDate(0, 0, 4),
+	}
+	err := refund.CreateRefund(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one dispute held before the period and won in it, one held and lost in it
+	disputes := []models.Dispute{
+		{Amount: 300, HeldAmount: 300, Status: models.DisputeWon, CreatedAt: start.AddDate(0, 0, -3), ResolvedAt: start.AddDate(0, 0, 5)},
+		{Amount: 400, HeldAmount: 400, Status: models.DisputeLost, CreatedAt: start.AddDate(0, 0, 6), ResolvedAt: start.AddDate(0, 0, 7)},
+	}
+	for _, dispute := range disputes {
+		dispute.MerchantID = accountID
+		dispute.CountryID = countryID
+		dispute.HoldMethod = models.DisputeHoldWallet
+		dispute.Provider = "manual"
+		dispute.ProviderReference = utility.RandomString(25)
+		err := dispute.CreateDispute(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.GET("/statements/get", mor.GetStatement)
+		paymentUrl.GET("/statements/list", mor.GetStatements)
+	}
+	merchantUrl := r.Group("v2")
+	{
+		merchantUrl.GET("/statements/get", mor.GetMerchantStatement)
+	}
+
+	send := func(path string, query string) *httptest.ResponseRecorder {
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(http.MethodGet, URI.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	checkStatement := func(t *testing.T, rr *httptest.ResponseRecorder, generated bool) {
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		statement, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("statement missing from response: %v", data)
+		}
+
+		expected := map[string]interface{}{
+			"currency":             "NGN",
+			"period":               period,
+			"opening_balance":      float64(500),
+			"transactions_count":   float64(2),
+			"transactions_amount":  float64(12750),
+			"processing_fee":       float64(100),
+			"tax_fee":              float64(750),
+			"payouts_count":        float64(1),
+			"payouts_amount":       float64(9000),
+			"withdrawals_count":    float64(1),
+			"withdrawals_amount":   float64(500),
+			"refunds_count":        float64(1),
+			"refunds_amount":       float64(0),
+			"chargebacks_count":    float64(1),
+			"chargebacks_amount":   float64(400),
+			"dispute_holds_amount": float64(100),
+			"closing_balance":      float64(8900),
+		}
+		for key, value := range expected {
+			if statement[key] != value {
+				t.Errorf("wrong %v: got %v expected %v", key, statement[key], value)
+			}
+		}
+
+		lines, _ := statement["lines"].([]interface{})
+		if len(lines) != 8 {
+			t.Errorf("wrong number of lines: got %v expected 8", len(lines))
+		} else if last := lines[len(lines)-1].(map[string]interface{}); last["balance"] != statement["closing_balance"] {
+			t.Errorf("last line balance %v doesn't match the closing balance %v", last["balance"], statement["closing_balance"])
+		}
+
+		if (statement["id"] != float64(0)) != generated {
+			t.Errorf("statement generated: got %v expected %v", statement["id"] != float64(0), generated)
+		}
+	}
+
+	t.Run("OK statement", func(t *testing.T) {
+		rr := send("/v2/admin/statements/get", fmt.Sprintf("account_id=%v&currency=NGN&period=%v", accountID, period))
+		checkStatement(t, rr, false)
+	})
+
+	t.Run("OK statement of merchant", func(t *testing.T) {
+		models.MyIdentity = &external_models.User{AccountID: uint(accountID)}
+		rr := send("/v2/statements/get", fmt.Sprintf("currency=MOR_NGN&period=%v", period))
+		checkStatement(t, rr, false)
+	})
+
+	t.Run("no account", func(t *testing.T) {
+		rr := send("/v2/admin/statements/get", fmt.Sprintf("currency=NGN&period=%v", period))
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		rr := send("/v2/admin/statements/get", fmt.Sprintf("account_id=%v&currency=NGN&period=2020-13", accountID))
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		rr := send("/v2/admin/statements/get", fmt.Sprintf("account_id=%v&currency=NGN&period=%v&format=xml", accountID, period))
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK csv", func(t *testing.T) {
+		rr := send("/v2/admin/statements/get", fmt.Sprintf("account_id=%v&currency=NGN&period=%v&format=csv", accountID, period))
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		rows, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// header, opening balance, eight lines and closing balance
+		if len(rows) != 11 {
+			t.Errorf("wrong number of csv rows: got %v expected 11", len(rows))
+		}
+	})
+
+	t.Run("OK month-end generation", func(t *testing.T) {
+		morService.GenerateMerchantStatements(extReq, db)
+
+		rr := send("/v2/admin/statements/list", fmt.Sprintf("account_id=%v", accountID))
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		statements, ok := data["data"].([]interface{})
+		if !ok || len(statements) != 1 {
+			t.Fatalf("expected one generated statement: %v", data)
+		}
+
+		rr = send("/v2/admin/statements/get", fmt.Sprintf("account_id=%v&currency=NGN&period=%v", accountID, period))
+		checkStatement(t, rr, true)
+	})
+}
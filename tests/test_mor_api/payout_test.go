@@ -0,0 +1,833 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/middleware"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestPayOutToWallets(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	var (
+		muuid, _  = uuid.NewV4()
+		accountID = uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+		testUser  = external_models.User{
+			ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+			AccountID:    accountID,
+			EmailAddress: fmt.Sprintf("testuser%v@qa.team", muuid.String()),
+			Firstname:    "test",
+			Lastname:     "user",
+		}
+	)
+
+	auth_mocks.User = &testUser
+	auth_mocks.ValidateAuthorizationRes = &external_models.ValidateAuthorizationDataModel{Status: true, Message: "authorized", Data: external_models.User{AccountID: accountID}}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	for _, amount := range []float64{12000, 8000} {
+		transaction := models.Transaction{
+			MerchantID: int64(accountID),
+			Reference:  utility.RandomString(20),
+			Amount:     amount,
+			CountryID:  int64(auth_mocks.Country.ID),
+			Status:     models.TransactionSuccessful,
+		}
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	// running the payout twice must not pay the same transactions again
+	tests := []struct {
+		Name            string
+		RequestBody     models.PayoutToWalletRequest
+		ExpectedCode    int
+		Message         string
+		ExpectedPayouts int
+	}{
+		{
+			Name:            "OK payout to wallets",
+			RequestBody:     models.PayoutToWalletRequest{Merchants: []int{int(accountID)}},
+			ExpectedCode:    http.StatusOK,
+			Message:         "payout successful",
+			ExpectedPayouts: 1,
+		},
+		{
+			Name:            "OK repeated payout to wallets",
+			RequestBody:     models.PayoutToWalletRequest{Merchants: []int{int(accountID)}},
+			ExpectedCode:    http.StatusOK,
+			Message:         "payout successful",
+			ExpectedPayouts: 1,
+		},
+	}
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"), middleware.Authorize(db, mor.ExtReq, middleware.BusinessAdmin))
+	{
+		paymentUrl.POST("/payout/to-wallet", mor.PayOutToWallets)
+		paymentUrl.POST("/payout/resume/:id", mor.ResumePayout)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var b bytes.Buffer
+			json.NewEncoder(&b).Encode(test.RequestBody)
+			URI := url.URL{Path: "/v2/admin/payout/to-wallet"}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("v-private-key", utility.RandomString(20))
+			req.Header.Set("v-public-key", utility.RandomString(20))
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			data := tst.ParseResponse(rr)
+			tst.AssertResponseMessage(t, data["message"].(string), test.Message)
+
+			payout := models.Payout{MerchantID: int64(accountID)}
+			payouts, _, err := payout.GetPayouts(db.MOR, postgresql.Pagination{Page: 1, Limit: 20}, "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payouts) != test.ExpectedPayouts {
+				t.Fatalf("wrong number of payouts: got %v expected %v", len(payouts), test.ExpectedPayouts)
+			}
+			if payouts[0].Status != models.PayoutSettled {
+				t.Errorf("wrong payout status: got %q expected %q, last error: %v", payouts[0].Status, models.PayoutSettled, payouts[0].LastError)
+			}
+			if payouts[0].Amount != 20000 {
+				t.Errorf("wrong payout amount: got %v expected 20000", payouts[0].Amount)
+			}
+
+			isPaidOut := false
+			transaction := models.Transaction{MerchantID: int64(accountID)}
+			unpaid, err := transaction.GetTransactionsAll(db.MOR, &isPaidOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unpaid) != 0 {
+				t.Errorf("wrong number of unpaid transactions: got %v expected 0", len(unpaid))
+			}
+
+			balances, _, err := morService.GetMerchantLedgerBalancesService(mor.ExtReq, db, models.GetLedgerRequest{Account: string(models.LedgerMerchantWallet)}, int(accountID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(balances) != 1 || balances[0].Balance != 20000 {
+				t.Errorf("wrong merchant wallet ledger balance: got %+v expected 20000", balances)
+			}
+		})
+	}
+
+	t.Run("OK resume payout stuck after wallet credit", func(t *testing.T) {
+		payout := models.Payout{
+			MerchantID: int64(accountID),
+			Reference:  utility.RandomString(25),
+			Amount:     5000,
+			CountryID:  int64(auth_mocks.Country.ID),
+			Status:     models.PayoutWalletCredited,
+		}
+		err := payout.CreatePayout(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		transaction := models.Transaction{
+			MerchantID: int64(accountID),
+			Reference:  utility.RandomString(20),
+			Amount:     5000,
+			CountryID:  int64(auth_mocks.Country.ID),
+			Status:     models.TransactionSuccessful,
+			PayoutID:   int64(payout.ID),
+		}
+		err = transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		URI := url.URL{Path: fmt.Sprintf("/v2/admin/payout/resume/%v", payout.ID)}
+		req, err := http.NewRequest(http.MethodPost, URI.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("v-private-key", utility.RandomString(20))
+		req.Header.Set("v-public-key", utility.RandomString(20))
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		_, err = payout.GetPayoutByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payout.Status != models.PayoutSettled {
+			t.Errorf("wrong payout status: got %q expected %q", payout.Status, models.PayoutSettled)
+		}
+
+		_, err = transaction.GetTransactionByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !transaction.IsPaidOut {
+			t.Errorf("transaction reserved for the resumed payout was not marked as paid out")
+		}
+	})
+}
+
+func TestRunScheduledPayouts(t *testing.T) {
+	logger := tst.Setup()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	tests := []struct {
+		Name            string
+		Schedule        models.PayoutSchedule
+		Threshold       float64
+		Amount          float64
+		ProcessingFee   float64
+		ExpectedPayouts int
+	}{
+		{
+			Name:            "OK daily payout due",
+			Schedule:        models.PayoutScheduleDaily,
+			Amount:          3000,
+			ExpectedPayouts: 1,
+		},
+		{
+			Name:            "OK threshold reached",
+			Schedule:        models.PayoutScheduleThreshold,
+			Threshold:       10000,
+			Amount:          12000,
+			ExpectedPayouts: 1,
+		},
+		{
+			Name:            "OK threshold not reached",
+			Schedule:        models.PayoutScheduleThreshold,
+			Threshold:       10000,
+			Amount:          5000,
+			ExpectedPayouts: 0,
+		},
+		{
+			Name:            "OK threshold reached before fees only",
+			Schedule:        models.PayoutScheduleThreshold,
+			Threshold:       10000,
+			Amount:          10500,
+			ProcessingFee:   600,
+			ExpectedPayouts: 0,
+		},
+		{
+			Name:            "OK manual schedule",
+			Schedule:        models.PayoutScheduleManual,
+			Amount:          3000,
+			ExpectedPayouts: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			accountID := utility.GetRandomNumbersInRange(1000000000, 9999999999)
+
+			setting := models.Setting{AccountID: int64(accountID), PayoutSchedule: test.Schedule, PayoutThreshold: test.Threshold}
+			err := setting.CreateSetting(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			transaction := models.Transaction{
+				MerchantID:    int64(accountID),
+				Reference:     utility.RandomString(20),
+				Amount:        test.Amount,
+				ProcessingFee: test.ProcessingFee,
+				CountryID:     int64(auth_mocks.Country.ID),
+				Status:        models.TransactionSuccessful,
+			}
+			err = transaction.CreateTransaction(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			morService.RunScheduledPayouts(extReq, db)
+
+			run := models.PayoutRun{}
+			runs, _, err := run.GetPayoutRuns(db.MOR, postgresql.Pagination{Page: 1, Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(runs) != 1 || runs[0].Status != models.PayoutRunCompleted || runs[0].MerchantsChecked == 0 {
+				t.Errorf("payout run was not logged: %+v", runs)
+			}
+			if test.ExpectedPayouts > 0 && len(runs) == 1 && runs[0].BatchID == 0 {
+				t.Errorf("payout run %v has no payout batch", runs[0].ID)
+			}
+
+			payout := models.Payout{MerchantID: int64(accountID)}
+			payouts, _, err := payout.GetPayouts(db.MOR, postgresql.Pagination{Page: 1, Limit: 20}, "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payouts) != test.ExpectedPayouts {
+				t.Fatalf("wrong number of payouts: got %v expected %v", len(payouts), test.ExpectedPayouts)
+			}
+
+			_, err = setting.GetSettingByAccountID(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.ExpectedPayouts > 0 && setting.LastPayoutAt.IsZero() {
+				t.Errorf("last payout time was not recorded")
+			}
+			if test.ExpectedPayouts == 0 && !setting.LastPayoutAt.IsZero() {
+				t.Errorf("last payout time recorded without a payout: %v", setting.LastPayoutAt)
+			}
+		})
+	}
+}
+
+func TestPayoutBatches(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	var (
+		accountID = uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+		testUser  = external_models.User{
+			ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+			AccountID: accountID,
+			Firstname: "test",
+			Lastname:  "user",
+		}
+		batchID float64
+	)
+
+	auth_mocks.ValidateAuthorizationRes = &external_models.ValidateAuthorizationDataModel{Status: true, Message: "authorized", Data: external_models.User{AccountID: accountID}}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	transaction := models.Transaction{
+		MerchantID: int64(accountID),
+		Reference:  utility.RandomString(20),
+		Amount:     7000,
+		CountryID:  int64(auth_mocks.Country.ID),
+		Status:     models.TransactionSuccessful,
+	}
+	err := transaction.CreateTransaction(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	// the merchant can't be found during the first payout, so its line fails and is retried once the merchant exists
+	tests := []struct {
+		Name              string
+		User              *external_models.User
+		Path              func() string
+		RequestBody       interface{}
+		ExpectedCode      int
+		ExpectedStatus    models.PayoutBatchStatus
+		ExpectedSucceeded float64
+		ExpectedFailed    float64
+	}{
+		{
+			Name:           "merchant not found",
+			Path:           func() string { return "/v2/admin/payout/to-wallet" },
+			RequestBody:    models.PayoutToWalletRequest{Merchants: []int{int(accountID)}},
+			ExpectedCode:   http.StatusBadRequest,
+			ExpectedStatus: models.PayoutBatchCompleted,
+			ExpectedFailed: 1,
+		},
+		{
+			Name:           "cancel completed batch",
+			Path:           func() string { return fmt.Sprintf("/v2/admin/payout/batches/cancel/%v", batchID) },
+			ExpectedCode:   http.StatusBadRequest,
+			ExpectedStatus: models.PayoutBatchCompleted,
+		},
+		{
+			Name:              "OK retry failed lines",
+			User:              &testUser,
+			Path:              func() string { return fmt.Sprintf("/v2/admin/payout/batches/retry/%v", batchID) },
+			ExpectedCode:      http.StatusOK,
+			ExpectedStatus:    models.PayoutBatchCompleted,
+			ExpectedSucceeded: 1,
+		},
+	}
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"), middleware.Authorize(db, mor.ExtReq, middleware.BusinessAdmin))
+	{
+		paymentUrl.POST("/payout/to-wallet", mor.PayOutToWallets)
+		paymentUrl.GET("/payout/batches/get/:id", mor.GetPayoutBatch)
+		paymentUrl.POST("/payout/batches/cancel/:id", mor.CancelPayoutBatch)
+		paymentUrl.POST("/payout/batches/retry/:id", mor.RetryPayoutBatch)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			auth_mocks.User = test.User
+
+			var b bytes.Buffer
+			json.NewEncoder(&b).Encode(test.RequestBody)
+			URI := url.URL{Path: test.Path()}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("v-private-key", utility.RandomString(20))
+			req.Header.Set("v-public-key", utility.RandomString(20))
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			data := tst.ParseResponse(rr)
+			batch, ok := data["data"].(map[string]interface{})
+			if !ok || batch["id"] == nil {
+				if test.ExpectedCode == http.StatusOK || batchID == 0 {
+					t.Fatalf("payout batch missing from response: %v", data)
+				}
+				return
+			}
+
+			if batchID == 0 {
+				batchID = batch["id"].(float64)
+			}
+
+			if batch["status"] != string(test.ExpectedStatus) {
+				t.Errorf("wrong batch status: got %v expected %v", batch["status"], test.ExpectedStatus)
+			}
+			if batch["succeeded"] != test.ExpectedSucceeded {
+				t.Errorf("wrong number of succeeded lines: got %v expected %v", batch["succeeded"], test.ExpectedSucceeded)
+			}
+			if batch["failed"] != test.ExpectedFailed {
+				t.Errorf("wrong number of failed lines: got %v expected %v", batch["failed"], test.ExpectedFailed)
+			}
+		})
+	}
+}
+
+func TestPayoutHoldsAndReserves(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.ValidateAuthorizationRes = &external_models.ValidateAuthorizationDataModel{Status: true, Message: "authorized", Data: external_models.User{AccountID: accountID}}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	// only the older transaction is past the payout delay
+	for _, trx := range []struct {
+		Amount float64
+		Date   time.Time
+	}{
+		{Amount: 10000, Date: time.Now().AddDate(0, 0, -10)},
+		{Amount: 5000, Date: time.Now()},
+	} {
+		transaction := models.Transaction{
+			MerchantID:      int64(accountID),
+			Reference:       utility.RandomString(20),
+			Amount:          trx.Amount,
+			CountryID:       int64(auth_mocks.Country.ID),
+			Status:          models.TransactionSuccessful,
+			TransactionDate: trx.Date,
+		}
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"), middleware.Authorize(db, mor.ExtReq, middleware.BusinessAdmin))
+	{
+		paymentUrl.POST("/payout/to-wallet", mor.PayOutToWallets)
+		paymentUrl.POST("/payout/policy/:account_id", mor.UpdatePayoutPolicy)
+		paymentUrl.POST("/payout/hold/:account_id", mor.HoldPayouts)
+		paymentUrl.DELETE("/payout/hold/:account_id", mor.LiftPayoutHold)
+	}
+
+	tests := []struct {
+		Name              string
+		Method            string
+		Path              string
+		RequestBody       interface{}
+		ExpectedCode      int
+		ExpectedSucceeded float64
+		ExpectedSkipped   float64
+	}{
+		{
+			Name:         "invalid reserve rate",
+			Method:       http.MethodPost,
+			Path:         fmt.Sprintf("/v2/admin/payout/policy/%v", accountID),
+			RequestBody:  models.UpdatePayoutPolicyRequest{ReserveRate: 120},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "OK set payout policy",
+			Method:       http.MethodPost,
+			Path:         fmt.Sprintf("/v2/admin/payout/policy/%v", accountID),
+			RequestBody:  models.UpdatePayoutPolicyRequest{ReserveRate: 10, PayoutDelayDays: 3},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "hold without reason",
+			Method:       http.MethodPost,
+			Path:         fmt.Sprintf("/v2/admin/payout/hold/%v", accountID),
+			RequestBody:  models.HoldPayoutsRequest{},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "OK hold payouts",
+			Method:       http.MethodPost,
+			Path:         fmt.Sprintf("/v2/admin/payout/hold/%v", accountID),
+			RequestBody:  models.HoldPayoutsRequest{Reason: "chargeback review"},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:            "OK payout skipped on hold",
+			Method:          http.MethodPost,
+			Path:            "/v2/admin/payout/to-wallet",
+			RequestBody:     models.PayoutToWalletRequest{Merchants: []int{int(accountID)}},
+			ExpectedCode:    http.StatusOK,
+			ExpectedSkipped: 1,
+		},
+		{
+			Name:         "OK lift hold",
+			Method:       http.MethodDelete,
+			Path:         fmt.Sprintf("/v2/admin/payout/hold/%v", accountID),
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "lift hold not on hold",
+			Method:       http.MethodDelete,
+			Path:         fmt.Sprintf("/v2/admin/payout/hold/%v", accountID),
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:              "OK payout with reserve",
+			Method:            http.MethodPost,
+			Path:              "/v2/admin/payout/to-wallet",
+			RequestBody:       models.PayoutToWalletRequest{Merchants: []int{int(accountID)}},
+			ExpectedCode:      http.StatusOK,
+			ExpectedSucceeded: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var b bytes.Buffer
+			json.NewEncoder(&b).Encode(test.RequestBody)
+			URI := url.URL{Path: test.Path}
+
+			req, err := http.NewRequest(test.Method, URI.String(), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("v-private-key", utility.RandomString(20))
+			req.Header.Set("v-public-key", utility.RandomString(20))
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			if test.ExpectedSucceeded == 0 && test.ExpectedSkipped == 0 {
+				return
+			}
+
+			data := tst.ParseResponse(rr)
+			batch, ok := data["data"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("payout batch missing from response: %v", data)
+			}
+			if batch["succeeded"] != test.ExpectedSucceeded {
+				t.Errorf("wrong number of succeeded lines: got %v expected %v", batch["succeeded"], test.ExpectedSucceeded)
+			}
+			if batch["skipped"] != test.ExpectedSkipped {
+				t.Errorf("wrong number of skipped lines: got %v expected %v", batch["skipped"], test.ExpectedSkipped)
+			}
+		})
+	}
+
+	payout := models.Payout{MerchantID: int64(accountID)}
+	payouts, _, err := payout.GetPayouts(db.MOR, postgresql.Pagination{Page: 1, Limit: 20}, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payouts) != 1 {
+		t.Fatalf("wrong number of payouts: got %v expected 1", len(payouts))
+	}
+	if payouts[0].Amount != 9000 || payouts[0].ReserveAmount != 1000 {
+		t.Errorf("wrong payout split: got amount %v reserve %v expected 9000 and 1000", payouts[0].Amount, payouts[0].ReserveAmount)
+	}
+
+	summaries, _, err := morService.GetMerchantTransactionsSummaryService(extReq, db, int(accountID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Reserved != 1000 || summaries[0].Payable != 0 || summaries[0].ReserveRate != 10 {
+		t.Errorf("wrong merchant summary: %+v", summaries)
+	}
+
+	// the reserve was held for 0 days, so it is due straight away
+	morService.ReleasePayoutReserves(extReq, db)
+
+	reserve := models.PayoutReserve{MerchantID: int64(accountID)}
+	reserves, _, err := reserve.GetPayoutReserves(db.MOR, postgresql.Pagination{Page: 1, Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reserves) != 1 || reserves[0].Status != models.PayoutReserveReleased {
+		t.Fatalf("reserve was not released: %+v", reserves)
+	}
+
+	release := models.Payout{ID: uint(reserves[0].ReleasePayoutID)}
+	_, err = release.GetPayoutByID(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Status != models.PayoutSettled || release.Amount != 1000 {
+		t.Errorf("wrong release payout: status %v amount %v", release.Status, release.Amount)
+	}
+
+	check, _, err := morService.CheckLedgerService(extReq, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Balanced {
+		t.Errorf("ledger is not balanced: %+v", check)
+	}
+}
+
+func TestPayoutNetSettlement(t *testing.T) {
+	logger := tst.Setup()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	for _, amount := range []float64{10000, 6000} {
+		transaction := models.Transaction{
+			MerchantID:    int64(accountID),
+			Reference:     utility.RandomString(20),
+			Amount:        amount,
+			ProcessingFee: amount * 0.015,
+			TaxFee:        amount * 0.0075,
+			CountryID:     int64(auth_mocks.Country.ID),
+			Status:        models.TransactionSuccessful,
+		}
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summaries, _, err := morService.GetMerchantTransactionsSummaryService(extReq, db, int(accountID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("wrong number of summaries: got %v expected 1", len(summaries))
+	}
+	if summaries[0].Amount != 16000 || summaries[0].ProcessingFee != 240 || summaries[0].TaxFee != 120 || summaries[0].Net != 15640 {
+		t.Errorf("wrong summary breakdown: %+v", summaries[0])
+	}
+
+	payouts, _, err := morService.PayoutToUser(extReq, db, int(accountID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payouts) != 1 {
+		t.Fatalf("wrong number of payouts: got %v expected 1", len(payouts))
+	}
+
+	payout := payouts[0]
+	if payout.GrossAmount != 16000 || payout.ProcessingFee != 240 || payout.TaxFee != 120 || payout.NetAmount != 15640 || payout.Amount != 15640 {
+		t.Errorf("wrong payout breakdown: %+v", payout)
+	}
+	if payout.Status != models.PayoutSettled {
+		t.Errorf("wrong payout status: got %v expected %v", payout.Status, models.PayoutSettled)
+	}
+
+	check, _, err := morService.CheckLedgerService(extReq, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Balanced {
+		t.Errorf("ledger is not balanced: %+v", check)
+	}
+}
+
+func TestPayoutCurrencyConversion(t *testing.T) {
+	logger := tst.Setup()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	setting := models.Setting{AccountID: int64(accountID), CurrencyCodes: []string{"NGN", "USD"}}
+	err := setting.CreateSetting(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, code, err := morService.UpdateSettlementCurrencyService(extReq, db, int(accountID), models.UpdateSettlementCurrencyRequest{Currency: "EUR"})
+	if err == nil || code != http.StatusBadRequest {
+		t.Errorf("settlement currency without a wallet was accepted: code %v", code)
+	}
+
+	_, _, err = morService.UpdateSettlementCurrencyService(extReq, db, int(accountID), models.UpdateSettlementCurrencyRequest{Currency: "mor_usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = morService.UpdatePayoutPolicyService(extReq, db, int(accountID), models.UpdatePayoutPolicyRequest{FxFeeRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transaction := models.Transaction{
+		MerchantID:    int64(accountID),
+		Reference:     utility.RandomString(20),
+		Amount:        10750,
+		ProcessingFee: 150,
+		TaxFee:        600,
+		CountryID:     int64(auth_mocks.Country.ID),
+		Status:        models.TransactionSuccessful,
+	}
+	err = transaction.CreateTransaction(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payouts, _, err := morService.PayoutToUser(extReq, db, int(accountID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payouts) != 1 {
+		t.Fatalf("wrong number of payouts: got %v expected 1", len(payouts))
+	}
+
+	// the mocked rate converts at 0.5, the 1% fee is taken from the converted amount
+	payout := payouts[0]
+	if payout.Amount != 10000 || payout.SettlementCurrency != "USD" || payout.FxRate != 0.5 || payout.FxFee != 50 || payout.SettlementAmount != 4950 {
+		t.Errorf("wrong converted payout: %+v", payout)
+	}
+	if payout.FxRateID == 0 || payout.ExchangeReference != payout.Reference {
+		t.Errorf("conversion rate and exchange reference not recorded: %+v", payout)
+	}
+	if payout.Status != models.PayoutSettled {
+		t.Errorf("wrong payout status: got %v expected %v", payout.Status, models.PayoutSettled)
+	}
+
+	check, _, err := morService.CheckLedgerService(extReq, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Balanced {
+		t.Errorf("ledger is not balanced: %+v", check)
+	}
+}
@@ -0,0 +1,149 @@
+package test_mor_api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestRunReconciliation(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	// the wallet mock always reports an available balance of 20000
+	tests := []struct {
+		Name                  string
+		PaidOutTransactions   float64
+		Payouts               float64
+		ExpectedDiscrepancies int
+		ExpectedDifference    float64
+		ExpectedIssues        int
+	}{
+		{
+			Name:                  "OK wallet matches payouts",
+			PaidOutTransactions:   20000,
+			Payouts:               20000,
+			ExpectedDiscrepancies: 0,
+		},
+		{
+			Name:                  "OK wallet holds more than payouts",
+			PaidOutTransactions:   15000,
+			Payouts:               15000,
+			ExpectedDiscrepancies: 1,
+			ExpectedDifference:    5000,
+			ExpectedIssues:        1,
+		},
+		{
+			Name:                  "OK paid out transactions don't match payouts",
+			PaidOutTransactions:   18000,
+			Payouts:               20000,
+			ExpectedDiscrepancies: 1,
+			ExpectedIssues:        1,
+		},
+	}
+
+	reconciliationUrl := r.Group(fmt.Sprintf("%v/admin/reconciliation", "v2"))
+	{
+		reconciliationUrl.POST("/run", mor.RunReconciliation)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			accountID := utility.GetRandomNumbersInRange(1000000000, 9999999999)
+
+			payout := models.Payout{
+				MerchantID: int64(accountID),
+				Reference:  utility.RandomString(25),
+				Amount:     test.Payouts,
+				CountryID:  int64(auth_mocks.Country.ID),
+				Status:     models.TransactionSuccessful,
+			}
+			err := payout.CreatePayout(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			transaction := models.Transaction{
+				MerchantID: int64(accountID),
+				Reference:  utility.RandomString(20),
+				Amount:     test.PaidOutTransactions,
+				CountryID:  int64(auth_mocks.Country.ID),
+				Status:     models.TransactionSuccessful,
+				IsPaidOut:  true,
+				PayoutID:   int64(payout.ID),
+			}
+			err = transaction.CreateTransaction(db.MOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			URI := url.URL{Path: "/v2/admin/reconciliation/run", RawQuery: fmt.Sprintf("account_id=%v", accountID)}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+			data := tst.ParseResponse(rr)
+			run := data["data"].(map[string]interface{})
+			if run["status"] != string(models.ReconciliationCompleted) {
+				t.Errorf("wrong reconciliation run status: got %v expected %v, error: %v", run["status"], models.ReconciliationCompleted, run["error"])
+			}
+
+			report := models.ReconciliationReport{RunID: int64(run["id"].(float64)), MerchantID: int64(accountID)}
+			reports, _, err := report.GetReconciliationReports(db.MOR, postgresql.Pagination{Page: 1, Limit: 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != test.ExpectedDiscrepancies {
+				t.Fatalf("wrong number of discrepancies: got %v expected %v", len(reports), test.ExpectedDiscrepancies)
+			}
+			if len(reports) == 0 {
+				return
+			}
+
+			if reports[0].Difference != test.ExpectedDifference {
+				t.Errorf("wrong difference: got %v expected %v", reports[0].Difference, test.ExpectedDifference)
+			}
+			if len(reports[0].Issues) != test.ExpectedIssues {
+				t.Errorf("wrong number of issues: got %v expected %v, issues: %v", len(reports[0].Issues), test.ExpectedIssues, reports[0].Issues)
+			}
+			if reports[0].Status != models.ReconciliationReportOpen {
+				t.Errorf("wrong report status: got %q expected %q", reports[0].Status, models.ReconciliationReportOpen)
+			}
+		})
+	}
+}
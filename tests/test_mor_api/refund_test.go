@@ -0,0 +1,276 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	"github.com/vesicash/mor-api/services/refunds"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestRefunds(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: accountID,
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	createTransaction := func(transaction models.Transaction) models.Transaction {
+		transaction.MerchantID = int64(accountID)
+		transaction.CountryID = int64(auth_mocks.Country.ID)
+		transaction.Reference = utility.RandomString(20)
+		err := transaction.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transaction
+	}
+
+	unpaid := createTransaction(models.Transaction{Amount: 10750, ProcessingFee: 100, TaxFee: 750, Status: models.TransactionSuccessful})
+	paidOut := createTransaction(models.Transaction{Amount: 1075, ProcessingFee: 10, TaxFee: 75, Status: models.TransactionSuccessful, IsPaidOut: true, PayoutID: 1})
+	failed := createTransaction(models.Transaction{Amount: 3000, Status: models.TransactionFailed})
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.POST("/transaction/refund/:id", mor.RefundTransaction)
+		paymentUrl.GET("/refunds/get", mor.GetRefunds)
+	}
+	merchantUrl := r.Group("v2")
+	{
+		merchantUrl.POST("/transactions/refund/:id", mor.RefundMerchantTransaction)
+	}
+
+	send := func(method string, path string, query string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(method, URI.String(), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	checkRefund := func(t *testing.T, rr *httptest.ResponseRecorder, expected map[string]interface{}) {
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		refund, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("refund missing from response: %v", data)
+		}
+
+		for key, value := range expected {
+			if refund[key] != value {
+				t.Errorf("wrong %v: got %v expected %v", key, refund[key], value)
+			}
+		}
+	}
+
+	getTransaction := func(t *testing.T, id uint) models.Transaction {
+		transaction := models.Transaction{ID: id}
+		_, err := transaction.GetTransactionByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transaction
+	}
+
+	t.Run("OK partial refund", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/refund/%v", unpaid.ID), "", models.CreateRefundRequest{Amount: 5375, Reason: "damaged"})
+		checkRefund(t, rr, map[string]interface{}{
+			"amount":          float64(5375),
+			"processing_fee":  float64(50),
+			"tax_fee":         float64(375),
+			"recovery_amount": float64(4950),
+			"recovery_method": string(models.RefundRecoveryNextPayout),
+			"recovery_status": string(models.RefundRecoveryPending),
+			"initiated_by":    string(models.RefundInitiatedByAdmin),
+		})
+
+		transaction := getTransaction(t, unpaid.ID)
+		if transaction.Status != models.TransactionSuccessful || transaction.RefundedAmount != 5375 {
+			t.Errorf("wrong partly refunded transaction: status %v refunded %v", transaction.Status, transaction.RefundedAmount)
+		}
+
+		// the unpaid totals count the failed transaction too, the refund takes 4950 off the net after its share of fee and tax
+		summaries, _, err := morService.GetMerchantTransactionsSummaryService(extReq, db, int(accountID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 || summaries[0].RefundedAmount != 5375 || summaries[0].Net != 7950 {
+			t.Errorf("wrong summary after partial refund: %+v", summaries)
+		}
+	})
+
+	t.Run("more than refundable", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/refund/%v", unpaid.ID), "", models.CreateRefundRequest{Amount: 6000})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("failed transaction", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/refund/%v", failed.ID), "", models.CreateRefundRequest{})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("transaction of another merchant", func(t *testing.T) {
+		models.MyIdentity = &external_models.User{AccountID: accountID + 1}
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/transactions/refund/%v", unpaid.ID), "", models.CreateRefundRequest{})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK merchant refunds the rest", func(t *testing.T) {
+		models.MyIdentity = &external_models.User{AccountID: accountID}
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/transactions/refund/%v", unpaid.ID), "", models.CreateRefundRequest{})
+		checkRefund(t, rr, map[string]interface{}{
+			"amount":          float64(5375),
+			"processing_fee":  float64(50),
+			"tax_fee":         float64(375),
+			"recovery_method": string(models.RefundRecoveryNextPayout),
+			"initiated_by":    string(models.RefundInitiatedByMerchant),
+		})
+
+		transaction := getTransaction(t, unpaid.ID)
+		if transaction.Status != models.TransactionRefunded || transaction.RefundedAmount != 10750 || transaction.RefundedAt.IsZero() {
+			t.Errorf("wrong refunded transaction: status %v refunded %v at %v", transaction.Status, transaction.RefundedAmount, transaction.RefundedAt)
+		}
+	})
+
+	t.Run("OK paid out transaction recovered from wallet", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/refund/%v", paidOut.ID), "", models.CreateRefundRequest{})
+		checkRefund(t, rr, map[string]interface{}{
+			"amount":           float64(1075),
+			"recovery_amount":  float64(990),
+			"recovered_amount": float64(990),
+			"recovery_method":  string(models.RefundRecoveryWallet),
+			"recovery_status":  string(models.RefundRecoveryRecovered),
+		})
+	})
+
+	t.Run("OK list", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/admin/refunds/get", fmt.Sprintf("account_id=%v", accountID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		refunds, ok := data["data"].([]interface{})
+		if !ok || len(refunds) != 3 {
+			t.Errorf("expected three refunds: %v", data)
+		}
+	})
+
+	t.Run("OK next payout recovers refunds", func(t *testing.T) {
+		createTransaction(models.Transaction{Amount: 2000, Status: models.TransactionSuccessful})
+
+		payouts, _, err := morService.PayoutToUser(extReq, db, int(accountID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payouts) != 1 {
+			t.Fatalf("wrong number of payouts: got %v expected 1", len(payouts))
+		}
+
+		// the fully refunded transaction is paid out with its refunds recovered from the same payout
+		payout := payouts[0]
+		if payout.GrossAmount != 12750 || payout.NetAmount != 11900 || payout.RefundDeduction != 9900 || payout.Amount != 2000 {
+			t.Errorf("wrong payout breakdown: %+v", payout)
+		}
+
+		refund := models.Refund{TransactionID: int64(unpaid.ID)}
+		refunds, err := refund.GetRefundsByTransactionID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range refunds {
+			if r.RecoveryStatus != models.RefundRecoveryRecovered || r.RecoveryPayoutID != int64(payout.ID) {
+				t.Errorf("refund %v not recovered from payout %v: %+v", r.Reference, payout.ID, r)
+			}
+		}
+
+		check, _, err := morService.CheckLedgerService(extReq, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check.Balanced {
+			t.Errorf("ledger is not balanced: %+v", check)
+		}
+	})
+
+	// a refund applied inside a webhook transaction is only saved, the recovery job debits the wallet when settling it right after never ran
+	t.Run("OK recovery job settles a wallet refund", func(t *testing.T) {
+		transaction := createTransaction(models.Transaction{Amount: 1075, ProcessingFee: 10, TaxFee: 75, Status: models.TransactionSuccessful, IsPaidOut: true, PayoutID: 1})
+
+		refund, _, err := refunds.CreateRefund(extReq, db, transaction, models.Refund{Amount: 1075}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refund.RecoveryMethod != models.RefundRecoveryWallet || refund.RecoveryStatus != models.RefundRecoveryPending {
+			t.Fatalf("refund should wait on its wallet debit: %+v", refund)
+		}
+
+		err = db.MOR.Model(&models.Refund{}).Where("id = ?", refund.ID).Update("updated_at", time.Now().Add(-time.Hour)).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		debits := mocks.SentRequests(request.DebitWallet)
+		morService.SettleWalletCalls(extReq, db)
+		if n := mocks.SentRequests(request.DebitWallet) - debits; n < 1 {
+			t.Errorf("recovery job didn't debit the wallet for refund %v", refund.Reference)
+		}
+
+		_, err = refund.GetRefundByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refund.RecoveryStatus != models.RefundRecoveryRecovered || refund.RecoveredAmount != 990 {
+			t.Errorf("wrong settled refund: %+v", refund)
+		}
+
+		check, _, err := morService.CheckLedgerService(extReq, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check.Balanced {
+			t.Errorf("ledger is not balanced: %+v", check)
+		}
+	})
+}
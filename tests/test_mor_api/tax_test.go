@@ -0,0 +1,333 @@
+package test_mor_api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestTaxCalculation(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	now := time.Now()
+
+	auth_mocks.User = &external_models.User{
+		ID:        uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Firstname: "test",
+		Lastname:  "user",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+	countryID := int(auth_mocks.Country.ID)
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.POST("/tax/rates/create", mor.CreateTaxRate)
+		paymentUrl.POST("/tax/calculate", mor.CalculateTax)
+	}
+
+	// the standard rate goes up from 7.5% to 10% tomorrow
+	tests := []struct {
+		Name          string
+		Path          string
+		RequestBody   interface{}
+		ExpectedCode  int
+		ExpectedTax   float64
+		ReverseCharge bool
+	}{
+		{
+			Name:         "exempt rate",
+			Path:         "/v2/admin/tax/rates/create",
+			RequestBody:  models.CreateTaxRateRequest{Country: countryID, Category: models.TaxCategoryExempt, Name: "VAT", Rate: 0, EffectiveFrom: int(now.AddDate(0, 0, -30).Unix())},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "OK standard rate",
+			Path:         "/v2/admin/tax/rates/create",
+			RequestBody:  models.CreateTaxRateRequest{Country: countryID, Category: models.TaxCategoryStandard, Name: "VAT", Rate: 7.5, EffectiveFrom: int(now.AddDate(0, 0, -30).Unix())},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "OK new standard rate",
+			Path:         "/v2/admin/tax/rates/create",
+			RequestBody:  models.CreateTaxRateRequest{Country: countryID, Category: models.TaxCategoryStandard, Name: "VAT", Rate: 10, EffectiveFrom: int(now.AddDate(0, 0, 1).Unix())},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "OK reduced rate",
+			Path:         "/v2/admin/tax/rates/create",
+			RequestBody:  models.CreateTaxRateRequest{Country: countryID, Category: models.TaxCategoryReduced, Name: "VAT", Rate: 5, EffectiveFrom: int(now.AddDate(0, 0, -30).Unix())},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "OK standard",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Amount: 10750},
+			ExpectedCode: http.StatusOK,
+			ExpectedTax:  750,
+		},
+		{
+			Name:         "OK standard after rate change",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Amount: 11000, Time: int(now.AddDate(0, 0, 2).Unix())},
+			ExpectedCode: http.StatusOK,
+			ExpectedTax:  1000,
+		},
+		{
+			Name:         "OK reduced",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Category: models.TaxCategoryReduced, Amount: 10500},
+			ExpectedCode: http.StatusOK,
+			ExpectedTax:  500,
+		},
+		{
+			Name:         "OK exempt",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Category: models.TaxCategoryExempt, Amount: 10000},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:          "OK reverse charge",
+			Path:          "/v2/admin/tax/calculate",
+			RequestBody:   models.CalculateTaxRequest{Country: countryID, Amount: 10750, CustomerTaxID: "12345678-0001"},
+			ExpectedCode:  http.StatusOK,
+			ReverseCharge: true,
+		},
+		{
+			Name:         "invalid tax id",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Amount: 10750, CustomerTaxID: "abc"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "no rate yet",
+			Path:         "/v2/admin/tax/calculate",
+			RequestBody:  models.CalculateTaxRequest{Country: countryID, Amount: 10750, Time: int(now.AddDate(0, 0, -60).Unix())},
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var b bytes.Buffer
+			json.NewEncoder(&b).Encode(test.RequestBody)
+			URI := url.URL{Path: test.Path}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			if test.ExpectedCode != http.StatusOK || test.Path != "/v2/admin/tax/calculate" {
+				return
+			}
+
+			data := tst.ParseResponse(rr)
+			calculation, ok := data["data"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("tax calculation missing from response: %v", data)
+			}
+			if calculation["tax"] != test.ExpectedTax {
+				t.Errorf("wrong tax: got %v expected %v", calculation["tax"], test.ExpectedTax)
+			}
+			if calculation["reverse_charge"] != test.ReverseCharge {
+				t.Errorf("wrong reverse charge: got %v expected %v", calculation["reverse_charge"], test.ReverseCharge)
+			}
+		})
+	}
+
+	transaction, _, err := morService.RecordTransactionService(extReq, db, models.RecordTransactionRequest{
+		AccountID:            int64(auth_mocks.User.AccountID),
+		Reference:            utility.RandomString(20),
+		Country:              countryID,
+		Amount:               21500,
+		TaxFee:               1,
+		TransactionCreatedAt: int(now.Unix()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.TaxFee != 1500 || transaction.TaxRate != 7.5 || transaction.TaxRateID == 0 || transaction.TaxCategory != models.TaxCategoryStandard {
+		t.Errorf("wrong transaction tax: fee %v rate %v rate id %v category %v", transaction.TaxFee, transaction.TaxRate, transaction.TaxRateID, transaction.TaxCategory)
+	}
+}
+
+func TestTaxReports(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := int64(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+	march := time.Date(2020, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+	countryID := int64(auth_mocks.Country.ID)
+
+	transactions := []models.Transaction{
+		{Amount: 10750, TaxFee: 750, TaxRate: 7.5, TaxCategory: models.TaxCategoryStandard, Status: models.TransactionSuccessful, TransactionDate: march},
+		{Amount: 5000, TaxCategory: models.TaxCategoryExempt, Status: models.TransactionSuccessful, TransactionDate: march},
+		{Amount: 2000, TaxCategory: models.TaxCategoryStandard, TaxReverseCharge: true, CustomerTaxID: "12345678-0001", Status: models.TransactionSuccessful, TransactionDate: march},
+		{Amount: 2150, TaxFee: 150, TaxRate: 7.5, TaxCategory: models.TaxCategoryStandard, Status: models.TransactionRefunded, TransactionDate: march, RefundedAt: march.AddDate(0, 0, 10)},
+		{Amount: 3000, Status: models.TransactionFailed, TransactionDate: march},
+	}
+	for _, trx := range transactions {
+		trx.MerchantID = accountID
+		trx.Reference = utility.RandomString(20)
+		trx.CountryID = countryID
+		err := trx.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.GET("/tax/reports/get", mor.GetTaxReports)
+		paymentUrl.GET("/tax/reports/export", mor.ExportTaxReport)
+		paymentUrl.POST("/tax/reports/close", mor.CloseTaxFiling)
+	}
+
+	send := func(method string, path string, query string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(method, URI.String(), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	checkFiling := func(t *testing.T, status models.TaxFilingStatus) {
+		rr := send(http.MethodGet, "/v2/admin/tax/reports/get", fmt.Sprintf("period=2020-Q1&country=%v", countryID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		filings, ok := data["data"].([]interface{})
+		if !ok || len(filings) != 1 {
+			t.Fatalf("expected one filing: %v", data)
+		}
+
+		filing := filings[0].(map[string]interface{})
+		expected := map[string]interface{}{
+			"status":                string(status),
+			"currency":              "NGN",
+			"sales_count":           float64(4),
+			"gross_sales":           float64(19900),
+			"taxable_amount":        float64(12000),
+			"exempt_amount":         float64(5000),
+			"reverse_charge_amount": float64(2000),
+			"tax_collected":         float64(900),
+			"refund_count":          float64(1),
+			"refunded_tax":          float64(150),
+			"net_tax":               float64(750),
+		}
+		for key, value := range expected {
+			if filing[key] != value {
+				t.Errorf("wrong %v: got %v expected %v", key, filing[key], value)
+			}
+		}
+	}
+
+	t.Run("OK open filing", func(t *testing.T) {
+		checkFiling(t, models.TaxFilingOpen)
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/admin/tax/reports/get", "period=2020-Q5", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK close filing", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/admin/tax/reports/close", "", models.CloseTaxFilingRequest{Country: int(countryID), Period: "2020-q1"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+	})
+
+	t.Run("close filing twice", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/admin/tax/reports/close", "", models.CloseTaxFilingRequest{Country: int(countryID), Period: "2020-Q1"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK closed filing is reproduced", func(t *testing.T) {
+		late := models.Transaction{MerchantID: accountID, Reference: utility.RandomString(20), CountryID: countryID, Amount: 1075, TaxFee: 75, Status: models.TransactionSuccessful, TransactionDate: march}
+		err := late.CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFiling(t, models.TaxFilingClosed)
+	})
+
+	t.Run("OK export audit trail", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/admin/tax/reports/export", fmt.Sprintf("period=2020-Q1&country=%v&lines=true", countryID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		rows, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// header, four sales and the refund
+		if len(rows) != 6 {
+			t.Errorf("wrong number of csv rows: got %v expected 6", len(rows))
+		}
+	})
+
+	t.Run("OK export xlsx", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/admin/tax/reports/export", fmt.Sprintf("period=2020-Q1&country=%v&format=xlsx", countryID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		_, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Errorf("export is not an xlsx file: %v", err)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestTransactionDocuments(t *testing.T) {
+	logger := tst.Setup()
+	if _, err := exec.LookPath("wkhtmltopdf"); err != nil {
+		t.Skip("wkhtmltopdf is not installed")
+	}
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+
+	// a fresh entity code so the numbering starts at 1
+	entity := "T" + strings.ToUpper(utility.RandomString(6))
+	config.GetConfig().LegalEntity = config.LegalEntity{Code: entity, Name: "Test Entity Ltd", Address: "Lagos", TaxID: "12345678-0001"}
+
+	auth_mocks.User = &external_models.User{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID:    uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Firstname:    "test",
+		Lastname:     "merchant",
+		EmailAddress: "merchant@example.com",
+	}
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+	accountID := int64(auth_mocks.User.AccountID)
+
+	customer := models.Customer{AccountID: accountID, Email: "customer@example.com", Firstname: "test", Lastname: "customer"}
+	err := customer.CreateCustomer(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := []models.Transaction{
+		{Amount: 10750, TaxFee: 750, TaxRate: 7.5, TaxCategory: models.TaxCategoryStandard, Status: models.TransactionSuccessful, CustomerID: int64(customer.ID)},
+		{Amount: 2000, Status: models.TransactionPending},
+		{Amount: 3000, Status: models.TransactionFailed},
+	}
+	for i := range transactions {
+		transactions[i].MerchantID = accountID
+		transactions[i].Reference = utility.RandomString(20)
+		transactions[i].CountryID = int64(auth_mocks.Country.ID)
+		transactions[i].TransactionDate = time.Now()
+		err := transactions[i].CreateTransaction(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	paid, pending, failed := transactions[0], transactions[1], transactions[2]
+
+	order := models.PaymentOrder{CustomerID: int64(customer.ID), TransactionID: int64(paid.ID), Item: "subscription", Quantity: 1, UnitPrice: 10750}
+	err = order.CreatePaymentOrder(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"))
+	{
+		paymentUrl.GET("/transaction/document/:id", mor.GetTransactionDocument)
+		paymentUrl.POST("/transaction/document/email/:id", mor.EmailTransactionDocument)
+	}
+	merchantUrl := r.Group("v2")
+	{
+		merchantUrl.GET("/transactions/document/:id", mor.GetMerchantTransactionDocument)
+	}
+
+	send := func(method string, path string, query string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(method, URI.String(), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	checkNumber := func(t *testing.T, transaction models.Transaction, documentType models.TransactionDocumentType, expected string) {
+		document := models.TransactionDocument{TransactionID: int64(transaction.ID), DocumentType: documentType}
+		_, err := document.GetTransactionDocumentByTransactionIDAndType(db.MOR)
+		if err != nil {
+			t.Fatalf("%v of transaction %v was not issued: %v", documentType, transaction.ID, err)
+		}
+		if document.Number != expected {
+			t.Errorf("wrong %v number: got %v expected %v", documentType, document.Number, expected)
+		}
+	}
+
+	tests := []struct {
+		Name         string
+		Transaction  models.Transaction
+		Type         models.TransactionDocumentType
+		ExpectedCode int
+		Number       string
+	}{
+		{
+			Name:         "OK invoice",
+			Transaction:  paid,
+			Type:         models.TransactionDocumentInvoice,
+			ExpectedCode: http.StatusOK,
+			Number:       entity + "-INV-000001",
+		},
+		{
+			Name:         "OK same invoice again",
+			Transaction:  paid,
+			Type:         models.TransactionDocumentInvoice,
+			ExpectedCode: http.StatusOK,
+			Number:       entity + "-INV-000001",
+		},
+		{
+			Name:         "receipt of unpaid transaction",
+			Transaction:  pending,
+			Type:         models.TransactionDocumentReceipt,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "invoice of failed transaction",
+			Transaction:  failed,
+			Type:         models.TransactionDocumentInvoice,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "OK invoice of unpaid transaction",
+			Transaction:  pending,
+			Type:         models.TransactionDocumentInvoice,
+			ExpectedCode: http.StatusOK,
+			Number:       entity + "-INV-000002",
+		},
+		{
+			Name:         "OK receipt",
+			Transaction:  paid,
+			Type:         models.TransactionDocumentReceipt,
+			ExpectedCode: http.StatusOK,
+			Number:       entity + "-RCT-000001",
+		},
+		{
+			Name:         "invalid type",
+			Transaction:  paid,
+			Type:         "quote",
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rr := send(http.MethodGet, fmt.Sprintf("/v2/admin/transaction/document/%v", test.Transaction.ID), fmt.Sprintf("type=%v", test.Type), nil)
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			if test.ExpectedCode != http.StatusOK {
+				return
+			}
+
+			if !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF")) {
+				t.Errorf("document is not a pdf")
+			}
+			checkNumber(t, test.Transaction, test.Type, test.Number)
+		})
+	}
+
+	t.Run("transaction of another merchant", func(t *testing.T) {
+		models.MyIdentity = &external_models.User{AccountID: uint(accountID + 1)}
+		rr := send(http.MethodGet, fmt.Sprintf("/v2/transactions/document/%v", paid.ID), "type=invoice", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK merchant download", func(t *testing.T) {
+		models.MyIdentity = &external_models.User{AccountID: uint(accountID)}
+		rr := send(http.MethodGet, fmt.Sprintf("/v2/transactions/document/%v", paid.ID), "type=invoice", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+	})
+
+	t.Run("email without customer email", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/document/email/%v", pending.ID), "", models.EmailTransactionDocumentRequest{Type: models.TransactionDocumentInvoice})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK email to customer", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/v2/admin/transaction/document/email/%v", paid.ID), "", models.EmailTransactionDocumentRequest{Type: models.TransactionDocumentReceipt})
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		document, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("document missing from response: %v", data)
+		}
+		if document["emailed_to"] != customer.Email || document["number"] != entity+"-RCT-000001" {
+			t.Errorf("wrong emailed document: %v", document)
+		}
+	})
+}
@@ -0,0 +1,738 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestETransactMerchantWebhook(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	var (
+		accountID = utility.GetRandomNumbersInRange(1000000000, 9999999999)
+		secretKey = config.GetConfig().ETranzact.SecretKey
+	)
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	tests := []struct {
+		Name              string
+		Fixture           string
+		Signature         func(body []byte) string
+		ExpectedCode      int
+		Message           string
+		Reference         string
+		ExpectedStatus    models.TransactionStatus
+		ExpectedMethod    models.PaymentMethod
+		ExpectedAmount    float64
+		ExpectedCountryID int64
+	}{
+		{
+			Name:    "OK successful card payment",
+			Fixture: "payment_successful.json",
+			Signature: func(body []byte) string {
+				return utility.Sha256Hmac(secretKey, body)
+			},
+			ExpectedCode:      http.StatusOK,
+			Message:           "successful",
+			Reference:         "ETZ-MOR-7f3c1d2a9b",
+			ExpectedStatus:    models.TransactionSuccessful,
+			ExpectedMethod:    models.CardMethod,
+			ExpectedAmount:    25000,
+			ExpectedCountryID: int64(auth_mocks.Country.ID),
+		},
+		{
+			Name:    "OK failed transfer payment",
+			Fixture: "payment_failed.json",
+			Signature: func(body []byte) string {
+				return utility.Sha256Hmac(secretKey, body)
+			},
+			ExpectedCode:      http.StatusOK,
+			Message:           "successful",
+			Reference:         "ETZ-MOR-c81e44b0d2",
+			ExpectedStatus:    models.TransactionFailed,
+			ExpectedMethod:    models.BankTransferMethod,
+			ExpectedAmount:    12500,
+			ExpectedCountryID: int64(auth_mocks.Country.ID),
+		},
+		{
+			Name:    "OK duplicate delivery of successful card payment",
+			Fixture: "payment_successful.json",
+			Signature: func(body []byte) string {
+				return utility.Sha256Hmac(secretKey, body)
+			},
+			ExpectedCode:      http.StatusOK,
+			Message:           "successful",
+			Reference:         "ETZ-MOR-7f3c1d2a9b",
+			ExpectedStatus:    models.TransactionSuccessful,
+			ExpectedMethod:    models.CardMethod,
+			ExpectedAmount:    25000,
+			ExpectedCountryID: int64(auth_mocks.Country.ID),
+		},
+		{
+			Name:    "invalid signature",
+			Fixture: "payment_successful.json",
+			Signature: func(body []byte) string {
+				return utility.Sha256Hmac("wrong-secret", body)
+			},
+			ExpectedCode: http.StatusUnauthorized,
+			Message:      "signature doesn't match",
+		},
+	}
+
+	morUrl := r.Group(fmt.Sprintf("%v", "v2"))
+	{
+		morUrl.POST("/webhook/:account_id", mor.MerchantWebhooks)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "etranzact", test.Fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			URI := url.URL{Path: fmt.Sprintf("/v2/webhook/%v", accountID)}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("x-etranzact-signature", test.Signature(body))
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			data := tst.ParseResponse(rr)
+
+			code := int(data["code"].(float64))
+			tst.AssertStatusCode(t, code, test.ExpectedCode)
+
+			if test.Message != "" {
+				message := data["message"]
+				if message != nil {
+					tst.AssertResponseMessage(t, message.(string), test.Message)
+				} else {
+					tst.AssertResponseMessage(t, "", test.Message)
+				}
+			}
+
+			if test.ExpectedCode != http.StatusOK {
+				return
+			}
+
+			processPendingWebhookLogs(t, mor.ExtReq, db, accountID)
+
+			transaction := models.Transaction{MerchantID: int64(accountID), Reference: test.Reference}
+			_, err = transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+			if err != nil {
+				t.Fatalf("transaction %v was not recorded: %v", test.Reference, err)
+			}
+
+			if transaction.Status != test.ExpectedStatus {
+				t.Errorf("wrong transaction status: got %q expected %q", transaction.Status, test.ExpectedStatus)
+			}
+			if transaction.PaymentMethod != test.ExpectedMethod {
+				t.Errorf("wrong payment method: got %q expected %q", transaction.PaymentMethod, test.ExpectedMethod)
+			}
+			if transaction.Amount != test.ExpectedAmount {
+				t.Errorf("wrong amount: got %v expected %v", transaction.Amount, test.ExpectedAmount)
+			}
+			if transaction.CountryID != test.ExpectedCountryID {
+				t.Errorf("wrong country id: got %v expected %v", transaction.CountryID, test.ExpectedCountryID)
+			}
+
+			transactions, err := transaction.GetTransactionsAll(db.MOR, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transactions) != 1 {
+				t.Errorf("wrong number of transactions for reference %v: got %v expected 1", test.Reference, len(transactions))
+			}
+		})
+
+	}
+
+}
+
+func TestFlutterwaveMerchantWebhook(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	var (
+		accountID = utility.GetRandomNumbersInRange(1000000000, 9999999999)
+		secretKey = config.GetConfig().Rave.WebhookSecret
+	)
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	// the refund is applied to the transaction created by the charge, so the cases run in order
+	tests := []struct {
+		Name           string
+		Fixture        string
+		Reference      string
+		ExpectedStatus models.TransactionStatus
+		ExpectedMethod models.PaymentMethod
+		ExpectedAmount float64
+	}{
+		{
+			Name:           "OK charge completed",
+			Fixture:        "charge_completed.json",
+			Reference:      "FLW-MOR-3e9a0c71d5",
+			ExpectedStatus: models.TransactionSuccessful,
+			ExpectedMethod: models.CardMethod,
+			ExpectedAmount: 18000,
+		},
+		{
+			Name:           "OK refund completed",
+			Fixture:        "refund_completed.json",
+			Reference:      "FLW-MOR-3e9a0c71d5",
+			ExpectedStatus: models.TransactionRefunded,
+			ExpectedMethod: models.CardMethod,
+			ExpectedAmount: 18000,
+		},
+		{
+			Name:           "OK failed transfer completed",
+			Fixture:        "transfer_completed.json",
+			Reference:      "FLW-MOR-TRF-91d0f2a7",
+			ExpectedStatus: models.TransactionFailed,
+			ExpectedAmount: 5000,
+		},
+	}
+
+	morUrl := r.Group(fmt.Sprintf("%v", "v2"))
+	{
+		morUrl.POST("/webhook/:account_id", mor.MerchantWebhooks)
+	}
+
+	// transfer events only update transfers recorded as transactions
+	transfer := models.Transaction{MerchantID: int64(accountID), Reference: "FLW-MOR-TRF-91d0f2a7", Amount: 5000, Status: models.TransactionPending}
+	err := transfer.CreateTransaction(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "flutterwave", test.Fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			URI := url.URL{Path: fmt.Sprintf("/v2/webhook/%v", accountID)}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("verif-hash", secretKey)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+			processPendingWebhookLogs(t, mor.ExtReq, db, accountID)
+
+			transaction := models.Transaction{MerchantID: int64(accountID), Reference: test.Reference}
+			_, err = transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+			if err != nil {
+				t.Fatalf("transaction %v was not recorded: %v", test.Reference, err)
+			}
+
+			if transaction.Status != test.ExpectedStatus {
+				t.Errorf("wrong transaction status: got %q expected %q", transaction.Status, test.ExpectedStatus)
+			}
+			if transaction.PaymentMethod != test.ExpectedMethod {
+				t.Errorf("wrong payment method: got %q expected %q", transaction.PaymentMethod, test.ExpectedMethod)
+			}
+			if transaction.Amount != test.ExpectedAmount {
+				t.Errorf("wrong amount: got %v expected %v", transaction.Amount, test.ExpectedAmount)
+			}
+		})
+	}
+
+	t.Run("OK unknown transfer ignored", func(t *testing.T) {
+		otherAccountID := utility.GetRandomNumbersInRange(1000000000, 9999999999)
+		body, err := os.ReadFile(filepath.Join("testdata", "flutterwave", "transfer_completed.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v2/webhook/%v", otherAccountID), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("verif-hash", secretKey)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		processPendingWebhookLogs(t, mor.ExtReq, db, otherAccountID)
+
+		transaction := models.Transaction{MerchantID: int64(otherAccountID), Reference: "FLW-MOR-TRF-91d0f2a7"}
+		_, err = transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+		if err == nil {
+			t.Errorf("unknown transfer was recorded as transaction %v", transaction.ID)
+		}
+	})
+
+	// the refund took the charge back out of the merchant's pending balance, except the processing fee and tax the next payout deducts
+	charge := models.Transaction{MerchantID: int64(accountID), Reference: "FLW-MOR-3e9a0c71d5"}
+	_, err = charge.GetTransactionByMerchantIDAndReference(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charge.RefundedAmount != 18000 {
+		t.Errorf("wrong refunded amount: got %v expected 18000", charge.RefundedAmount)
+	}
+
+	balances, _, err := morService.GetMerchantLedgerBalancesService(mor.ExtReq, db, models.GetLedgerRequest{Currency: "NGN"}, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range balances {
+		expected := 0.0
+		if b.Account == models.LedgerMerchantPending {
+			expected = charge.ProcessingFee + charge.TaxFee
+		}
+		if b.Balance != expected {
+			t.Errorf("wrong %v ledger balance: got %v expected %v", b.Account, b.Balance, expected)
+		}
+	}
+
+	check, _, err := morService.CheckLedgerService(mor.ExtReq, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Balanced {
+		t.Errorf("ledger is not balanced, unbalanced journal entries: %v", check.UnbalancedEntries)
+	}
+}
+
+func TestPaystackMerchantWebhook(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	var (
+		accountID = utility.GetRandomNumbersInRange(1000000000, 9999999999)
+		secretKey = config.GetConfig().Paystack.SecretKey
+	)
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: request.ExternalRequest{
+		Logger: logger,
+		Test:   true,
+	}}
+	r := gin.Default()
+
+	// the refunds are applied to the transaction created by the charge, so the cases run in order
+	tests := []struct {
+		Name                   string
+		Fixture                string
+		Signature              func(body []byte) string
+		ExpectedCode           int
+		Message                string
+		ExpectedStatus         models.TransactionStatus
+		ExpectedAmount         float64
+		ExpectedRefundedAmount float64
+	}{
+		{
+			Name:    "invalid signature",
+			Fixture: "charge_success.json",
+			Signature: func(body []byte) string {
+				return utility.Sha512Hmac("wrong-secret", body)
+			},
+			ExpectedCode: http.StatusUnauthorized,
+			Message:      "signature doesn't match",
+		},
+		{
+			Name:    "OK charge success",
+			Fixture: "charge_success.json",
+			Signature: func(body []byte) string {
+				return utility.Sha512Hmac(secretKey, body)
+			},
+			ExpectedCode:   http.StatusOK,
+			Message:        "successful",
+			ExpectedStatus: models.TransactionSuccessful,
+			ExpectedAmount: 30000,
+		},
+		{
+			Name:    "OK partial refund processed",
+			Fixture: "refund_processed_partial.json",
+			Signature: func(body []byte) string {
+				return utility.Sha512Hmac(secretKey, body)
+			},
+			ExpectedCode:           http.StatusOK,
+			Message:                "successful",
+			ExpectedStatus:         models.TransactionSuccessful,
+			ExpectedAmount:         30000,
+			ExpectedRefundedAmount: 10000,
+		},
+		{
+			Name:    "OK refund of the rest processed",
+			Fixture: "refund_processed.json",
+			Signature: func(body []byte) string {
+				return utility.Sha512Hmac(secretKey, body)
+			},
+			ExpectedCode:           http.StatusOK,
+			Message:                "successful",
+			ExpectedStatus:         models.TransactionRefunded,
+			ExpectedAmount:         30000,
+			ExpectedRefundedAmount: 30000,
+		},
+	}
+
+	morUrl := r.Group(fmt.Sprintf("%v", "v2"))
+	{
+		morUrl.POST("/webhook/:account_id", mor.MerchantWebhooks)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "paystack", test.Fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			URI := url.URL{Path: fmt.Sprintf("/v2/webhook/%v", accountID)}
+
+			req, err := http.NewRequest(http.MethodPost, URI.String(), bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("x-paystack-signature", test.Signature(body))
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			tst.AssertStatusCode(t, rr.Code, test.ExpectedCode)
+
+			data := tst.ParseResponse(rr)
+
+			message := data["message"]
+			if message != nil {
+				tst.AssertResponseMessage(t, message.(string), test.Message)
+			} else {
+				tst.AssertResponseMessage(t, "", test.Message)
+			}
+
+			if test.ExpectedCode != http.StatusOK {
+				return
+			}
+
+			processPendingWebhookLogs(t, mor.ExtReq, db, accountID)
+
+			transaction := models.Transaction{MerchantID: int64(accountID), Reference: "PSK-MOR-5b2e9d0c47"}
+			_, err = transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+			if err != nil {
+				t.Fatalf("transaction was not recorded: %v", err)
+			}
+
+			if transaction.Status != test.ExpectedStatus {
+				t.Errorf("wrong transaction status: got %q expected %q", transaction.Status, test.ExpectedStatus)
+			}
+			if transaction.PaymentMethod != models.CardMethod {
+				t.Errorf("wrong payment method: got %q expected %q", transaction.PaymentMethod, models.CardMethod)
+			}
+			if transaction.Amount != test.ExpectedAmount {
+				t.Errorf("wrong amount: got %v expected %v", transaction.Amount, test.ExpectedAmount)
+			}
+			if transaction.RefundedAmount != test.ExpectedRefundedAmount {
+				t.Errorf("wrong refunded amount: got %v expected %v", transaction.RefundedAmount, test.ExpectedRefundedAmount)
+			}
+		})
+	}
+
+	// both refunds were recorded with their share of the processing fee reversed
+	transaction := models.Transaction{MerchantID: int64(accountID), Reference: "PSK-MOR-5b2e9d0c47"}
+	_, err := transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refund := models.Refund{TransactionID: int64(transaction.ID)}
+	refunds, err := refund.GetRefundsByTransactionID(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 {
+		t.Fatalf("wrong number of refunds of transaction %v: got %v expected 2", transaction.Reference, len(refunds))
+	}
+	for _, r := range refunds {
+		if r.InitiatedBy != models.RefundInitiatedByProvider || r.ProcessingFee == 0 {
+			t.Errorf("wrong provider refund %v: initiated by %v with processing fee %v", r.Reference, r.InitiatedBy, r.ProcessingFee)
+		}
+	}
+}
+
+// TestWebhookDryRunReplay checks a dry run never moves money or notifies anyone, whatever the event does when it is applied
+func TestWebhookDryRunReplay(t *testing.T) {
+	logger := tst.Setup()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := utility.GetRandomNumbersInRange(1000000000, 9999999999)
+
+	auth_mocks.Country = &external_models.Country{
+		ID:           uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		Name:         "nigeria",
+		CountryCode:  "NG",
+		CurrencyCode: "NGN",
+	}
+
+	externalRequests := []string{request.DebitWallet, request.CreditWallet, request.DisputeNotification, request.SuccessfulRefundNotification}
+
+	dryRun := func(t *testing.T, provider string, body []byte) models.WebhookReplayResult {
+		webhookLog := models.WebhookLog{Log: string(body), Provider: provider, MerchantID: int64(accountID), Status: models.WebhookLogDeadLetter}
+		err := webhookLog.CreateWebhookLog(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sent := map[string]int{}
+		for _, name := range externalRequests {
+			sent[name] = mocks.SentRequests(name)
+		}
+
+		replay, _, err := morService.ReplayWebhookLogService(extReq, db, int(webhookLog.ID), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range externalRequests {
+			if n := mocks.SentRequests(name) - sent[name]; n != 0 {
+				t.Errorf("dry run sent %v %v requests", n, name)
+			}
+		}
+		return replay
+	}
+
+	t.Run("OK charge", func(t *testing.T) {
+		body, err := os.ReadFile(filepath.Join("testdata", "flutterwave", "charge_completed.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replay := dryRun(t, "flutterwave", body)
+		if replay.Duplicate || len(replay.Changes) == 0 {
+			t.Errorf("dry run of a new charge should report changes: got duplicate %v with %v changes", replay.Duplicate, len(replay.Changes))
+		}
+
+		transaction := models.Transaction{MerchantID: int64(accountID), Reference: "FLW-MOR-3e9a0c71d5"}
+		_, err = transaction.GetTransactionByMerchantIDAndReference(db.MOR)
+		if err == nil {
+			t.Errorf("dry run recorded transaction %v", transaction.ID)
+		}
+	})
+
+	// a chargeback of a paid out transaction holds the disputed amount from the MOR_ wallet, winning it gives it back
+	paidOut := models.Transaction{
+		MerchantID: int64(accountID),
+		Reference:  utility.RandomString(20),
+		Amount:     5000,
+		CountryID:  int64(auth_mocks.Country.ID),
+		Status:     models.TransactionSuccessful,
+		IsPaidOut:  true,
+	}
+	err := paidOut.CreateTransaction(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chargeback := func(t *testing.T, id int, status string) []byte {
+		body, err := json.Marshal(map[string]interface{}{
+			"event": "chargeback." + status,
+			"data": map[string]interface{}{
+				"id":       id,
+				"tx_ref":   paidOut.Reference,
+				"amount":   paidOut.Amount,
+				"currency": "NGN",
+				"status":   status,
+				"comment":  "Customer does not recognise the charge",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	t.Run("OK chargeback opened", func(t *testing.T) {
+		id := utility.GetRandomNumbersInRange(1000000, 9999999)
+		dryRun(t, "flutterwave", chargeback(t, id, "initiated"))
+
+		dispute := models.Dispute{Provider: "flutterwave", ProviderReference: fmt.Sprint(id)}
+		_, err := dispute.GetDisputeByProviderReference(db.MOR)
+		if err == nil {
+			t.Errorf("dry run opened dispute %v", dispute.ID)
+		}
+	})
+
+	t.Run("OK chargeback won", func(t *testing.T) {
+		id := utility.GetRandomNumbersInRange(1000000, 9999999)
+		dispute := models.Dispute{
+			TransactionID:     int64(paidOut.ID),
+			MerchantID:        paidOut.MerchantID,
+			CountryID:         paidOut.CountryID,
+			Provider:          "flutterwave",
+			ProviderReference: fmt.Sprint(id),
+			Amount:            paidOut.Amount,
+			HeldAmount:        paidOut.Amount,
+			HoldMethod:        models.DisputeHoldWallet,
+			Status:            models.DisputeOpened,
+		}
+		err := dispute.CreateDispute(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dryRun(t, "flutterwave", chargeback(t, id, "won"))
+
+		_, err = dispute.GetDisputeByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dispute.Status != models.DisputeOpened {
+			t.Errorf("dry run moved dispute %v to %v", dispute.ID, dispute.Status)
+		}
+	})
+
+	// a failed transfer of a debited withdrawal credits the MOR_ wallet back and notifies the merchant
+	t.Run("OK withdrawal transfer failed", func(t *testing.T) {
+		withdrawal := models.Withdrawal{
+			MerchantID:        int64(accountID),
+			Currency:          "NGN",
+			Amount:            2000,
+			Status:            models.WithdrawalProcessing,
+			Provider:          "monnify",
+			TransferReference: utility.RandomString(25),
+		}
+		err := withdrawal.CreateWithdrawal(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := json.Marshal(map[string]interface{}{
+			"eventType": "FAILED_DISBURSEMENT",
+			"eventData": map[string]interface{}{
+				"amount":                 withdrawal.Amount,
+				"fee":                    10,
+				"transactionReference":   "MFDS" + utility.RandomString(20),
+				"transactionDescription": "Beneficiary account is dormant",
+				"reference":              withdrawal.TransferReference,
+				"currency":               "NGN",
+				"completedOn":            "2024-03-01T10:15:00.000+0000",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dryRun(t, "monnify", body)
+
+		_, err = withdrawal.GetWithdrawalByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withdrawal.Status != models.WithdrawalProcessing {
+			t.Errorf("dry run moved withdrawal %v to %v", withdrawal.ID, withdrawal.Status)
+		}
+	})
+}
+
+// processPendingWebhookLogs runs the merchant's queued deliveries the way the webhook workers would and checks they were processed
+func processPendingWebhookLogs(t *testing.T, extReq request.ExternalRequest, db postgresql.Databases, accountID int) {
+	webhookLog := models.WebhookLog{MerchantID: int64(accountID), Status: models.WebhookLogPending}
+	webhookLogs, _, err := webhookLog.GetWebhookLogs(db.MOR, postgresql.Pagination{Page: 1, Limit: 20}, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhookLogs) != 1 {
+		t.Fatalf("wrong number of pending webhook logs: got %v expected 1", len(webhookLogs))
+	}
+
+	err = morService.ProcessWebhookLog(extReq, db, webhookLogs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhookLog = models.WebhookLog{ID: webhookLogs[0].ID}
+	_, err = webhookLog.GetWebhookLogByID(db.MOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhookLog.Status != models.WebhookLogProcessed {
+		t.Errorf("wrong webhook log status: got %q expected %q, last error: %v", webhookLog.Status, models.WebhookLogProcessed, webhookLog.LastError)
+	}
+
+	replay, _, err := morService.ReplayWebhookLogService(extReq, db, int(webhookLog.ID), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Duplicate || len(replay.Changes) != 0 {
+		t.Errorf("dry run replay of a processed delivery should be a duplicate without changes: got duplicate %v with %v changes", replay.Duplicate, len(replay.Changes))
+	}
+}
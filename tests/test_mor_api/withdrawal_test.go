@@ -0,0 +1,352 @@
+package test_mor_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/vesicash/mor-api/external/external_models"
+	"github.com/vesicash/mor-api/external/mocks/auth_mocks"
+	"github.com/vesicash/mor-api/external/request"
+	"github.com/vesicash/mor-api/internal/config"
+	"github.com/vesicash/mor-api/internal/models"
+	"github.com/vesicash/mor-api/pkg/controller/mor"
+	"github.com/vesicash/mor-api/pkg/middleware"
+	"github.com/vesicash/mor-api/pkg/repository/storage/postgresql"
+	morService "github.com/vesicash/mor-api/services/mor-api"
+	tst "github.com/vesicash/mor-api/tests"
+	"github.com/vesicash/mor-api/utility"
+)
+
+func TestWithdrawalBalance(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+	otherAccountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+	withdrawalDate := int(time.Now().Add(24 * time.Hour).Unix())
+
+	// the wallet mock always has 20000 available
+	models.MyIdentity = &external_models.User{AccountID: accountID}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	merchantUrl := r.Group("v2")
+	{
+		merchantUrl.POST("/withdrawal/request", mor.RequestWithdrawal)
+		merchantUrl.GET("/withdrawal/balance", mor.GetWithdrawalBalance)
+	}
+
+	send := func(method string, path string, query string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		URI := url.URL{Path: path, RawQuery: query}
+
+		req, err := http.NewRequest(method, URI.String(), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	getBalance := func(t *testing.T, currency string) map[string]interface{} {
+		rr := send(http.MethodGet, "/v2/withdrawal/balance", "currency="+currency, nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		data := tst.ParseResponse(rr)
+		balance, ok := data["data"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("balance missing from response: %v", data)
+		}
+		return balance
+	}
+
+	t.Run("OK other merchant's withdrawals aren't counted", func(t *testing.T) {
+		code, err := morService.RequestWithdrawalService(extReq, db, external_models.User{AccountID: otherAccountID}, models.RequestWithdrawalRequest{Currency: "NGN", Amount: 15000, WithdrawalDate: withdrawalDate})
+		if err != nil {
+			t.Fatalf("code %v: %v", code, err)
+		}
+
+		rr := send(http.MethodPost, "/v2/withdrawal/request", "", models.RequestWithdrawalRequest{Currency: "MOR_NGN", Amount: 12000, WithdrawalDate: withdrawalDate})
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+	})
+
+	t.Run("OK other currency's withdrawals aren't counted", func(t *testing.T) {
+		balance := getBalance(t, "USD")
+		if balance["available"] != float64(20000) || balance["pending_withdrawals"] != float64(0) {
+			t.Errorf("wrong USD balance: %v", balance)
+		}
+	})
+
+	t.Run("OK balance", func(t *testing.T) {
+		balance := getBalance(t, "MOR_NGN")
+		expected := map[string]interface{}{
+			"currency":            "NGN",
+			"wallet_balance":      float64(20000),
+			"pending_withdrawals": float64(12000),
+			"available":           float64(8000),
+		}
+		for key, value := range expected {
+			if balance[key] != value {
+				t.Errorf("wrong %v: got %v expected %v", key, balance[key], value)
+			}
+		}
+	})
+
+	t.Run("more than available", func(t *testing.T) {
+		rr := send(http.MethodPost, "/v2/withdrawal/request", "", models.RequestWithdrawalRequest{Currency: "NGN", Amount: 8001, WithdrawalDate: withdrawalDate})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("no currency", func(t *testing.T) {
+		rr := send(http.MethodGet, "/v2/withdrawal/balance", "", nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK concurrent requests don't overdraw", func(t *testing.T) {
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := morService.RequestWithdrawalService(extReq, db, external_models.User{AccountID: accountID}, models.RequestWithdrawalRequest{Currency: "NGN", Amount: 3000, WithdrawalDate: withdrawalDate})
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 2 {
+			t.Errorf("expected 2 of the concurrent withdrawals to succeed, got %v", succeeded)
+		}
+		if balance := getBalance(t, "NGN"); balance["available"] != float64(2000) {
+			t.Errorf("wrong available balance after concurrent withdrawals: %v", balance)
+		}
+	})
+}
+
+func TestWithdrawalLifecycle(t *testing.T) {
+	logger := tst.Setup()
+	gin.SetMode(gin.TestMode)
+	validatorRef := validator.New()
+	db := postgresql.Connection()
+	extReq := request.ExternalRequest{Logger: logger, Test: true}
+	accountID := uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))
+
+	models.MyIdentity = &external_models.User{AccountID: accountID}
+	auth_mocks.ValidateAuthorizationRes = &external_models.ValidateAuthorizationDataModel{Status: true, Message: "authorized", Data: external_models.User{AccountID: uint(utility.GetRandomNumbersInRange(1000000000, 9999999999))}}
+	auth_mocks.BankDetail = &external_models.BankDetail{
+		ID:          uint(utility.GetRandomNumbersInRange(1000000000, 9999999999)),
+		AccountID:   int(accountID),
+		BankID:      1,
+		AccountName: "test user",
+		AccountNo:   "0690000040",
+		Country:     "NG",
+		Currency:    "NGN",
+	}
+
+	createWithdrawal := func() models.Withdrawal {
+		withdrawal := models.Withdrawal{MerchantID: int64(accountID), Currency: "NGN", Amount: 1000, WithdrawalDate: time.Now(), Status: models.WithdrawalPending}
+		err := withdrawal.CreateWithdrawal(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return withdrawal
+	}
+
+	mor := mor.Controller{Db: db, Validator: validatorRef, Logger: logger, ExtReq: extReq}
+	r := gin.Default()
+
+	morUrl := r.Group("v2")
+	{
+		morUrl.POST("/webhook/:account_id", mor.MerchantWebhooks)
+		morUrl.PATCH("/withdrawal/cancel/:withdrawal_id", mor.CancelWithdrawal)
+	}
+	paymentUrl := r.Group(fmt.Sprintf("%v/admin", "v2"), middleware.Authorize(db, extReq, middleware.BusinessAdmin))
+	{
+		paymentUrl.PATCH("/withdrawal/approve/:withdrawal_id", mor.ApproveWithdrawal)
+		paymentUrl.PATCH("/withdrawal/reject/:withdrawal_id", mor.RejectWithdrawal)
+	}
+
+	send := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+
+		req, err := http.NewRequest(method, path, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("v-private-key", utility.RandomString(20))
+		req.Header.Set("v-public-key", utility.RandomString(20))
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	getWithdrawal := func(t *testing.T, id uint) models.Withdrawal {
+		withdrawal := models.Withdrawal{ID: id}
+		_, err := withdrawal.GetWithdrawalByID(db.MOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return withdrawal
+	}
+
+	sendMonnifyWebhook := func(t *testing.T, eventType string, withdrawal models.Withdrawal, description string) {
+		body, err := json.Marshal(map[string]interface{}{
+			"eventType": eventType,
+			"eventData": map[string]interface{}{
+				"amount":                 withdrawal.Amount,
+				"fee":                    10,
+				"transactionReference":   "MFDS" + utility.RandomString(20),
+				"transactionDescription": description,
+				"reference":              withdrawal.TransferReference,
+				"currency":               "NGN",
+				"completedOn":            "2024-03-01T10:15:00.000+0000",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v2/webhook/%v", accountID), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("monnify-signature", utility.Sha512Hmac(config.GetConfig().Monnify.MonnifySecret, body))
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		processPendingWebhookLogs(t, extReq, db, int(accountID))
+	}
+
+	t.Run("OK cancel", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/withdrawal/cancel/%v", withdrawal.ID), models.CancelWithdrawalRequest{Reason: "wrong amount"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalCancelled || withdrawal.Reason != "wrong amount" {
+			t.Errorf("wrong cancelled withdrawal: status %v reason %v", withdrawal.Status, withdrawal.Reason)
+		}
+
+		rr = send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("reject without reason", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/reject/%v", withdrawal.ID), models.RejectWithdrawalRequest{})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("OK reject", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/reject/%v", withdrawal.ID), models.RejectWithdrawalRequest{Reason: "bank account not verified"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalRejected {
+			t.Errorf("wrong status: got %v expected %v", withdrawal.Status, models.WithdrawalRejected)
+		}
+
+		rr = send(http.MethodPatch, fmt.Sprintf("/v2/withdrawal/cancel/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("started transfer can't be rejected", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		updated, err := withdrawal.UpdateWithdrawalStatus(db.MOR, models.WithdrawalPending, map[string]interface{}{"status": models.WithdrawalApproved, "transfer_reference": utility.RandomString(25)})
+		if err != nil || !updated {
+			t.Fatalf("error approving withdrawal: %v", err)
+		}
+
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/reject/%v", withdrawal.ID), models.RejectWithdrawalRequest{Reason: "bank account not verified"})
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalApproved {
+			t.Errorf("wrong status: got %v expected %v", withdrawal.Status, models.WithdrawalApproved)
+		}
+	})
+
+	t.Run("OK approve and transfer", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		withdrawal = getWithdrawal(t, withdrawal.ID)
+		if withdrawal.Status != models.WithdrawalProcessing || withdrawal.Provider != "monnify" || withdrawal.TransferReference == "" || withdrawal.BankDetailID != int64(auth_mocks.BankDetail.ID) {
+			t.Fatalf("wrong approved withdrawal: %+v", withdrawal)
+		}
+
+		rr = send(http.MethodPatch, fmt.Sprintf("/v2/withdrawal/cancel/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusBadRequest)
+
+		sendMonnifyWebhook(t, "SUCCESSFUL_DISBURSEMENT", withdrawal, "Approved or completed successfully")
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalSuccessful || withdrawal.CompletedAt.IsZero() {
+			t.Errorf("wrong transferred withdrawal: status %v completed at %v", withdrawal.Status, withdrawal.CompletedAt)
+		}
+	})
+
+	t.Run("OK failed transfer reverses the debit", func(t *testing.T) {
+		withdrawal := createWithdrawal()
+		rr := send(http.MethodPatch, fmt.Sprintf("/v2/admin/withdrawal/approve/%v", withdrawal.ID), nil)
+		tst.AssertStatusCode(t, rr.Code, http.StatusOK)
+
+		sendMonnifyWebhook(t, "FAILED_DISBURSEMENT", getWithdrawal(t, withdrawal.ID), "Beneficiary account is dormant")
+
+		if withdrawal = getWithdrawal(t, withdrawal.ID); withdrawal.Status != models.WithdrawalFailed || withdrawal.Reason != "Beneficiary account is dormant" {
+			t.Errorf("wrong failed withdrawal: status %v reason %v", withdrawal.Status, withdrawal.Reason)
+		}
+	})
+
+	t.Run("OK unknown disbursement ignored", func(t *testing.T) {
+		sendMonnifyWebhook(t, "SUCCESSFUL_DISBURSEMENT", models.Withdrawal{Amount: 1000, TransferReference: utility.RandomString(25)}, "Approved or completed successfully")
+	})
+
+	t.Run("OK ledger", func(t *testing.T) {
+		// only the successful transfer left the wallet, the failed one was given back
+		balances, _, err := morService.GetMerchantLedgerBalancesService(extReq, db, models.GetLedgerRequest{Account: string(models.LedgerMerchantWallet)}, int(accountID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(balances) != 1 || balances[0].Balance != -1000 {
+			t.Errorf("wrong merchant wallet ledger balance: got %+v expected -1000", balances)
+		}
+
+		check, _, err := morService.CheckLedgerService(extReq, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check.Balanced {
+			t.Errorf("ledger is not balanced: %+v", check)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package utility
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+const (
+	CSVContentType  = "text/csv"
+	XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// WriteCSV writes the header and rows as a csv file
+func WriteCSV(header []string, rows [][]string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+
+	err := w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.WriteAll(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// WriteXLSX writes the header and rows to a single sheet xlsx workbook, values that parse as numbers are stored as numbers
+func WriteXLSX(sheetName string, header []string, rows [][]string) ([]byte, error) {
+	var (
+		b     bytes.Buffer
+		sheet bytes.Buffer
+		z     = zip.NewWriter(&b)
+	)
+
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range append([][]string{header}, rows...) {
+		sheet.WriteString(fmt.Sprintf(`<row r="%v">`, i+1))
+		for j, value := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			if _, err := strconv.ParseFloat(value, 64); err == nil && i > 0 {
+				sheet.WriteString(fmt.Sprintf(`<c r="%v"><v>%v</v></c>`, ref, value))
+				continue
+			}
+			sheet.WriteString(fmt.Sprintf(`<c r="%v" t="inlineStr"><is><t>`, ref))
+			xml.EscapeText(&sheet, []byte(value))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(sheetName))
+
+	files := []struct {
+		Name    string
+		Content string
+	}{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	for _, f := range files {
+		w, err := z.Create(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write([]byte(f.Content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := z.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// xlsxColumn turns a zero based column index into its spreadsheet letters, 0 is A and 26 is AA
+func xlsxColumn(index int) string {
+	column := ""
+	for index >= 0 {
+		column = string(rune('A'+index%26)) + column
+		index = index/26 - 1
+	}
+	return column
+}